@@ -0,0 +1,675 @@
+// Package asm implements a two-pass assembler for the Intel 8080.
+//
+// The assembler accepts the standard Intel mnemonics, and the 8085's RIM and
+// SIM, along with labels, expressions and the ORG, EQU, SET, DB, DW, DS, END
+// and INCLUDE directives.
+// The first pass establishes the value of every symbol and the second pass
+// generates the code, so symbols may be referenced before they are defined.
+package asm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth limits how deeply INCLUDE directives may be nested, which
+// stops a file which includes itself from looping forever.
+const maxIncludeDepth = 16
+
+type (
+	// Error describes a problem with a single line of source.
+	Error struct {
+		File string
+		Line int
+		Msg  string
+	}
+
+	// ErrorList is a list of errors, in the order they were found in the
+	// source.
+	ErrorList []*Error
+
+	// Option is a functional option that modifies a field on the assembler.
+	Option func(*assembler)
+
+	// srcLine is a single line of source, after any INCLUDE directives have
+	// been expanded.
+	srcLine struct {
+		file string
+		num  int
+		text string
+	}
+
+	// symbol is a named value in the symbol table.
+	symbol struct {
+		value int
+
+		// Set for symbols defined by SET, which may be redefined.
+		set bool
+
+		// Pass in which the symbol was last defined.
+		pass int
+	}
+
+	// assembler holds the state of the assembler across both passes.
+	assembler struct {
+		// Directories searched for included files, after the directory of the
+		// including file.
+		includeDirs []string
+
+		// The source, with includes expanded.
+		lines []srcLine
+
+		// The current pass, either 1 or 2.
+		pass int
+
+		// Location counter.
+		pc int
+
+		// Symbol table, keyed by upper case name.
+		symbols map[string]*symbol
+
+		// The program being generated during the second pass.
+		prog *Program
+
+		// The first error found on each line. Errors found during the first
+		// pass are not reported again during the second.
+		errs map[srcLine]*Error
+	}
+)
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Error implements the error interface.
+func (el ErrorList) Error() string {
+	msgs := make([]string, 0, len(el))
+	for _, e := range el {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// WithIncludeDirs adds directories which are searched for files named by
+// INCLUDE directives, after the directory containing the including file.
+func WithIncludeDirs(dirs ...string) Option {
+	return func(a *assembler) {
+		a.includeDirs = append(a.includeDirs, dirs...)
+	}
+}
+
+// AssembleFile assembles the source file at the given path.
+func AssembleFile(path string, opts ...Option) (*Program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Assemble(path, f, opts...)
+}
+
+// Assemble assembles the source read from r. The name is used in error
+// messages and to locate included files.
+//
+// If the source contains errors the returned error is an ErrorList.
+func Assemble(name string, r io.Reader, opts ...Option) (*Program, error) {
+	a := &assembler{}
+	for _, o := range opts {
+		o(a)
+	}
+
+	if err := a.read(name, r, 0); err != nil {
+		return nil, err
+	}
+
+	a.symbols = make(map[string]*symbol)
+	a.errs = make(map[srcLine]*Error)
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc = 0
+		a.prog = &Program{}
+
+		for _, l := range a.lines {
+			if !a.assembleLine(l) {
+				break
+			}
+		}
+	}
+	if len(a.errs) > 0 {
+		var el ErrorList
+		for _, l := range a.lines {
+			if e, ok := a.errs[l]; ok {
+				el = append(el, e)
+			}
+		}
+		return nil, el
+	}
+
+	a.prog.Symbols = make(map[string]uint16, len(a.symbols))
+	for name, s := range a.symbols {
+		a.prog.Symbols[name] = uint16(s.value)
+	}
+
+	return a.prog, nil
+}
+
+// read reads the source from r into the assembler, expanding any INCLUDE
+// directives.
+func (a *assembler) read(name string, r io.Reader, depth int) error {
+	sc := bufio.NewScanner(r)
+	for num := 1; sc.Scan(); num++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+
+		_, op, args := splitLine(text)
+		if op != "INCLUDE" {
+			a.lines = append(a.lines, srcLine{file: name, num: num, text: text})
+			continue
+		}
+
+		if depth == maxIncludeDepth {
+			return &Error{File: name, Line: num, Msg: "includes nested too deeply"}
+		}
+		if err := a.include(name, num, args, depth); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
+
+// include reads the file named by the arguments of an INCLUDE directive on the
+// given line of the named file.
+func (a *assembler) include(name string, num int, args string, depth int) error {
+	file := strings.TrimSpace(args)
+	if len(file) > 1 && (file[0] == '\'' || file[0] == '"') {
+		file = unquote(file)
+	}
+	if file == "" {
+		return &Error{File: name, Line: num, Msg: "missing file name"}
+	}
+
+	dirs := append([]string{filepath.Dir(name)}, a.includeDirs...)
+	if filepath.IsAbs(file) {
+		dirs = []string{""}
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, file)
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return &Error{File: name, Line: num, Msg: err.Error()}
+		}
+		defer f.Close()
+
+		return a.read(path, f, depth+1)
+	}
+
+	return &Error{File: name, Line: num, Msg: fmt.Sprintf("cannot find include file %q", file)}
+}
+
+// assembleLine assembles a single line of source during the current pass. It
+// returns false once the END directive has been reached.
+func (a *assembler) assembleLine(l srcLine) bool {
+	label, op, args := splitLine(l.text)
+
+	ll := &listLine{file: l.file, num: l.num, text: l.text, addr: uint16(a.pc)}
+	if a.pass == 2 {
+		a.prog.lines = append(a.prog.lines, ll)
+	}
+
+	fail := func(err error) bool {
+		if a.pass == 2 || !errors.Is(err, errUndefined) {
+			a.errorf(l, "%v", err)
+		}
+		return true
+	}
+
+	switch op {
+	case "EQU", "SET":
+		if label == "" {
+			return fail(fmt.Errorf("%s requires a name", op))
+		}
+		v, err := a.eval(args)
+		if err != nil {
+			return fail(err)
+		}
+		if err := a.define(label, v, op == "SET"); err != nil {
+			return fail(err)
+		}
+		ll.value, ll.hasValue = uint16(v), true
+		return true
+	}
+
+	if label != "" {
+		if err := a.define(label, a.pc, false); err != nil {
+			fail(err)
+		}
+	}
+	if op == "" {
+		return true
+	}
+	ll.hasAddr = true
+
+	switch op {
+	case "ORG":
+		v, err := a.evalDefined(args)
+		if err != nil {
+			return fail(err)
+		}
+		a.pc = v
+		ll.addr = uint16(v)
+
+	case "DS":
+		v, err := a.evalDefined(args)
+		if err != nil {
+			return fail(err)
+		}
+		a.pc += v
+
+	case "DB":
+		b, err := a.db(args)
+		a.emit(ll, b...)
+		if err != nil {
+			return fail(err)
+		}
+
+	case "DW":
+		for _, arg := range splitArgs(args) {
+			v, err := a.word(arg)
+			a.emit(ll, byte(v), byte(v>>8))
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+	case "END":
+		ll.hasAddr = false
+		if strings.TrimSpace(args) != "" {
+			v, err := a.word(args)
+			if err != nil {
+				return fail(err)
+			}
+			a.prog.Start, a.prog.HasStart = v, true
+		}
+		return false
+
+	default:
+		in, ok := instructions[op]
+		if !ok {
+			return fail(fmt.Errorf("unknown instruction %q", op))
+		}
+		b, err := a.encode(in, splitArgs(args))
+		a.emit(ll, b...)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if a.pc > 0x10000 {
+		return fail(errors.New("location counter exceeds 0FFFFH"))
+	}
+
+	return true
+}
+
+// define sets the value of the named symbol.
+func (a *assembler) define(name string, v int, set bool) error {
+	if !isSymbolStart(name[0]) {
+		return fmt.Errorf("invalid symbol name %q", name)
+	}
+	if _, ok := instructions[name]; ok {
+		return fmt.Errorf("%q is reserved", name)
+	}
+
+	s, ok := a.symbols[name]
+	switch {
+	case !ok:
+		a.symbols[name] = &symbol{value: v, set: set, pass: a.pass}
+		return nil
+	case set && s.set:
+		s.value, s.pass = v, a.pass
+		return nil
+	case s.pass == a.pass:
+		return fmt.Errorf("duplicate symbol %q", name)
+	case s.value != v:
+		return fmt.Errorf("phase error: %q moved from %04XH to %04XH", name, s.value, v)
+	}
+
+	s.pass = a.pass
+	return nil
+}
+
+// lookup returns the value of the named symbol.
+func (a *assembler) lookup(name string) (int, bool) {
+	s, ok := a.symbols[name]
+	if !ok {
+		return 0, false
+	}
+	return s.value, true
+}
+
+// eval evaluates the expression s at the current location.
+func (a *assembler) eval(s string) (int, error) {
+	return eval(s, a.pc, a.lookup)
+}
+
+// evalDefined evaluates an expression which must not contain forward
+// references, because its value is needed in the first pass.
+func (a *assembler) evalDefined(s string) (int, error) {
+	v, err := a.eval(s)
+	if errors.Is(err, errUndefined) {
+		return 0, errors.New("expression must not contain forward references")
+	}
+	return v, err
+}
+
+// byte evaluates an expression which must fit in a single byte.
+func (a *assembler) byte(s string) (byte, error) {
+	v, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < -256 || v > 0xff {
+		return 0, fmt.Errorf("value %d does not fit in a byte", v)
+	}
+	return byte(v), nil
+}
+
+// word evaluates an expression which must fit in a word.
+func (a *assembler) word(s string) (uint16, error) {
+	v, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < -0x10000 || v > 0xffff {
+		return 0, fmt.Errorf("value %d does not fit in a word", v)
+	}
+	return uint16(v), nil
+}
+
+// db returns the bytes defined by the arguments of a DB directive.
+func (a *assembler) db(args string) ([]byte, error) {
+	var b []byte
+	for _, arg := range splitArgs(args) {
+		if len(arg) > 2 && (arg[0] == '\'' || arg[0] == '"') {
+			if end, err := stringEnd(arg, 0); err == nil && end == len(arg) {
+				b = append(b, unquote(arg)...)
+				continue
+			}
+		}
+
+		v, err := a.byte(arg)
+		b = append(b, v)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	return b, nil
+}
+
+// encode returns the machine code for the given instruction and arguments.
+//
+// The returned slice always has the length of the instruction, so that the
+// location counter stays in step even when the arguments are invalid.
+func (a *assembler) encode(in instruction, args []string) ([]byte, error) {
+	b := make([]byte, in.size())
+	b[0] = in.opc
+
+	want := map[operand]int{
+		opNone:      0,
+		opMove:      2,
+		opRegImm8:   2,
+		opPairImm16: 2,
+	}
+	n, ok := want[in.args]
+	if !ok {
+		n = 1
+	}
+	if len(args) != n {
+		return b, fmt.Errorf("expected %d operand(s), got %d", n, len(args))
+	}
+
+	var err error
+	switch in.args {
+	case opSrc:
+		var r int
+		r, err = reg(args[0])
+		b[0] |= byte(r)
+
+	case opDst:
+		var r int
+		r, err = reg(args[0])
+		b[0] |= byte(r) << 3
+
+	case opMove:
+		var d, s int
+		if d, err = reg(args[0]); err != nil {
+			break
+		}
+		if s, err = reg(args[1]); err != nil {
+			break
+		}
+		if d == 6 && s == 6 {
+			return b, errors.New("MOV M,M is not a valid instruction")
+		}
+		b[0] |= byte(d)<<3 | byte(s)
+
+	case opRegImm8:
+		var r int
+		if r, err = reg(args[0]); err != nil {
+			break
+		}
+		b[0] |= byte(r) << 3
+		b[1], err = a.byte(args[1])
+
+	case opImm8:
+		b[1], err = a.byte(args[0])
+
+	case opImm16:
+		var v uint16
+		v, err = a.word(args[0])
+		b[1], b[2] = byte(v), byte(v>>8)
+
+	case opPairImm16:
+		var p int
+		if p, err = pair(args[0], "B", "D", "H", "SP"); err != nil {
+			break
+		}
+		b[0] |= byte(p) << 4
+
+		var v uint16
+		v, err = a.word(args[1])
+		b[1], b[2] = byte(v), byte(v>>8)
+
+	case opPair:
+		var p int
+		p, err = pair(args[0], "B", "D", "H", "SP")
+		b[0] |= byte(p) << 4
+
+	case opPairPSW:
+		var p int
+		p, err = pair(args[0], "B", "D", "H", "PSW")
+		b[0] |= byte(p) << 4
+
+	case opPairBD:
+		var p int
+		p, err = pair(args[0], "B", "D")
+		b[0] |= byte(p) << 4
+
+	case opRestart:
+		var v int
+		if v, err = a.eval(args[0]); err != nil {
+			break
+		}
+		if v < 0 || v > 7 {
+			return b, fmt.Errorf("restart number %d out of range", v)
+		}
+		b[0] |= byte(v) << 3
+	}
+
+	return b, err
+}
+
+// reg returns the encoding of the named register.
+func reg(s string) (int, error) {
+	r, ok := registers[strings.ToUpper(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("invalid register %q", s)
+	}
+	return r, nil
+}
+
+// pair returns the encoding of the named register pair, which must be one of
+// the valid names given.
+func pair(s string, valid ...string) (int, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if !contains(valid, name) {
+		return 0, fmt.Errorf("invalid register pair %q", s)
+	}
+	return pairs[name], nil
+}
+
+// emit adds the given bytes to the program at the current location, and to the
+// listing line.
+func (a *assembler) emit(ll *listLine, b ...byte) {
+	if a.pass == 2 {
+		a.prog.write(uint16(a.pc), b)
+		ll.bytes = append(ll.bytes, b...)
+	}
+	a.pc += len(b)
+}
+
+// errorf records an error against the given line.
+func (a *assembler) errorf(l srcLine, format string, args ...interface{}) {
+	if _, ok := a.errs[l]; ok {
+		return
+	}
+
+	a.errs[l] = &Error{
+		File: l.file,
+		Line: l.num,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// splitLine splits a line of source into its label, upper cased operation and
+// arguments, discarding any comment.
+func splitLine(text string) (label, op, args string) {
+	text = stripComment(text)
+
+	fields := func(s string) (string, string) {
+		s = strings.TrimLeft(s, " \t")
+		i := strings.IndexAny(s, " \t")
+		if i == -1 {
+			return s, ""
+		}
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+
+	first, rest := fields(text)
+	if first == "" {
+		return "", "", ""
+	}
+
+	upper := strings.ToUpper(first)
+	switch {
+	case strings.HasSuffix(first, ":"):
+		// An explicitly marked label, in any column.
+		label = strings.ToUpper(strings.TrimSuffix(first, ":"))
+		op, args = fields(rest)
+
+	case text[0] != ' ' && text[0] != '\t' && !isKeyword(upper):
+		// A name in the first column.
+		label = upper
+		op, args = fields(rest)
+
+	default:
+		// A name followed by EQU or SET need not start in the first column.
+		if next, nextArgs := fields(rest); isAssignment(next) {
+			return upper, strings.ToUpper(next), nextArgs
+		}
+		op, args = first, rest
+	}
+
+	return label, strings.ToUpper(op), args
+}
+
+// isKeyword returns true if s is an instruction or directive.
+func isKeyword(s string) bool {
+	if _, ok := instructions[s]; ok {
+		return true
+	}
+	switch s {
+	case "ORG", "EQU", "SET", "DB", "DW", "DS", "END", "INCLUDE":
+		return true
+	}
+	return false
+}
+
+// isAssignment returns true if s is the EQU or SET directive.
+func isAssignment(s string) bool {
+	s = strings.ToUpper(s)
+	return s == "EQU" || s == "SET"
+}
+
+// stripComment removes a trailing comment from a line of source, taking care
+// not to mistake a semicolon within a string for the start of a comment.
+func stripComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case ';':
+			return text[:i]
+		case '\'', '"':
+			end, err := stringEnd(text, i)
+			if err != nil {
+				return text
+			}
+			i = end - 1
+		}
+	}
+	return text
+}
+
+// splitArgs splits a comma separated argument list, ignoring commas within
+// strings and parentheses.
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	var (
+		args  []string
+		depth int
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '\'', '"':
+			if end, err := stringEnd(s, i); err == nil {
+				i = end - 1
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(args, strings.TrimSpace(s[start:]))
+}
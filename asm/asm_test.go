@@ -0,0 +1,219 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/disasm"
+)
+
+const testSource = `; Print a message using CP/M.
+BDOS	EQU	5
+PRINT	EQU	9
+CR	EQU	0DH
+LF	EQU	0AH
+
+	ORG	100H
+START:	LXI	D,MSG
+	MVI	C,PRINT
+	CALL	BDOS
+	LXI	H,TABLE+2*2
+	MOV	A,M
+	CPI	HIGH(TABLE) AND 0FFH
+	JZ	DONE
+	MVI	A,LOW -1
+DONE:	RST	0
+
+MSG:	DB	'Hello; world',CR,LF,'$'
+TABLE:	DW	1234H,START,$
+	DS	2
+	DB	10101010B,17O,255D,'''',"A"+1
+	END	START
+`
+
+func TestAssemble(t *testing.T) {
+	prog, err := Assemble("test.asm", strings.NewReader(testSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x11, 0x14, 0x01, // LXI D,MSG
+		0x0e, 0x09, // MVI C,PRINT
+		0xcd, 0x05, 0x00, // CALL BDOS
+		0x21, 0x27, 0x01, // LXI H,TABLE+4
+		0x7e,       // MOV A,M
+		0xfe, 0x01, // CPI HIGH(TABLE) AND 0FFH
+		0xca, 0x13, 0x01, // JZ DONE
+		0x3e, 0xff, // MVI A,LOW -1
+		0xc7, // RST 0
+	}
+	want = append(want, "Hello; world\r\n$"...)
+	want = append(want,
+		0x34, 0x12, 0x00, 0x01, 0x27, 0x01, // DW
+		0x00, 0x00, // DS
+		0xaa, 0x0f, 0xff, '\'', 'B', // DB
+	)
+
+	if org := prog.Origin(); org != 0x100 {
+		t.Errorf("origin = %04x, want 0100", org)
+	}
+	if got := prog.Binary(); !bytes.Equal(got, want) {
+		t.Errorf("binary =\n% x\nwant\n% x", got, want)
+	}
+	if !prog.HasStart || prog.Start != 0x100 {
+		t.Errorf("start = %04x (%v), want 0100", prog.Start, prog.HasStart)
+	}
+	if got := prog.Symbols["TABLE"]; got != 0x123 {
+		t.Errorf("TABLE = %04x, want 0123", got)
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	lookup := func(name string) (int, bool) {
+		return map[string]int{"FOO": 0x1234}[name], name == "FOO"
+	}
+
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"HIGH FOO", 0x12},
+		{"LOW FOO+1", 0x35},
+		{"0FFH AND NOT 0FH", 0xf0},
+		{"1 SHL 4 OR 1", 0x11},
+		{"FOO SHR 8", 0x12},
+		{"17 MOD 5", 2},
+		{"$+3", 0x103},
+		{"'AB'", 0x4142},
+		{"2 EQ 2", 0xffff},
+		{"2 GT 3", 0},
+		{"-1", -1},
+		{"777Q", 0x1ff},
+	}
+	for _, tt := range tests {
+		got, err := eval(tt.expr, 0x100, lookup)
+		if err != nil {
+			t.Errorf("eval(%q): %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("eval(%q) = %#x, want %#x", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	src := "\tMOV\tA,Q\n\tJMP\tNOWHERE\nX:\tNOP\nX:\tNOP\n\tFOO\n\tMVI\tA,300\n"
+
+	_, err := Assemble("bad.asm", strings.NewReader(src))
+	el, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("error = %v, want ErrorList", err)
+	}
+
+	want := []string{
+		`bad.asm:1: invalid register "Q"`,
+		`bad.asm:2: undefined symbol: NOWHERE`,
+		`bad.asm:4: duplicate symbol "X"`,
+		`bad.asm:5: unknown instruction "FOO"`,
+		`bad.asm:6: value 300 does not fit in a byte`,
+	}
+	if len(el) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(el), len(want), el)
+	}
+	for i, e := range el {
+		if e.Error() != want[i] {
+			t.Errorf("error %d = %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.asm":         "\tINCLUDE\t'defs.inc'\n\tMVI\tA,VALUE\n\tEND\n",
+		"inc/defs.inc":     "\tINCLUDE\tmore.inc\nVALUE\tEQU\tOTHER+1\n",
+		"inc/sub/more.inc": "OTHER\tEQU\t41H\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prog, err := AssembleFile(
+		filepath.Join(dir, "main.asm"),
+		WithIncludeDirs(filepath.Join(dir, "inc"), filepath.Join(dir, "inc", "sub")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prog.Binary(), []byte{0x3e, 0x42}; !bytes.Equal(got, want) {
+		t.Errorf("binary = % x, want % x", got, want)
+	}
+}
+
+func TestHexAndListing(t *testing.T) {
+	prog, err := Assemble("test.asm", strings.NewReader("\tORG\t0C000H\nL:\tJMP\tL\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hex bytes.Buffer
+	if err = prog.WriteHex(&hex); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.String(), ":03C00000C300C0BA\n:00000001FF\n"; got != want {
+		t.Errorf("hex =\n%s\nwant\n%s", got, want)
+	}
+
+	var lst bytes.Buffer
+	if err = prog.WriteListing(&lst); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{" C000  C3 00 C0         2  L:\tJMP\tL\n", "C000  L\n"} {
+		if !strings.Contains(lst.String(), want) {
+			t.Errorf("listing does not contain %q:\n%s", want, lst.String())
+		}
+	}
+}
+
+// TestDisassemblyRoundTrip checks that a listing from the disassembler
+// assembles back into the original image.
+func TestDisassemblyRoundTrip(t *testing.T) {
+	prog, err := Assemble("test.asm", strings.NewReader(testSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := prog.Binary()
+
+	var src bytes.Buffer
+	d := disasm.New(rom, disasm.WithOrigin(0x100), disasm.WithEntryPoints(0x100))
+	if err = d.Listing(&src); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := Assemble("disasm.asm", &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Binary(), rom) {
+		t.Errorf("round trip =\n% x\nwant\n% x", again.Binary(), rom)
+	}
+}
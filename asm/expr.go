@@ -0,0 +1,357 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errUndefined is returned when an expression refers to a symbol which has not
+// been defined yet.
+var errUndefined = errors.New("undefined symbol")
+
+// operator precedence, from lowest to highest, following the Intel 8080
+// assembler:
+//
+//	OR XOR
+//	AND
+//	NOT
+//	EQ NE LT LE GT GE
+//	+ - (binary)
+//	* / MOD SHL SHR
+//	HIGH LOW + - (unary)
+var binaryOps = [][]string{
+	{"OR", "XOR"},
+	{"AND"},
+	nil, // NOT is unary and handled separately.
+	{"EQ", "NE", "LT", "LE", "GT", "GE"},
+	{"+", "-"},
+	{"*", "/", "MOD", "SHL", "SHR"},
+}
+
+// notLevel is the precedence level of the NOT operator.
+const notLevel = 2
+
+// exprParser is a recursive descent parser and evaluator for operand
+// expressions.
+type exprParser struct {
+	toks []string
+	pos  int
+
+	// Resolves symbols, returning false if the symbol is not defined.
+	lookup func(string) (int, bool)
+
+	// Value of the location counter, referenced by '$'.
+	pc int
+
+	// The first undefined symbol the expression referred to, if any.
+	undefined string
+}
+
+// eval evaluates the expression s, using lookup to resolve symbols and pc as
+// the value of the location counter.
+//
+// If the expression refers to a symbol which is not yet defined the error wraps
+// errUndefined, naming the symbol, and the returned value should not be relied
+// upon.
+func eval(s string, pc int, lookup func(string) (int, bool)) (int, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(toks) == 0 {
+		return 0, errors.New("missing expression")
+	}
+
+	p := &exprParser{toks: toks, lookup: lookup, pc: pc}
+	v, err := p.parse(0)
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.toks) {
+		return 0, fmt.Errorf("unexpected %q in expression", p.toks[p.pos])
+	}
+	if p.undefined != "" {
+		return v, fmt.Errorf("%w: %s", errUndefined, p.undefined)
+	}
+
+	return v, nil
+}
+
+// peek returns the next token, upper cased, without consuming it.
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return strings.ToUpper(p.toks[p.pos])
+}
+
+// parse parses a binary expression at the given precedence level.
+func (p *exprParser) parse(level int) (int, error) {
+	if level == len(binaryOps) {
+		return p.unary()
+	}
+	if level == notLevel {
+		if p.peek() == "NOT" {
+			p.pos++
+			v, err := p.parse(level)
+			return ^v, err
+		}
+		return p.parse(level + 1)
+	}
+
+	lhs, err := p.parse(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.peek()
+		if !contains(binaryOps[level], op) {
+			return lhs, nil
+		}
+		p.pos++
+
+		rhs, err := p.parse(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		if lhs, err = apply(op, lhs, rhs); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// unary parses a unary operator or a primary value.
+func (p *exprParser) unary() (int, error) {
+	switch p.peek() {
+	case "-":
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case "+":
+		p.pos++
+		return p.unary()
+	case "HIGH":
+		p.pos++
+		v, err := p.unary()
+		return (v >> 8) & 0xff, err
+	case "LOW":
+		p.pos++
+		v, err := p.unary()
+		return v & 0xff, err
+	}
+
+	return p.primary()
+}
+
+// primary parses a number, string, symbol or parenthesised expression.
+func (p *exprParser) primary() (int, error) {
+	if p.pos >= len(p.toks) {
+		return 0, errors.New("unexpected end of expression")
+	}
+	tok := p.toks[p.pos]
+	p.pos++
+
+	switch {
+	case tok == "(":
+		v, err := p.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ")" {
+			return 0, errors.New("missing ')' in expression")
+		}
+		p.pos++
+		return v, nil
+
+	case tok == "$":
+		return p.pc, nil
+
+	case tok[0] == '\'' || tok[0] == '"':
+		s := unquote(tok)
+		if len(s) == 0 || len(s) > 2 {
+			return 0, fmt.Errorf("string %s cannot be used as a value", tok)
+		}
+		v := 0
+		for j := 0; j < len(s); j++ {
+			v = v<<8 | int(s[j])
+		}
+		return v, nil
+
+	case tok[0] >= '0' && tok[0] <= '9':
+		return parseNumber(tok)
+
+	case isSymbolStart(tok[0]):
+		name := strings.ToUpper(tok)
+		v, ok := p.lookup(name)
+		if !ok && p.undefined == "" {
+			p.undefined = name
+		}
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("unexpected %q in expression", tok)
+}
+
+// apply applies the binary operator op to the operands.
+func apply(op string, lhs, rhs int) (int, error) {
+	switch op {
+	case "+":
+		return lhs + rhs, nil
+	case "-":
+		return lhs - rhs, nil
+	case "*":
+		return lhs * rhs, nil
+	case "/", "MOD":
+		if rhs == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if op == "/" {
+			return lhs / rhs, nil
+		}
+		return lhs % rhs, nil
+	case "SHL":
+		return lhs << uint(rhs&0x1f), nil
+	case "SHR":
+		return int(uint16(lhs) >> uint(rhs&0x1f)), nil
+	case "AND":
+		return lhs & rhs, nil
+	case "OR":
+		return lhs | rhs, nil
+	case "XOR":
+		return lhs ^ rhs, nil
+	}
+
+	// Relational operators yield all ones for true and zero for false.
+	var b bool
+	switch op {
+	case "EQ":
+		b = uint16(lhs) == uint16(rhs)
+	case "NE":
+		b = uint16(lhs) != uint16(rhs)
+	case "LT":
+		b = uint16(lhs) < uint16(rhs)
+	case "LE":
+		b = uint16(lhs) <= uint16(rhs)
+	case "GT":
+		b = uint16(lhs) > uint16(rhs)
+	case "GE":
+		b = uint16(lhs) >= uint16(rhs)
+	}
+	if b {
+		return 0xffff, nil
+	}
+	return 0, nil
+}
+
+// parseNumber parses a number written with an optional Intel radix suffix:
+// B for binary, O or Q for octal, D for decimal and H for hexadecimal.
+func parseNumber(tok string) (int, error) {
+	s := strings.ToUpper(tok)
+
+	base := 10
+	switch s[len(s)-1] {
+	case 'H':
+		base = 16
+	case 'O', 'Q':
+		base = 8
+	case 'B':
+		base = 2
+	case 'D':
+		base = 10
+	}
+	if s[len(s)-1] > '9' {
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", tok)
+	}
+
+	return int(v), nil
+}
+
+// tokenize splits an expression into numbers, symbols, strings and operators.
+func tokenize(s string) ([]string, error) {
+	var toks []string
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case c == '\'' || c == '"':
+			j, err := stringEnd(s, i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, s[i:j])
+			i = j
+
+		case isSymbolChar(c):
+			j := i + 1
+			for j < len(s) && isSymbolChar(s[j]) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+
+		case strings.IndexByte("+-*/()$", c) != -1:
+			toks = append(toks, s[i:i+1])
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q in expression", c)
+		}
+	}
+
+	return toks, nil
+}
+
+// stringEnd returns the index just past the end of the quoted string starting
+// at s[i]. A doubled quote character within the string stands for itself.
+func stringEnd(s string, i int) (int, error) {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if s[j] != q {
+			continue
+		}
+		if j+1 < len(s) && s[j+1] == q {
+			j++
+			continue
+		}
+		return j + 1, nil
+	}
+
+	return 0, errors.New("unterminated string")
+}
+
+// unquote returns the contents of the quoted string tok.
+func unquote(tok string) string {
+	q := tok[:1]
+	return strings.Replace(tok[1:len(tok)-1], q+q, q, -1)
+}
+
+// isSymbolStart returns true if c may start a symbol name.
+func isSymbolStart(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_' || c == '?' || c == '@' || c == '.'
+}
+
+// isSymbolChar returns true if c may appear within a symbol name or number.
+func isSymbolChar(c byte) bool {
+	return isSymbolStart(c) || c >= '0' && c <= '9'
+}
+
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
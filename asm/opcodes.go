@@ -0,0 +1,177 @@
+package asm
+
+// operand describes the operands taken by an instruction, and therefore how it
+// is encoded.
+type operand int
+
+const (
+	// No operands.
+	opNone operand = iota
+
+	// A source register in bits 0-2, e.g. ADD r.
+	opSrc
+
+	// A destination register in bits 3-5, e.g. INR r.
+	opDst
+
+	// A destination and source register, e.g. MOV d,s.
+	opMove
+
+	// A destination register and an immediate byte, e.g. MVI r,data.
+	opRegImm8
+
+	// An immediate byte, e.g. ADI data.
+	opImm8
+
+	// An immediate word, e.g. JMP addr.
+	opImm16
+
+	// A register pair (B, D, H or SP) in bits 4-5 and an immediate word, e.g.
+	// LXI rp,data.
+	opPairImm16
+
+	// A register pair (B, D, H or SP) in bits 4-5, e.g. INX rp.
+	opPair
+
+	// A register pair (B, D, H or PSW) in bits 4-5, e.g. PUSH rp.
+	opPairPSW
+
+	// The register pair B or D in bit 4, e.g. LDAX rp.
+	opPairBD
+
+	// A restart number in bits 3-5, e.g. RST n.
+	opRestart
+)
+
+// instruction describes how to encode an 8080 instruction.
+type instruction struct {
+	opc  byte
+	args operand
+}
+
+// instructions maps each 8080 mnemonic to its encoding.
+var instructions = map[string]instruction{
+	"NOP":  {0x00, opNone},
+	"RLC":  {0x07, opNone},
+	"RRC":  {0x0f, opNone},
+	"RAL":  {0x17, opNone},
+	"RAR":  {0x1f, opNone},
+	"DAA":  {0x27, opNone},
+	"CMA":  {0x2f, opNone},
+	"STC":  {0x37, opNone},
+	"CMC":  {0x3f, opNone},
+	"HLT":  {0x76, opNone},
+	"RIM":  {0x20, opNone},
+	"SIM":  {0x30, opNone},
+	"RET":  {0xc9, opNone},
+	"RNZ":  {0xc0, opNone},
+	"RZ":   {0xc8, opNone},
+	"RNC":  {0xd0, opNone},
+	"RC":   {0xd8, opNone},
+	"RPO":  {0xe0, opNone},
+	"RPE":  {0xe8, opNone},
+	"RP":   {0xf0, opNone},
+	"RM":   {0xf8, opNone},
+	"XTHL": {0xe3, opNone},
+	"PCHL": {0xe9, opNone},
+	"XCHG": {0xeb, opNone},
+	"DI":   {0xf3, opNone},
+	"SPHL": {0xf9, opNone},
+	"EI":   {0xfb, opNone},
+
+	"ADD": {0x80, opSrc},
+	"ADC": {0x88, opSrc},
+	"SUB": {0x90, opSrc},
+	"SBB": {0x98, opSrc},
+	"ANA": {0xa0, opSrc},
+	"XRA": {0xa8, opSrc},
+	"ORA": {0xb0, opSrc},
+	"CMP": {0xb8, opSrc},
+
+	"INR": {0x04, opDst},
+	"DCR": {0x05, opDst},
+
+	"MOV": {0x40, opMove},
+	"MVI": {0x06, opRegImm8},
+
+	"ADI": {0xc6, opImm8},
+	"ACI": {0xce, opImm8},
+	"SUI": {0xd6, opImm8},
+	"SBI": {0xde, opImm8},
+	"ANI": {0xe6, opImm8},
+	"XRI": {0xee, opImm8},
+	"ORI": {0xf6, opImm8},
+	"CPI": {0xfe, opImm8},
+	"OUT": {0xd3, opImm8},
+	"IN":  {0xdb, opImm8},
+
+	"SHLD": {0x22, opImm16},
+	"LHLD": {0x2a, opImm16},
+	"STA":  {0x32, opImm16},
+	"LDA":  {0x3a, opImm16},
+	"JMP":  {0xc3, opImm16},
+	"JNZ":  {0xc2, opImm16},
+	"JZ":   {0xca, opImm16},
+	"JNC":  {0xd2, opImm16},
+	"JC":   {0xda, opImm16},
+	"JPO":  {0xe2, opImm16},
+	"JPE":  {0xea, opImm16},
+	"JP":   {0xf2, opImm16},
+	"JM":   {0xfa, opImm16},
+	"CALL": {0xcd, opImm16},
+	"CNZ":  {0xc4, opImm16},
+	"CZ":   {0xcc, opImm16},
+	"CNC":  {0xd4, opImm16},
+	"CC":   {0xdc, opImm16},
+	"CPO":  {0xe4, opImm16},
+	"CPE":  {0xec, opImm16},
+	"CP":   {0xf4, opImm16},
+	"CM":   {0xfc, opImm16},
+
+	"LXI": {0x01, opPairImm16},
+
+	"INX": {0x03, opPair},
+	"DAD": {0x09, opPair},
+	"DCX": {0x0b, opPair},
+
+	"POP":  {0xc1, opPairPSW},
+	"PUSH": {0xc5, opPairPSW},
+
+	"STAX": {0x02, opPairBD},
+	"LDAX": {0x0a, opPairBD},
+
+	"RST": {0xc7, opRestart},
+}
+
+// size returns the number of bytes occupied by the encoded instruction.
+func (in instruction) size() int {
+	switch in.args {
+	case opRegImm8, opImm8:
+		return 2
+	case opImm16, opPairImm16:
+		return 3
+	}
+	return 1
+}
+
+// registers maps register names to their 3-bit encoding.
+var registers = map[string]int{
+	"B": 0,
+	"C": 1,
+	"D": 2,
+	"E": 3,
+	"H": 4,
+	"L": 5,
+	"M": 6,
+	"A": 7,
+}
+
+// pairs maps register pair names to their 2-bit encoding. PSW shares the
+// encoding of SP and is only valid for PUSH and POP.
+var pairs = map[string]int{
+	"B":   0,
+	"D":   1,
+	"H":   2,
+	"SP":  3,
+	"PSW": 3,
+}
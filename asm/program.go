@@ -0,0 +1,185 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/danmrichards/go8080/ihex"
+)
+
+type (
+	// Program is the result of assembling a source file.
+	Program struct {
+		// Symbols holds the value of every symbol defined by the program, keyed
+		// by upper case name.
+		Symbols map[string]uint16
+
+		// Start is the entry point given by the END directive, only valid if
+		// HasStart is true.
+		Start    uint16
+		HasStart bool
+
+		// Contiguous runs of generated code, in the order they were generated.
+		chunks []chunk
+
+		// The listing, one entry per line of source.
+		lines []*listLine
+	}
+
+	// chunk is a contiguous run of generated code.
+	chunk struct {
+		addr uint16
+		data []byte
+	}
+
+	// listLine is a single line of the listing.
+	listLine struct {
+		file string
+		num  int
+		text string
+
+		// The location counter at the start of the line, only shown if hasAddr
+		// is true.
+		addr    uint16
+		hasAddr bool
+
+		// The value assigned by EQU or SET, only shown if hasValue is true.
+		value    uint16
+		hasValue bool
+
+		// The bytes generated by the line.
+		bytes []byte
+	}
+)
+
+// write adds the given bytes to the program at addr.
+func (p *Program) write(addr uint16, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	if n := len(p.chunks); n > 0 {
+		last := &p.chunks[n-1]
+		if int(last.addr)+len(last.data) == int(addr) {
+			last.data = append(last.data, b...)
+			return
+		}
+	}
+
+	p.chunks = append(p.chunks, chunk{addr: addr, data: append([]byte(nil), b...)})
+}
+
+// Origin returns the lowest address occupied by the program.
+func (p *Program) Origin() uint16 {
+	if len(p.chunks) == 0 {
+		return 0
+	}
+
+	org := p.chunks[0].addr
+	for _, c := range p.chunks[1:] {
+		if c.addr < org {
+			org = c.addr
+		}
+	}
+
+	return org
+}
+
+// Binary returns the program as a raw memory image, starting at the address
+// returned by Origin. Gaps between the code, such as those reserved by DS, are
+// filled with zeros.
+func (p *Program) Binary() []byte {
+	org := int(p.Origin())
+
+	var end int
+	for _, c := range p.chunks {
+		if e := int(c.addr) + len(c.data); e > end {
+			end = e
+		}
+	}
+	if end <= org {
+		return nil
+	}
+
+	b := make([]byte, end-org)
+	for _, c := range p.chunks {
+		copy(b[int(c.addr)-org:], c.data)
+	}
+
+	return b
+}
+
+// WriteHex writes the program to w in Intel HEX format, including the start
+// address if one was given.
+func (p *Program) WriteHex(w io.Writer) error {
+	hw := ihex.NewWriter(w)
+	for _, c := range p.chunks {
+		hw.WriteData(c.addr, c.data)
+	}
+	if p.HasStart {
+		hw.WriteStart(p.Start)
+	}
+
+	return hw.Close()
+}
+
+// WriteListing writes a listing of the program to w, showing the address and
+// generated code for each line of source, followed by the symbol table.
+func (p *Program) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	// The number of bytes of code shown on each line of the listing.
+	const perLine = 4
+
+	for _, l := range p.lines {
+		var addr, code string
+		switch {
+		case l.hasValue:
+			addr = fmt.Sprintf("=%04X", l.value)
+		case l.hasAddr:
+			addr = fmt.Sprintf(" %04X", l.addr)
+		}
+
+		first := l.bytes
+		if len(first) > perLine {
+			first = first[:perLine]
+		}
+		code = hexBytes(first)
+
+		fmt.Fprintf(bw, "%-5s  %-12s %5d  %s\n", addr, code, l.num, l.text)
+
+		// Continue long runs of code on the following lines.
+		for off := perLine; off < len(l.bytes); off += perLine {
+			end := off + perLine
+			if end > len(l.bytes) {
+				end = len(l.bytes)
+			}
+			fmt.Fprintf(bw, " %04X  %s\n", int(l.addr)+off, hexBytes(l.bytes[off:end]))
+		}
+	}
+
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(bw, "\nSymbols:\n")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%04X  %s\n", p.Symbols[name], name)
+	}
+
+	return bw.Flush()
+}
+
+// hexBytes returns b as space separated hexadecimal bytes.
+func hexBytes(b []byte) string {
+	s := make([]string, len(b))
+	for i, v := range b {
+		s[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(s, " ")
+}
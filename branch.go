@@ -40,6 +40,7 @@ func (i *Intel8080) jnz() {
 
 	if !i.cc.z {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -54,6 +55,7 @@ func (i *Intel8080) jz() {
 
 	if i.cc.z {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -68,6 +70,7 @@ func (i *Intel8080) jnc() {
 
 	if !i.cc.cy {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -82,6 +85,7 @@ func (i *Intel8080) jc() {
 
 	if i.cc.cy {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -96,6 +100,7 @@ func (i *Intel8080) jpo() {
 
 	if !i.cc.p {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -110,6 +115,7 @@ func (i *Intel8080) jpe() {
 
 	if i.cc.p {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -124,6 +130,7 @@ func (i *Intel8080) jp() {
 
 	if !i.cc.s {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -138,6 +145,7 @@ func (i *Intel8080) jm() {
 
 	if i.cc.s {
 		i.pc = addr
+		i.cyc += i.timing.jump
 	}
 }
 
@@ -152,7 +160,7 @@ func (i *Intel8080) cz() {
 	if i.cc.z {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -167,7 +175,7 @@ func (i *Intel8080) cnz() {
 	if !i.cc.z {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -182,7 +190,7 @@ func (i *Intel8080) cic() {
 	if i.cc.cy {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -197,7 +205,7 @@ func (i *Intel8080) cnc() {
 	if !i.cc.cy {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -213,7 +221,7 @@ func (i *Intel8080) cpo() {
 	if !i.cc.p {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -229,7 +237,7 @@ func (i *Intel8080) cpe() {
 	if i.cc.p {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -245,7 +253,7 @@ func (i *Intel8080) cp() {
 	if !i.cc.s {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -261,7 +269,7 @@ func (i *Intel8080) cm() {
 	if i.cc.s {
 		i.stackAdd(i.pc)
 		i.pc = addr
-		i.cyc += 6
+		i.cyc += i.timing.call
 	}
 }
 
@@ -271,7 +279,7 @@ func (i *Intel8080) cm() {
 func (i *Intel8080) rnz() {
 	if !i.cc.z {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -281,7 +289,7 @@ func (i *Intel8080) rnz() {
 func (i *Intel8080) rz() {
 	if i.cc.z {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -291,7 +299,7 @@ func (i *Intel8080) rz() {
 func (i *Intel8080) rnc() {
 	if !i.cc.cy {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -301,7 +309,7 @@ func (i *Intel8080) rnc() {
 func (i *Intel8080) rc() {
 	if i.cc.cy {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -312,7 +320,7 @@ func (i *Intel8080) rc() {
 func (i *Intel8080) rpo() {
 	if !i.cc.p {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -323,7 +331,7 @@ func (i *Intel8080) rpo() {
 func (i *Intel8080) rpe() {
 	if i.cc.p {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -334,7 +342,7 @@ func (i *Intel8080) rpe() {
 func (i *Intel8080) rp() {
 	if !i.cc.s {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -345,7 +353,7 @@ func (i *Intel8080) rp() {
 func (i *Intel8080) rm() {
 	if i.cc.s {
 		i.ret()
-		i.cyc += 6
+		i.cyc += i.timing.ret
 	}
 }
 
@@ -0,0 +1,76 @@
+// Package bus connects peripheral devices to the I/O ports of the 8080.
+//
+// The CPU's input and output handlers see only a port number; a Ports map
+// routes each access to the device decoding that port, so machines can be
+// assembled from independent devices.
+package bus
+
+import (
+	"fmt"
+)
+
+// Floating is the value read from a port no device responds to.
+const Floating = 0xff
+
+type (
+	// Device is the interface implemented by a peripheral decoding a range of
+	// consecutive I/O ports.
+	//
+	// Ports returns the number of ports decoded.
+	//
+	// In returns the value read from the port at offset from the device's
+	// base port, and Out writes v to it.
+	Device interface {
+		Ports() int
+		In(offset byte) byte
+		Out(offset, v byte)
+	}
+
+	// Ports maps the 256 I/O ports to the devices attached to them.
+	Ports struct {
+		ports [256]port
+	}
+
+	// port is a single port and the device decoding it.
+	port struct {
+		dev    Device
+		offset byte
+	}
+)
+
+// Attach attaches d to the ports starting at base. It is an error for the
+// ports to overlap those of another device or run past port 0FFH.
+func (p *Ports) Attach(base byte, d Device) error {
+	n := d.Ports()
+	if int(base)+n > len(p.ports) {
+		return fmt.Errorf("bus: device at port %02xh decodes %d ports, past port 0ffh", base, n)
+	}
+	for i := 0; i < n; i++ {
+		if p.ports[int(base)+i].dev != nil {
+			return fmt.Errorf("bus: port %02xh is already in use", int(base)+i)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		p.ports[int(base)+i] = port{dev: d, offset: byte(i)}
+	}
+
+	return nil
+}
+
+// In returns the value read from the given port, or Floating if no device
+// is attached to it.
+func (p *Ports) In(n byte) byte {
+	if pt := p.ports[n]; pt.dev != nil {
+		return pt.dev.In(pt.offset)
+	}
+
+	return Floating
+}
+
+// Out writes v to the device attached to the given port, if any.
+func (p *Ports) Out(n, v byte) {
+	if pt := p.ports[n]; pt.dev != nil {
+		pt.dev.Out(pt.offset, v)
+	}
+}
@@ -0,0 +1,39 @@
+package bus
+
+import (
+	"testing"
+)
+
+// latch is a device which reads back the last value written to each port.
+type latch []byte
+
+func (l latch) Ports() int          { return len(l) }
+func (l latch) In(offset byte) byte { return l[offset] }
+func (l latch) Out(offset, v byte)  { l[offset] = v }
+
+func TestPorts(t *testing.T) {
+	var p Ports
+
+	d := make(latch, 2)
+	if err := p.Attach(0x10, d); err != nil {
+		t.Fatal(err)
+	}
+	p.Out(0x11, 0x42)
+	if d[1] != 0x42 {
+		t.Errorf("offset 1 = %02x, want 42", d[1])
+	}
+	if got := p.In(0x11); got != 0x42 {
+		t.Errorf("In(11H) = %02x, want 42", got)
+	}
+	if got := p.In(0x12); got != Floating {
+		t.Errorf("In(12H) = %02x, want %02x", got, Floating)
+	}
+	p.Out(0x12, 0)
+
+	if err := p.Attach(0x11, make(latch, 1)); err == nil {
+		t.Error("Attach succeeded over another device")
+	}
+	if err := p.Attach(0xff, make(latch, 2)); err == nil {
+		t.Error("Attach succeeded past port 0FFH")
+	}
+}
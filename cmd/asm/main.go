@@ -0,0 +1,86 @@
+// Command asm assembles Intel 8080 source into a raw binary or Intel HEX file,
+// optionally writing a listing.
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danmrichards/go8080/asm"
+)
+
+var (
+	outPath     string
+	format      string
+	listPath    string
+	includeDirs string
+)
+
+func main() {
+	flag.StringVar(&outPath, "o", "", "Path to the output file (default: source path with the format's extension)")
+	flag.StringVar(&format, "format", "bin", "Output format: bin or hex")
+	flag.StringVar(&listPath, "l", "", "Path to write the listing to")
+	flag.StringVar(&includeDirs, "I", "", "Colon separated list of directories to search for include files")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatal("usage: asm [flags] source.asm")
+	}
+	src := flag.Arg(0)
+
+	var opts []asm.Option
+	if includeDirs != "" {
+		opts = append(opts, asm.WithIncludeDirs(filepath.SplitList(includeDirs)...))
+	}
+
+	prog, err := asm.AssembleFile(src, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if outPath == "" {
+		ext := ".bin"
+		if format == "hex" {
+			ext = ".hex"
+		}
+		outPath = strings.TrimSuffix(src, filepath.Ext(src)) + ext
+	}
+
+	switch format {
+	case "bin":
+		err = ioutil.WriteFile(outPath, prog.Binary(), 0644)
+	case "hex":
+		err = writeFile(outPath, prog.WriteHex)
+	default:
+		log.Fatalf("unknown output format %q", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if listPath != "" {
+		if err = writeFile(listPath, prog.WriteListing); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// writeFile creates the file at path and writes its contents with fn.
+func writeFile(path string, fn func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+// Command cpm runs a CP/M 2.2 .COM program with the console connected to
+// stdin and stdout and the drives mapped to host directories.
+//
+// Arguments after the program are passed to it as the CCP would, so
+//
+//	cpm -B src ASM.COM B:HELLO
+//
+// assembles src/HELLO.ASM with the CP/M assembler.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/cpm"
+)
+
+var (
+	drives [16]string
+	raw    bool
+	debug  bool
+)
+
+func main() {
+	for d := range drives {
+		letter := string(rune('A' + d))
+		flag.StringVar(&drives[d], letter, "", "Host directory for drive "+letter+":")
+	}
+	flag.BoolVar(&raw, "raw", false, "Put the terminal into raw mode while the program runs")
+	flag.BoolVar(&debug, "debug", false, "Run the emulator in debug mode")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: cpm [flags] program.com [args...]")
+	}
+	if drives[0] == "" {
+		drives[0] = "."
+	}
+
+	opts := []cpm.Option{cpm.WithConsole(os.Stdin, os.Stdout)}
+	for d, dir := range drives {
+		if dir != "" {
+			opts = append(opts, cpm.WithDrive(byte('A'+d), dir))
+		}
+	}
+	if debug {
+		opts = append(opts, cpm.WithCPUOptions(go8080.WithDebugEnabled()))
+	}
+
+	m := cpm.New(opts...)
+	if err := m.LoadFile(programPath(flag.Arg(0))); err != nil {
+		log.Fatal(err)
+	}
+	m.SetArgs(flag.Args()[1:]...)
+
+	if err := run(m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the program, with the terminal in raw mode if requested. The
+// terminal is restored before returning.
+func run(m *cpm.Machine) error {
+	if raw {
+		restore, err := makeRaw(os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	return m.Run()
+}
+
+// programPath returns the path of the program to run, adding a .COM extension
+// if the path has none and the file does not exist as given.
+func programPath(path string) string {
+	if filepath.Ext(path) != "" {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	for _, ext := range []string{".COM", ".com"} {
+		if _, err := os.Stat(path + ext); err == nil {
+			return path + ext
+		}
+	}
+
+	return path + ".COM"
+}
@@ -0,0 +1,215 @@
+// Command cpmtool lists, extracts and inserts files on CP/M disk images, and
+// formats new images.
+//
+// Usage:
+//
+//	cpmtool [-f format] ls image
+//	cpmtool [-f format] get image [user:]name [dest]
+//	cpmtool [-f format] put image src [[user:]name]
+//	cpmtool [-f format] rm image [user:]name...
+//	cpmtool [-f format] mkfs image
+//	cpmtool [-f format] sys image system
+//	cpmtool [-f format] check image
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danmrichards/go8080/cpm/disk"
+)
+
+var format string
+
+func main() {
+	flag.StringVar(&format, "f", disk.IBM3740.Name, "Disk format: "+formatNames())
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: cpmtool [flags] ls|get|put|rm|mkfs|sys|check image [args...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, ok := disk.Formats[format]
+	if !ok {
+		log.Fatalf("unknown disk format %q", format)
+	}
+
+	cmd, path, args := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+	if cmd == "mkfs" {
+		d, err := disk.Create(path, f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	d, err := disk.Open(path, f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = run(cmd, d, args)
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs a command on the image.
+func run(cmd string, d *disk.Image, args []string) error {
+	fs := disk.NewFS(d)
+
+	switch cmd {
+	case "ls":
+		return list(fs)
+
+	case "get":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("usage: cpmtool get image [user:]name [dest]")
+		}
+		user, name, err := parseName(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(user, name)
+		if err != nil {
+			return err
+		}
+		dest := strings.ToLower(name)
+		if len(args) == 2 {
+			dest = args[1]
+		}
+		return ioutil.WriteFile(dest, data, 0644)
+
+	case "put":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("usage: cpmtool put image src [[user:]name]")
+		}
+		target := filepath.Base(args[0])
+		if len(args) == 2 {
+			target = args[1]
+		}
+		user, name, err := parseName(target)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		return fs.WriteFile(user, name, data)
+
+	case "rm":
+		for _, a := range args {
+			user, name, err := parseName(a)
+			if err != nil {
+				return err
+			}
+			if err := fs.Remove(user, name); err != nil {
+				return fmt.Errorf("%s: %v", a, err)
+			}
+		}
+		return nil
+
+	case "sys":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: cpmtool sys image system")
+		}
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		return disk.WriteSystem(d, data)
+
+	case "check":
+		problems, err := fs.Check()
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problems found", len(problems))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// list prints the files on the disk, grouped by user area.
+func list(fs *disk.FS) error {
+	files, err := fs.List()
+	if err != nil {
+		return err
+	}
+
+	user := -1
+	for _, f := range files {
+		if f.User != user {
+			user = f.User
+			fmt.Printf("%d:\n", user)
+		}
+
+		var attrs string
+		if f.ReadOnly {
+			attrs += " r/o"
+		}
+		if f.System {
+			attrs += " sys"
+		}
+		fmt.Printf("  %-12s %6dK%s\n", f.Name, (f.Size+1023)/1024, attrs)
+	}
+
+	free, err := fs.Free()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%dK free\n", free/1024)
+
+	return nil
+}
+
+// parseName splits a name of the form user:name, where the user area
+// defaults to 0.
+func parseName(s string) (int, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return 0, s, nil
+	}
+
+	user, err := strconv.Atoi(s[:i])
+	if err != nil || user < 0 || user > 15 {
+		return 0, "", fmt.Errorf("invalid user area in %q", s)
+	}
+
+	return user, s[i+1:], nil
+}
+
+// formatNames returns the names of the built in formats.
+func formatNames() string {
+	var names []string
+	for n := range disk.Formats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,166 @@
+// Command link links Microsoft REL object files, as produced by M80, into a
+// CP/M .COM file or an Intel HEX image.
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/danmrichards/go8080/rel"
+)
+
+var (
+	outPath    string
+	format     string
+	origin     string
+	dataOrigin string
+	libraries  string
+)
+
+func main() {
+	flag.StringVar(&outPath, "o", "", "Path to the output file (default: first object's path with the format's extension)")
+	flag.StringVar(&format, "format", "com", "Output format: com or hex")
+	flag.StringVar(&origin, "origin", "100H", "Load address of the program")
+	flag.StringVar(&dataOrigin, "data", "", "Load address of the data segments (default: after the code)")
+	flag.StringVar(&libraries, "l", "", "Comma separated list of libraries to search for undefined symbols")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: link [flags] module.rel...")
+	}
+
+	org, err := parseAddr(origin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []rel.Option{rel.WithOrigin(org)}
+	if dataOrigin != "" {
+		addr, err := parseAddr(dataOrigin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, rel.WithDataOrigin(addr))
+	}
+	l := rel.NewLinker(opts...)
+
+	// Libraries requested by the modules themselves are searched for next to
+	// the module which asked for them.
+	var libPaths []string
+	if libraries != "" {
+		libPaths = strings.Split(libraries, ",")
+	}
+	for _, path := range flag.Args() {
+		mods, err := readModules(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l.Add(mods...)
+
+		for _, m := range mods {
+			for _, name := range m.Libraries {
+				if lib, ok := findLibrary(filepath.Dir(path), name); ok {
+					libPaths = append(libPaths, lib)
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range libPaths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		mods, err := readModules(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l.AddLibrary(mods...)
+	}
+
+	img, err := l.Link()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if outPath == "" {
+		src := flag.Arg(0)
+		ext := ".com"
+		if format == "hex" {
+			ext = ".hex"
+		}
+		outPath = strings.TrimSuffix(src, filepath.Ext(src)) + ext
+	}
+
+	switch format {
+	case "com":
+		if img.Origin != 0x100 {
+			log.Printf("warning: image starts at %04XH, not 0100H", img.Origin)
+		}
+		err = ioutil.WriteFile(outPath, img.Data, 0644)
+	case "hex":
+		err = writeFile(outPath, img.WriteHex)
+	default:
+		log.Fatalf("unknown output format %q", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readModules reads every module from the REL file at path.
+func readModules(path string) ([]*rel.Module, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return rel.ReadModules(f)
+}
+
+// findLibrary returns the path of the named library in dir, trying the upper
+// and lower case spellings used by CP/M tools.
+func findLibrary(dir, name string) (string, bool) {
+	for _, n := range []string{name, strings.ToUpper(name), strings.ToLower(name)} {
+		for _, ext := range []string{".REL", ".rel"} {
+			path := filepath.Join(dir, n+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseAddr parses an address written either in Intel style, such as 100H, or
+// Go style, such as 0x100.
+func parseAddr(s string) (uint16, error) {
+	if strings.HasSuffix(strings.ToUpper(s), "H") {
+		s = "0x" + s[:len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 0, 16)
+	return uint16(v), err
+}
+
+// writeFile creates the file at path and writes its contents with fn.
+func writeFile(path string, fn func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,269 @@
+package cpm
+
+import (
+	"github.com/danmrichards/go8080"
+)
+
+// BDOS function numbers, as passed in register C.
+const (
+	fnSystemReset      = 0
+	fnConsoleInput     = 1
+	fnConsoleOutput    = 2
+	fnReaderInput      = 3
+	fnPunchOutput      = 4
+	fnListOutput       = 5
+	fnDirectConsoleIO  = 6
+	fnGetIOByte        = 7
+	fnSetIOByte        = 8
+	fnPrintString      = 9
+	fnReadConsoleBuf   = 10
+	fnConsoleStatus    = 11
+	fnVersion          = 12
+	fnResetDiskSystem  = 13
+	fnSelectDisk       = 14
+	fnOpenFile         = 15
+	fnCloseFile        = 16
+	fnSearchFirst      = 17
+	fnSearchNext       = 18
+	fnDeleteFile       = 19
+	fnReadSequential   = 20
+	fnWriteSequential  = 21
+	fnMakeFile         = 22
+	fnRenameFile       = 23
+	fnLoginVector      = 24
+	fnCurrentDisk      = 25
+	fnSetDMA           = 26
+	fnAllocVector      = 27
+	fnWriteProtect     = 28
+	fnReadOnlyVector   = 29
+	fnSetAttributes    = 30
+	fnDiskParams       = 31
+	fnUserCode         = 32
+	fnReadRandom       = 33
+	fnWriteRandom      = 34
+	fnFileSize         = 35
+	fnSetRandomRecord  = 36
+	fnResetDrive       = 37
+	fnWriteRandomZero  = 40
+	versionCPM22       = 0x0022
+	directConsoleInput = 0xff
+	directConsoleStat  = 0xfe
+	getUserCode        = 0xff
+)
+
+// bdos handles a call to the BDOS entry point. The function number is in
+// register C and its parameter in E or DE.
+//
+// Unsupported functions return 0FFH.
+func (m *Machine) bdos() error {
+	fn := m.cpu.Register(go8080.C)
+	e := m.cpu.Register(go8080.E)
+	de := uint16(m.cpu.Register(go8080.D))<<8 | uint16(e)
+
+	switch fn {
+	case fnSystemReset:
+		m.done = true
+
+	case fnConsoleInput:
+		c, err := m.con.read()
+		if err != nil {
+			return err
+		}
+		m.con.write(c)
+		m.setResult(uint16(c))
+
+	case fnConsoleOutput:
+		m.con.write(e)
+
+	case fnReaderInput:
+		m.setResult(0x1a)
+
+	case fnPunchOutput, fnListOutput:
+		// There is no punch or printer attached.
+
+	case fnDirectConsoleIO:
+		switch e {
+		case directConsoleInput:
+			var c byte
+			if m.con.status() != 0 {
+				var err error
+				if c, err = m.con.read(); err != nil {
+					return err
+				}
+			}
+			m.setResult(uint16(c))
+		case directConsoleStat:
+			m.setResult(m.con.status())
+		default:
+			m.con.write(e)
+		}
+
+	case fnGetIOByte:
+		m.setResult(uint16(m.mem[iobyte]))
+
+	case fnSetIOByte:
+		m.mem[iobyte] = e
+
+	case fnPrintString:
+		// A string without a '$' stops after the whole of memory.
+		for addr, n := de, 0; m.mem[addr] != '$' && n < 0x10000; addr, n = addr+1, n+1 {
+			m.con.write(m.mem[addr])
+		}
+
+	case fnReadConsoleBuf:
+		return m.readConsoleBuffer(de)
+
+	case fnConsoleStatus:
+		m.setResult(m.con.status())
+
+	case fnVersion:
+		m.setResult(versionCPM22)
+
+	case fnResetDiskSystem:
+		m.closeFiles()
+		m.drive, m.dma = 0, defaultDMA
+		m.mem[driveUser] = m.user<<4 | m.drive
+		m.setResult(0)
+
+	case fnSelectDisk:
+		if e >= 16 || m.drives[e] == "" {
+			m.setResult(0xff)
+			break
+		}
+		m.drive = e
+		m.mem[driveUser] = m.user<<4 | m.drive
+		m.setResult(0)
+
+	case fnOpenFile:
+		m.setResult(m.openFile(de))
+
+	case fnCloseFile:
+		m.setResult(m.closeFile(de))
+
+	case fnSearchFirst:
+		m.setResult(m.searchFirst(de))
+
+	case fnSearchNext:
+		m.setResult(m.searchNext())
+
+	case fnDeleteFile:
+		m.setResult(m.deleteFile(de))
+
+	case fnReadSequential:
+		m.setResult(m.readSequential(de))
+
+	case fnWriteSequential:
+		m.setResult(m.writeSequential(de))
+
+	case fnMakeFile:
+		m.setResult(m.makeFile(de))
+
+	case fnRenameFile:
+		m.setResult(m.renameFile(de))
+
+	case fnLoginVector:
+		var v uint16
+		for d, dir := range m.drives {
+			if dir != "" {
+				v |= 1 << uint(d)
+			}
+		}
+		m.setResult(v)
+
+	case fnCurrentDisk:
+		m.setResult(uint16(m.drive))
+
+	case fnSetDMA:
+		m.dma = de
+
+	case fnAllocVector:
+		m.setResult(alvAddr)
+
+	case fnWriteProtect:
+		// Drives are always writable.
+
+	case fnReadOnlyVector:
+		m.setResult(0)
+
+	case fnSetAttributes:
+		m.setResult(m.setAttributes(de))
+
+	case fnDiskParams:
+		m.setResult(dpbAddr)
+
+	case fnUserCode:
+		if e == getUserCode {
+			m.setResult(uint16(m.user))
+			break
+		}
+		m.user = e & 0x0f
+		m.mem[driveUser] = m.user<<4 | m.drive
+
+	case fnReadRandom:
+		m.setResult(m.readRandom(de))
+
+	case fnWriteRandom, fnWriteRandomZero:
+		m.setResult(m.writeRandom(de))
+
+	case fnFileSize:
+		m.setResult(m.fileSize(de))
+
+	case fnSetRandomRecord:
+		f := fcb{m.mem, de}
+		f.setRandom(f.record())
+
+	case fnResetDrive:
+		m.setResult(0)
+
+	default:
+		m.setResult(0xff)
+	}
+
+	return nil
+}
+
+// readConsoleBuffer reads a line of console input into the buffer at addr.
+// The first byte of the buffer gives its size, the second is set to the
+// number of characters read and the characters follow.
+//
+// Backspace and delete remove the last character, and CTRL-C at the start of
+// the line causes a warm boot.
+func (m *Machine) readConsoleBuffer(addr uint16) error {
+	max := int(m.mem[addr])
+
+	var line []byte
+	for {
+		c, err := m.con.read()
+		if err != nil {
+			return err
+		}
+
+		switch c {
+		case '\r':
+			m.con.write('\r')
+			m.mem[addr+1] = byte(len(line))
+			copy(m.mem[addr+2:], line)
+			return nil
+
+		case 0x03:
+			if len(line) == 0 {
+				m.done = true
+				return nil
+			}
+
+		case 0x08, 0x7f:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				for _, b := range []byte("\b \b") {
+					m.con.write(b)
+				}
+			}
+
+		default:
+			if len(line) < max {
+				line = append(line, c)
+				m.con.write(c)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package cpm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/cpm/disk"
+)
+
+// BIOS jump table entries.
+const (
+	biosBoot = iota
+	biosWarmBoot
+	biosConsoleStatus
+	biosConsoleInput
+	biosConsoleOutput
+	biosList
+	biosPunch
+	biosReader
+	biosHome
+	biosSelectDisk
+	biosSetTrack
+	biosSetSector
+	biosSetDMA
+	biosRead
+	biosWrite
+	biosListStatus
+	biosSectorTranslate
+)
+
+// Size of a disk parameter header.
+const dphSize = 16
+
+// Boot starts a real CP/M system from the disk in drive A, as a cold boot
+// loader would. The CCP and BDOS are read from the reserved tracks, starting
+// at the second sector of track 0, and the BIOS is provided by the machine
+// immediately above them.
+//
+// The system must have been built for the address given by WithCCPAddress.
+func (m *Machine) Boot() error {
+	if m.disks[0] == nil {
+		return errors.New("cpm: no disk in drive A")
+	}
+
+	m.biosAddr = m.ccp + ccpSize + bdosSize
+	if err := m.setupBIOS(); err != nil {
+		return err
+	}
+	if err := m.loadSystem(); err != nil {
+		return err
+	}
+	m.booted = true
+
+	m.mem[iobyte] = 0
+	m.mem[driveUser] = 0
+	m.startCCP()
+
+	return nil
+}
+
+// setupBIOS writes the BIOS jump table followed by the disk parameter headers
+// and the tables they point to for each attached disk.
+func (m *Machine) setupBIOS() error {
+	for e := 0; e < biosEntries; e++ {
+		m.mem[int(m.biosAddr)+e*3] = 0xc9
+	}
+
+	// All drives share one directory buffer.
+	addr := int(m.biosAddr) + biosEntries*3
+	dirBuf := addr
+	addr += disk.SectorSize
+
+	for d, img := range m.disks {
+		if img == nil {
+			continue
+		}
+
+		f := img.Format()
+		dpb := f.DPB()
+		xlt := f.Translation()
+
+		size := len(dpb.Bytes()) + len(xlt) + int(dpb.CKS) + int(dpb.DSM)/8 + 1 + dphSize
+		if addr+size > len(m.mem) {
+			return fmt.Errorf("cpm: BIOS tables for drive %c do not fit in memory", 'A'+d)
+		}
+
+		dpbAt := addr
+		addr += copy(m.mem[addr:], dpb.Bytes())
+
+		var xltAt int
+		if xlt != nil {
+			xltAt = addr
+			addr += copy(m.mem[addr:], xlt)
+		}
+
+		// The check and allocation vectors are maintained by the BDOS.
+		csvAt := addr
+		addr += int(dpb.CKS)
+		alvAt := addr
+		addr += int(dpb.DSM)/8 + 1
+
+		m.dph[d] = uint16(addr)
+		for i, w := range []int{xltAt, 0, 0, 0, dirBuf, dpbAt, csvAt, alvAt} {
+			m.mem[addr+i*2] = byte(w)
+			m.mem[addr+i*2+1] = byte(w >> 8)
+		}
+		addr += dphSize
+	}
+
+	return nil
+}
+
+// loadSystem reads the CCP and BDOS from the reserved tracks of drive A.
+func (m *Machine) loadSystem() error {
+	img := m.disks[0]
+	f := img.Format()
+
+	// The first sector holds the cold boot loader.
+	track, sector := 0, 1
+	buf := make([]byte, disk.SectorSize)
+	for addr := int(m.ccp); addr < int(m.biosAddr); addr += disk.SectorSize {
+		if sector == f.SectorsPerTrack {
+			track, sector = track+1, 0
+		}
+		if track >= f.ReservedTracks {
+			return errors.New("cpm: system does not fit in the reserved tracks")
+		}
+		if err := img.ReadSector(track, sector+f.FirstSector, buf); err != nil {
+			return err
+		}
+		copy(m.mem[addr:], buf)
+		sector++
+	}
+
+	return nil
+}
+
+// startCCP sets up the zero page and enters the CCP, as the BIOS does at the
+// end of a cold or warm boot.
+func (m *Machine) startCCP() {
+	m.jump(0x0000, m.biosAddr+3)
+	m.jump(0x0005, m.ccp+ccpSize+6)
+
+	m.diskDMA = defaultDMA
+	m.cpu.SetStackPointer(defaultDMA)
+	m.cpu.SetRegister(go8080.C, m.mem[driveUser])
+	m.cpu.SetProgramCounter(m.ccp)
+}
+
+// bios handles a call to the given entry of the BIOS jump table.
+//
+// Programs run on the BDOS emulation only use the character I/O entries, and
+// a warm boot ends the program. Once a system has been booted the disk
+// entries access the attached images and a warm boot reloads the system.
+func (m *Machine) bios(entry int) error {
+	bc := uint16(m.cpu.Register(go8080.B))<<8 | uint16(m.cpu.Register(go8080.C))
+
+	switch entry {
+	case biosBoot, biosWarmBoot:
+		if !m.booted {
+			m.done = true
+			break
+		}
+		if entry == biosBoot {
+			return m.Boot()
+		}
+		if err := m.loadSystem(); err != nil {
+			return err
+		}
+		m.startCCP()
+
+	case biosConsoleStatus:
+		m.setResult(m.con.status())
+
+	case biosConsoleInput:
+		c, err := m.con.read()
+		if err != nil {
+			return err
+		}
+		m.setResult(uint16(c))
+
+	case biosConsoleOutput:
+		m.con.write(m.cpu.Register(go8080.C))
+
+	case biosReader:
+		m.setResult(0x1a)
+
+	case biosHome:
+		m.track = 0
+
+	case biosSelectDisk:
+		d := bc & 0xff
+		if d >= 16 || m.disks[d] == nil {
+			m.setResult(0)
+			break
+		}
+		m.disk = byte(d)
+		m.setResult(m.dph[d])
+
+	case biosSetTrack:
+		m.track = bc
+
+	case biosSetSector:
+		m.sector = bc
+
+	case biosSetDMA:
+		m.diskDMA = bc
+
+	case biosRead:
+		m.setResult(m.diskIO(false))
+
+	case biosWrite:
+		m.setResult(m.diskIO(true))
+
+	case biosListStatus:
+		m.setResult(0xff)
+
+	case biosSectorTranslate:
+		de := uint16(m.cpu.Register(go8080.D))<<8 | uint16(m.cpu.Register(go8080.E))
+		if de == 0 {
+			// Without a translation table the sectors are numbered from 0,
+			// so only the first sector number of the format is applied.
+			var first int
+			if img := m.disks[m.disk]; img != nil {
+				first = img.Format().FirstSector
+			}
+			m.setResult(bc + uint16(first))
+			break
+		}
+		m.setResult(uint16(m.mem[de+bc]))
+	}
+
+	return nil
+}
+
+// diskIO reads or writes the selected sector to or from the DMA buffer,
+// returning 0 on success and 1 on error as the BIOS READ and WRITE entries
+// do.
+func (m *Machine) diskIO(write bool) uint16 {
+	img := m.disks[m.disk]
+	if img == nil {
+		return 1
+	}
+
+	buf := make([]byte, disk.SectorSize)
+	if write {
+		for i := range buf {
+			buf[i] = m.mem[m.diskDMA+uint16(i)]
+		}
+		if err := img.WriteSector(int(m.track), int(m.sector), buf); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if err := img.ReadSector(int(m.track), int(m.sector), buf); err != nil {
+		return 1
+	}
+	for i, b := range buf {
+		m.mem[m.diskDMA+uint16(i)] = b
+	}
+
+	return 0
+}
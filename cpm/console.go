@@ -0,0 +1,100 @@
+package cpm
+
+import (
+	"io"
+)
+
+// console connects the CP/M console to the host.
+//
+// Input is read by a separate goroutine so that the console status can be
+// polled without blocking, as programs commonly check for a keypress while
+// they are busy.
+type console struct {
+	in  chan byte
+	out io.Writer
+
+	// A byte taken from the input channel by a status check but not yet read.
+	pending    byte
+	hasPending bool
+
+	// Set once the input has been exhausted.
+	eof bool
+}
+
+// newConsole returns a console reading from r and writing to w. If r is nil
+// the console has no input.
+func newConsole(r io.Reader, w io.Writer) *console {
+	c := &console{in: make(chan byte, 256), out: w}
+	if r == nil {
+		close(c.in)
+		return c
+	}
+
+	go func() {
+		defer close(c.in)
+
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			for _, b := range buf[:n] {
+				c.in <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// status returns 0FFH if a character is waiting to be read, otherwise 00H.
+func (c *console) status() uint16 {
+	if c.hasPending {
+		return 0xff
+	}
+	if c.eof {
+		return 0
+	}
+
+	select {
+	case b, ok := <-c.in:
+		if !ok {
+			c.eof = true
+			return 0
+		}
+		c.pending, c.hasPending = b, true
+		return 0xff
+	default:
+		return 0
+	}
+}
+
+// read returns the next character of input, waiting for one if necessary.
+func (c *console) read() (byte, error) {
+	b := c.pending
+	switch {
+	case c.hasPending:
+		c.hasPending = false
+	case c.eof:
+		return 0, ErrInputExhausted
+	default:
+		var ok bool
+		if b, ok = <-c.in; !ok {
+			c.eof = true
+			return 0, ErrInputExhausted
+		}
+	}
+
+	// Hosts end lines with LF where CP/M programs expect CR.
+	if b == '\n' {
+		b = '\r'
+	}
+
+	return b, nil
+}
+
+// write writes a single character to the console output.
+func (c *console) write(b byte) {
+	c.out.Write([]byte{b})
+}
@@ -0,0 +1,377 @@
+// Package cpm runs CP/M 2.2 programs on the Intel 8080 emulator.
+//
+// Rather than booting a real copy of CP/M, the machine traps calls to the
+// BDOS and BIOS entry points and implements them in Go. Console I/O is mapped
+// to an io.Reader and io.Writer, and each drive is mapped to a directory on
+// the host so that programs can read and write ordinary files.
+package cpm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/cpm/disk"
+)
+
+// Memory layout of the emulated system.
+const (
+	// Start of the transient program area, where .COM files are loaded.
+	tpa = 0x0100
+
+	// Default file control blocks and DMA buffer in the zero page.
+	fcb1       = 0x005c
+	fcb2       = 0x006c
+	defaultDMA = 0x0080
+
+	// Address of the IOBYTE and the current drive/user byte.
+	iobyte    = 0x0003
+	driveUser = 0x0004
+
+	// Base of the BDOS. Programs find the top of the TPA by reading the
+	// address of the BDOS entry point from the jump at 0005H.
+	bdosBase  = 0xfc00
+	bdosEntry = bdosBase + 0x06
+
+	// Fake disk parameter block and allocation vector returned by the BDOS.
+	dpbAddr = bdosBase + 0x10
+	alvAddr = bdosBase + 0x20
+
+	// Base of the BIOS jump table. The zero page jumps to the warm boot
+	// entry, the second in the table.
+	biosBase    = 0xfe00
+	biosEntries = 17
+
+	// Size of the CCP and BDOS of a real CP/M system, and the address of the
+	// CCP in a 64K system.
+	ccpSize    = 0x0800
+	bdosSize   = 0x0e00
+	defaultCCP = 0xe400
+)
+
+// ErrInputExhausted is returned by Run if a program waits for console input
+// after the end of the input has been reached.
+var ErrInputExhausted = errors.New("cpm: console input exhausted")
+
+type (
+	// Machine is an Intel 8080 running CP/M 2.2.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		con *console
+
+		// Host directory for each drive, empty if the drive is not mapped.
+		drives [16]string
+
+		// Currently selected drive and user number.
+		drive byte
+		user  byte
+
+		// Address of the buffer used for disk reads and writes.
+		dma uint16
+
+		// Open host files, keyed by path.
+		files map[string]*os.File
+
+		// Remaining results of a search first/search next sequence.
+		search []dirEntry
+
+		// Set once the program has returned to CP/M.
+		done bool
+
+		// Address of the BIOS jump table.
+		biosAddr uint16
+
+		// Disk images attached to the BIOS, and the address of the disk
+		// parameter header of each.
+		disks [16]*disk.Image
+		dph   [16]uint16
+
+		// Disk, track, sector and DMA address selected through the BIOS.
+		disk    byte
+		track   uint16
+		sector  uint16
+		diskDMA uint16
+
+		// Address of the CCP of a booted system, and whether the system has
+		// been booted from disk rather than running a program on the BDOS
+		// emulation.
+		ccp    uint16
+		booted bool
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the 64K of RAM seen by the CPU.
+	memory []byte
+)
+
+// Read returns the value from memory at the given address.
+func (m memory) Read(addr uint16) byte {
+	return m[addr]
+}
+
+// ReadAll returns the full memory contents.
+func (m memory) ReadAll() []byte {
+	return m
+}
+
+// Write writes the value v into memory at the given address.
+func (m memory) Write(addr uint16, v byte) {
+	m[addr] = v
+}
+
+// WithConsole connects the console to the given reader and writer.
+func WithConsole(in io.Reader, out io.Writer) Option {
+	return func(m *Machine) {
+		m.con = newConsole(in, out)
+	}
+}
+
+// WithDrive maps the drive with the given letter, 'A' to 'P', to a directory
+// on the host.
+func WithDrive(drive byte, dir string) Option {
+	return func(m *Machine) {
+		if d := drive - 'A'; d < 16 {
+			m.drives[d] = dir
+		}
+	}
+}
+
+// WithDisk attaches a disk image to the drive with the given letter, 'A' to
+// 'P', for use by a system booted with Boot.
+func WithDisk(drive byte, d *disk.Image) Option {
+	return func(m *Machine) {
+		if n := drive - 'A'; n < 16 {
+			m.disks[n] = d
+		}
+	}
+}
+
+// WithCCPAddress sets the address that the CP/M system on the boot disk was
+// built to run at, given by the address of its CCP. The default, E400H, is
+// that of a 64K system.
+func WithCCPAddress(addr uint16) Option {
+	return func(m *Machine) {
+		m.ccp = addr
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// New returns a CP/M machine with an empty transient program area. By default
+// the console is not connected and drive A is mapped to the current directory.
+func New(opts ...Option) *Machine {
+	m := &Machine{
+		mem:      make(memory, 0x10000),
+		dma:      defaultDMA,
+		files:    make(map[string]*os.File),
+		biosAddr: biosBase,
+		ccp:      defaultCCP,
+	}
+	m.drives[0] = "."
+
+	for _, o := range opts {
+		o(m)
+	}
+	if m.con == nil {
+		m.con = newConsole(nil, ioutil.Discard)
+	}
+
+	m.cpu = go8080.NewIntel8080(m.mem, m.cpuOpts...)
+	m.reset()
+
+	return m
+}
+
+// reset sets up the zero page, the BDOS and BIOS entry points and the stack
+// ready for a program to be run.
+func (m *Machine) reset() {
+	// JMP WBOOT at 0000H, JMP BDOS at 0005H.
+	m.jump(0x0000, biosBase+3)
+	m.jump(0x0005, bdosEntry)
+	m.mem[iobyte] = 0
+	m.mem[driveUser] = m.user<<4 | m.drive
+
+	// The BDOS and BIOS are implemented in Go. The entry points are trapped
+	// before they execute and hold a RET to return to the caller afterwards.
+	m.mem[bdosEntry] = 0xc9
+	for e := 0; e < biosEntries; e++ {
+		m.mem[biosBase+e*3] = 0xc9
+	}
+
+	// A disk parameter block for a 256K disk with 1K blocks and 64 directory
+	// entries, for programs which inspect the disk.
+	copy(m.mem[dpbAddr:], []byte{
+		32, 0, // SPT: sectors per track.
+		3, 7, 0, // BSH, BLM, EXM: 1K blocks and 16K extents.
+		255, 0, // DSM: highest block number.
+		63, 0, // DRM: highest directory entry number.
+		0xc0, 0x00, // AL0, AL1: the directory occupies two blocks.
+		0, 0, // CKS: no directory checksums.
+		0, 0, // OFF: no reserved tracks.
+	})
+	m.mem[alvAddr] = 0xc0
+
+	// Programs are entered with a return address of 0000H on the stack so
+	// that a RET ends the program.
+	m.cpu.SetStackPointer(bdosBase - 2)
+	m.mem[bdosBase-2] = 0
+	m.mem[bdosBase-1] = 0
+	m.cpu.SetProgramCounter(tpa)
+
+	m.dma = defaultDMA
+	m.done = false
+	m.biosAddr, m.booted = biosBase, false
+}
+
+// jump writes a JMP to target at addr.
+func (m *Machine) jump(addr, target uint16) {
+	m.mem[addr] = 0xc3
+	m.mem[addr+1] = byte(target)
+	m.mem[addr+2] = byte(target >> 8)
+}
+
+// CPU returns the CPU of the machine.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the memory of the machine.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// Load loads a .COM program read from r into the transient program area.
+func (m *Machine) Load(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(b) > bdosBase-tpa {
+		return fmt.Errorf("cpm: program of %d bytes does not fit in the TPA", len(b))
+	}
+
+	copy(m.mem[tpa:], b)
+	m.reset()
+
+	return nil
+}
+
+// LoadFile loads the .COM program at path into the transient program area.
+func (m *Machine) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return m.Load(f)
+}
+
+// SetArgs sets up the command line of the loaded program as the CCP would:
+// the arguments are converted to upper case and stored as the command tail
+// at 0080H, and the first two are parsed into the default file control
+// blocks at 005CH and 006CH. It must be called after the program is loaded.
+func (m *Machine) SetArgs(args ...string) {
+	var tail string
+	for _, a := range args {
+		tail += " " + strings.ToUpper(a)
+	}
+	if len(tail) > 126 {
+		tail = tail[:126]
+	}
+	m.mem[defaultDMA] = byte(len(tail))
+	copy(m.mem[defaultDMA+1:], tail)
+	m.mem[defaultDMA+1+len(tail)] = 0
+
+	for i := fcb1; i < defaultDMA; i++ {
+		m.mem[i] = 0
+	}
+	names := strings.Fields(tail)
+	for i, addr := range []uint16{fcb1, fcb2} {
+		var arg string
+		if i < len(names) {
+			arg = names[i]
+		}
+		drive, name := parseFileName(arg)
+		m.mem[addr] = drive
+		copy(m.mem[addr+fcbName:], name[:])
+	}
+}
+
+// Run runs the loaded program until it returns to CP/M, either by a warm boot
+// or by calling BDOS function 0.
+//
+// A system started with Boot never returns to a program loader, so it runs
+// until an error occurs, usually ErrInputExhausted at the end of a scripted
+// session.
+func (m *Machine) Run() error {
+	defer m.closeFiles()
+
+	for !m.done {
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step executes a single instruction, or a single BDOS or BIOS call if the
+// program counter is at one of their entry points.
+func (m *Machine) Step() error {
+	pc := m.cpu.ProgramCounter()
+
+	switch {
+	case !m.booted && pc == bdosEntry:
+		if err := m.bdos(); err != nil {
+			return err
+		}
+
+	case pc >= m.biosAddr && pc < m.biosAddr+biosEntries*3 && (pc-m.biosAddr)%3 == 0:
+		if err := m.bios(int(pc-m.biosAddr) / 3); err != nil {
+			return err
+		}
+	}
+	if m.done {
+		return nil
+	}
+
+	if !m.cpu.Running() {
+		return fmt.Errorf("cpm: CPU halted at %04X", pc)
+	}
+
+	return m.cpu.Step()
+}
+
+// setResult sets the value returned from a BDOS or BIOS call. By convention
+// the value is returned in HL, with A and B holding copies of L and H.
+func (m *Machine) setResult(v uint16) {
+	m.cpu.SetRegister(go8080.H, byte(v>>8))
+	m.cpu.SetRegister(go8080.L, byte(v))
+	m.cpu.SetRegister(go8080.B, byte(v>>8))
+	m.cpu.SetRegister(go8080.A, byte(v))
+}
+
+// closeFiles closes every open host file.
+func (m *Machine) closeFiles() {
+	for path, f := range m.files {
+		f.Close()
+		delete(m.files, path)
+	}
+}
@@ -0,0 +1,405 @@
+package cpm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/cpm/disk"
+)
+
+// run assembles src, runs it on a machine with the given options and returns
+// the console output.
+func run(t *testing.T, src, in string, opts ...Option) string {
+	t.Helper()
+
+	prog, err := asm.Assemble("test.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	m := New(append([]Option{WithConsole(strings.NewReader(in), &out)}, opts...)...)
+	if err := m.Load(bytes.NewReader(prog.Binary())); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run: %v (output %q)", err, out.String())
+	}
+
+	return out.String()
+}
+
+func TestConsole(t *testing.T) {
+	const src = `
+BDOS	EQU	5
+	ORG	100H
+	LXI	D,PROMPT
+	MVI	C,9
+	CALL	BDOS
+	LXI	D,BUF
+	MVI	C,10
+	CALL	BDOS
+	LDA	BUF+1
+	MOV	E,A
+	MVI	D,0
+	LXI	H,BUF+2
+	DAD	D
+	MVI	M,'$'
+	LXI	D,BUF+2
+	MVI	C,9
+	CALL	BDOS
+	MVI	C,12
+	CALL	BDOS
+	CPI	22H
+	RNZ
+	MVI	E,'!'
+	MVI	C,2
+	CALL	BDOS
+	RET
+PROMPT:	DB	'Name? $'
+BUF:	DB	10,0
+	DS	11
+`
+	got := run(t, src, "Davx\x7fid\n")
+	if want := "Name? Davx\b \bid\rDavid!"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestInputExhausted(t *testing.T) {
+	prog, err := asm.Assemble("test.asm", strings.NewReader("\tORG\t100H\n\tMVI\tC,1\n\tJMP\t5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(WithConsole(strings.NewReader(""), ioutil.Discard))
+	if err := m.Load(bytes.NewReader(prog.Binary())); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != ErrInputExhausted {
+		t.Errorf("Run error = %v, want %v", err, ErrInputExhausted)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy B:IN.TXT to B:OUT.TXT, then list B:*.TXT and rename OUT.TXT to
+	// NEW.TXT.
+	const src = `
+BDOS	EQU	5
+DMA	EQU	80H
+	ORG	100H
+	LXI	D,INFCB
+	MVI	C,15
+	CALL	BDOS
+	ORA	A
+	JNZ	FAIL
+	LXI	D,OUTFCB
+	MVI	C,22
+	CALL	BDOS
+	ORA	A
+	JNZ	FAIL
+COPY:	LXI	D,INFCB
+	MVI	C,20
+	CALL	BDOS
+	ORA	A
+	JNZ	DONE
+	LXI	D,OUTFCB
+	MVI	C,21
+	CALL	BDOS
+	JMP	COPY
+DONE:	LXI	D,OUTFCB
+	MVI	C,16
+	CALL	BDOS
+	LXI	D,WILD
+	MVI	C,17
+NEXT:	CALL	BDOS
+	CPI	0FFH
+	JZ	REN
+	LXI	H,DMA+1
+	MVI	B,11
+SHOW:	MOV	E,M
+	PUSH	H
+	PUSH	B
+	MVI	C,2
+	CALL	BDOS
+	POP	B
+	POP	H
+	INX	H
+	DCR	B
+	JNZ	SHOW
+	MVI	C,18
+	JMP	NEXT
+REN:	LXI	D,RENFCB
+	MVI	C,23
+	CALL	BDOS
+	ORA	A
+	RZ
+FAIL:	LXI	D,ERR
+	MVI	C,9
+	JMP	BDOS
+ERR:	DB	'ERROR$'
+INFCB:	DB	2,'IN      TXT'
+	DS	24
+OUTFCB:	DB	2,'OUT     TXT'
+	DS	24
+WILD:	DB	2,'????????TXT'
+	DS	24
+RENFCB:	DB	2,'OUT     TXT'
+	DB	0,0,0,0
+	DB	2,'NEW     TXT'
+	DS	8
+`
+	got := run(t, src, "", WithDrive('B', dir))
+	if want := "IN      TXTOUT     TXT"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "NEW.TXT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("hello"), bytes.Repeat([]byte{eofMarker}, recordSize-5)...)
+	if !bytes.Equal(b, want) {
+		t.Errorf("NEW.TXT = %q, want %q", b, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "OUT.TXT")); !os.IsNotExist(err) {
+		t.Errorf("OUT.TXT still exists after rename")
+	}
+}
+
+func TestRandomAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Write record 2 of a new file, then report its size in records.
+	const src = `
+BDOS	EQU	5
+	ORG	100H
+	LXI	D,FCB
+	MVI	C,22
+	CALL	BDOS
+	LXI	H,2
+	SHLD	FCB+33
+	LXI	D,FCB
+	MVI	C,34
+	CALL	BDOS
+	LXI	D,FCB
+	MVI	C,35
+	CALL	BDOS
+	LDA	FCB+33
+	ADI	'0'
+	MOV	E,A
+	MVI	C,2
+	JMP	BDOS
+FCB:	DB	0,'DATA    BIN'
+	DS	24
+`
+	if got := run(t, src, "", WithDrive('A', dir)); got != "3" {
+		t.Errorf("output = %q, want %q", got, "3")
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "DATA.BIN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 3*recordSize {
+		t.Errorf("size = %d, want %d", fi.Size(), 3*recordSize)
+	}
+}
+
+func TestSetArgs(t *testing.T) {
+	m := New()
+	m.SetArgs("b:foo.asm", "*.h", "$sz")
+
+	tail := "\x12 B:FOO.ASM *.H $SZ\x00"
+	if got := string(m.mem[defaultDMA : defaultDMA+len(tail)]); got != tail {
+		t.Errorf("command tail = %q, want %q", got, tail)
+	}
+	if got, want := string(m.mem[fcb1:fcb1+12]), "\x02FOO     ASM"; got != want {
+		t.Errorf("FCB 1 = %q, want %q", got, want)
+	}
+	if got, want := string(m.mem[fcb2:fcb2+12]), "\x00????????H  "; got != want {
+		t.Errorf("FCB 2 = %q, want %q", got, want)
+	}
+}
+
+func TestBoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A stand in for the CCP which reads logical sector 1 of track 2 through
+	// the BIOS, prints it and writes it back modified. It then warm boots
+	// once before waiting for input.
+	const src = `
+BIOS	EQU	0FA00H
+COUNT	EQU	40H
+BUF	EQU	1000H
+	ORG	0E400H
+	MVI	C,0
+	CALL	BIOS+27
+	MOV	E,M
+	INX	H
+	MOV	D,M
+	LXI	B,1
+	CALL	BIOS+48
+	MOV	B,H
+	MOV	C,L
+	CALL	BIOS+33
+	LXI	B,2
+	CALL	BIOS+30
+	LXI	B,BUF
+	CALL	BIOS+36
+	CALL	BIOS+39
+	ORA	A
+	JNZ	FAIL
+	LXI	H,BUF
+	MVI	B,5
+SHOW:	MOV	C,M
+	PUSH	H
+	PUSH	B
+	CALL	BIOS+12
+	POP	B
+	POP	H
+	INX	H
+	DCR	B
+	JNZ	SHOW
+	MVI	A,'J'
+	STA	BUF
+	CALL	BIOS+42
+	LXI	H,COUNT
+	INR	M
+	MOV	A,M
+	CPI	2
+	JNZ	0
+	JMP	BIOS+9
+FAIL:	MVI	C,'!'
+	CALL	BIOS+12
+	JMP	BIOS+9
+`
+	prog, err := asm.Assemble("ccp.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "a.img")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := disk.Open(path, disk.IBM3740)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// The system starts at the second sector of track 0.
+	sector := make([]byte, disk.SectorSize)
+	for i, b := 0, prog.Binary(); i < len(b); i += disk.SectorSize {
+		copy(sector, b[i:])
+		if err := d.WriteSector(0, 2+i/disk.SectorSize, sector); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Logical sector 1 is physical sector 7 with the IBM 3740 skew.
+	copy(sector, "HELLO")
+	if err := d.WriteSector(2, 7, sector); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	m := New(WithConsole(strings.NewReader(""), &out), WithDisk('A', d))
+	if err := m.Boot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != ErrInputExhausted {
+		t.Fatalf("Run error = %v, want %v", err, ErrInputExhausted)
+	}
+	if got, want := out.String(), "HELLOJELLO"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	if err := d.ReadSector(2, 7, sector); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(sector[:5]); got != "JELLO" {
+		t.Errorf("sector after write = %q, want %q", got, "JELLO")
+	}
+}
+
+func TestDMAWrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data.bin")
+	rec := bytes.Repeat([]byte{0x55}, recordSize)
+	if err := ioutil.WriteFile(path, rec, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hf, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.Close()
+
+	// A buffer at FFC0H runs on into the bottom of memory.
+	m := New()
+	m.dma = 0xffc0
+	if !m.readRecord(hf, 0) {
+		t.Fatal("readRecord failed")
+	}
+	if m.mem[0xffff] != 0x55 || m.mem[0x003f] != 0x55 || m.mem[0x0040] == 0x55 {
+		t.Error("record not read into memory at FFC0H-003FH")
+	}
+
+	m.mem[0x0000] = 0xaa
+	if !m.writeRecord(hf, 1) {
+		t.Fatal("writeRecord failed")
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2*recordSize || got[recordSize+0x40] != 0xaa {
+		t.Errorf("record written from FFC0H = % x", got[recordSize:])
+	}
+}
+
+func TestPrintStringUnterminated(t *testing.T) {
+	var out bytes.Buffer
+	m := New(WithConsole(strings.NewReader(""), &out))
+	for i := range m.mem {
+		m.mem[i] = 'x'
+	}
+	m.cpu.SetRegister(go8080.C, 9)
+	if err := m.bdos(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0x10000 {
+		t.Errorf("printed %d characters, want %d", out.Len(), 0x10000)
+	}
+}
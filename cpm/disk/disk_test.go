@@ -0,0 +1,95 @@
+package disk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDPB(t *testing.T) {
+	tests := []struct {
+		f    *Format
+		want DPB
+	}{
+		{IBM3740, DPB{SPT: 26, BSH: 3, BLM: 7, EXM: 0, DSM: 242, DRM: 63, AL0: 0xc0, AL1: 0x00, CKS: 16, OFF: 2}},
+		{HD4MB, DPB{SPT: 32, BSH: 4, BLM: 15, EXM: 0, DSM: 2047, DRM: 255, AL0: 0xf0, AL1: 0x00, CKS: 0, OFF: 0}},
+	}
+	for _, tt := range tests {
+		if got := tt.f.DPB(); got != tt.want {
+			t.Errorf("%s DPB = %+v, want %+v", tt.f.Name, got, tt.want)
+		}
+	}
+
+	want := []byte{26, 0, 3, 7, 0, 242, 0, 63, 0, 0xc0, 0, 16, 0, 2, 0}
+	if got := IBM3740.DPB().Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("IBM3740 DPB bytes = % x, want % x", got, want)
+	}
+}
+
+func TestTranslation(t *testing.T) {
+	want := []byte{
+		1, 7, 13, 19, 25, 5, 11, 17, 23, 3, 9, 15, 21,
+		2, 8, 14, 20, 26, 6, 12, 18, 24, 4, 10, 16, 22,
+	}
+	if got := IBM3740.Translation(); !bytes.Equal(got, want) {
+		t.Errorf("IBM3740 translation = %v, want %v", got, want)
+	}
+	if got := HD4MB.Translation(); got != nil {
+		t.Errorf("HD4MB translation = %v, want nil", got)
+	}
+}
+
+func TestImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.img")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(path, IBM3740)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	sector := bytes.Repeat([]byte{0x55}, SectorSize)
+	if err := d.WriteSector(1, 2, sector); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, SectorSize)
+	if err := d.ReadSector(1, 2, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, sector) {
+		t.Errorf("sector 1/2 = % x, want % x", buf, sector)
+	}
+
+	// Sector 1/2 is the 28th sector of the image, so the one after it has
+	// not been written.
+	if err := d.ReadSector(1, 3, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, bytes.Repeat([]byte{0xe5}, SectorSize)) {
+		t.Errorf("unwritten sector = % x, want e5s", buf)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 28*SectorSize {
+		t.Errorf("image size = %d, want %d", fi.Size(), 28*SectorSize)
+	}
+
+	for _, ts := range [][2]int{{0, 0}, {0, 27}, {77, 1}} {
+		if err := d.ReadSector(ts[0], ts[1], buf); err == nil {
+			t.Errorf("ReadSector(%d, %d) succeeded, want range error", ts[0], ts[1])
+		}
+	}
+}
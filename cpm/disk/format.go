@@ -0,0 +1,172 @@
+// Package disk provides CP/M disk formats and raw disk image files.
+//
+// A raw image holds every sector of the disk in physical order, track by
+// track, as written by tools such as cpmtools. Sector skew is not applied to
+// the image; it is applied by the BIOS through the translation table of the
+// format.
+package disk
+
+// SectorSize is the size of a CP/M record, and of the sectors of every format
+// in this package.
+const SectorSize = 128
+
+type (
+	// Format describes the geometry and file system layout of a disk.
+	Format struct {
+		// Name of the format, following the naming used by cpmtools.
+		Name string
+
+		// Number of tracks and of 128 byte sectors on each track.
+		Tracks          int
+		SectorsPerTrack int
+
+		// Number of the first sector on a track, usually 1 for floppy disks
+		// and 0 for hard disks.
+		FirstSector int
+
+		// Skew between logically consecutive sectors, or 0 if sectors are
+		// not translated.
+		Skew int
+
+		// Size of an allocation block in bytes, from 1024 to 16384.
+		BlockSize int
+
+		// Number of blocks and of directory entries.
+		Blocks     int
+		DirEntries int
+
+		// Number of tracks reserved for the operating system.
+		ReservedTracks int
+
+		// Removable disks have their directory checked for changes.
+		Removable bool
+	}
+
+	// DPB is a CP/M 2.2 disk parameter block.
+	DPB struct {
+		SPT uint16 // Sectors per track.
+		BSH byte   // Block shift factor.
+		BLM byte   // Block mask.
+		EXM byte   // Extent mask.
+		DSM uint16 // Highest block number.
+		DRM uint16 // Highest directory entry number.
+		AL0 byte   // Directory allocation bitmap, first byte.
+		AL1 byte   // Directory allocation bitmap, second byte.
+		CKS uint16 // Size of the directory check vector.
+		OFF uint16 // Number of reserved tracks.
+	}
+)
+
+var (
+	// IBM3740 is the standard 8" single sided, single density format, and
+	// the distribution format of CP/M 2.2.
+	IBM3740 = &Format{
+		Name:            "ibm-3740",
+		Tracks:          77,
+		SectorsPerTrack: 26,
+		FirstSector:     1,
+		Skew:            6,
+		BlockSize:       1024,
+		Blocks:          243,
+		DirEntries:      64,
+		ReservedTracks:  2,
+		Removable:       true,
+	}
+
+	// HD4MB is a 4MB hard disk without reserved tracks, as used by many
+	// emulators.
+	HD4MB = &Format{
+		Name:            "4mb-hd",
+		Tracks:          1024,
+		SectorsPerTrack: 32,
+		BlockSize:       2048,
+		Blocks:          2048,
+		DirEntries:      256,
+	}
+
+	// Formats lists the built in formats by name.
+	Formats = map[string]*Format{
+		IBM3740.Name: IBM3740,
+		HD4MB.Name:   HD4MB,
+	}
+)
+
+// Size returns the size of an image of the format in bytes.
+func (f *Format) Size() int64 {
+	return int64(f.Tracks) * int64(f.SectorsPerTrack) * SectorSize
+}
+
+// DirBlocks returns the number of allocation blocks used by the directory.
+func (f *Format) DirBlocks() int {
+	return (f.DirEntries*32 + f.BlockSize - 1) / f.BlockSize
+}
+
+// DPB returns the disk parameter block for the format.
+func (f *Format) DPB() DPB {
+	d := DPB{
+		SPT: uint16(f.SectorsPerTrack),
+		BLM: byte(f.BlockSize/SectorSize - 1),
+		DSM: uint16(f.Blocks - 1),
+		DRM: uint16(f.DirEntries - 1),
+		OFF: uint16(f.ReservedTracks),
+	}
+	for n := f.BlockSize / SectorSize; n > 1; n >>= 1 {
+		d.BSH++
+	}
+
+	// Each directory entry maps 16 blocks with 8 bit block numbers, or 8
+	// with 16 bit numbers. The extent mask gives the number of 16K logical
+	// extents this covers, less one.
+	if f.Blocks <= 256 {
+		d.EXM = byte(f.BlockSize/1024 - 1)
+	} else {
+		d.EXM = byte(f.BlockSize/2048 - 1)
+	}
+
+	al := uint16(0xffff) << uint(16-f.DirBlocks())
+	d.AL0, d.AL1 = byte(al>>8), byte(al)
+
+	if f.Removable {
+		d.CKS = uint16(f.DirEntries / 4)
+	}
+
+	return d
+}
+
+// Bytes returns the disk parameter block as laid out in memory.
+func (d DPB) Bytes() []byte {
+	return []byte{
+		byte(d.SPT), byte(d.SPT >> 8),
+		d.BSH, d.BLM, d.EXM,
+		byte(d.DSM), byte(d.DSM >> 8),
+		byte(d.DRM), byte(d.DRM >> 8),
+		d.AL0, d.AL1,
+		byte(d.CKS), byte(d.CKS >> 8),
+		byte(d.OFF), byte(d.OFF >> 8),
+	}
+}
+
+// Translation returns the sector translation table of the format, mapping
+// logical sectors to physical sector numbers, or nil if the format has no
+// skew.
+func (f *Format) Translation() []byte {
+	if f.Skew == 0 {
+		return nil
+	}
+
+	t := make([]byte, f.SectorsPerTrack)
+	used := make([]bool, f.SectorsPerTrack)
+	s := 0
+	for i := range t {
+		// Move to the next free sector when the skew wraps onto one that is
+		// already used.
+		for used[s] {
+			s = (s + 1) % f.SectorsPerTrack
+		}
+		used[s] = true
+		t[i] = byte(s + f.FirstSector)
+		s = (s + f.Skew) % f.SectorsPerTrack
+	}
+
+	return t
+}
@@ -0,0 +1,574 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Size of a directory entry.
+	dirEntrySize = 32
+
+	// User number of an unused directory entry.
+	unused = 0xe5
+
+	// Records in a 16K logical extent.
+	extentRecords = 128
+)
+
+var (
+	// ErrNotFound is returned when a file does not exist.
+	ErrNotFound = errors.New("disk: file not found")
+
+	// ErrDiskFull is returned when there are not enough free blocks for a
+	// file.
+	ErrDiskFull = errors.New("disk: disk full")
+
+	// ErrDirectoryFull is returned when there are not enough free directory
+	// entries for a file.
+	ErrDirectoryFull = errors.New("disk: directory full")
+)
+
+type (
+	// FS is the CP/M 2.2 file system on a disk image.
+	FS struct {
+		img *Image
+		f   *Format
+		dpb DPB
+		xlt []byte
+	}
+
+	// File describes a file in the directory.
+	File struct {
+		// User area of the file, 0 to 15.
+		User int
+
+		// Name in the form NAME.TYP.
+		Name string
+
+		// Size in bytes, a multiple of the 128 byte record size.
+		Size int64
+
+		// File attributes, held in the top bits of the file type.
+		ReadOnly bool
+		System   bool
+	}
+
+	// entry is a directory entry.
+	entry []byte
+)
+
+// NewFS returns the file system on the image.
+func NewFS(img *Image) *FS {
+	f := img.Format()
+
+	return &FS{img: img, f: f, dpb: f.DPB(), xlt: f.Translation()}
+}
+
+// user returns the user number of the entry, or unused.
+func (e entry) user() byte {
+	return e[0]
+}
+
+// name returns the name of the entry as 11 characters with attributes
+// removed.
+func (e entry) name() string {
+	b := make([]byte, 11)
+	for i := range b {
+		b[i] = e[1+i] & 0x7f
+	}
+
+	return string(b)
+}
+
+// extent returns the number of the last logical extent held in the entry.
+func (e entry) extent() int {
+	return int(e[14]&0x3f)*32 + int(e[12]&0x1f)
+}
+
+// records returns the number of records held in the last logical extent of
+// the entry.
+func (e entry) records() int {
+	return int(e[15])
+}
+
+// blocksPerEntry returns the number of block pointers in a directory entry.
+func (fs *FS) blocksPerEntry() int {
+	if fs.bigBlocks() {
+		return 8
+	}
+
+	return 16
+}
+
+// bigBlocks reports whether block numbers are 16 bits wide.
+func (fs *FS) bigBlocks() bool {
+	return fs.dpb.DSM > 255
+}
+
+// blocks returns the block pointers of the entry, including unused zeros.
+func (fs *FS) blocks(e entry) []int {
+	n := fs.blocksPerEntry()
+	b := make([]int, n)
+	for i := range b {
+		if fs.bigBlocks() {
+			b[i] = int(e[16+i*2]) | int(e[17+i*2])<<8
+		} else {
+			b[i] = int(e[16+i])
+		}
+	}
+
+	return b
+}
+
+// entryRecords returns the number of records in a logical extent group,
+// which is the most a single directory entry can describe.
+func (fs *FS) entryRecords() int {
+	return (int(fs.dpb.EXM) + 1) * extentRecords
+}
+
+// span returns the first record of the file held in the entry and the
+// number of records it holds.
+func (fs *FS) span(e entry) (int, int) {
+	ext, exm := e.extent(), int(fs.dpb.EXM)
+
+	return ext / (exm + 1) * fs.entryRecords(), (ext&exm)*extentRecords + e.records()
+}
+
+// blockRecords returns the number of records in a block.
+func (fs *FS) blockRecords() int {
+	return fs.f.BlockSize / SectorSize
+}
+
+// sector returns the track and physical sector of a record of the data area,
+// which starts after the reserved tracks.
+func (fs *FS) sector(rec int) (int, int) {
+	track := fs.f.ReservedTracks + rec/fs.f.SectorsPerTrack
+	s := rec % fs.f.SectorsPerTrack
+	if fs.xlt != nil {
+		return track, int(fs.xlt[s])
+	}
+
+	return track, s + fs.f.FirstSector
+}
+
+// readRecord reads a record of the data area into buf.
+func (fs *FS) readRecord(rec int, buf []byte) error {
+	t, s := fs.sector(rec)
+
+	return fs.img.ReadSector(t, s, buf)
+}
+
+// writeRecord writes buf to a record of the data area.
+func (fs *FS) writeRecord(rec int, buf []byte) error {
+	t, s := fs.sector(rec)
+
+	return fs.img.WriteSector(t, s, buf)
+}
+
+// readDir returns the directory as a single buffer.
+func (fs *FS) readDir() ([]byte, error) {
+	dir := make([]byte, fs.f.DirEntries*dirEntrySize)
+	for r := 0; r*SectorSize < len(dir); r++ {
+		if err := fs.readRecord(r, dir[r*SectorSize:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return dir, nil
+}
+
+// writeDir writes the directory back to the disk.
+func (fs *FS) writeDir(dir []byte) error {
+	for r := 0; r*SectorSize < len(dir); r++ {
+		if err := fs.writeRecord(r, dir[r*SectorSize:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// entries returns the used entries of the directory.
+func (fs *FS) entries(dir []byte) []entry {
+	var es []entry
+	for i := 0; i < len(dir); i += dirEntrySize {
+		e := entry(dir[i : i+dirEntrySize])
+		if e.user() < 16 {
+			es = append(es, e)
+		}
+	}
+
+	return es
+}
+
+// List returns the files on the disk, sorted by user area and name.
+func (fs *FS) List() ([]File, error) {
+	dir, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		user int
+		name string
+	}
+	files := make(map[key]*File)
+	for _, e := range fs.entries(dir) {
+		k := key{int(e.user()), e.name()}
+		f, ok := files[k]
+		if !ok {
+			f = &File{
+				User:     k.user,
+				Name:     displayName(k.name),
+				ReadOnly: e[9]&0x80 != 0,
+				System:   e[10]&0x80 != 0,
+			}
+			files[k] = f
+		}
+
+		// The file ends at the end of its highest extent.
+		start, recs := fs.span(e)
+		if end := int64(start+recs) * SectorSize; end > f.Size {
+			f.Size = end
+		}
+	}
+
+	list := make([]File, 0, len(files))
+	for _, f := range files {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].User != list[j].User {
+			return list[i].User < list[j].User
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+// ReadFile returns the contents of the named file in the given user area.
+// The contents are whole records, so text files end with CTRL-Z padding.
+func (fs *FS) ReadFile(user int, name string) ([]byte, error) {
+	n, err := cpmName(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		data  []byte
+		found bool
+		buf   = make([]byte, SectorSize)
+	)
+	for _, e := range fs.entries(dir) {
+		if int(e.user()) != user || e.name() != n {
+			continue
+		}
+		found = true
+
+		// Entries are placed by their extent number, so sparse files read
+		// back with zeros in the gaps.
+		start, recs := fs.span(e)
+		if end := (start + recs) * SectorSize; end > len(data) {
+			data = append(data, make([]byte, end-len(data))...)
+		}
+
+		for i, b := range fs.blocks(e) {
+			for r := 0; r < fs.blockRecords(); r++ {
+				rec := i*fs.blockRecords() + r
+				if rec >= recs {
+					break
+				}
+				if b == 0 {
+					continue
+				}
+				if err := fs.readRecord(b*fs.blockRecords()+r, buf); err != nil {
+					return nil, err
+				}
+				copy(data[(start+rec)*SectorSize:], buf)
+			}
+		}
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return data, nil
+}
+
+// WriteFile writes data to the named file in the given user area, replacing
+// any existing file. A partial final record is padded with CTRL-Z.
+func (fs *FS) WriteFile(user int, name string, data []byte) error {
+	n, err := cpmName(name)
+	if err != nil {
+		return err
+	}
+	if user < 0 || user > 15 {
+		return fmt.Errorf("disk: invalid user number %d", user)
+	}
+	dir, err := fs.readDir()
+	if err != nil {
+		return err
+	}
+	fs.remove(dir, byte(user), n)
+
+	used := fs.allocated(dir)
+	recs := (len(data) + SectorSize - 1) / SectorSize
+	nEntries := (recs + fs.entryRecords() - 1) / fs.entryRecords()
+	if nEntries == 0 {
+		nEntries = 1
+	}
+
+	// Find the directory entries and blocks before writing anything, so
+	// that a full disk leaves it unchanged.
+	var free []entry
+	for i := 0; i < len(dir) && len(free) < nEntries; i += dirEntrySize {
+		if dir[i] == unused {
+			free = append(free, entry(dir[i:i+dirEntrySize]))
+		}
+	}
+	if len(free) < nEntries {
+		return ErrDirectoryFull
+	}
+
+	nBlocks := (recs + fs.blockRecords() - 1) / fs.blockRecords()
+	var blocks []int
+	for b := 0; b < len(used) && len(blocks) < nBlocks; b++ {
+		if !used[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	if len(blocks) < nBlocks {
+		return ErrDiskFull
+	}
+
+	buf := make([]byte, SectorSize)
+	for r := 0; r < recs; r++ {
+		for i := range buf {
+			buf[i] = 0x1a
+		}
+		copy(buf, data[r*SectorSize:])
+		b := blocks[r/fs.blockRecords()]
+		if err := fs.writeRecord(b*fs.blockRecords()+r%fs.blockRecords(), buf); err != nil {
+			return err
+		}
+	}
+
+	perEntry := fs.blocksPerEntry()
+	for i, e := range free {
+		for j := range e {
+			e[j] = 0
+		}
+		e[0] = byte(user)
+		copy(e[1:12], n)
+
+		// The entry records its last logical extent and the records in
+		// it.
+		er := recs - i*fs.entryRecords()
+		if er > fs.entryRecords() {
+			er = fs.entryRecords()
+		}
+		ext := i * (int(fs.dpb.EXM) + 1)
+		if er > 0 {
+			ext += (er - 1) / extentRecords
+		}
+		e[12] = byte(ext % 32)
+		e[14] = byte(ext / 32)
+		if er > 0 {
+			e[15] = byte(er - (ext-i*(int(fs.dpb.EXM)+1))*extentRecords)
+		}
+
+		eb := blocks[i*perEntry:]
+		if len(eb) > perEntry {
+			eb = eb[:perEntry]
+		}
+		for j, b := range eb {
+			if fs.bigBlocks() {
+				e[16+j*2], e[17+j*2] = byte(b), byte(b>>8)
+			} else {
+				e[16+j] = byte(b)
+			}
+		}
+	}
+
+	return fs.writeDir(dir)
+}
+
+// Remove deletes the named file in the given user area.
+func (fs *FS) Remove(user int, name string) error {
+	n, err := cpmName(name)
+	if err != nil {
+		return err
+	}
+	dir, err := fs.readDir()
+	if err != nil {
+		return err
+	}
+	if !fs.remove(dir, byte(user), n) {
+		return ErrNotFound
+	}
+
+	return fs.writeDir(dir)
+}
+
+// remove marks the entries of a file unused, reporting whether there were
+// any.
+func (fs *FS) remove(dir []byte, user byte, name string) bool {
+	var found bool
+	for _, e := range fs.entries(dir) {
+		if e.user() == user && e.name() == name {
+			e[0] = unused
+			found = true
+		}
+	}
+
+	return found
+}
+
+// allocated returns the allocation map of the disk: the directory blocks
+// and every block used by a file.
+func (fs *FS) allocated(dir []byte) []bool {
+	used := make([]bool, fs.f.Blocks)
+	for b := 0; b < fs.f.DirBlocks(); b++ {
+		used[b] = true
+	}
+	for _, e := range fs.entries(dir) {
+		for _, b := range fs.blocks(e) {
+			if b != 0 && b < len(used) {
+				used[b] = true
+			}
+		}
+	}
+
+	return used
+}
+
+// Free returns the number of free bytes on the disk.
+func (fs *FS) Free() (int64, error) {
+	dir, err := fs.readDir()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, u := range fs.allocated(dir) {
+		if !u {
+			n += int64(fs.f.BlockSize)
+		}
+	}
+
+	return n, nil
+}
+
+// Check checks the consistency of the directory and returns a description
+// of each problem found: invalid entries, blocks outside the disk or in the
+// directory, and blocks allocated to more than one file.
+func (fs *FS) Check() ([]string, error) {
+	dir, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	owner := make(map[int]string)
+	for i := 0; i < len(dir); i += dirEntrySize {
+		e := entry(dir[i : i+dirEntrySize])
+		if e.user() == unused {
+			continue
+		}
+
+		file := fmt.Sprintf("entry %d (%d:%s)", i/dirEntrySize, e.user(), displayName(e.name()))
+		if e.user() > 15 {
+			problems = append(problems, fmt.Sprintf("%s: invalid user number %d", file, e.user()))
+			continue
+		}
+		for _, c := range e.name() {
+			if c < ' ' || c > '~' {
+				problems = append(problems, fmt.Sprintf("%s: invalid character in name", file))
+				break
+			}
+		}
+		if e.records() > extentRecords {
+			problems = append(problems, fmt.Sprintf("%s: record count %d is too large", file, e.records()))
+		}
+
+		for _, b := range fs.blocks(e) {
+			switch {
+			case b == 0:
+			case b >= fs.f.Blocks:
+				problems = append(problems, fmt.Sprintf("%s: block %d is outside the disk", file, b))
+			case b < fs.f.DirBlocks():
+				problems = append(problems, fmt.Sprintf("%s: block %d is in the directory", file, b))
+			case owner[b] != "":
+				problems = append(problems, fmt.Sprintf("%s: block %d is also allocated to %s", file, b, owner[b]))
+			default:
+				owner[b] = file
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// WriteSystem writes the operating system to the reserved tracks, starting
+// at the first sector of track 0. The data normally holds the cold boot
+// loader in its first record, followed by the CCP and BDOS.
+func WriteSystem(img *Image, data []byte) error {
+	f := img.Format()
+	if len(data) > f.ReservedTracks*f.SectorsPerTrack*SectorSize {
+		return fmt.Errorf("disk: system of %d bytes does not fit in the reserved tracks", len(data))
+	}
+
+	buf := make([]byte, SectorSize)
+	for r := 0; r*SectorSize < len(data); r++ {
+		for i := range buf {
+			buf[i] = 0
+		}
+		copy(buf, data[r*SectorSize:])
+		track, s := r/f.SectorsPerTrack, r%f.SectorsPerTrack
+		if err := img.WriteSector(track, s+f.FirstSector, buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cpmName converts a name of the form NAME.TYP to the 11 characters held in
+// a directory entry.
+func cpmName(s string) (string, error) {
+	s = strings.ToUpper(s)
+	base, ext := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		base, ext = s[:i], s[i+1:]
+	}
+	if base == "" || len(base) > 8 || len(ext) > 3 {
+		return "", fmt.Errorf("disk: invalid file name %q", s)
+	}
+	for _, c := range base + ext {
+		if c <= ' ' || c > '~' || strings.ContainsRune(`.,;:=?*<>[]|"/\`, c) {
+			return "", fmt.Errorf("disk: invalid file name %q", s)
+		}
+	}
+
+	return fmt.Sprintf("%-8s%-3s", base, ext), nil
+}
+
+// displayName converts the 11 characters of a directory entry name to the
+// form NAME.TYP.
+func displayName(n string) string {
+	base := strings.TrimRight(n[:8], " ")
+	ext := strings.TrimRight(n[8:], " ")
+	if ext == "" {
+		return base
+	}
+
+	return base + "." + ext
+}
@@ -0,0 +1,189 @@
+package disk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tempImage returns a freshly formatted image in a temporary directory.
+func tempImage(t *testing.T, f *Format) (*Image, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Create(filepath.Join(dir, "disk.img"), f)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return d, func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFS(t *testing.T) {
+	for _, f := range []*Format{IBM3740, HD4MB} {
+		t.Run(f.Name, func(t *testing.T) {
+			d, cleanup := tempImage(t, f)
+			defer cleanup()
+			fs := NewFS(d)
+
+			// Large enough to need several directory entries.
+			big := make([]byte, 40000)
+			for i := range big {
+				big[i] = byte(i * 7)
+			}
+			if err := fs.WriteFile(0, "big.dat", big); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.WriteFile(3, "HELLO.TXT", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.WriteFile(0, "EMPTY", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := fs.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []File{
+				{User: 0, Name: "BIG.DAT", Size: 40064},
+				{User: 0, Name: "EMPTY", Size: 0},
+				{User: 3, Name: "HELLO.TXT", Size: 128},
+			}
+			if !reflect.DeepEqual(list, want) {
+				t.Errorf("List = %+v, want %+v", list, want)
+			}
+
+			got, err := fs.ReadFile(0, "BIG.DAT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[:len(big)], big) {
+				t.Error("BIG.DAT does not match what was written")
+			}
+			if pad := got[len(big):]; !bytes.Equal(pad, bytes.Repeat([]byte{0x1a}, len(pad))) {
+				t.Errorf("BIG.DAT padding = % x, want 1as", pad)
+			}
+
+			if _, err := fs.ReadFile(0, "HELLO.TXT"); err != ErrNotFound {
+				t.Errorf("ReadFile in wrong user area error = %v, want %v", err, ErrNotFound)
+			}
+
+			free, err := fs.Free()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Remove(0, "BIG.DAT"); err != nil {
+				t.Fatal(err)
+			}
+			after, err := fs.Free()
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := (40064 + int64(f.BlockSize) - 1) / int64(f.BlockSize)
+			if after-free != blocks*int64(f.BlockSize) {
+				t.Errorf("Remove freed %d bytes, want %d", after-free, blocks*int64(f.BlockSize))
+			}
+
+			problems, err := fs.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 0 {
+				t.Errorf("Check = %v, want no problems", problems)
+			}
+		})
+	}
+}
+
+func TestFSFull(t *testing.T) {
+	d, cleanup := tempImage(t, IBM3740)
+	defer cleanup()
+	fs := NewFS(d)
+
+	if err := fs.WriteFile(0, "A", make([]byte, 300*1024)); err != ErrDiskFull {
+		t.Errorf("WriteFile of 300K error = %v, want %v", err, ErrDiskFull)
+	}
+	for i := 0; i < 64; i++ {
+		if err := fs.WriteFile(0, string(rune('A'+i/26))+string(rune('A'+i%26)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.WriteFile(0, "MORE", nil); err != ErrDirectoryFull {
+		t.Errorf("WriteFile to full directory error = %v, want %v", err, ErrDirectoryFull)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	d, cleanup := tempImage(t, IBM3740)
+	defer cleanup()
+	fs := NewFS(d)
+
+	if err := fs.WriteFile(0, "ONE", make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(0, "TWO", make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the second file at the block of the first, and at the directory.
+	dir, err := fs.readDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir[32+16] = dir[16]
+	dir[32+17] = 1
+	if err := fs.writeDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := fs.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"entry 1 (0:TWO): block 2 is also allocated to entry 0 (0:ONE)",
+		"entry 1 (0:TWO): block 1 is in the directory",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("Check = %q, want %q", problems, want)
+	}
+}
+
+func TestWriteSystem(t *testing.T) {
+	d, cleanup := tempImage(t, IBM3740)
+	defer cleanup()
+
+	sys := bytes.Repeat([]byte{0x76}, 30*SectorSize)
+	if err := WriteSystem(d, sys); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, SectorSize)
+	if err := d.ReadSector(1, 4, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0x76 {
+		t.Errorf("track 1 sector 4 = %02x, want 76", buf[0])
+	}
+	if err := d.ReadSector(1, 5, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0xe5 {
+		t.Errorf("track 1 sector 5 = %02x, want e5", buf[0])
+	}
+
+	if err := WriteSystem(d, make([]byte, 53*SectorSize)); err == nil {
+		t.Error("WriteSystem of 53 sectors succeeded, want error")
+	}
+}
@@ -0,0 +1,125 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+type (
+	// ReadWriterAt is the storage behind a disk image.
+	ReadWriterAt interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+
+	// Image is a raw disk image.
+	Image struct {
+		rw     ReadWriterAt
+		format *Format
+		closer io.Closer
+	}
+)
+
+// NewImage returns an image of the given format stored in rw.
+func NewImage(rw ReadWriterAt, f *Format) *Image {
+	return &Image{rw: rw, format: f}
+}
+
+// Open opens the image file at path. The file is opened read-only if it
+// cannot be opened for writing.
+func Open(path string, f *Format) (*Image, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	d := NewImage(file, f)
+	d.closer = file
+
+	return d, nil
+}
+
+// Create creates a freshly formatted image file at path, replacing any
+// existing file. Every byte of a formatted disk is E5H, which leaves the
+// directory empty.
+func Create(path string, f *Format) (*Image, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	track := bytes.Repeat([]byte{0xe5}, f.SectorsPerTrack*SectorSize)
+	for t := 0; t < f.Tracks; t++ {
+		if _, err := file.Write(track); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	d := NewImage(file, f)
+	d.closer = file
+
+	return d, nil
+}
+
+// Close closes the image file, if the image was opened with Open.
+func (d *Image) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+
+	return d.closer.Close()
+}
+
+// Format returns the format of the image.
+func (d *Image) Format() *Format {
+	return d.format
+}
+
+// offset returns the position of a sector in the image.
+func (d *Image) offset(track, sector int) (int64, error) {
+	f := d.format
+	s := sector - f.FirstSector
+	if track < 0 || track >= f.Tracks || s < 0 || s >= f.SectorsPerTrack {
+		return 0, fmt.Errorf("disk: track %d sector %d out of range for %s", track, sector, f.Name)
+	}
+
+	return (int64(track)*int64(f.SectorsPerTrack) + int64(s)) * SectorSize, nil
+}
+
+// ReadSector reads a physical sector into buf, which must be SectorSize
+// bytes long. Sectors past the end of a short image read as freshly
+// formatted, filled with E5H.
+func (d *Image) ReadSector(track, sector int, buf []byte) error {
+	off, err := d.offset(track, sector)
+	if err != nil {
+		return err
+	}
+
+	n, err := d.rw.ReadAt(buf[:SectorSize], off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < SectorSize; i++ {
+		buf[i] = 0xe5
+	}
+
+	return nil
+}
+
+// WriteSector writes buf, which must be SectorSize bytes long, to a physical
+// sector.
+func (d *Image) WriteSector(track, sector int, buf []byte) error {
+	off, err := d.offset(track, sector)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.rw.WriteAt(buf[:SectorSize], off)
+
+	return err
+}
@@ -0,0 +1,655 @@
+package cpm
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// Size of a CP/M record, the unit of all disk reads and writes.
+	recordSize = 128
+
+	// Records in a logical extent.
+	extentRecords = 128
+
+	// Offsets of fields within a file control block.
+	fcbDrive   = 0
+	fcbName    = 1
+	fcbExtent  = 12
+	fcbS2      = 14
+	fcbRecords = 15
+	fcbCurrent = 32
+	fcbRandom  = 33
+
+	// Offset of the new name in a rename FCB, which follows a second drive
+	// code at offset 16.
+	fcbRename = 17
+
+	// The end of a text file is marked by CTRL-Z.
+	eofMarker = 0x1a
+
+	// Value of unused directory entries.
+	emptyEntry = 0xe5
+)
+
+// BDOS file function return codes.
+const (
+	resultOK            = 0x00
+	resultEOF           = 0x01
+	resultNoData        = 0x01
+	resultDiskFull      = 0x02
+	resultSeekPastDisk  = 0x06
+	resultNotFound      = 0xff
+	resultDirectoryFull = 0xff
+)
+
+type (
+	// fcb is a file control block in the machine's memory.
+	fcb struct {
+		mem  memory
+		addr uint16
+	}
+
+	// fileName is a CP/M file name, eight characters of name and three of
+	// type padded with spaces. It may contain '?' wildcards.
+	fileName [11]byte
+
+	// dirEntry is a file found by a directory search.
+	dirEntry struct {
+		name fileName
+		size int64
+	}
+)
+
+// drive returns the drive code of the FCB: 0 for the current drive or 1 to 16
+// for drives A to P.
+func (f fcb) drive() byte {
+	return f.mem[f.addr+fcbDrive]
+}
+
+// name returns the file name held in the FCB at the given offset. Attribute
+// bits, held in the top bit of each character, are ignored.
+func (f fcb) name(off uint16) fileName {
+	var n fileName
+	for i := range n {
+		n[i] = f.mem[f.addr+off+uint16(i)] & 0x7f
+	}
+
+	return n
+}
+
+// setName writes n into the name field of the FCB.
+func (f fcb) setName(n fileName) {
+	copy(f.mem[f.addr+fcbName:], n[:])
+}
+
+// record returns the current record number for sequential access, built from
+// the S2, extent and current record fields.
+func (f fcb) record() int {
+	return int(f.mem[f.addr+fcbS2]&0x3f)*4096 +
+		int(f.mem[f.addr+fcbExtent]&0x1f)*extentRecords +
+		int(f.mem[f.addr+fcbCurrent])
+}
+
+// setRecord sets the current record number for sequential access. The record
+// count is updated to match the extent, given the length of the file in
+// records.
+func (f fcb) setRecord(r, records int) {
+	f.mem[f.addr+fcbS2] = byte(r / 4096)
+	f.mem[f.addr+fcbExtent] = byte(r / extentRecords % 32)
+	f.mem[f.addr+fcbCurrent] = byte(r % extentRecords)
+
+	rc := records - r/extentRecords*extentRecords
+	switch {
+	case rc < 0:
+		rc = 0
+	case rc > extentRecords:
+		rc = extentRecords
+	}
+	f.mem[f.addr+fcbRecords] = byte(rc)
+}
+
+// random returns the random record number of the FCB.
+func (f fcb) random() int {
+	return int(f.mem[f.addr+fcbRandom]) |
+		int(f.mem[f.addr+fcbRandom+1])<<8 |
+		int(f.mem[f.addr+fcbRandom+2])<<16
+}
+
+// setRandom sets the random record number of the FCB.
+func (f fcb) setRandom(r int) {
+	f.mem[f.addr+fcbRandom] = byte(r)
+	f.mem[f.addr+fcbRandom+1] = byte(r >> 8)
+	f.mem[f.addr+fcbRandom+2] = byte(r >> 16)
+}
+
+// hostName converts a host file name to a CP/M file name. It returns false if
+// the name cannot be represented in CP/M, for example because it is too long.
+func hostName(s string) (fileName, bool) {
+	var n fileName
+	for i := range n {
+		n[i] = ' '
+	}
+
+	base, ext := s, ""
+	if i := strings.LastIndexByte(s, '.'); i >= 0 {
+		base, ext = s[:i], s[i+1:]
+	}
+	if base == "" || len(base) > 8 || len(ext) > 3 {
+		return n, false
+	}
+
+	for i, part := range []string{base, ext} {
+		for j := 0; j < len(part); j++ {
+			c := part[j]
+			if c <= ' ' || c >= 0x7f || strings.IndexByte(`.,;:=?*<>[]|"/\`, c) >= 0 {
+				return n, false
+			}
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			n[i*8+j] = c
+		}
+	}
+
+	return n, true
+}
+
+// parseFileName parses a file name typed on the command line, such as
+// B:FOO.ASM, into a drive code and a CP/M file name. A '*' fills the rest of
+// the name or type with '?' wildcards.
+func parseFileName(s string) (byte, fileName) {
+	var drive byte
+	if len(s) >= 2 && s[1] == ':' {
+		drive = s[0] - 'A' + 1
+		s = s[2:]
+	}
+
+	base, ext := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		base, ext = s[:i], s[i+1:]
+	}
+
+	var n fileName
+	fillName(n[:8], base)
+	fillName(n[8:], ext)
+
+	return drive, n
+}
+
+// fillName copies part into field, padding it with spaces or, after a '*',
+// with '?' wildcards.
+func fillName(field []byte, part string) {
+	pad := byte(' ')
+	for i := range field {
+		switch {
+		case pad == ' ' && i < len(part) && part[i] == '*':
+			pad = '?'
+			field[i] = pad
+		case pad == ' ' && i < len(part):
+			field[i] = part[i]
+		default:
+			field[i] = pad
+		}
+	}
+}
+
+// String returns the name in the form used on the host, with trailing spaces
+// removed and a dot between the name and type.
+func (n fileName) String() string {
+	base := strings.TrimRight(string(n[:8]), " ")
+	ext := strings.TrimRight(string(n[8:]), " ")
+	if ext == "" {
+		return base
+	}
+
+	return base + "." + ext
+}
+
+// wild reports whether the name contains wildcards.
+func (n fileName) wild() bool {
+	for _, c := range n {
+		if c == '?' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether name matches the pattern n, which may contain '?'
+// wildcards.
+func (n fileName) match(name fileName) bool {
+	for i, c := range n {
+		if c != '?' && c != name[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dir returns the host directory for the drive code of f, which is empty if
+// the drive is not mapped.
+func (m *Machine) dir(f fcb) string {
+	d := f.drive()
+	if d == 0 || d == '?' {
+		return m.drives[m.drive]
+	}
+	if d > 16 {
+		return ""
+	}
+
+	return m.drives[d-1]
+}
+
+// find returns the files in dir matching the pattern, sorted by name.
+func (m *Machine) find(dir string, pattern fileName) []dirEntry {
+	if dir == "" {
+		return nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var found []dirEntry
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		n, ok := hostName(fi.Name())
+		if !ok || !pattern.match(n) {
+			continue
+		}
+		found = append(found, dirEntry{name: n, size: fi.Size()})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return string(found[i].name[:]) < string(found[j].name[:])
+	})
+
+	return found
+}
+
+// path returns the host path of the file named in the FCB, which must not
+// contain wildcards. Existing files are matched regardless of case, new files
+// are named in upper case.
+func (m *Machine) path(f fcb, off uint16) (string, bool) {
+	dir, n := m.dir(f), f.name(off)
+	if dir == "" || n.wild() {
+		return "", false
+	}
+
+	if path, ok := m.pathIn(dir, n); ok {
+		return path, true
+	}
+
+	return filepath.Join(dir, n.String()), true
+}
+
+// file returns an open host file for the given path, opening it if needed.
+func (m *Machine) file(path string) (*os.File, error) {
+	if f, ok := m.files[path]; ok {
+		return f, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	m.files[path] = f
+
+	return f, nil
+}
+
+// closePath closes the host file for path if it is open.
+func (m *Machine) closePath(path string) {
+	if f, ok := m.files[path]; ok {
+		f.Close()
+		delete(m.files, path)
+	}
+}
+
+// records returns the length of the file in records.
+func records(f *os.File) int {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return int((fi.Size() + recordSize - 1) / recordSize)
+}
+
+// openFile implements BDOS function 15. Wildcards in the name are replaced by
+// the first matching file.
+func (m *Machine) openFile(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	found := m.find(m.dir(f), f.name(fcbName))
+	if len(found) == 0 {
+		return resultNotFound
+	}
+	f.setName(found[0].name)
+
+	path, _ := m.path(f, fcbName)
+	hf, err := m.file(path)
+	if err != nil {
+		return resultNotFound
+	}
+	f.mem[addr+fcbCurrent] = 0
+	f.setRecord(f.record(), records(hf))
+
+	return resultOK
+}
+
+// closeFile implements BDOS function 16. Host files are left open until the
+// program ends so that closing is cheap for programs which close after every
+// extent.
+func (m *Machine) closeFile(addr uint16) uint16 {
+	path, ok := m.path(fcb{m.mem, addr}, fcbName)
+	if !ok {
+		return resultNotFound
+	}
+	if _, err := os.Stat(path); err != nil {
+		return resultNotFound
+	}
+
+	return resultOK
+}
+
+// searchFirst implements BDOS function 17, returning the first matching file
+// as a directory entry in the DMA buffer.
+func (m *Machine) searchFirst(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	pattern := f.name(fcbName)
+	if f.drive() == '?' {
+		for i := range pattern {
+			pattern[i] = '?'
+		}
+	}
+	m.search = m.find(m.dir(f), pattern)
+
+	return m.searchNext()
+}
+
+// searchNext implements BDOS function 18.
+func (m *Machine) searchNext() uint16 {
+	if len(m.search) == 0 {
+		return resultNotFound
+	}
+	e := m.search[0]
+	m.search = m.search[1:]
+
+	buf := make([]byte, recordSize)
+	for i := range buf {
+		buf[i] = emptyEntry
+	}
+
+	// The entry describes the first extent of the file, with as many
+	// allocation blocks as its size needs.
+	recs := int((e.size + recordSize - 1) / recordSize)
+	if recs > extentRecords {
+		recs = extentRecords
+	}
+	buf[0] = m.user
+	copy(buf[1:12], e.name[:])
+	buf[12], buf[13], buf[14] = 0, 0, 0
+	buf[15] = byte(recs)
+	for i := 0; i < 16; i++ {
+		buf[16+i] = 0
+		if i*8 < recs {
+			buf[16+i] = byte(i + 2)
+		}
+	}
+	m.setDMABuffer(buf)
+
+	return 0
+}
+
+// deleteFile implements BDOS function 19, deleting every matching file.
+func (m *Machine) deleteFile(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+	dir := m.dir(f)
+
+	found := m.find(dir, f.name(fcbName))
+	if len(found) == 0 {
+		return resultNotFound
+	}
+	for _, e := range found {
+		path, _ := m.pathIn(dir, e.name)
+		m.closePath(path)
+		os.Remove(path)
+	}
+
+	return resultOK
+}
+
+// pathIn returns the host path of the file with the given name in dir.
+func (m *Machine) pathIn(dir string, n fileName) (string, bool) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, fi := range infos {
+		if hn, ok := hostName(fi.Name()); ok && hn == n {
+			return filepath.Join(dir, fi.Name()), true
+		}
+	}
+
+	return "", false
+}
+
+// readSequential implements BDOS function 20.
+func (m *Machine) readSequential(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	hf, ok := m.openFCB(f)
+	if !ok {
+		return resultEOF
+	}
+	r := f.record()
+	if !m.readRecord(hf, r) {
+		return resultEOF
+	}
+	f.setRecord(r+1, records(hf))
+
+	return resultOK
+}
+
+// writeSequential implements BDOS function 21.
+func (m *Machine) writeSequential(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	hf, ok := m.openFCB(f)
+	if !ok {
+		return resultDiskFull
+	}
+	r := f.record()
+	if !m.writeRecord(hf, r) {
+		return resultDiskFull
+	}
+	f.setRecord(r+1, records(hf))
+
+	return resultOK
+}
+
+// makeFile implements BDOS function 22, creating an empty file.
+func (m *Machine) makeFile(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	path, ok := m.path(f, fcbName)
+	if !ok {
+		return resultDirectoryFull
+	}
+	m.closePath(path)
+
+	hf, err := os.Create(path)
+	if err != nil {
+		return resultDirectoryFull
+	}
+	m.files[path] = hf
+	f.mem[addr+fcbCurrent] = 0
+	f.setRecord(f.record(), 0)
+
+	return resultOK
+}
+
+// renameFile implements BDOS function 23. The new name is held in the second
+// half of the FCB.
+func (m *Machine) renameFile(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	from, ok := m.pathIn(m.dir(f), f.name(fcbName))
+	if !ok {
+		return resultNotFound
+	}
+	to, ok := m.path(f, fcbRename)
+	if !ok {
+		return resultNotFound
+	}
+	if _, err := os.Stat(to); err == nil {
+		return resultNotFound
+	}
+
+	m.closePath(from)
+	if err := os.Rename(from, to); err != nil {
+		return resultNotFound
+	}
+
+	return resultOK
+}
+
+// setAttributes implements BDOS function 30. Attributes are not stored, so
+// this only checks that the file exists.
+func (m *Machine) setAttributes(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+	if len(m.find(m.dir(f), f.name(fcbName))) == 0 {
+		return resultNotFound
+	}
+
+	return resultOK
+}
+
+// readRandom implements BDOS function 33.
+func (m *Machine) readRandom(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	r := f.random()
+	if r > 0xffff {
+		return resultSeekPastDisk
+	}
+	hf, ok := m.openFCB(f)
+	if !ok {
+		return resultNoData
+	}
+	if !m.readRecord(hf, r) {
+		return resultNoData
+	}
+	f.setRecord(r, records(hf))
+
+	return resultOK
+}
+
+// writeRandom implements BDOS functions 34 and 40. Host files have no
+// unallocated blocks, so gaps are always filled with zeros.
+func (m *Machine) writeRandom(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	r := f.random()
+	if r > 0xffff {
+		return resultSeekPastDisk
+	}
+	hf, ok := m.openFCB(f)
+	if !ok {
+		return resultDiskFull
+	}
+	if !m.writeRecord(hf, r) {
+		return resultDiskFull
+	}
+	f.setRecord(r, records(hf))
+
+	return resultOK
+}
+
+// fileSize implements BDOS function 35, setting the random record number to
+// the length of the file in records.
+func (m *Machine) fileSize(addr uint16) uint16 {
+	f := fcb{m.mem, addr}
+
+	path, ok := m.pathIn(m.dir(f), f.name(fcbName))
+	if !ok {
+		return resultNotFound
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return resultNotFound
+	}
+	f.setRandom(int((fi.Size() + recordSize - 1) / recordSize))
+
+	return resultOK
+}
+
+// openFCB returns the host file for the FCB, opening it if needed.
+func (m *Machine) openFCB(f fcb) (*os.File, bool) {
+	path, ok := m.pathIn(m.dir(f), f.name(fcbName))
+	if !ok {
+		return nil, false
+	}
+	hf, err := m.file(path)
+	if err != nil {
+		return nil, false
+	}
+
+	return hf, true
+}
+
+// readRecord reads record r of the file into the DMA buffer, padding a
+// partial final record with CTRL-Z. It returns false if the record is past
+// the end of the file.
+func (m *Machine) readRecord(hf *os.File, r int) bool {
+	buf := make([]byte, recordSize)
+
+	n, err := hf.ReadAt(buf, int64(r)*recordSize)
+	if n == 0 || (err != nil && err != io.EOF) {
+		return false
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = eofMarker
+	}
+	m.setDMABuffer(buf)
+
+	return true
+}
+
+// writeRecord writes the DMA buffer to record r of the file.
+func (m *Machine) writeRecord(hf *os.File, r int) bool {
+	_, err := hf.WriteAt(m.dmaBuffer(), int64(r)*recordSize)
+
+	return err == nil
+}
+
+// dmaBuffer returns a copy of the record at the DMA address. A buffer near
+// the top of memory wraps round to 0000H, as it would for the CPU.
+func (m *Machine) dmaBuffer() []byte {
+	buf := make([]byte, recordSize)
+	for i := range buf {
+		buf[i] = m.mem.Read(m.dma + uint16(i))
+	}
+
+	return buf
+}
+
+// setDMABuffer copies buf to the DMA address, wrapping round to 0000H.
+func (m *Machine) setDMABuffer(buf []byte) {
+	for i, v := range buf {
+		m.mem.Write(m.dma+uint16(i), v)
+	}
+}
@@ -26,6 +26,29 @@ var (
 		05, 10, 10, 18, 11, 11, 07, 11, 05, 05, 10, 05, 11, 17, 07, 11, // e
 		05, 10, 10, 04, 11, 11, 07, 11, 05, 05, 10, 04, 11, 17, 07, 11, // f
 	}
+
+	//  0   1   2   3   4   5   6   7   8   9   a   b   c   d   e   f
+	opCycles8085 = [256]uint32{
+		04, 10, 07, 06, 04, 04, 07, 04, 04, 10, 07, 06, 04, 04, 07, 04, // 0
+		04, 10, 07, 06, 04, 04, 07, 04, 04, 10, 07, 06, 04, 04, 07, 04, // 1
+		04, 10, 16, 06, 04, 04, 07, 04, 04, 10, 16, 06, 04, 04, 07, 04, // 2
+		04, 10, 13, 06, 10, 10, 10, 04, 04, 10, 13, 06, 04, 04, 07, 04, // 3
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // 4
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // 5
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // 6
+		07, 07, 07, 07, 07, 07, 05, 07, 04, 04, 04, 04, 04, 04, 07, 04, // 7
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // 8
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // 9
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // a
+		04, 04, 04, 04, 04, 04, 07, 04, 04, 04, 04, 04, 04, 04, 07, 04, // b
+		06, 10, 07, 10, 9, 12, 07, 12, 06, 10, 07, 10, 9, 18, 07, 12, // c
+		06, 10, 07, 10, 9, 12, 07, 12, 06, 10, 07, 10, 9, 18, 07, 12, // d
+		06, 10, 07, 16, 9, 12, 07, 12, 06, 06, 07, 04, 9, 18, 07, 12, // e
+		06, 10, 07, 04, 9, 12, 07, 12, 06, 06, 07, 04, 9, 18, 07, 12, // f
+	}
+
+	timing8080 = &timing{op: opCycles, call: 6, ret: 6}
+	timing8085 = &timing{op: opCycles8085, jump: 3, call: 9, ret: 6}
 )
 
 type (
@@ -43,8 +66,10 @@ type (
 		// Conditions represents the condition bits of the CPU.
 		cc *conditions
 
-		// Interrupts enabled.
-		ie bool
+		// Interrupts enabled, and whether EI has just enabled them: they are
+		// not accepted until the instruction after it has run.
+		ie        bool
+		eiPending bool
 
 		// Has the CPU been halted?
 		halted bool
@@ -63,8 +88,37 @@ type (
 
 		// If set to true the emulation cycle will print debug information.
 		debug bool
+
+		// The member of the 8080 family being emulated, and its instruction
+		// timings.
+		variant Variant
+		timing  *timing
+
+		// 8085 interrupt inputs and serial lines: the RST 5.5, 6.5 and 7.5
+		// masks set by SIM, the levels on the RST 5.5, 6.5 and 7.5 inputs, the
+		// RST 7.5 flip-flop, and the SID and SOD handlers.
+		mask         byte
+		rst55, rst65 bool
+		rst75Level   bool
+		rst75        bool
+		sid          func() bool
+		sod          func(bool)
+
+		// The device driving INTR, if any.
+		intc InterruptController
+	}
+
+	// timing holds the number of cycles taken by each instruction, and the
+	// extra cycles taken by conditional jumps, calls and returns whose
+	// condition is met.
+	timing struct {
+		op              [256]uint32
+		jump, call, ret uint32
 	}
 
+	// Variant identifies a member of the 8080 family or one of its clones.
+	Variant int
+
 	// Option is a functional option that modifies a field on the CPU.
 	Option func(*Intel8080)
 
@@ -80,6 +134,31 @@ func WithDebugEnabled() Option {
 	}
 }
 
+// Variants of the CPU.
+//
+// The Soviet KR580VM80A is a clone of the 8080A. No difference in its
+// instruction timing or flags has been established, so it is emulated exactly
+// as the 8080A; the variant records which CPU a machine has.
+//
+// The Intel 8085 runs the 8080 instruction set with its own timings, sets the
+// auxiliary carry on AND, and adds the RIM and SIM instructions, the serial
+// SID and SOD lines and the TRAP, RST 5.5, 6.5 and 7.5 interrupt inputs. The
+// undocumented 8085 instructions are not emulated; those opcodes behave as
+// on the 8080.
+const (
+	Intel8080A Variant = iota
+	KR580VM80A
+	Intel8085
+)
+
+// WithVariant sets the member of the 8080 family to emulate. The default is
+// the Intel 8080A.
+func WithVariant(v Variant) Option {
+	return func(i *Intel8080) {
+		i.variant = v
+	}
+}
+
 // WithInput sets input as the input handler function.
 func WithInput(input ifn) Option {
 	return func(i *Intel8080) {
@@ -94,6 +173,17 @@ func WithOutput(output ofn) Option {
 	}
 }
 
+// WithInterruptController connects an interrupt controller to the INTR
+// input. Before each instruction, if interrupts are enabled and the controller
+// is requesting an interrupt, the CPU acknowledges it and executes the
+// instruction the controller supplies instead of the next one from memory.
+// On the 8085, INTR has a lower priority than the restart interrupts.
+func WithInterruptController(ic InterruptController) Option {
+	return func(i *Intel8080) {
+		i.intc = ic
+	}
+}
+
 // NewIntel8080 returns an instantiated Intel 8080.
 func NewIntel8080(mem MemReadWriter, opts ...Option) *Intel8080 {
 	i := &Intel8080{
@@ -105,6 +195,12 @@ func NewIntel8080(mem MemReadWriter, opts ...Option) *Intel8080 {
 		o(i)
 	}
 
+	i.timing = timing8080
+	if i.variant == Intel8085 {
+		i.timing = timing8085
+	}
+	i.mask = maskAll
+
 	return i
 }
 
@@ -112,8 +208,19 @@ func NewIntel8080(mem MemReadWriter, opts ...Option) *Intel8080 {
 func (i *Intel8080) Step() error {
 	// Use the current value of the program counter to get the next opcode from
 	// the attached memory.
+	if i.eiPending {
+		i.eiPending = false
+	} else {
+		if i.variant == Intel8085 {
+			i.restartInterrupt()
+		}
+		if i.ie && i.intc != nil && i.intc.Requesting() {
+			return i.acknowledge()
+		}
+	}
+
 	opc := i.immediateByte()
-	i.cyc += opCycles[opc]
+	i.cyc += i.timing.op[opc]
 
 	// Dump the assembly code if debug mode is on.
 	if i.debug {
@@ -142,16 +249,81 @@ func (i *Intel8080) Step() error {
 }
 
 // Interrupt sets the interrupt address which will be handled on the next
-// step.
-func (i *Intel8080) Interrupt(addr uint16) {
-	if !i.ie {
-		return
+// step. A halted CPU resumes at the instruction following the HLT.
+//
+// The interrupt is refused, returning false, if interrupts are disabled or
+// were enabled by an EI which has not yet been followed by another
+// instruction.
+func (i *Intel8080) Interrupt(addr uint16) bool {
+	if !i.ie || i.eiPending {
+		return false
+	}
+
+	i.interrupt(addr, i.timing.op[0xcd])
+
+	return true
+}
+
+// interrupt calls the given address, resuming a halted CPU and disabling
+// interrupts, and takes the given number of cycles.
+func (i *Intel8080) interrupt(addr uint16, cycles uint32) {
+	if i.halted {
+		i.halted = false
+		i.pc++
 	}
 
 	i.ie = false
 	i.stackAdd(i.pc)
 	i.pc = addr
-	i.cyc += opCycles[0xcd]
+	i.cyc += cycles
+}
+
+// acknowledge executes the instruction supplied by the interrupt controller in
+// response to INTR, resuming a halted CPU and disabling interrupts. A CALL
+// takes its address from the controller; any other instruction must be a
+// single byte, usually an RST.
+func (i *Intel8080) acknowledge() error {
+	if i.halted {
+		i.halted = false
+		i.pc++
+	}
+	i.ie = false
+
+	opc := i.intc.Acknowledge()
+	i.cyc += i.timing.op[opc]
+	if opc != 0xcd {
+		return i.handleOp(opc)
+	}
+
+	lo := i.intc.Acknowledge()
+	hi := i.intc.Acknowledge()
+	i.stackAdd(i.pc)
+	i.pc = uint16(hi)<<8 | uint16(lo)
+
+	return nil
+}
+
+// Reset emulates the RESET input: the program counter is cleared, interrupts
+// are disabled and a halted CPU resumes. On the 8085 the RST 5.5, 6.5 and 7.5
+// interrupts are also masked and the RST 7.5 flip-flop cleared. Other
+// registers are unaffected.
+func (i *Intel8080) Reset() {
+	i.pc = 0
+	i.ie, i.eiPending = false, false
+	i.halted = false
+	i.mask = maskAll
+	i.rst75 = false
+}
+
+// InterruptsEnabled returns true if the CPU will accept an interrupt, i.e. the
+// state of its INTE output.
+func (i *Intel8080) InterruptsEnabled() bool {
+	return i.ie
+}
+
+// Variant returns the member of the 8080 family being emulated.
+func (i *Intel8080) Variant() Variant {
+	return i.variant
 }
 
 // Cycles returns the current cycle count.
@@ -164,6 +336,27 @@ func (i *Intel8080) Accumulator() byte {
 	return i.r[A]
 }
 
+// ProgramCounter returns the address of the next instruction to be executed.
+func (i *Intel8080) ProgramCounter() uint16 {
+	return i.pc
+}
+
+// SetProgramCounter sets the address of the next instruction to be executed,
+// for example to the start address of a loaded program.
+func (i *Intel8080) SetProgramCounter(addr uint16) {
+	i.pc = addr
+}
+
+// StackPointer returns the address of the top of the stack.
+func (i *Intel8080) StackPointer() uint16 {
+	return i.sp
+}
+
+// SetStackPointer sets the address of the top of the stack.
+func (i *Intel8080) SetStackPointer(addr uint16) {
+	i.sp = addr
+}
+
 // Running returns true if the CPU is running.
 func (i *Intel8080) Running() bool {
 	return !i.halted
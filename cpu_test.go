@@ -1,30 +1,17 @@
-package go8080
+package go8080_test
 
 import (
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
-)
-
-type mem []byte
-
-// Read returns the value from memory at the given address.
-func (m mem) Read(addr uint16) byte {
-	return m[addr]
-}
-
-// ReadAll returns the full memory contents.
-func (m mem) ReadAll() []byte {
-	return m
-}
-
-// Write writes the value v into memory at the given address.
-func (m mem) Write(addr uint16, v byte) {
-	m[addr] = v
-}
 
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/cpm"
+)
 
 var debug = flag.Bool("debug", false, "Run the emulator in debug mode")
 
@@ -44,77 +31,81 @@ func TestCPU(t *testing.T) {
 func testHarness(t *testing.T, rom string) {
 	fmt.Println("*******************")
 
-	// Instantiate 64K of memory.
-	mem := make(mem, 65536)
+	var opts []go8080.Option
+	if *debug {
+		opts = append(opts, go8080.WithDebugEnabled())
+	}
 
-	// Load the test ROM.
-	rf, err := os.Open(rom)
-	if err != nil {
+	// The test ROMs are CP/M programs which report their results through the
+	// BDOS console functions.
+	m := cpm.New(
+		cpm.WithConsole(nil, os.Stdout),
+		cpm.WithCPUOptions(opts...),
+	)
+	if err := m.LoadFile(rom); err != nil {
 		t.Fatal(err)
 	}
-	defer rf.Close()
-
-	// The test ROM assumes the program code starts at 0x100. So read the ROM
-	// into memory with this as an offset.
-	if _, err = rf.Read(mem[0x100:]); err != nil {
+	if err := m.Run(); err != nil {
 		t.Fatal(err)
 	}
 
-	// Manually set the first instruction in the memory to be a JMP to 0x100.
-	// This will force the emulation to start at the point where the ROM expects.
-	mem.Write(0, 0xc3)
-	mem.Write(1, 0)
-	mem.Write(2, 0x01)
-
-	// Fix a bug in the test ROM where it does not return from the final success
-	// message.
-	mem.Write(0x0005, 0xc9)
+	fmt.Println()
+	fmt.Println("*******************")
+}
 
-	var opts []Option
-	if *debug {
-		opts = append(opts, WithDebugEnabled())
+// rst7 is an interrupt controller which always requests RST 7.
+type rst7 struct{}
+
+func (rst7) Requesting() bool  { return true }
+func (rst7) Acknowledge() byte { return 0xff }
+
+// A program which enables interrupts and halts, with interrupt handlers for
+// RST 6.5 and RST 7 which return with EI and RET.
+const programEI = `
+	ORG	0
+	LXI	SP,1000H
+	MVI	A,08H
+	SIM
+	EI
+LOOP:	HLT
+	JMP	LOOP
+	ORG	34H
+	EI
+	RET
+	ORG	38H
+	EI
+	RET
+`
+
+func TestEIDelay(t *testing.T) {
+	prog, err := asm.Assemble("ei.asm", strings.NewReader(programEI))
+	if err != nil {
+		t.Fatal(err)
 	}
-	i80 := NewIntel8080(mem, opts...)
-
-	for {
-		if i80.halted {
-			t.Fatal("unexpected halt")
-		}
 
-		if err := i80.Step(); err != nil {
-			t.Fatal(err)
-		}
-
-		// Emulate the standard out process implemented in CP/M OS in order to
-		// allow us to see the output from the ROM.
-		//
-		// See: https://en.wikipedia.org/wiki/CP/M
-		if i80.pc == 0x05 {
-			if i80.r[C] == 0x09 {
-				addr := uint16(i80.r[D])<<8 | uint16(i80.r[E])
-
-				for {
-					c := mem.Read(addr)
-
-					if fmt.Sprintf("%c", c) == "$" {
-						break
-					} else {
-						addr++
-					}
-
-					fmt.Printf("%c", c)
-				}
+	// An interrupt held active is taken again only after each RET, so
+	// the stack never holds more than one return address. The last case
+	// raises RST 7 through Interrupt before every instruction.
+	for _, opts := range [][]go8080.Option{
+		{go8080.WithInterruptController(rst7{})},
+		{go8080.WithVariant(go8080.Intel8085)},
+		nil,
+	} {
+		mem := make(ram, 0x10000)
+		copy(mem, prog.Binary())
+		cpu := go8080.NewIntel8080(mem, opts...)
+		cpu.SetInterruptInput(go8080.RST65, true)
+
+		for n := 0; n < 200; n++ {
+			if opts == nil {
+				cpu.Interrupt(0x38)
 			}
-			if i80.r[C] == 0x02 {
-				fmt.Printf("%c", i80.r[E])
+			if err := cpu.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if sp := cpu.StackPointer(); sp < 0x0ffe {
+				t.Fatalf("SP = %04x after %d steps, want at least 0ffe", sp, n+1)
 			}
-		}
-
-		if i80.pc == 0x00 {
-			break
 		}
 	}
-
-	fmt.Println()
-	fmt.Println("*******************")
 }
@@ -0,0 +1,265 @@
+// Package i8155 emulates the Intel 8155 and 8156 static RAM with I/O ports
+// and timer.
+//
+// The chip has 256 bytes of RAM, two 8-bit ports and a 6-bit port, and a
+// 14-bit timer. Its RAM is reached with Read and Write, addressed by the low
+// byte of the memory address, and its ports and timer through the six I/O
+// registers. The ports work as simple inputs and outputs; the strobed
+// handshake modes of ports A and B are not emulated and port C always acts as
+// a simple port in those modes.
+//
+// The timer counts cycles of its TIMER IN pin, which the machine supplies with
+// Clock, usually from the CPU clock.
+package i8155
+
+// Register offsets.
+const (
+	CommandStatus = 0
+	A             = 1
+	B             = 2
+	C             = 3
+	TimerLow      = 4
+	TimerHigh     = 5
+)
+
+// RAMSize is the size of the RAM.
+const RAMSize = 256
+
+// Command register bits.
+const (
+	cmdAOutput     = 0x01
+	cmdBOutput     = 0x02
+	cmdCMode       = 0x0c
+	cmdCOutput     = 0x0c
+	cmdTimer       = 0xc0
+	timerStop      = 0x40
+	timerStopTC    = 0x80
+	timerStart     = 0xc0
+	timerModeShift = 6
+)
+
+// TimerDone is the status register bit set when the timer reaches its
+// terminal count, and cleared when the status is read.
+const TimerDone = 0x40
+
+// Timer output modes, from the top two bits of the high timer register.
+const (
+	SingleSquareWave     = 0
+	ContinuousSquareWave = 1
+	SinglePulse          = 2
+	ContinuousPulse      = 3
+)
+
+type (
+	// RAMIO is an 8155.
+	RAMIO struct {
+		ram [RAMSize]byte
+
+		command byte
+		latch   [3]byte
+		in      [3]func() byte
+		out     [3]func(byte)
+
+		// The timer's length and mode as written.
+		length uint16
+		mode   byte
+
+		// Cycles left until the terminal count, whether the timer is
+		// running, and whether it stops at the next terminal count.
+		count   uint32
+		running bool
+		stopTC  bool
+
+		done    bool
+		timeout func()
+	}
+
+	// Option is a functional option that modifies a field on the chip.
+	Option func(*RAMIO)
+)
+
+// WithInput sets the function which supplies the levels on the pins of the
+// given port, A, B or C, when it is read as an input.
+func WithInput(port int, fn func() byte) Option {
+	return func(r *RAMIO) {
+		r.in[port-A] = fn
+	}
+}
+
+// WithOutput sets the function called with the given port's output latch
+// whenever it is written while the port is an output.
+func WithOutput(port int, fn func(v byte)) Option {
+	return func(r *RAMIO) {
+		r.out[port-A] = fn
+	}
+}
+
+// WithTimerOutput sets the function called each time the timer reaches its
+// terminal count, when the TIMER OUT pin pulses or changes level.
+func WithTimerOutput(fn func()) Option {
+	return func(r *RAMIO) {
+		r.timeout = fn
+	}
+}
+
+// New returns a chip in its reset state, with all ports programmed as inputs
+// and the timer stopped.
+func New(opts ...Option) *RAMIO {
+	r := &RAMIO{}
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Read returns the byte of RAM at the given address.
+func (r *RAMIO) Read(addr byte) byte {
+	return r.ram[addr]
+}
+
+// Write writes the byte of RAM at the given address.
+func (r *RAMIO) Write(addr, v byte) {
+	r.ram[addr] = v
+}
+
+// Ports returns the number of I/O registers decoded by the chip.
+func (r *RAMIO) Ports() int {
+	return 6
+}
+
+// In reads an I/O register. Reading the status clears the timer's terminal
+// count flag. Ports programmed as outputs read back the latch.
+func (r *RAMIO) In(offset byte) byte {
+	switch offset {
+	case CommandStatus:
+		var s byte
+		if r.done {
+			s |= TimerDone
+		}
+		r.done = false
+		return s
+	case A:
+		return r.read(A, r.command&cmdAOutput == 0)
+	case B:
+		return r.read(B, r.command&cmdBOutput == 0)
+	case C:
+		return r.read(C, r.command&cmdCMode != cmdCOutput) & 0x3f
+	case TimerLow:
+		return byte(r.remaining())
+	case TimerHigh:
+		return byte(r.remaining()>>8)&0x3f | r.mode<<timerModeShift
+	}
+
+	return 0xff
+}
+
+// Out writes an I/O register.
+func (r *RAMIO) Out(offset, v byte) {
+	switch offset {
+	case CommandStatus:
+		r.command = v
+		switch v & cmdTimer {
+		case timerStop:
+			r.running = false
+		case timerStopTC:
+			r.stopTC = r.running
+		case timerStart:
+			if !r.running {
+				r.start()
+			}
+		}
+		for port := A; port <= C; port++ {
+			r.notify(port)
+		}
+	case A, B, C:
+		r.latch[offset-A] = v
+		r.notify(int(offset))
+	case TimerLow:
+		r.length = r.length&0x3f00 | uint16(v)
+	case TimerHigh:
+		r.length = r.length&0x00ff | uint16(v&0x3f)<<8
+		r.mode = v >> timerModeShift
+	}
+}
+
+// Clock runs the timer for the given number of TIMER IN cycles.
+func (r *RAMIO) Clock(cycles uint32) {
+	for r.running && cycles > 0 {
+		if cycles < r.count {
+			r.count -= cycles
+			return
+		}
+		cycles -= r.count
+		r.terminalCount()
+	}
+}
+
+// Running returns true if the timer is running.
+func (r *RAMIO) Running() bool {
+	return r.running
+}
+
+// start loads the timer from the length and mode registers and starts it. A
+// length below 2 is not allowed on the real chip and is treated as 2.
+func (r *RAMIO) start() {
+	r.count = uint32(r.length)
+	if r.count < 2 {
+		r.count = 2
+	}
+	r.running, r.stopTC = true, false
+}
+
+// terminalCount handles the timer reaching its terminal count: it reloads in
+// the continuous modes and stops otherwise, or if asked to stop at the
+// terminal count. A new length and mode written while it ran take effect.
+func (r *RAMIO) terminalCount() {
+	r.done = true
+	if r.timeout != nil {
+		r.timeout()
+	}
+
+	if r.stopTC || r.mode == SingleSquareWave || r.mode == SinglePulse {
+		r.running = false
+		return
+	}
+	r.start()
+}
+
+// remaining returns the count left in the timer.
+func (r *RAMIO) remaining() uint16 {
+	if !r.running {
+		return r.length
+	}
+
+	return uint16(r.count)
+}
+
+// read reads port A, B or C.
+func (r *RAMIO) read(port int, input bool) byte {
+	if !input {
+		return r.latch[port-A]
+	}
+	if r.in[port-A] == nil {
+		return 0xff
+	}
+
+	return r.in[port-A]()
+}
+
+// notify calls a port's output callback, if it is an output.
+func (r *RAMIO) notify(port int) {
+	var output bool
+	switch port {
+	case A:
+		output = r.command&cmdAOutput != 0
+	case B:
+		output = r.command&cmdBOutput != 0
+	case C:
+		output = r.command&cmdCMode == cmdCOutput
+	}
+
+	if output && r.out[port-A] != nil {
+		r.out[port-A](r.latch[port-A])
+	}
+}
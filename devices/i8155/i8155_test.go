@@ -0,0 +1,63 @@
+package i8155
+
+import "testing"
+
+func TestPorts(t *testing.T) {
+	var outA []byte
+	r := New(
+		WithInput(B, func() byte { return 0x5a }),
+		WithInput(C, func() byte { return 0xff }),
+		WithOutput(A, func(v byte) { outA = append(outA, v) }),
+	)
+
+	r.Write(0x10, 0x42)
+	if v := r.Read(0x10); v != 0x42 {
+		t.Errorf("RAM = %02x, want 42", v)
+	}
+
+	// Port A output, B and C input.
+	r.Out(CommandStatus, 0x01)
+	r.Out(A, 0x12)
+	if v := r.In(A); v != 0x12 {
+		t.Errorf("port A = %02x, want 12", v)
+	}
+	if v := r.In(B); v != 0x5a {
+		t.Errorf("port B = %02x, want 5a", v)
+	}
+	if v := r.In(C); v != 0x3f {
+		t.Errorf("port C = %02x, want 3f", v)
+	}
+	if want := []byte{0, 0x12}; string(outA) != string(want) {
+		t.Errorf("port A output = % x, want % x", outA, want)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	var pulses int
+	r := New(WithTimerOutput(func() { pulses++ }))
+
+	// A continuous pulse every 100 cycles.
+	r.Out(TimerLow, 100)
+	r.Out(TimerHigh, ContinuousPulse<<6)
+	r.Out(CommandStatus, timerStart)
+	r.Clock(250)
+	if pulses != 2 {
+		t.Errorf("pulses = %d, want 2", pulses)
+	}
+	if v := r.In(TimerLow); v != 50 {
+		t.Errorf("count = %d, want 50", v)
+	}
+	if s := r.In(CommandStatus); s != TimerDone {
+		t.Errorf("status = %02x, want %02x", s, TimerDone)
+	}
+	if s := r.In(CommandStatus); s != 0 {
+		t.Errorf("status = %02x after read, want 0", s)
+	}
+
+	// Stop at the next terminal count.
+	r.Out(CommandStatus, timerStopTC)
+	r.Clock(1000)
+	if pulses != 3 || r.Running() {
+		t.Errorf("pulses = %d, running %v, want 3 and stopped", pulses, r.Running())
+	}
+}
@@ -0,0 +1,412 @@
+// Package i8251 emulates the Intel 8251A universal synchronous/asynchronous
+// receiver/transmitter (USART).
+//
+// The USART's serial side is an io.ReadWriter on the host: characters it
+// transmits are written to it and characters read from it are received. The
+// host is read in the background, so a program polling the USART never
+// blocks, and a character is only taken from the host once the receiver's
+// buffer is empty, as if the host used flow control, so the host cannot
+// overrun the receiver.
+//
+// Without WithClock characters are transferred as soon as they are written or
+// arrive. With it each character takes the time it would on the line, at the
+// baud rate given by the TxC and RxC clock and the mode's factor, measured in
+// CPU cycles.
+//
+// In synchronous mode the receiver hunts for the sync characters after an
+// enter hunt command, or for the SYNDET input with external sync detect, and
+// then receives every character. The sync characters the transmitter sends
+// while it has no data are not written to the host. Parity, framing and break
+// conditions cannot arise from a byte stream and are not emulated.
+package i8251
+
+import (
+	"io"
+
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// Register offsets: the C/D input is wired to the low address bit.
+const (
+	Data    = 0
+	Control = 1
+)
+
+// Status register bits.
+const (
+	TxRDY   = 1 << iota // Transmit buffer empty.
+	RxRDY               // A character has been received.
+	TxEMPTY             // Transmitter idle.
+	PE                  // Parity error.
+	OE                  // Overrun error.
+	FE                  // Framing error.
+	SYNDET              // Sync character detected, or break detected.
+	DSR                 // Data set ready.
+)
+
+// Mode word fields.
+const (
+	modeFactor   = 0x03
+	modeSync     = 0x00
+	modeLength   = 0x0c
+	modeParity   = 0x10
+	modeStop     = 0xc0
+	modeExtSync  = 0x40
+	modeOneSync  = 0x80
+	lengthShift  = 2
+	stopShift    = 6
+	stopOneHalf  = 2
+	stopTwo      = 3
+	minCharBits  = 5
+	halfBitShift = 1
+)
+
+// Command word bits.
+const (
+	cmdTxEnable      = 0x01
+	cmdDTR           = 0x02
+	cmdRxEnable      = 0x04
+	cmdErrorReset    = 0x10
+	cmdRTS           = 0x20
+	cmdInternalReset = 0x40
+	cmdEnterHunt     = 0x80
+)
+
+// factors are the asynchronous baud rate factors, by the low bits of the
+// mode word.
+var factors = [4]uint64{1, 1, 16, 64}
+
+type (
+	// USART is an 8251A.
+	USART struct {
+		line *serial.Line
+
+		// The CPU cycle counter and clock rates timing each character, and
+		// the cycles each character takes. cycles is nil for instant
+		// transfers.
+		cycles         func() uint32
+		cpuHz, clockHz uint64
+		charCycles     uint32
+
+		// Set until the mode word has been written after a reset, and the
+		// number of sync characters still to be written after it.
+		expectMode bool
+		expectSync int
+
+		mode, command byte
+		sync          [2]byte
+
+		// The DSR and CTS inputs.
+		dsr, cts bool
+
+		// Status flags kept between reads: RxRDY, the error flags and SYNDET.
+		status byte
+
+		// The receive buffer, and the cycle count at which the next
+		// character can arrive. While hunting, huntMatched counts the sync
+		// characters matched so far.
+		rx          byte
+		rxNext      uint32
+		hunting     bool
+		huntMatched int
+
+		// The transmit buffer and shift register, and the cycle count at
+		// which the character being shifted out has been sent.
+		tx, shift       byte
+		txFull, txShift bool
+		txDone          uint32
+	}
+
+	// Option is a functional option that modifies a field on the USART.
+	Option func(*USART)
+)
+
+// WithClock times the transfer of each character. cycles returns the CPU
+// cycle count, cpuHz is the CPU clock rate and clockHz the rate of the TxC
+// and RxC clocks, which is the baud rate times the mode's factor of 1, 16 or
+// 64 in asynchronous mode, or the baud rate in synchronous mode.
+func WithClock(cycles func() uint32, cpuHz, clockHz int) Option {
+	return func(u *USART) {
+		u.cycles = cycles
+		u.cpuHz, u.clockHz = uint64(cpuHz), uint64(clockHz)
+	}
+}
+
+// New returns a USART whose serial side is rw, waiting for its mode word. rw
+// may be nil for a USART which never receives and whose output is discarded.
+// A *serial.Line is used as it is; anything else is read through a new one.
+// DSR and CTS are asserted.
+func New(rw io.ReadWriter, opts ...Option) *USART {
+	u := &USART{expectMode: true, dsr: true, cts: true}
+	switch l := rw.(type) {
+	case *serial.Line:
+		u.line = l
+	case nil:
+		u.line = serial.NewLine(nil, nil)
+	default:
+		u.line = serial.NewLine(rw, rw)
+	}
+
+	for _, o := range opts {
+		o(u)
+	}
+
+	return u
+}
+
+// Ports returns the number of ports decoded by the USART.
+func (u *USART) Ports() int {
+	return 2
+}
+
+// In reads the received data or the status register. Reading the status in
+// synchronous mode clears the sync detect flag.
+func (u *USART) In(offset byte) byte {
+	u.update()
+	if offset&1 == Data {
+		u.status &^= RxRDY
+		return u.rx
+	}
+
+	s := u.Status()
+	if u.synchronous() && u.mode&modeExtSync == 0 {
+		u.status &^= SYNDET
+	}
+
+	return s
+}
+
+// Out writes the transmit data, or a mode, sync or command word.
+func (u *USART) Out(offset, v byte) {
+	u.update()
+	if offset&1 == Data {
+		u.tx, u.txFull = v&u.charMask(), true
+		u.update()
+		return
+	}
+
+	switch {
+	case u.expectMode:
+		u.setMode(v)
+	case u.expectSync > 0:
+		u.sync[len(u.sync)-u.expectSync] = v
+		u.expectSync--
+		if u.mode&modeOneSync != 0 {
+			u.sync[1] = v
+			u.expectSync = 0
+		}
+	case v&cmdInternalReset != 0:
+		u.reset()
+	default:
+		u.command = v &^ (cmdErrorReset | cmdEnterHunt)
+		if v&cmdErrorReset != 0 {
+			u.status &^= PE | OE | FE
+		}
+		if v&cmdEnterHunt != 0 && u.synchronous() {
+			u.hunting, u.huntMatched = true, 0
+			u.status &^= SYNDET
+		}
+		u.update()
+	}
+}
+
+// Status returns the status register. Unlike reading it with In, it does not
+// clear the sync detect flag.
+func (u *USART) Status() byte {
+	u.update()
+
+	s := u.status
+	if !u.txFull {
+		s |= TxRDY
+		if !u.txShift {
+			s |= TxEMPTY
+		}
+	}
+	if u.dsr {
+		s |= DSR
+	}
+
+	return s
+}
+
+// TxReady returns the level of the TxRDY output: the transmit buffer is
+// empty, the transmitter enabled and CTS asserted. It is usually wired to an
+// interrupt request.
+func (u *USART) TxReady() bool {
+	u.update()
+	return !u.txFull && u.command&cmdTxEnable != 0 && u.cts
+}
+
+// RxReady returns the level of the RxRDY output: a character has been
+// received and not read. It is usually wired to an interrupt request.
+func (u *USART) RxReady() bool {
+	u.update()
+	return u.status&RxRDY != 0
+}
+
+// TxEmpty returns the level of the TxEMPTY output.
+func (u *USART) TxEmpty() bool {
+	return u.Status()&TxEMPTY != 0
+}
+
+// SyncDetect returns the level of the SYNDET output.
+func (u *USART) SyncDetect() bool {
+	return u.Status()&SYNDET != 0
+}
+
+// DTR returns true if the DTR output is asserted.
+func (u *USART) DTR() bool {
+	return u.command&cmdDTR != 0
+}
+
+// RTS returns true if the RTS output is asserted.
+func (u *USART) RTS() bool {
+	return u.command&cmdRTS != 0
+}
+
+// SetDSR sets the DSR input, which is read back in the status register.
+func (u *USART) SetDSR(asserted bool) {
+	u.dsr = asserted
+}
+
+// SetCTS sets the CTS input, which must be asserted for the transmitter to
+// send.
+func (u *USART) SetCTS(asserted bool) {
+	u.cts = asserted
+}
+
+// ExternalSync raises the SYNDET input in synchronous mode with external sync
+// detect, ending the receiver's hunt.
+func (u *USART) ExternalSync() {
+	if u.synchronous() && u.mode&modeExtSync != 0 && u.hunting {
+		u.hunting = false
+		u.status |= SYNDET
+	}
+}
+
+// Exhausted returns true once all the host's input has been received.
+func (u *USART) Exhausted() bool {
+	return u.line.Exhausted()
+}
+
+// reset returns the USART to waiting for a mode word.
+func (u *USART) reset() {
+	u.expectMode, u.expectSync = true, 0
+	u.command, u.status = 0, 0
+	u.txFull, u.txShift = false, false
+	u.hunting = false
+}
+
+// setMode sets the mode word and the time taken by each character.
+func (u *USART) setMode(v byte) {
+	u.mode, u.expectMode = v, false
+
+	bits := uint64(v&modeLength>>lengthShift) + minCharBits
+	if v&modeParity != 0 {
+		bits++
+	}
+
+	factor := uint64(1)
+	if u.synchronous() {
+		u.expectSync = len(u.sync)
+		u.hunting, u.huntMatched = true, 0
+	} else {
+		factor = factors[v&modeFactor]
+		bits++ // Start bit.
+	}
+
+	// Count in half bits, for one and a half stop bits.
+	half := bits << halfBitShift
+	if !u.synchronous() {
+		switch v & modeStop >> stopShift {
+		case stopOneHalf:
+			half += 3
+		case stopTwo:
+			half += 4
+		default:
+			half += 2
+		}
+	}
+
+	u.charCycles = 0
+	if u.cycles != nil && u.clockHz > 0 {
+		u.charCycles = uint32(u.cpuHz * factor * half / (2 * u.clockHz))
+	}
+	if u.cycles != nil {
+		u.rxNext = u.cycles()
+	}
+}
+
+// update brings the transmitter and receiver up to the current cycle count.
+func (u *USART) update() {
+	now := u.now()
+
+	// Finish the character being sent, and start the next one when it is
+	// done, or now if the transmitter was idle.
+	start := now
+	for {
+		if u.txShift && int32(now-u.txDone) >= 0 {
+			u.line.Transmit(u.shift)
+			u.txShift, start = false, u.txDone
+		}
+		if u.txShift || !u.txFull || u.command&cmdTxEnable == 0 || !u.cts {
+			break
+		}
+		u.shift, u.txFull, u.txShift = u.tx, false, true
+		u.txDone = start + u.charCycles
+	}
+
+	u.receive(now)
+}
+
+// receive takes characters from the host while the receiver is enabled and
+// its buffer empty, once each character's time on the line has passed.
+func (u *USART) receive(now uint32) {
+	for u.command&cmdRxEnable != 0 && u.status&RxRDY == 0 && int32(now-u.rxNext) >= 0 {
+		b, ok := u.line.Receive()
+		if !ok {
+			return
+		}
+		u.rxNext = now + u.charCycles
+		b &= u.charMask()
+
+		if !u.hunting {
+			u.rx = b
+			u.status |= RxRDY
+			continue
+		}
+		if u.mode&modeExtSync != 0 {
+			continue
+		}
+
+		// Hunt for the sync characters.
+		if b == u.sync[u.huntMatched] {
+			u.huntMatched++
+		} else if u.huntMatched = 0; b == u.sync[0] {
+			u.huntMatched = 1
+		}
+		if u.huntMatched == len(u.sync) || u.mode&modeOneSync != 0 && u.huntMatched == 1 {
+			u.hunting = false
+			u.status |= SYNDET
+		}
+	}
+}
+
+// now returns the current CPU cycle count, or zero without a clock.
+func (u *USART) now() uint32 {
+	if u.cycles == nil {
+		return 0
+	}
+
+	return u.cycles()
+}
+
+// charMask returns the mask of the bits in a character.
+func (u *USART) charMask() byte {
+	return byte(0xff) >> (8 - (u.mode&modeLength>>lengthShift + minCharBits))
+}
+
+// synchronous returns true in synchronous mode.
+func (u *USART) synchronous() bool {
+	return !u.expectMode && u.mode&modeFactor == modeSync
+}
@@ -0,0 +1,189 @@
+package i8251
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// poll calls read until its result has any of the given bits set, giving the
+// host's line time to deliver its input.
+func poll(t *testing.T, read func() byte, bits byte) byte {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if s := read(); s&bits != 0 {
+			return s
+		}
+		runtime.Gosched()
+	}
+	t.Fatalf("status bits %02x never set", bits)
+
+	return 0
+}
+
+// status polls the USART's status register until any of the given bits are
+// set.
+func status(t *testing.T, u *USART, bits byte) byte {
+	t.Helper()
+
+	return poll(t, func() byte { return u.In(Control) }, bits)
+}
+
+func TestUSART(t *testing.T) {
+	var out bytes.Buffer
+	u := New(serial.NewLine(strings.NewReader("A"), &out))
+
+	// Mode, then a command with the transmitter disabled.
+	u.Out(Control, 0x4e)
+	u.Out(Control, 0x04)
+	u.Out(Data, 'X')
+	if s := u.In(Control); s&(TxRDY|TxEMPTY) != 0 {
+		t.Errorf("status = %02x, want transmitter busy", s)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q with transmitter disabled", out.String())
+	}
+
+	// Enabling the transmitter sends the waiting character.
+	u.Out(Control, 0x05)
+	if got := out.String(); got != "X" {
+		t.Errorf("output = %q, want %q", got, "X")
+	}
+	if s := status(t, u, RxRDY); s != TxRDY|RxRDY|TxEMPTY|DSR {
+		t.Errorf("status = %02x, want %02x", s, TxRDY|RxRDY|TxEMPTY|DSR)
+	}
+	if b := u.In(Data); b != 'A' {
+		t.Errorf("data = %q, want 'A'", b)
+	}
+
+	// An internal reset returns to expecting a mode word.
+	u.Out(Control, 0x40)
+	u.Out(Control, 0x4e)
+	if u.expectMode || u.mode != 0x4e || u.command != 0 {
+		t.Errorf("after reset: expectMode %v mode %02x command %02x", u.expectMode, u.mode, u.command)
+	}
+}
+
+func TestClock(t *testing.T) {
+	var (
+		out    bytes.Buffer
+		cycles uint32
+	)
+	host := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader("AB"), &out}
+
+	// 8 data bits, no parity, 1 stop bit, x16: 10 bits at 1000 cycles a bit.
+	u := New(host, WithClock(func() uint32 { return cycles }, 2000000, 32000))
+	u.Out(Control, 0x4e)
+	u.Out(Control, 0x05)
+
+	u.Out(Data, 'X')
+	u.Out(Data, 'Y')
+	if s := u.In(Control); s&(TxRDY|TxEMPTY) != 0 {
+		t.Errorf("status = %02x, want transmitter busy", s)
+	}
+	if u.TxReady() {
+		t.Error("TxRDY with the buffer full")
+	}
+
+	cycles = 9999
+	u.Status()
+	if out.String() != "" {
+		t.Errorf("output = %q before the first character is sent", out.String())
+	}
+	cycles = 10000
+	if !u.TxReady() {
+		t.Error("no TxRDY after the first character")
+	}
+	cycles = 20000
+	if s := u.Status(); s&TxEMPTY == 0 {
+		t.Errorf("status = %02x, want transmitter empty", s)
+	}
+	if got := out.String(); got != "XY" {
+		t.Errorf("output = %q, want %q", got, "XY")
+	}
+
+	// The second character arrives a character time after the first is
+	// taken.
+	status(t, u, RxRDY)
+	if !u.RxReady() {
+		t.Error("no RxRDY with a character received")
+	}
+	if b := u.In(Data); b != 'A' {
+		t.Errorf("data = %q, want 'A'", b)
+	}
+	for i := 0; i < 1000; i++ {
+		if u.Status()&RxRDY != 0 {
+			t.Fatal("second character received too soon")
+		}
+	}
+	cycles += 10000
+	status(t, u, RxRDY)
+	if b := u.In(Data); b != 'B' {
+		t.Errorf("data = %q, want 'B'", b)
+	}
+
+	// CTS gates the transmitter.
+	u.SetCTS(false)
+	u.Out(Data, 'Z')
+	cycles += 10000
+	if u.TxReady() || out.String() != "XY" {
+		t.Errorf("sent %q with CTS negated", out.String())
+	}
+	u.SetCTS(true)
+	cycles += 10000
+	u.Status()
+	cycles += 10000
+	u.Status()
+	if got := out.String(); got != "XYZ" {
+		t.Errorf("output = %q, want %q", got, "XYZ")
+	}
+}
+
+func TestSync(t *testing.T) {
+	var out bytes.Buffer
+	u := New(serial.NewLine(strings.NewReader("xx\x16\x16AB"), &out))
+
+	// Synchronous, 8 bits, two sync characters.
+	u.Out(Control, 0x0c)
+	u.Out(Control, 0x16)
+	u.Out(Control, 0x16)
+	u.Out(Control, 0xb7)
+	if !u.DTR() || !u.RTS() {
+		t.Error("DTR and RTS not asserted")
+	}
+
+	// Poll without clearing SYNDET.
+	poll(t, u.Status, RxRDY)
+	if s := u.In(Control); s&SYNDET == 0 {
+		t.Errorf("status = %02x, want sync detected", s)
+	}
+	if s := u.In(Control); s&SYNDET != 0 {
+		t.Errorf("status = %02x, SYNDET not cleared by read", s)
+	}
+	if b := u.In(Data); b != 'A' {
+		t.Errorf("data = %q, want 'A'", b)
+	}
+
+	u.Out(Data, 'Q')
+	if got := out.String(); got != "Q" {
+		t.Errorf("output = %q, want %q", got, "Q")
+	}
+
+	// 7 bit characters lose their top bit.
+	u.Out(Control, 0x40)
+	u.Out(Control, 0x4a)
+	u.Out(Control, 0x01)
+	u.Out(Data, 0xc1)
+	if got := out.String(); got != "QA" {
+		t.Errorf("output = %q, want %q", got, "QA")
+	}
+}
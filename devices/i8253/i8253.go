@@ -0,0 +1,596 @@
+// Package i8253 emulates the Intel 8253 and 8254 programmable interval
+// timers.
+//
+// The chip has three 16-bit down counters, each with a CLK input, a GATE
+// input and an OUT pin, counting in binary or BCD in any of six modes. Counts
+// are written and read a byte at a time, low byte, high byte or both, and a
+// counter's value can be latched for reading while it runs. The 8254 adds the
+// read-back command, which latches the counts and status of several counters
+// at once; it is enabled with With8254.
+//
+// The counters are clocked from the CPU cycle count with WithClock, at the
+// ratio of their CLK rate to the CPU's, or by the machine calling Clock. With
+// WithClock the counters catch up whenever the timer is accessed, and the
+// machine calls Update after each instruction so that the OUT pins change, and
+// raise interrupts, on time.
+package i8253
+
+import (
+	"github.com/danmrichards/go8080"
+)
+
+// Register offsets: the counters, then the control word register.
+const (
+	Counter0 = 0
+	Counter1 = 1
+	Counter2 = 2
+	Control  = 3
+)
+
+// Counter modes.
+const (
+	InterruptOnTerminalCount = 0
+	OneShot                  = 1
+	RateGenerator            = 2
+	SquareWave               = 3
+	SoftwareStrobe           = 4
+	HardwareStrobe           = 5
+)
+
+// Status byte bits, returned by the 8254's read-back command above the
+// counter's control word.
+const (
+	StatusOut       = 0x80
+	StatusNullCount = 0x40
+)
+
+// Control word fields.
+const (
+	ctrlBCD        = 0x01
+	ctrlMode       = 0x0e
+	ctrlRW         = 0x30
+	ctrlSelect     = 0xc0
+	modeShift      = 1
+	rwShift        = 4
+	selectShift    = 6
+	selectReadBack = 3
+	rwLatch        = 0
+	rwLSB          = 1
+	rwMSB          = 2
+	rwBoth         = 3
+)
+
+// Read-back command bits.
+const (
+	readBackCount  = 0x20
+	readBackStatus = 0x10
+)
+
+type (
+	// PIT is an 8253 or 8254.
+	PIT struct {
+		counters [3]counter
+		readBack bool
+
+		// The CPU cycle counter and clock rates clocking the counters, the
+		// cycle count they were last brought up to, and the remainder of the
+		// last conversion from cycles to CLK pulses. cycles is nil if the
+		// machine clocks the counters itself.
+		cycles         func() uint32
+		cpuHz, clockHz uint64
+		last           uint32
+		frac           uint64
+
+		// Functions called as each OUT pin changes level, and the CPUs
+		// interrupted, and where, as each rises.
+		out    [3]func(high bool)
+		cpu    [3]*go8080.Intel8080
+		vector [3]uint16
+	}
+
+	// Option is a functional option that modifies a field on the timer.
+	Option func(*PIT)
+
+	// counter is one of the three counters.
+	counter struct {
+		// The low six bits of the control word, and the fields in them.
+		control byte
+		mode    byte
+		rw      byte
+		bcd     bool
+
+		// The count register, and whether a count has been written since
+		// the control word. lsb holds the low byte of a two byte count
+		// until the high byte is written.
+		cr       uint16
+		hasCount bool
+		lsb      byte
+		writeMSB bool
+
+		// The count element, in pulses, or in half pulses in mode 3.
+		// loadPending is set for the count register to be loaded into it on
+		// the next CLK pulse. armed is set until a strobe has been output
+		// in modes 4 and 5.
+		ce          uint32
+		counting    bool
+		loadPending bool
+		nullCount   bool
+		armed       bool
+
+		gate bool
+		out  bool
+
+		// Latched count and status, and whether the high byte of the count
+		// is read next.
+		latched       bool
+		latch         uint16
+		statusLatched bool
+		status        byte
+		readMSB       bool
+	}
+)
+
+// WithClock clocks the counters from the CPU. cycles returns the CPU cycle
+// count, cpuHz is the CPU clock rate and clockHz the rate of the counters'
+// CLK inputs.
+func WithClock(cycles func() uint32, cpuHz, clockHz int) Option {
+	return func(p *PIT) {
+		p.cycles = cycles
+		p.cpuHz, p.clockHz = uint64(cpuHz), uint64(clockHz)
+		p.last = cycles()
+	}
+}
+
+// WithOutput sets the function called each time the given counter's OUT pin
+// changes level.
+func WithOutput(counter int, fn func(high bool)) Option {
+	return func(p *PIT) {
+		p.out[counter] = fn
+	}
+}
+
+// WithInterrupt interrupts the CPU, calling the code at addr, each time the
+// given counter's OUT pin rises, if the CPU has interrupts enabled.
+func WithInterrupt(counter int, cpu *go8080.Intel8080, addr uint16) Option {
+	return func(p *PIT) {
+		p.cpu[counter], p.vector[counter] = cpu, addr
+	}
+}
+
+// With8254 makes the timer an 8254, with the read-back command.
+func With8254() Option {
+	return func(p *PIT) {
+		p.readBack = true
+	}
+}
+
+// New returns a timer whose counters wait to be programmed, with their GATE
+// inputs high.
+func New(opts ...Option) *PIT {
+	p := &PIT{}
+	for i := range p.counters {
+		p.counters[i].gate = true
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Ports returns the number of ports decoded by the timer.
+func (p *PIT) Ports() int {
+	return 4
+}
+
+// In reads a counter: its latched status or count if any, or else its
+// current count. The control word register cannot be read.
+func (p *PIT) In(offset byte) byte {
+	p.Update()
+	if offset >= Control {
+		return 0xff
+	}
+
+	return p.counters[offset].read()
+}
+
+// Out writes a count to a counter, or a control word.
+func (p *PIT) Out(offset, v byte) {
+	p.Update()
+	if offset < Control {
+		p.notify(int(offset), p.counters[offset].write(v))
+		return
+	}
+
+	sel := v & ctrlSelect >> selectShift
+	switch {
+	case sel == selectReadBack:
+		if p.readBack {
+			p.readBackCommand(v)
+		}
+	case v&ctrlRW == rwLatch:
+		p.counters[sel].latchCount()
+	default:
+		p.notify(int(sel), p.counters[sel].setControl(v))
+	}
+}
+
+// Update brings the counters up to the current CPU cycle count, when they
+// are clocked with WithClock.
+func (p *PIT) Update() {
+	if p.cycles == nil || p.cpuHz == 0 {
+		return
+	}
+
+	now := p.cycles()
+	n := uint64(now-p.last)*p.clockHz + p.frac
+	p.last = now
+	p.frac = n % p.cpuHz
+	p.Clock(uint32(n / p.cpuHz))
+}
+
+// Clock runs the counters for the given number of CLK pulses.
+func (p *PIT) Clock(pulses uint32) {
+	for i := range p.counters {
+		c := &p.counters[i]
+		for n := pulses; n > 0; {
+			k, changed := c.advance(n)
+			n -= k
+			p.notify(i, changed)
+		}
+	}
+}
+
+// SetGate sets the given counter's GATE input. In modes 0 and 4 a low gate
+// stops the count; in modes 1 and 5 a rising gate triggers the counter; and
+// in modes 2 and 3 a low gate stops the count and sets OUT high, and a rising
+// gate reloads the counter.
+func (p *PIT) SetGate(counter int, high bool) {
+	p.Update()
+	p.notify(counter, p.counters[counter].setGate(high))
+}
+
+// Output returns the level of the given counter's OUT pin.
+func (p *PIT) Output(counter int) bool {
+	p.Update()
+	return p.counters[counter].out
+}
+
+// readBackCommand latches the count and status of the selected counters.
+func (p *PIT) readBackCommand(v byte) {
+	for i := range p.counters {
+		if v&(2<<uint(i)) == 0 {
+			continue
+		}
+		c := &p.counters[i]
+		if v&readBackCount == 0 {
+			c.latchCount()
+		}
+		if v&readBackStatus == 0 && !c.statusLatched {
+			c.statusLatched = true
+			c.status = c.control
+			if c.out {
+				c.status |= StatusOut
+			}
+			if c.nullCount {
+				c.status |= StatusNullCount
+			}
+		}
+	}
+}
+
+// notify calls the output function and raises the interrupt for a counter
+// whose OUT pin has changed.
+func (p *PIT) notify(i int, changed bool) {
+	if !changed {
+		return
+	}
+
+	high := p.counters[i].out
+	if p.out[i] != nil {
+		p.out[i](high)
+	}
+	if high && p.cpu[i] != nil {
+		p.cpu[i].Interrupt(p.vector[i])
+	}
+}
+
+// setControl programs the counter with a control word, returning true if OUT
+// changed.
+func (c *counter) setControl(v byte) bool {
+	*c = counter{
+		control:   v &^ ctrlSelect,
+		mode:      v & ctrlMode >> modeShift,
+		rw:        v & ctrlRW >> rwShift,
+		bcd:       v&ctrlBCD != 0,
+		nullCount: true,
+		gate:      c.gate,
+		out:       c.out,
+	}
+	if c.mode > HardwareStrobe {
+		// Modes 6 and 7 are modes 2 and 3.
+		c.mode &^= 4
+	}
+
+	return c.setOut(c.mode != InterruptOnTerminalCount)
+}
+
+// write writes a byte of the count, returning true if OUT changed.
+func (c *counter) write(v byte) bool {
+	switch {
+	case c.rw == rwLSB:
+		c.cr = uint16(v)
+	case c.rw == rwMSB:
+		c.cr = uint16(v) << 8
+	case !c.writeMSB:
+		c.lsb, c.writeMSB = v, true
+		if c.mode == InterruptOnTerminalCount {
+			// Writing the first byte stops the count.
+			c.counting = false
+			return c.setOut(false)
+		}
+		return false
+	default:
+		c.cr, c.writeMSB = uint16(v)<<8|uint16(c.lsb), false
+	}
+
+	first := !c.hasCount
+	c.hasCount, c.nullCount = true, true
+	switch c.mode {
+	case InterruptOnTerminalCount:
+		c.counting, c.loadPending = false, true
+		return c.setOut(false)
+	case SoftwareStrobe:
+		c.counting, c.loadPending = false, true
+	case RateGenerator, SquareWave:
+		// A new count takes effect at the next reload.
+		if first {
+			c.loadPending = true
+		}
+	}
+
+	return false
+}
+
+// setGate sets the GATE input, returning true if OUT changed.
+func (c *counter) setGate(high bool) bool {
+	rising := high && !c.gate
+	c.gate = high
+
+	switch c.mode {
+	case OneShot, HardwareStrobe:
+		if rising && c.hasCount {
+			c.loadPending = true
+		}
+	case RateGenerator, SquareWave:
+		if !high {
+			return c.setOut(true)
+		}
+		if rising && c.hasCount {
+			c.loadPending = true
+		}
+	}
+
+	return false
+}
+
+// advance runs the counter for up to n CLK pulses, stopping after the first
+// pulse which changes OUT. It returns the pulses run and whether OUT changed.
+func (c *counter) advance(n uint32) (uint32, bool) {
+	if c.loadPending {
+		return 1, c.load()
+	}
+	if !c.counting || !c.gate && c.mode != OneShot && c.mode != HardwareStrobe {
+		return n, false
+	}
+
+	// Skip the pulses before the next one which may change OUT.
+	k := c.untilEvent()
+	if n < k {
+		c.decrement(n)
+		return n, false
+	}
+	c.decrement(k - 1)
+
+	return k, c.pulse()
+}
+
+// untilEvent returns the number of pulses up to and including the next one
+// which may change OUT.
+func (c *counter) untilEvent() uint32 {
+	mod := c.modulus()
+	switch {
+	case c.mode == SquareWave:
+		return c.ce / 2
+	case c.mode == RateGenerator:
+		if k := (c.ce + mod - 1) % mod; k > 0 {
+			return k
+		}
+		return 1
+	case !c.out && (c.mode == SoftwareStrobe || c.mode == HardwareStrobe):
+		// The strobe ends on the next pulse.
+		return 1
+	case c.ce == 0:
+		return mod
+	}
+
+	return c.ce
+}
+
+// decrement counts down n pulses which do not change OUT.
+func (c *counter) decrement(n uint32) {
+	if c.mode == SquareWave {
+		c.ce -= 2 * n
+		return
+	}
+
+	mod := c.modulus()
+	c.ce = (c.ce + mod - n%mod) % mod
+}
+
+// pulse counts down a single pulse, returning true if OUT changed.
+func (c *counter) pulse() bool {
+	switch c.mode {
+	case SquareWave:
+		if c.ce -= 2; c.ce > 0 {
+			return false
+		}
+		c.out = !c.out
+		c.reloadSquareWave()
+		return true
+	case RateGenerator:
+		if c.ce == 1 {
+			c.ce, c.nullCount = c.initial(), false
+			return c.setOut(true)
+		}
+		c.decrement(1)
+		return c.setOut(c.ce != 1)
+	}
+
+	c.decrement(1)
+	switch c.mode {
+	case SoftwareStrobe, HardwareStrobe:
+		if !c.out {
+			return c.setOut(true)
+		}
+		if c.ce == 0 && c.armed {
+			c.armed = false
+			return c.setOut(false)
+		}
+	default:
+		if c.ce == 0 {
+			return c.setOut(true)
+		}
+	}
+
+	return false
+}
+
+// load loads the count register into the count element on a CLK pulse,
+// returning true if OUT changed.
+func (c *counter) load() bool {
+	c.loadPending, c.nullCount = false, false
+	c.counting, c.armed = true, true
+
+	switch c.mode {
+	case SquareWave:
+		c.reloadSquareWave()
+		return false
+	case OneShot:
+		c.ce = c.initial()
+		return c.setOut(false)
+	}
+	c.ce = c.initial()
+
+	return false
+}
+
+// reloadSquareWave reloads the count element in mode 3. An odd count keeps
+// OUT high for one pulse more than it keeps it low.
+func (c *counter) reloadSquareWave() {
+	c.nullCount = false
+
+	n := c.initial()
+	if n == 0 {
+		n = c.modulus()
+	}
+	if n&1 != 0 {
+		if c.out {
+			n++
+		} else {
+			n--
+		}
+	}
+	if n == 0 {
+		n = 2
+	}
+	c.ce = n
+}
+
+// initial returns the count register as a number of pulses, where zero is
+// the largest count.
+func (c *counter) initial() uint32 {
+	if c.bcd {
+		return uint32(fromBCD(c.cr))
+	}
+
+	return uint32(c.cr)
+}
+
+// modulus returns the number of counts the counter wraps at.
+func (c *counter) modulus() uint32 {
+	if c.bcd {
+		return 10000
+	}
+
+	return 0x10000
+}
+
+// value returns the count element as it is read.
+func (c *counter) value() uint16 {
+	v := c.ce % c.modulus()
+	if !c.counting {
+		v = 0
+	}
+	if c.bcd {
+		return toBCD(uint16(v))
+	}
+
+	return uint16(v)
+}
+
+// latchCount latches the count for reading, unless it is already latched.
+func (c *counter) latchCount() {
+	if !c.latched {
+		c.latched, c.latch, c.readMSB = true, c.value(), false
+	}
+}
+
+// read returns the next byte read from the counter.
+func (c *counter) read() byte {
+	if c.statusLatched {
+		c.statusLatched = false
+		return c.status
+	}
+
+	v := c.value()
+	if c.latched {
+		v = c.latch
+	}
+
+	var b byte
+	switch {
+	case c.rw == rwLSB:
+		b = byte(v)
+	case c.rw == rwMSB:
+		b = byte(v >> 8)
+	case !c.readMSB:
+		c.readMSB = true
+		return byte(v)
+	default:
+		c.readMSB = false
+		b = byte(v >> 8)
+	}
+	c.latched = false
+
+	return b
+}
+
+// setOut sets OUT, returning true if it changed.
+func (c *counter) setOut(high bool) bool {
+	changed := c.out != high
+	c.out = high
+
+	return changed
+}
+
+// fromBCD converts a four digit BCD number to binary.
+func fromBCD(v uint16) uint16 {
+	return v>>12&0xf*1000 + v>>8&0xf*100 + v>>4&0xf*10 + v&0xf
+}
+
+// toBCD converts a binary number below 10000 to four digit BCD.
+func toBCD(v uint16) uint16 {
+	return v/1000<<12 | v/100%10<<8 | v/10%10<<4 | v%10
+}
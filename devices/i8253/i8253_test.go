@@ -0,0 +1,244 @@
+package i8253
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+)
+
+// ram is a flat 64K memory.
+type ram []byte
+
+func (r ram) Read(addr uint16) byte     { return r[addr] }
+func (r ram) ReadAll() []byte           { return r }
+func (r ram) Write(addr uint16, v byte) { r[addr] = v }
+
+// levels clocks the counter a pulse at a time, returning its OUT level after
+// each pulse.
+func levels(p *PIT, counter, pulses int) string {
+	var sb strings.Builder
+	for i := 0; i < pulses; i++ {
+		p.Clock(1)
+		if p.Output(counter) {
+			sb.WriteByte('H')
+		} else {
+			sb.WriteByte('L')
+		}
+	}
+
+	return sb.String()
+}
+
+func TestInterruptOnTerminalCount(t *testing.T) {
+	var edges []bool
+	p := New(WithOutput(0, func(high bool) { edges = append(edges, high) }))
+
+	p.Out(Control, 0x30)
+	p.Out(Counter0, 5)
+	p.Out(Counter0, 0)
+
+	// The count is loaded on the first pulse, and OUT rises when it reaches
+	// zero.
+	if got := levels(p, 0, 7); got != "LLLLLHH" {
+		t.Errorf("OUT = %s, want LLLLLHH", got)
+	}
+	if len(edges) != 1 || !edges[0] {
+		t.Errorf("edges = %v, want [true]", edges)
+	}
+
+	// Writing a new count lowers OUT again.
+	p.Out(Counter0, 2)
+	if p.Output(0) {
+		t.Error("OUT high after a new count")
+	}
+	p.Out(Counter0, 0)
+	if got := levels(p, 0, 4); got != "LLHH" {
+		t.Errorf("OUT = %s, want LLHH", got)
+	}
+}
+
+func TestSquareWave(t *testing.T) {
+	var edges int
+	p := New(WithOutput(1, func(bool) { edges++ }))
+
+	// An odd count is high for one pulse longer than it is low.
+	p.Out(Control, 0x76)
+	p.Out(Counter1, 5)
+	p.Out(Counter1, 0)
+	if got := levels(p, 1, 11); got != "HHHLLHHHLLH" {
+		t.Errorf("OUT = %s, want HHHLLHHHLLH", got)
+	}
+
+	// Running many pulses at once gives the same wave.
+	edges = 0
+	p.Clock(500)
+	if !p.Output(1) || edges != 200 {
+		t.Errorf("after 500 pulses: OUT %v, %d edges; want true, 200", p.Output(1), edges)
+	}
+
+	// A low gate holds OUT high and stops the count, and a rising gate
+	// restarts it.
+	p.Clock(3)
+	p.SetGate(1, false)
+	p.Clock(100)
+	if !p.Output(1) {
+		t.Error("OUT low with the gate low")
+	}
+	p.SetGate(1, true)
+	if got := levels(p, 1, 6); got != "HHHLLH" {
+		t.Errorf("OUT = %s, want HHHLLH", got)
+	}
+}
+
+func TestOneShot(t *testing.T) {
+	p := New()
+	p.Out(Control, 0x12)
+	p.Out(Counter0, 3)
+
+	p.Clock(10)
+	if !p.Output(0) {
+		t.Error("OUT low before a trigger")
+	}
+
+	p.SetGate(0, false)
+	p.SetGate(0, true)
+	if got := levels(p, 0, 5); got != "LLLHH" {
+		t.Errorf("OUT = %s, want LLLHH", got)
+	}
+}
+
+func TestStrobe(t *testing.T) {
+	p := New()
+	p.Out(Control, 0x18)
+	p.Out(Counter0, 3)
+
+	// A single strobe, one pulse long.
+	if got := levels(p, 0, 8); got != "HHHLHHHH" {
+		t.Errorf("OUT = %s, want HHHLHHHH", got)
+	}
+	p.Clock(0x10000)
+	if !p.Output(0) {
+		t.Error("strobed again after the counter wrapped")
+	}
+}
+
+func TestReadCount(t *testing.T) {
+	p := New()
+
+	// BCD counting from 100.
+	p.Out(Control, 0xb1)
+	p.Out(Counter2, 0x00)
+	p.Out(Counter2, 0x01)
+	p.Clock(38)
+
+	// A latched count is held until it has been read.
+	p.Out(Control, 0x80)
+	p.Clock(10)
+	if lo, hi := p.In(Counter2), p.In(Counter2); lo != 0x63 || hi != 0x00 {
+		t.Errorf("latched count = %02x%02x, want 0063", hi, lo)
+	}
+	if lo, hi := p.In(Counter2), p.In(Counter2); lo != 0x53 || hi != 0x00 {
+		t.Errorf("count = %02x%02x, want 0053", hi, lo)
+	}
+
+	// Low byte only.
+	p.Out(Control, 0x50)
+	p.Out(Counter1, 0x10)
+	p.Clock(5)
+	if v := p.In(Counter1); v != 0x0c {
+		t.Errorf("count = %02x, want 0c", v)
+	}
+
+	// High byte only.
+	p.Out(Control, 0x24)
+	p.Out(Counter0, 0x02)
+	p.Clock(0x101)
+	if v := p.In(Counter0); v != 0x01 {
+		t.Errorf("count = %02x00, want 0100", v)
+	}
+}
+
+func TestReadBack(t *testing.T) {
+	p := New(With8254())
+	p.Out(Control, 0x34)
+
+	p.Out(Control, 0xc2)
+	if s := p.In(Counter0); s != 0x34|StatusOut|StatusNullCount {
+		t.Errorf("status = %02x, want %02x", s, 0x34|StatusOut|StatusNullCount)
+	}
+	p.In(Counter0)
+	p.In(Counter0)
+
+	p.Out(Counter0, 10)
+	p.Out(Counter0, 0)
+	p.Clock(4)
+	p.Out(Control, 0xc2)
+	p.Clock(2)
+	if s := p.In(Counter0); s != 0x34|StatusOut {
+		t.Errorf("status = %02x, want %02x", s, 0x34|StatusOut)
+	}
+	if lo, hi := p.In(Counter0), p.In(Counter0); lo != 7 || hi != 0 {
+		t.Errorf("count = %02x%02x, want 0007", hi, lo)
+	}
+
+	// An 8253 ignores the command.
+	p = New()
+	p.Out(Control, 0x14)
+	p.Out(Counter0, 10)
+	p.Clock(4)
+	p.Out(Control, 0xc2)
+	p.Clock(2)
+	if v := p.In(Counter0); v != 5 {
+		t.Errorf("count = %d, want 5", v)
+	}
+}
+
+// A program which counts the timer's interrupts in memory.
+const program = `
+	ORG	0
+	LXI	SP,1000H
+	EI
+LOOP:	HLT
+	JMP	LOOP
+	ORG	38H
+	PUSH	H
+	LXI	H,2000H
+	INR	M
+	POP	H
+	EI
+	RET
+`
+
+func TestInterrupt(t *testing.T) {
+	prog, err := asm.Assemble("pit.asm", strings.NewReader(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := make(ram, 0x10000)
+	copy(mem, prog.Binary())
+
+	// A 1MHz timer on a 2MHz CPU, interrupting every 100 pulses. OUT rises
+	// as the counter reloads, a pulse after each terminal count.
+	cpu := go8080.NewIntel8080(mem)
+	p := New(
+		WithClock(cpu.Cycles, 2000000, 1000000),
+		WithInterrupt(0, cpu, 0x38),
+	)
+	p.Out(Control, 0x34)
+	p.Out(Counter0, 100)
+	p.Out(Counter0, 0)
+
+	// The 100th interrupt comes after 10001 pulses, at 20002 cycles.
+	for cpu.Cycles() < 20100 {
+		if err := cpu.Step(); err != nil {
+			t.Fatal(err)
+		}
+		p.Update()
+	}
+
+	if n := mem[0x2000]; n != 100 {
+		t.Errorf("%d interrupts, want 100", n)
+	}
+}
@@ -0,0 +1,393 @@
+// Package i8255 emulates the Intel 8255 programmable peripheral interface
+// (PPI).
+//
+// Machines attach keyboards, printers and the like to the PPI's pins with
+// callbacks: an input callback supplies the levels on an input port's pins
+// when it is read, and an output callback is called with a port's latch
+// whenever it changes.
+//
+// In mode 0 each port is a simple input or output. In mode 1 ports A and B
+// are strobed inputs or outputs, and in mode 2 port A is a strobed
+// bidirectional bus; port C then carries the handshake signals. The
+// peripheral drives the STB and ACK inputs with Strobe and Acknowledge, and
+// sees the IBF, OBF and INTR outputs in port C's output callback or through
+// InputFull, OutputFull and InterruptRequest. Reading port C returns the
+// status: the handshake outputs, with the interrupt enables in place of the
+// STB and ACK inputs.
+package i8255
+
+// Register offsets.
+const (
+	A       = 0
+	B       = 1
+	C       = 2
+	Control = 3
+)
+
+// Control word bits.
+const (
+	modeSet    = 0x80
+	aMode      = 0x60
+	aInput     = 0x10
+	cUpperIn   = 0x08
+	bMode      = 0x04
+	bInput     = 0x02
+	cLowerIn   = 0x01
+	aModeShift = 5
+	bModeShift = 2
+	bitSetMask = 0x01
+	bitNumber  = 0x0e
+)
+
+// Port C bits carrying the handshake signals in modes 1 and 2. OBF is active
+// low; the others are active high.
+const (
+	intrB = 0x01
+	ibfB  = 0x02
+	obfB  = 0x02
+	inteB = 0x04 // STB or ACK for port B.
+	intrA = 0x08
+	inteA = 0x10 // STB for port A, and INTE2 in mode 2.
+	ibfA  = 0x20
+	inteO = 0x40 // ACK for port A, and INTE1 in mode 2.
+	obfA  = 0x80
+)
+
+type (
+	// PPI is an 8255.
+	PPI struct {
+		control byte
+		latch   [3]byte
+		in      [3]func() byte
+		out     [3]func(byte)
+
+		// The input latches of ports A and B in modes 1 and 2, and the
+		// handshake state: the input buffer full and output buffer full
+		// flags and the interrupt enables for input and output.
+		inLatch         [2]byte
+		ibf, full       [2]bool
+		inteIn, inteOut [2]bool
+	}
+
+	// Option is a functional option that modifies a field on the PPI.
+	Option func(*PPI)
+)
+
+// WithInput sets the function which supplies the levels on the pins of the
+// given port when it is read as an input, or latched by a strobe.
+func WithInput(port int, fn func() byte) Option {
+	return func(p *PPI) {
+		p.in[port] = fn
+	}
+}
+
+// WithOutput sets the function called with the given port's output latch
+// whenever it is written. For port C the latch includes any bits of a half
+// programmed as input, which are not driven onto the pins; in modes 1 and 2
+// it carries the handshake outputs, and it is also called as they change.
+func WithOutput(port int, fn func(v byte)) Option {
+	return func(p *PPI) {
+		p.out[port] = fn
+	}
+}
+
+// New returns a PPI in its reset state, with all ports programmed as inputs.
+func New(opts ...Option) *PPI {
+	p := &PPI{control: modeSet | aInput | cUpperIn | bInput | cLowerIn}
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Ports returns the number of ports decoded by the PPI.
+func (p *PPI) Ports() int {
+	return 4
+}
+
+// In reads a port. Pins programmed as outputs read back the latch, and a
+// strobed input reads its input latch, clearing IBF and INTR. The control
+// register cannot be read.
+func (p *PPI) In(offset byte) byte {
+	switch offset & 3 {
+	case A:
+		return p.read(A)
+	case B:
+		return p.read(B)
+	case C:
+		v := p.latch[C]
+		if mask := p.inputsC(); mask != 0 && p.in[C] != nil {
+			v = v&^mask | p.in[C]()&mask
+		}
+		return v&^p.handshakeC() | p.status(true)
+	}
+
+	return 0xff
+}
+
+// Out writes a port's latch or the control register. Writing a strobed
+// output sets OBF and clears INTR.
+func (p *PPI) Out(offset, v byte) {
+	switch offset & 3 {
+	case A, B:
+		port := int(offset & 3)
+		p.write(port, v)
+		if p.strobedOutput(port) {
+			p.full[port] = true
+			p.notify(C)
+		}
+
+	case C:
+		p.write(C, v)
+
+	case Control:
+		if v&modeSet != 0 {
+			p.control = v
+			p.latch = [3]byte{}
+			p.ibf, p.full = [2]bool{}, [2]bool{}
+			p.inteIn, p.inteOut = [2]bool{}, [2]bool{}
+			for port := A; port <= C; port++ {
+				p.notify(port)
+			}
+			return
+		}
+
+		// Set or reset a single bit of port C, or an interrupt enable.
+		bit := byte(1) << (v & bitNumber >> 1)
+		set := v&bitSetMask != 0
+		switch {
+		case bit == inteA && p.strobedInput(A):
+			p.inteIn[A] = set
+		case bit == inteO && p.strobedOutput(A):
+			p.inteOut[A] = set
+		case bit == inteB && p.mode(B) == 1:
+			p.inteIn[B], p.inteOut[B] = set, set
+		case bit&p.handshakeC() != 0:
+			// The other handshake pins are not affected.
+			return
+		default:
+			c := p.latch[C] &^ bit
+			if set {
+				c |= bit
+			}
+			p.write(C, c)
+			return
+		}
+		p.notify(C)
+	}
+}
+
+// Latch returns the output latch of a port.
+func (p *PPI) Latch(port int) byte {
+	return p.latch[port]
+}
+
+// Strobe pulses the STB input of port A or B, latching the levels on the
+// port's pins, as supplied by its input callback, when it is a strobed
+// input. IBF is set, and INTR if the interrupt is enabled.
+func (p *PPI) Strobe(port int) {
+	if !p.strobedInput(port) {
+		return
+	}
+
+	p.inLatch[port] = 0xff
+	if p.in[port] != nil {
+		p.inLatch[port] = p.in[port]()
+	}
+	p.ibf[port] = true
+	p.notify(C)
+}
+
+// Acknowledge pulses the ACK input of port A or B, returning the port's
+// output latch, which in mode 2 is only driven onto the pins while ACK is
+// low. When the port is a strobed output OBF is cleared, and INTR set if the
+// interrupt is enabled.
+func (p *PPI) Acknowledge(port int) byte {
+	if p.strobedOutput(port) {
+		p.full[port] = false
+		p.notify(C)
+	}
+
+	return p.latch[port]
+}
+
+// InputFull returns the level of the IBF output of port A or B: a strobed
+// input has been latched and not yet read.
+func (p *PPI) InputFull(port int) bool {
+	return p.strobedInput(port) && p.ibf[port]
+}
+
+// OutputFull returns true if the OBF output of port A or B is asserted: a
+// strobed output has been written and not yet acknowledged.
+func (p *PPI) OutputFull(port int) bool {
+	return p.strobedOutput(port) && p.full[port]
+}
+
+// InterruptRequest returns the level of the INTR output of port A or B in
+// modes 1 and 2.
+func (p *PPI) InterruptRequest(port int) bool {
+	in := p.strobedInput(port) && p.inteIn[port] && p.ibf[port]
+	out := p.strobedOutput(port) && p.inteOut[port] && !p.full[port]
+
+	return in || out
+}
+
+// mode returns the mode of port A or B.
+func (p *PPI) mode(port int) byte {
+	if port == B {
+		return p.control & bMode >> bModeShift
+	}
+	if m := p.control & aMode >> aModeShift; m < 2 {
+		return m
+	}
+
+	return 2
+}
+
+// strobedInput returns true if port A or B is an input in mode 1 or 2.
+func (p *PPI) strobedInput(port int) bool {
+	switch p.mode(port) {
+	case 1:
+		return p.input(port)
+	case 2:
+		return true
+	}
+
+	return false
+}
+
+// strobedOutput returns true if port A or B is an output in mode 1 or 2.
+func (p *PPI) strobedOutput(port int) bool {
+	switch p.mode(port) {
+	case 1:
+		return !p.input(port)
+	case 2:
+		return true
+	}
+
+	return false
+}
+
+// input returns true if port A or B is programmed as an input.
+func (p *PPI) input(port int) bool {
+	if port == A {
+		return p.control&aInput != 0
+	}
+
+	return p.control&bInput != 0
+}
+
+// handshakeC returns the port C bits used for handshake signals.
+func (p *PPI) handshakeC() byte {
+	var mask byte
+	switch {
+	case p.mode(A) == 2:
+		mask = intrA | inteA | ibfA | inteO | obfA
+	case p.strobedInput(A):
+		mask = intrA | inteA | ibfA
+	case p.strobedOutput(A):
+		mask = intrA | inteO | obfA
+	}
+	if p.mode(B) == 1 {
+		mask |= intrB | obfB | inteB
+	}
+
+	return mask
+}
+
+// inputsC returns the port C bits which are simple inputs.
+func (p *PPI) inputsC() byte {
+	var mask byte
+	if p.control&cUpperIn != 0 {
+		mask |= 0xf0
+	}
+	if p.control&cLowerIn != 0 {
+		mask |= 0x0f
+	}
+
+	return mask &^ p.handshakeC()
+}
+
+// status returns the handshake bits of port C: the IBF, OBF and INTR
+// outputs, and with enables set the interrupt enables in place of the STB and
+// ACK inputs, which otherwise read as high.
+func (p *PPI) status(enables bool) byte {
+	var s byte
+	bits := func(port int, intr, buffer, inte byte, inteSet bool) {
+		if p.InterruptRequest(port) {
+			s |= intr
+		}
+		s |= buffer
+		if !enables || inteSet {
+			s |= inte
+		}
+	}
+
+	if p.strobedInput(A) {
+		var ibf byte
+		if p.ibf[A] {
+			ibf = ibfA
+		}
+		bits(A, intrA, ibf, inteA, p.inteIn[A])
+	}
+	if p.strobedOutput(A) {
+		var obf byte
+		if !p.full[A] {
+			obf = obfA
+		}
+		bits(A, intrA, obf, inteO, p.inteOut[A])
+	}
+	if p.mode(B) == 1 {
+		var buffer byte
+		if p.input(B) && p.ibf[B] || !p.input(B) && !p.full[B] {
+			buffer = ibfB
+		}
+		bits(B, intrB, buffer, inteB, p.inteIn[B])
+	}
+
+	return s
+}
+
+// read reads port A or B.
+func (p *PPI) read(port int) byte {
+	if p.strobedInput(port) {
+		p.ibf[port] = false
+		p.notify(C)
+		return p.inLatch[port]
+	}
+	if !p.input(port) {
+		return p.latch[port]
+	}
+	if p.in[port] == nil {
+		return 0xff
+	}
+
+	return p.in[port]()
+}
+
+// write sets a port's latch and notifies its output.
+func (p *PPI) write(port int, v byte) {
+	p.latch[port] = v
+	p.notify(port)
+}
+
+// notify calls a port's output callback, if any of its pins are outputs. Port
+// C's handshake outputs are included in its latch.
+func (p *PPI) notify(port int) {
+	if p.out[port] == nil {
+		return
+	}
+
+	switch port {
+	case A, B:
+		if p.strobedOutput(port) || !p.input(port) {
+			p.out[port](p.latch[port])
+		}
+	case C:
+		hs := p.handshakeC()
+		if p.inputsC()|hs != 0xff || hs != 0 {
+			p.out[C](p.latch[C]&^hs | p.status(false))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package i8255
+
+import "testing"
+
+func TestPPI(t *testing.T) {
+	var outA, outC []byte
+	p := New(
+		WithInput(B, func() byte { return 0x5a }),
+		WithInput(C, func() byte { return 0xa5 }),
+		WithOutput(A, func(v byte) { outA = append(outA, v) }),
+		WithOutput(C, func(v byte) { outC = append(outC, v) }),
+	)
+
+	// Unconnected inputs float high.
+	if v := p.In(A); v != 0xff {
+		t.Errorf("port A = %02x, want ff", v)
+	}
+	if v := p.In(Control); v != 0xff {
+		t.Errorf("control = %02x, want ff", v)
+	}
+
+	// A and the lower half of C output, B and the upper half of C input.
+	p.Out(Control, 0x8a)
+	p.Out(A, 0x12)
+	if v := p.In(A); v != 0x12 {
+		t.Errorf("port A = %02x, want 12", v)
+	}
+	if v := p.In(B); v != 0x5a {
+		t.Errorf("port B = %02x, want 5a", v)
+	}
+
+	// Set bits 0 and 3 of port C, then reset bit 0.
+	p.Out(Control, 0x01)
+	p.Out(Control, 0x07)
+	p.Out(Control, 0x00)
+	if v := p.In(C); v != 0xa8 {
+		t.Errorf("port C = %02x, want a8", v)
+	}
+
+	if want := []byte{0, 0x12}; string(outA) != string(want) {
+		t.Errorf("port A output = % x, want % x", outA, want)
+	}
+	if want := []byte{0, 0x01, 0x09, 0x08}; string(outC) != string(want) {
+		t.Errorf("port C output = % x, want % x", outC, want)
+	}
+}
+
+func TestStrobed(t *testing.T) {
+	var outC byte
+	a := byte(0x41)
+	p := New(
+		WithInput(A, func() byte { return a }),
+		WithInput(C, func() byte { return 0xff }),
+		WithOutput(C, func(v byte) { outC = v }),
+	)
+
+	// Port A a strobed input, port B a strobed output.
+	p.Out(Control, 0xbc)
+	if v := p.In(C); v != 0xc2 {
+		t.Errorf("port C = %02x, want c2", v)
+	}
+
+	// Enabling port B's interrupt requests one at once, as its buffer is
+	// empty.
+	p.Out(Control, 0x09)
+	p.Out(Control, 0x05)
+	if v := p.In(C); v != 0xd7 {
+		t.Errorf("port C = %02x, want d7", v)
+	}
+
+	p.Strobe(A)
+	a = 0
+	if !p.InputFull(A) || !p.InterruptRequest(A) {
+		t.Error("strobe did not set IBF and INTR")
+	}
+	if v := p.In(C); v != 0xff {
+		t.Errorf("port C = %02x, want ff", v)
+	}
+	if v := p.In(A); v != 0x41 {
+		t.Errorf("port A = %02x, want the latched 41", v)
+	}
+	if p.InputFull(A) || p.InterruptRequest(A) {
+		t.Error("reading did not clear IBF and INTR")
+	}
+
+	p.Out(B, 0x55)
+	if !p.OutputFull(B) || p.InterruptRequest(B) {
+		t.Error("writing did not set OBF and clear INTR")
+	}
+	if v := p.In(C); v != 0xd4 {
+		t.Errorf("port C = %02x, want d4", v)
+	}
+	if v := p.Acknowledge(B); v != 0x55 {
+		t.Errorf("acknowledged %02x, want 55", v)
+	}
+	if p.OutputFull(B) || !p.InterruptRequest(B) {
+		t.Error("acknowledge did not clear OBF and set INTR")
+	}
+
+	// The pins carry the handshake outputs, with STB and ACK high.
+	if outC != 0x17 {
+		t.Errorf("port C output = %02x, want 17", outC)
+	}
+}
+
+func TestBidirectional(t *testing.T) {
+	p := New(WithInput(A, func() byte { return 0x44 }))
+
+	p.Out(Control, 0xc0)
+	p.Out(Control, 0x0d)
+	p.Out(Control, 0x09)
+	if !p.InterruptRequest(A) {
+		t.Error("no INTR with the output buffer empty")
+	}
+
+	p.Out(A, 0x33)
+	if p.InterruptRequest(A) {
+		t.Error("INTR with the output buffer full")
+	}
+	p.Strobe(A)
+	if !p.InterruptRequest(A) {
+		t.Error("no INTR with the input buffer full")
+	}
+	if v := p.In(A); v != 0x44 {
+		t.Errorf("port A = %02x, want 44", v)
+	}
+	if v := p.Acknowledge(A); v != 0x33 {
+		t.Errorf("acknowledged %02x, want 33", v)
+	}
+	if v := p.In(C); v != 0xd8 {
+		t.Errorf("port C = %02x, want d8", v)
+	}
+}
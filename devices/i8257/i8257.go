@@ -0,0 +1,268 @@
+// Package i8257 emulates the Intel 8257 programmable DMA controller.
+//
+// The controller has four channels. A device served by a channel, such as a
+// CRT controller, asks the DMA controller for each byte with Read, or hands
+// it bytes with Write; the transfer happens immediately, and the machine
+// accounts for the bus cycles it takes from the CPU. When several devices
+// request at once, Priority picks the channel served first, with fixed or
+// rotating priority as programmed. Verify transfers count without touching
+// memory. Extended write only changes the bus timing, and has no effect.
+package i8257
+
+import (
+	"github.com/danmrichards/go8080"
+)
+
+// Register offsets: a DMA address and a terminal count register for each
+// channel, then the mode set (written) and status (read) register.
+const (
+	Mode   = 8
+	Status = 8
+)
+
+// Mode set register bits, above the four channel enables.
+const (
+	modeRotate   = 0x10
+	modeTCStop   = 0x40
+	modeAutoLoad = 0x80
+)
+
+// Status register bits, above the four terminal count flags.
+const statusUpdate = 0x10
+
+// countMask is the part of a terminal count register holding the count; the
+// top two bits select the transfer type.
+const countMask = 0x3fff
+
+// Transfer types, from the top two bits of a terminal count register.
+const (
+	Verify = iota
+	WriteMem
+	ReadMem
+)
+
+// CyclesPerByte is the number of CPU clock cycles each transfer takes from
+// the CPU.
+const CyclesPerByte = 4
+
+type (
+	// DMA is an 8257.
+	DMA struct {
+		addr  [4]uint16
+		count [4]uint16
+
+		mode   byte
+		status byte
+
+		// The first/last flip-flop selecting the low or high byte of the
+		// next register access.
+		high bool
+
+		// The channel served last, which has the lowest priority when it
+		// rotates.
+		last int
+	}
+
+	// Channel is a channel connected to memory, as seen by the device it
+	// serves: each transfer reports the level of the TC output with it.
+	Channel struct {
+		d   *DMA
+		ch  int
+		mem go8080.MemReadWriter
+	}
+)
+
+// New returns a DMA controller with all channels disabled.
+func New() *DMA {
+	return &DMA{last: 3}
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (d *DMA) Ports() int {
+	return 9
+}
+
+// In reads a channel register, a byte at a time, or the status register.
+// Reading the status clears the terminal count flags.
+func (d *DMA) In(offset byte) byte {
+	if offset >= Mode {
+		s := d.status
+		d.status &^= 0x0f
+		return s
+	}
+
+	v := d.reg(offset)
+	if d.high {
+		v >>= 8
+	}
+	d.high = !d.high
+
+	return byte(v)
+}
+
+// Out writes a channel register, low byte then high byte, or the mode set
+// register. With auto load enabled, writes to channel 2 also set channel 3,
+// which holds the values reloaded into channel 2 at its terminal count.
+func (d *DMA) Out(offset, v byte) {
+	if offset >= Mode {
+		d.mode = v
+		d.high = false
+		d.status &^= statusUpdate
+		return
+	}
+
+	r := d.reg(offset)
+	if d.high {
+		r = r&0x00ff | uint16(v)<<8
+	} else {
+		r = r&0xff00 | uint16(v)
+	}
+	d.high = !d.high
+
+	ch := offset >> 1
+	d.setReg(ch, offset&1, r)
+	if ch == 2 && d.mode&modeAutoLoad != 0 {
+		d.setReg(3, offset&1, r)
+	}
+}
+
+// Enabled returns true if the given channel is enabled.
+func (d *DMA) Enabled(ch int) bool {
+	return d.mode&(1<<uint(ch)) != 0
+}
+
+// TransferType returns the type of transfer programmed for the given
+// channel: Verify, WriteMem or ReadMem.
+func (d *DMA) TransferType(ch int) int {
+	return int(d.count[ch] >> 14)
+}
+
+// Priority returns the channel to serve first of those whose bits are set in
+// requests, or false if none of them is enabled. Channel 0 has the highest
+// priority, unless the priority rotates, when the channel served last has
+// the lowest.
+func (d *DMA) Priority(requests byte) (int, bool) {
+	first := 0
+	if d.mode&modeRotate != 0 {
+		first = d.last + 1
+	}
+	for i := 0; i < 4; i++ {
+		ch := (first + i) % 4
+		if requests&(1<<uint(ch)) != 0 && d.Enabled(ch) {
+			return ch, true
+		}
+	}
+
+	return 0, false
+}
+
+// Read transfers the next byte of a DMA read on the given channel from mem,
+// returning false if the channel is disabled. A verify transfer reads 0FFH
+// without accessing memory.
+func (d *DMA) Read(ch int, mem go8080.MemReader) (byte, bool) {
+	if !d.Enabled(ch) {
+		return 0, false
+	}
+	v := byte(0xff)
+	if d.TransferType(ch) != Verify {
+		v = mem.Read(d.addr[ch])
+	}
+	d.advance(ch)
+
+	return v, true
+}
+
+// Channel returns the given channel, transferring to and from mem.
+func (d *DMA) Channel(ch int, mem go8080.MemReadWriter) *Channel {
+	return &Channel{d: d, ch: ch, mem: mem}
+}
+
+// Read transfers the next byte of a DMA read from memory, returning true
+// with the last byte before the terminal count. A disabled channel returns
+// true at once.
+func (c *Channel) Read() (byte, bool) {
+	if !c.d.Enabled(c.ch) {
+		return 0xff, true
+	}
+	v := byte(0xff)
+	if c.d.TransferType(c.ch) != Verify {
+		v = c.mem.Read(c.d.addr[c.ch])
+	}
+
+	return v, c.d.advance(c.ch)
+}
+
+// Write transfers a byte of a DMA write into memory, returning true with the
+// last byte before the terminal count. A disabled channel returns true at
+// once.
+func (c *Channel) Write(v byte) bool {
+	if !c.d.Enabled(c.ch) {
+		return true
+	}
+	if c.d.TransferType(c.ch) != Verify {
+		c.mem.Write(c.d.addr[c.ch], v)
+	}
+
+	return c.d.advance(c.ch)
+}
+
+// Write transfers a byte of a DMA write on the given channel into mem,
+// returning false if the channel is disabled. A verify transfer leaves
+// memory untouched.
+func (d *DMA) Write(ch int, mem go8080.MemWriter, v byte) bool {
+	if !d.Enabled(ch) {
+		return false
+	}
+	if d.TransferType(ch) != Verify {
+		mem.Write(d.addr[ch], v)
+	}
+	d.advance(ch)
+
+	return true
+}
+
+// TerminalCount returns true if the given channel has reached its terminal
+// count since the status was last read.
+func (d *DMA) TerminalCount(ch int) bool {
+	return d.status&(1<<uint(ch)) != 0
+}
+
+// advance moves a channel on to its next byte, handling the terminal count,
+// and returns true if the terminal count was reached.
+func (d *DMA) advance(ch int) bool {
+	d.last = ch
+	d.addr[ch]++
+	if d.count[ch]&countMask != 0 {
+		d.count[ch]--
+		return false
+	}
+
+	d.status |= 1 << uint(ch)
+	switch {
+	case ch == 2 && d.mode&modeAutoLoad != 0:
+		d.addr[2], d.count[2] = d.addr[3], d.count[3]
+		d.status |= statusUpdate
+	case d.mode&modeTCStop != 0:
+		d.mode &^= 1 << uint(ch)
+	}
+
+	return true
+}
+
+// reg returns the register at the given offset.
+func (d *DMA) reg(offset byte) uint16 {
+	if offset&1 == 0 {
+		return d.addr[offset>>1]
+	}
+
+	return d.count[offset>>1]
+}
+
+// setReg sets a channel's address (0) or terminal count (1) register.
+func (d *DMA) setReg(ch, which byte, v uint16) {
+	if which == 0 {
+		d.addr[ch] = v
+	} else {
+		d.count[ch] = v
+	}
+}
@@ -0,0 +1,154 @@
+package i8257
+
+import "testing"
+
+// mem is a flat 64K memory.
+type mem []byte
+
+func (m mem) Read(addr uint16) byte     { return m[addr] }
+func (m mem) ReadAll() []byte           { return m }
+func (m mem) Write(addr uint16, v byte) { m[addr] = v }
+
+func TestAutoLoad(t *testing.T) {
+	m := make(mem, 0x10000)
+	copy(m[0x1000:], "ABC")
+
+	d := New()
+	d.Out(Mode, modeAutoLoad)
+	for _, v := range []byte{0x00, 0x10} {
+		d.Out(4, v)
+	}
+	for _, v := range []byte{0x02, ReadMem << 6} {
+		d.Out(5, v)
+	}
+	d.Out(Mode, modeAutoLoad|modeTCStop|0x04)
+	if d.TransferType(2) != ReadMem {
+		t.Errorf("transfer type = %d, want %d", d.TransferType(2), ReadMem)
+	}
+
+	// Three bytes, then channel 2 is reloaded from channel 3 and starts
+	// again.
+	var got []byte
+	for i := 0; i < 4; i++ {
+		b, ok := d.Read(2, m)
+		if !ok {
+			t.Fatalf("read %d failed", i)
+		}
+		got = append(got, b)
+	}
+	if string(got) != "ABCA" {
+		t.Errorf("read %q, want %q", got, "ABCA")
+	}
+	if !d.TerminalCount(2) {
+		t.Error("no terminal count")
+	}
+	if s := d.In(Status); s != statusUpdate|0x04 {
+		t.Errorf("status = %02x, want %02x", s, statusUpdate|0x04)
+	}
+	if d.TerminalCount(2) {
+		t.Error("terminal count not cleared by status read")
+	}
+}
+
+func TestTCStop(t *testing.T) {
+	m := make(mem, 0x10000)
+
+	d := New()
+	if d.Write(0, m, 1) {
+		t.Error("write on a disabled channel")
+	}
+	d.Out(0, 0x00)
+	d.Out(0, 0x20)
+	d.Out(1, 0x01)
+	d.Out(1, WriteMem<<6)
+	d.Out(Mode, modeTCStop|0x01)
+
+	for i, ok := range []bool{true, true, false} {
+		if got := d.Write(0, m, byte(i+1)); got != ok {
+			t.Errorf("write %d = %v, want %v", i, got, ok)
+		}
+	}
+	if m[0x2000] != 1 || m[0x2001] != 2 {
+		t.Errorf("memory = % x, want 01 02", m[0x2000:0x2002])
+	}
+	if d.Enabled(0) {
+		t.Error("channel still enabled after terminal count")
+	}
+}
+
+func TestChannel(t *testing.T) {
+	m := make(mem, 0x10000)
+	copy(m[0x3000:], "XY")
+
+	d := New()
+	d.Out(6, 0x00)
+	d.Out(6, 0x30)
+	d.Out(7, 0x01)
+	d.Out(7, ReadMem<<6)
+	d.Out(Mode, 0x08)
+
+	// The terminal count comes with the last byte.
+	ch := d.Channel(3, m)
+	for i, want := range []bool{false, true} {
+		v, tc := ch.Read()
+		if v != "XY"[i] || tc != want {
+			t.Errorf("read %d = %q, %v, want %q, %v", i, v, tc, "XY"[i], want)
+		}
+	}
+
+	d.Out(Mode, 0)
+	if tc := ch.Write(1); !tc {
+		t.Error("no terminal count from a disabled channel")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	d := New()
+	d.Out(Mode, 0x0e)
+	if ch, ok := d.Priority(0x0f); !ok || ch != 1 {
+		t.Errorf("priority = %d, %v, want channel 1", ch, ok)
+	}
+	if _, ok := d.Priority(0x01); ok {
+		t.Error("disabled channel given priority")
+	}
+
+	// With rotating priority the channel served goes to the back. The
+	// channels are left to verify, so need no memory.
+	d.Out(Mode, modeRotate|0x0f)
+	for _, want := range []int{0, 1, 2, 3, 0} {
+		ch, _ := d.Priority(0x0f)
+		if ch != want {
+			t.Errorf("priority = %d, want %d", ch, want)
+		}
+		d.Read(ch, nil)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := make(mem, 0x10000)
+	m[0x4000] = 0x55
+
+	// Channel 1 verifies the byte at 4000H, and channel 0 writes there.
+	d := New()
+	d.Out(0, 0x00)
+	d.Out(0, 0x40)
+	d.Out(1, 0x01)
+	d.Out(1, WriteMem<<6)
+	d.Out(2, 0x00)
+	d.Out(2, 0x40)
+	d.Out(3, 0x01)
+	d.Out(3, Verify<<6)
+	d.Out(Mode, 0x03)
+
+	if v, _ := d.Read(1, m); v != 0xff {
+		t.Errorf("verify read %02x, want ff", v)
+	}
+	d.Write(1, m, 0x66)
+	if m[0x4000] != 0x55 || m[0x4001] != 0 {
+		t.Errorf("memory = % x after verify", m[0x4000:0x4002])
+	}
+	d.Write(0, m, 0x66)
+	if m[0x4000] != 0x66 {
+		t.Errorf("memory = %02x after write", m[0x4000])
+	}
+}
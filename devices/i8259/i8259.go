@@ -0,0 +1,328 @@
+// Package i8259 emulates the Intel 8259A programmable interrupt controller.
+//
+// The controller collects eight interrupt requests, IR0 to IR7, and drives
+// the CPU's INTR input with the highest priority one which is not masked or
+// blocked by an interrupt already in service. Connected to the CPU with
+// go8080.WithInterruptController, it answers the CPU's interrupt acknowledge
+// with a CALL to the request's vector, spaced 4 or 8 bytes apart from the
+// address given in ICW1 and ICW2.
+//
+// A single controller is emulated in 8080 mode: ICW3 is accepted but
+// cascading slaves is not emulated, and the 8086 mode selected by ICW4 is
+// ignored. Fully nested, automatic EOI, rotating priority, special mask mode
+// and polling are supported.
+package i8259
+
+// Register offsets: the A0 input is wired to the low address bit.
+const (
+	Command = 0
+	Data    = 1
+)
+
+// ICW1 bits.
+const (
+	icw1       = 0x10
+	icw1IC4    = 0x01
+	icw1Single = 0x02
+	icw1ADI    = 0x04
+	icw1LTIM   = 0x08
+)
+
+// ICW4 bits.
+const icw4AEOI = 0x02
+
+// OCW2 and OCW3 are told apart by bit 3.
+const ocw3 = 0x08
+
+// OCW2 commands, in bits 7-5, and the level in bits 2-0.
+const (
+	ocw2Command          = 0xe0
+	ocw2Level            = 0x07
+	rotateAEOIClear      = 0x00
+	nonSpecificEOI       = 0x20
+	specificEOI          = 0x60
+	rotateAEOISet        = 0x80
+	rotateNonSpecificEOI = 0xa0
+	setPriority          = 0xc0
+	rotateSpecificEOI    = 0xe0
+)
+
+// OCW3 bits.
+const (
+	ocw3ReadISR = 0x01
+	ocw3Read    = 0x02
+	ocw3Poll    = 0x04
+	ocw3SMM     = 0x20
+	ocw3ESMM    = 0x40
+)
+
+// The opcode of the CALL instruction supplied in the first INTA cycle.
+const call = 0xcd
+
+// pollActive is set in the byte read after a poll command if an interrupt is
+// being requested.
+const pollActive = 0x80
+
+// PIC is an 8259A.
+type PIC struct {
+	// The initialisation command word expected next, or zero once
+	// initialised, and ICW1 as written.
+	expect int
+	icw1   byte
+
+	// The vector address from ICW1 and ICW2, and whether automatic EOI
+	// is set by ICW4.
+	vector uint16
+	aeoi   bool
+
+	// The interrupt request, in service and mask registers, and the
+	// levels on the IR inputs.
+	irr, isr, imr byte
+	levels        byte
+
+	// The request with the lowest priority; the one after it has the
+	// highest.
+	lowest uint
+
+	rotateAEOI  bool
+	specialMask bool
+	readISR     bool
+	poll        bool
+
+	// The INTA cycle of the acknowledge in progress, and the level
+	// being acknowledged.
+	inta  int
+	level uint
+}
+
+// New returns a controller waiting for ICW1.
+func New() *PIC {
+	return &PIC{expect: 1, lowest: 7}
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (p *PIC) Ports() int {
+	return 2
+}
+
+// In reads the interrupt request or in service register, as selected by
+// OCW3, or the interrupt mask register. After a poll command the first read
+// acknowledges the highest priority request, returning its level with bit 7
+// set, or zero if there is none.
+func (p *PIC) In(offset byte) byte {
+	if offset&1 == Data {
+		return p.imr
+	}
+
+	if p.poll {
+		p.poll = false
+		l, ok := p.pending()
+		if !ok {
+			return 0
+		}
+		p.inService(l)
+		return pollActive | byte(l)
+	}
+	if p.readISR {
+		return p.isr
+	}
+
+	return p.irr
+}
+
+// Out writes an initialisation or operation command word.
+func (p *PIC) Out(offset, v byte) {
+	switch {
+	case offset&1 == Command && v&icw1 != 0:
+		p.initialise(v)
+	case offset&1 == Command && v&ocw3 != 0:
+		p.operation3(v)
+	case offset&1 == Command:
+		p.operation2(v)
+	case p.expect == 2:
+		p.vector = p.vector&0x00ff | uint16(v)<<8
+		p.expect = 3
+		if p.icw1&icw1Single != 0 {
+			p.expectICW4()
+		}
+	case p.expect == 3:
+		p.expectICW4()
+	case p.expect == 4:
+		p.aeoi = v&icw4AEOI != 0
+		p.expect = 0
+	default:
+		p.imr = v
+	}
+}
+
+// SetIRQ sets the level on an IR input. In edge triggered mode a rising edge
+// requests an interrupt, which is held until acknowledged; in level
+// triggered mode the request follows the level.
+func (p *PIC) SetIRQ(line int, high bool) {
+	bit := byte(1) << uint(line)
+	if high && (p.levels&bit == 0 || p.levelTriggered()) {
+		p.irr |= bit
+	}
+	if !high && p.levelTriggered() {
+		p.irr &^= bit
+	}
+
+	if high {
+		p.levels |= bit
+	} else {
+		p.levels &^= bit
+	}
+}
+
+// Requesting returns the level of the INT output: there is an unmasked
+// request with a higher priority than any interrupt in service.
+func (p *PIC) Requesting() bool {
+	if p.expect != 0 {
+		return false
+	}
+	_, ok := p.pending()
+
+	return ok
+}
+
+// Acknowledge returns the byte put on the data bus in each INTA cycle: a CALL
+// opcode, then the low and high bytes of the vector of the highest priority
+// request, which is put in service. With no request, IR7's vector is given.
+func (p *PIC) Acknowledge() byte {
+	p.inta++
+	switch p.inta {
+	case 1:
+		l, ok := p.pending()
+		if !ok {
+			l = 7
+		} else {
+			p.inService(l)
+		}
+		p.level = l
+		return call
+	case 2:
+		return byte(p.address(p.level))
+	}
+
+	p.inta = 0
+	if p.aeoi {
+		p.endOfInterrupt(p.level, p.rotateAEOI)
+	}
+
+	return byte(p.address(p.level) >> 8)
+}
+
+// initialise starts the initialisation sequence with ICW1, resetting the
+// controller.
+func (p *PIC) initialise(v byte) {
+	*p = PIC{
+		expect: 2,
+		icw1:   v,
+		vector: p.vector&0xff00 | uint16(v&0xe0),
+		levels: p.levels,
+		lowest: 7,
+	}
+}
+
+// expectICW4 waits for ICW4 if ICW1 asked for it, or else finishes the
+// initialisation sequence.
+func (p *PIC) expectICW4() {
+	p.expect = 0
+	if p.icw1&icw1IC4 != 0 {
+		p.expect = 4
+	}
+}
+
+// operation2 handles OCW2: end of interrupt and priority commands.
+func (p *PIC) operation2(v byte) {
+	l := uint(v & ocw2Level)
+	switch v & ocw2Command {
+	case nonSpecificEOI, rotateNonSpecificEOI:
+		if h, ok := p.highestInService(); ok {
+			p.endOfInterrupt(h, v&ocw2Command == rotateNonSpecificEOI)
+		}
+	case specificEOI, rotateSpecificEOI:
+		p.endOfInterrupt(l, v&ocw2Command == rotateSpecificEOI)
+	case rotateAEOISet:
+		p.rotateAEOI = true
+	case rotateAEOIClear:
+		p.rotateAEOI = false
+	case setPriority:
+		p.lowest = l
+	}
+}
+
+// operation3 handles OCW3: the register to read, polling and special mask
+// mode.
+func (p *PIC) operation3(v byte) {
+	if v&ocw3Read != 0 {
+		p.readISR = v&ocw3ReadISR != 0
+	}
+	p.poll = v&ocw3Poll != 0
+	if v&ocw3ESMM != 0 {
+		p.specialMask = v&ocw3SMM != 0
+	}
+}
+
+// pending returns the highest priority request which should interrupt the
+// CPU.
+func (p *PIC) pending() (uint, bool) {
+	req := p.irr &^ p.imr
+	for i := uint(1); i <= 8; i++ {
+		l := (p.lowest + i) % 8
+		bit := byte(1) << l
+		switch {
+		case p.isr&bit != 0 && !p.specialMask:
+			// Levels in service block themselves and lower priorities,
+			// except in special mask mode.
+			return 0, false
+		case req&bit != 0 && p.isr&bit == 0:
+			return l, true
+		}
+	}
+
+	return 0, false
+}
+
+// highestInService returns the highest priority level in service.
+func (p *PIC) highestInService() (uint, bool) {
+	for i := uint(1); i <= 8; i++ {
+		if l := (p.lowest + i) % 8; p.isr&(1<<l) != 0 {
+			return l, true
+		}
+	}
+
+	return 0, false
+}
+
+// inService puts a request in service.
+func (p *PIC) inService(l uint) {
+	p.isr |= 1 << l
+	if !p.levelTriggered() {
+		p.irr &^= 1 << l
+	}
+}
+
+// endOfInterrupt takes a level out of service, making it the lowest priority
+// if rotate is set.
+func (p *PIC) endOfInterrupt(l uint, rotate bool) {
+	p.isr &^= 1 << l
+	if rotate {
+		p.lowest = l
+	}
+}
+
+// address returns the vector for a level.
+func (p *PIC) address(l uint) uint16 {
+	if p.icw1&icw1ADI != 0 {
+		return p.vector&0xffe0 | uint16(l)<<2
+	}
+
+	return p.vector&0xffc0 | uint16(l)<<3
+}
+
+// levelTriggered returns true if the requests follow the levels on the IR
+// inputs.
+func (p *PIC) levelTriggered() bool {
+	return p.icw1&icw1LTIM != 0
+}
@@ -0,0 +1,255 @@
+package i8259
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+)
+
+// ram is a flat 64K memory.
+type ram []byte
+
+func (r ram) Read(addr uint16) byte     { return r[addr] }
+func (r ram) ReadAll() []byte           { return r }
+func (r ram) Write(addr uint16, v byte) { r[addr] = v }
+
+// acknowledge runs the three INTA cycles, returning the CALL address.
+func acknowledge(t *testing.T, p *PIC) uint16 {
+	t.Helper()
+
+	if op := p.Acknowledge(); op != call {
+		t.Fatalf("first INTA = %02x, want CALL", op)
+	}
+	lo := p.Acknowledge()
+	hi := p.Acknowledge()
+
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// isr reads the in service register.
+func isr(p *PIC) byte {
+	p.Out(Command, 0x0b)
+	defer p.Out(Command, 0x0a)
+
+	return p.In(Command)
+}
+
+func TestVectors(t *testing.T) {
+	p := New()
+
+	// Single, interval 4, at 2000H.
+	p.Out(Command, 0x16)
+	p.Out(Data, 0x20)
+	if p.Requesting() {
+		t.Error("requesting with no requests")
+	}
+
+	p.SetIRQ(3, true)
+	if !p.Requesting() {
+		t.Fatal("IR3 not requested")
+	}
+	if v := p.In(Command); v != 0x08 {
+		t.Errorf("IRR = %02x, want 08", v)
+	}
+	if addr := acknowledge(t, p); addr != 0x200c {
+		t.Errorf("IR3 vector = %04x, want 200c", addr)
+	}
+	if v := isr(p); v != 0x08 {
+		t.Errorf("ISR = %02x, want 08", v)
+	}
+
+	// Lower priorities wait for the end of the interrupt, higher ones
+	// nest.
+	p.SetIRQ(5, true)
+	if p.Requesting() {
+		t.Error("IR5 interrupted IR3")
+	}
+	p.SetIRQ(1, true)
+	if addr := acknowledge(t, p); addr != 0x2004 {
+		t.Errorf("IR1 vector = %04x, want 2004", addr)
+	}
+	p.Out(Command, 0x20)
+	p.Out(Command, 0x20)
+	if v := isr(p); v != 0 {
+		t.Errorf("ISR = %02x after two EOIs", v)
+	}
+	if addr := acknowledge(t, p); addr != 0x2014 {
+		t.Errorf("IR5 vector = %04x, want 2014", addr)
+	}
+
+	// Interval 8, with A7 and A6 from ICW1.
+	p.Out(Command, 0xd2)
+	p.Out(Data, 0x30)
+	p.SetIRQ(3, false)
+	p.SetIRQ(3, true)
+	if addr := acknowledge(t, p); addr != 0x30d8 {
+		t.Errorf("IR3 vector = %04x, want 30d8", addr)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	p := New()
+	p.Out(Command, 0x16)
+	p.Out(Data, 0x00)
+
+	// Masked requests are held.
+	p.Out(Data, 0x03)
+	if v := p.In(Data); v != 0x03 {
+		t.Errorf("IMR = %02x, want 03", v)
+	}
+	p.SetIRQ(0, true)
+	p.SetIRQ(6, true)
+	if addr := acknowledge(t, p); addr != 0x18 {
+		t.Errorf("vector = %04x, want IR6's 0018", addr)
+	}
+	p.Out(Command, 0x66)
+	p.Out(Data, 0x00)
+	if addr := acknowledge(t, p); addr != 0x00 {
+		t.Errorf("vector = %04x, want IR0's 0000", addr)
+	}
+
+	// A rotating EOI makes IR0 the lowest priority.
+	p.Out(Command, 0xa0)
+	for _, l := range []int{0, 2, 7} {
+		p.SetIRQ(l, false)
+		p.SetIRQ(l, true)
+	}
+	for _, want := range []uint16{0x08, 0x1c, 0x00} {
+		if addr := acknowledge(t, p); addr != want {
+			t.Errorf("vector = %04x, want %04x", addr, want)
+		}
+		p.Out(Command, 0x20)
+	}
+
+	// Set priority makes IR4 the lowest.
+	p.Out(Command, 0xc4)
+	p.SetIRQ(2, false)
+	p.SetIRQ(2, true)
+	p.SetIRQ(5, true)
+	if addr := acknowledge(t, p); addr != 0x14 {
+		t.Errorf("vector = %04x, want IR5's 0014", addr)
+	}
+
+	// In special mask mode, masking the level in service lets lower
+	// priorities in.
+	p.Out(Command, 0x68)
+	p.Out(Data, 0x20)
+	if addr := acknowledge(t, p); addr != 0x08 {
+		t.Errorf("vector = %04x, want IR2's 0008", addr)
+	}
+	if v := isr(p); v != 0x24 {
+		t.Errorf("ISR = %02x, want 24", v)
+	}
+}
+
+func TestAutomaticEOI(t *testing.T) {
+	p := New()
+	p.Out(Command, 0x17)
+	p.Out(Data, 0x01)
+	p.Out(Data, 0x02)
+
+	p.SetIRQ(7, true)
+	if addr := acknowledge(t, p); addr != 0x11c {
+		t.Errorf("vector = %04x, want 011c", addr)
+	}
+	if v := isr(p); v != 0 {
+		t.Errorf("ISR = %02x with automatic EOI", v)
+	}
+}
+
+func TestPollAndLevel(t *testing.T) {
+	p := New()
+
+	// Level triggered.
+	p.Out(Command, 0x1e)
+	p.Out(Data, 0x00)
+
+	p.Out(Command, 0x0c)
+	if v := p.In(Command); v != 0 {
+		t.Errorf("poll = %02x with no request", v)
+	}
+
+	p.SetIRQ(4, true)
+	p.Out(Command, 0x0c)
+	if v := p.In(Command); v != pollActive|4 {
+		t.Errorf("poll = %02x, want %02x", v, pollActive|4)
+	}
+	p.Out(Command, 0x20)
+	if !p.Requesting() {
+		t.Error("level held high not requested again")
+	}
+	p.SetIRQ(4, false)
+	if p.Requesting() {
+		t.Error("requesting after the level fell")
+	}
+}
+
+// A program which enables interrupts and halts. The handlers for IR2 and IR3
+// count themselves in memory and issue a non-specific EOI.
+const program = `
+	ORG	0
+	LXI	SP,1000H
+	MVI	A,16H
+	OUT	20H
+	MVI	A,01H
+	OUT	21H
+	EI
+LOOP:	HLT
+	JMP	LOOP
+	ORG	108H
+	JMP	IR2
+	NOP
+	JMP	IR3
+IR2:	PUSH	H
+	LXI	H,2002H
+	JMP	DONE
+IR3:	PUSH	H
+	LXI	H,2003H
+DONE:	INR	M
+	MVI	A,20H
+	OUT	20H
+	POP	H
+	EI
+	RET
+`
+
+func TestCPU(t *testing.T) {
+	prog, err := asm.Assemble("pic.asm", strings.NewReader(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := make(ram, 0x10000)
+	copy(mem, prog.Binary())
+
+	p := New()
+	var cpu *go8080.Intel8080
+	cpu = go8080.NewIntel8080(mem,
+		go8080.WithInterruptController(p),
+		go8080.WithInput(func(port byte) byte { return p.In(port & 1) }),
+		go8080.WithOutput(func(port byte) { p.Out(port&1, cpu.Accumulator()) }),
+	)
+	run := func(n int) {
+		t.Helper()
+		for ; n > 0; n-- {
+			if err := cpu.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	run(10)
+	if cpu.Running() {
+		t.Fatal("not halted")
+	}
+	p.SetIRQ(3, true)
+	p.SetIRQ(2, true)
+	run(40)
+	if mem[0x2002] != 1 || mem[0x2003] != 1 {
+		t.Errorf("handled IR2 %d times and IR3 %d times, want once each", mem[0x2002], mem[0x2003])
+	}
+	if cpu.Running() || cpu.StackPointer() != 0x1000 {
+		t.Errorf("not halted with the stack empty: SP = %04x", cpu.StackPointer())
+	}
+}
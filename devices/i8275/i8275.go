@@ -0,0 +1,600 @@
+// Package i8275 emulates the Intel 8275 programmable CRT controller.
+//
+// The controller fetches each frame's characters through DMA, normally from
+// an 8257, a row at a time into its row buffer, and keeps the screen they
+// make up. The screen is rendered a whole frame at a time rather than raster
+// line by raster line.
+//
+// Character codes 80H and above control the display. Field attribute codes
+// set the highlight, blink, reverse video, underline and general purpose
+// attributes of the characters which follow them, to the end of the frame or
+// the next field attribute; in transparent mode they take no place on the
+// screen, and in non-transparent mode they are shown as blanks. Character
+// attribute codes draw lines for boxes and forms, and the special control
+// codes end a row or the screen early, blanking the rest of it.
+//
+// The screen can be read as character codes and attributes, as text, or as
+// an image drawn with a character generator supplied by the machine. Spaced
+// rows and the retrace timings are accepted but not emulated, and the DMA
+// burst settings are only reported, for machines timing the DMA.
+package i8275
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Register offsets.
+const (
+	Param   = 0 // Parameter register, written after a command or read.
+	Command = 1 // Command register when written, status when read.
+)
+
+// Commands, in the top three bits of the command register.
+const (
+	cmdReset        = 0x00
+	cmdStartDisplay = 0x20
+	cmdStopDisplay  = 0x40
+	cmdReadLightPen = 0x60
+	cmdLoadCursor   = 0x80
+	cmdEnableInt    = 0xa0
+	cmdDisableInt   = 0xc0
+	cmdPreset       = 0xe0
+	cmdMask         = 0xe0
+)
+
+// Status register bits.
+const (
+	FO = 1 << iota // FIFO overrun.
+	DU             // DMA underrun.
+	VE             // Video enabled.
+	IC             // Improper command.
+	LP             // Light pen input.
+	IR             // Interrupt request.
+	IE             // Interrupts enabled.
+)
+
+// Reset command parameter fields.
+const (
+	resetCols       = 0x7f
+	resetRows       = 0x3f
+	resetLines      = 0x0f
+	resetUnderline  = 0xf0
+	resetOffset     = 0x80
+	resetOpaque     = 0x40
+	resetCursor     = 0x30
+	underlineShift  = 4
+	cursorShift     = 4
+	blankEdgesAbove = 7
+)
+
+// Start display command fields: the burst space and count codes.
+const (
+	startSpace      = 0x1c
+	startCount      = 0x03
+	startSpaceShift = 2
+)
+
+// Cursor formats, from the reset command.
+const (
+	cursorBlinkBlock = iota
+	cursorBlinkUnderline
+	cursorBlock
+	cursorUnderline
+)
+
+// Attribute bits of a screen position. The low six are those of a field
+// attribute code.
+const (
+	Highlight   = 0x01
+	Blink       = 0x02
+	GPA0        = 0x04 // General purpose attribute outputs.
+	GPA1        = 0x08
+	Reverse     = 0x10
+	Underline   = 0x20
+	LineDrawing = 0x40 // The code is a character attribute's line drawing code.
+	Blanked     = 0x80 // Nothing is displayed.
+)
+
+// Line drawing codes, from character attribute codes.
+const (
+	TopLeft = iota
+	TopRight
+	BottomLeft
+	BottomRight
+	TopIntersect
+	RightIntersect
+	LeftIntersect
+	BottomIntersect
+	Horizontal
+	Vertical
+	Cross
+)
+
+// Character codes 80H and above.
+const (
+	fieldAttribute  = 0x80
+	fieldMask       = 0x3f
+	charAttribute   = 0xc0
+	charAttrBits    = Blink | Highlight
+	charAttrShift   = 2
+	charAttrCode    = 0x0f
+	special         = 0xf0
+	specialStopDMA  = 0x01
+	specialScreen   = 0x02
+	specialLast     = 0xf3
+	lineDrawingLast = Cross
+)
+
+// fifoSize is the number of field attribute codes a row can hold in
+// transparent mode.
+const fifoSize = 16
+
+// Blink periods, in frames: characters blink at 1/32 of the frame rate and
+// the cursor at 1/16.
+const (
+	charBlink   = 32
+	cursorBlink = 16
+)
+
+// Dot intensities in images.
+var (
+	normalDot    = color.Gray{Y: 0xbf}
+	highlightDot = color.Gray{Y: 0xff}
+)
+
+// lines holds the segments drawn for each line drawing code: through the
+// middle of the cell to the left and right along the underline line, and up
+// and down from it.
+var lines = [...]byte{
+	TopLeft:         right | down,
+	TopRight:        left | down,
+	BottomLeft:      right | up,
+	BottomRight:     left | up,
+	TopIntersect:    left | right | down,
+	RightIntersect:  left | up | down,
+	LeftIntersect:   right | up | down,
+	BottomIntersect: left | right | up,
+	Horizontal:      left | right,
+	Vertical:        up | down,
+	Cross:           left | right | up | down,
+}
+
+// Line drawing segments.
+const (
+	left = 1 << iota
+	right
+	up
+	down
+)
+
+// boxRunes are the line drawing codes as text.
+const boxRunes = "┌┐└┘┬┤├┴─│┼"
+
+type (
+	// CRT is an 8275.
+	CRT struct {
+		// The command waiting for parameters, and those received so far.
+		cmd    byte
+		params []byte
+		want   int
+
+		// Parameters to be read back, after a read light pen command.
+		readBack []byte
+
+		// Screen format, from the reset command.
+		cols, rows  int
+		linesPerRow int
+		underline   int
+		offset      bool
+		opaque      bool
+		cursorFmt   int
+
+		// DMA bursts, from the start display command.
+		burstCount, burstSpace int
+
+		cursorCol, cursorRow int
+
+		// The character and row counts latched by the light pen.
+		penCol, penRow int
+
+		status byte
+
+		// The screen displayed in the last frame, and the number of frames
+		// displayed, which times blinking.
+		screen [][]Cell
+		frames int
+	}
+
+	// Cell is a position on the screen: the character code displayed there
+	// and its attributes. With LineDrawing set, Code is a line drawing
+	// code.
+	Cell struct {
+		Code byte
+		Attr byte
+	}
+)
+
+// New returns a CRT controller with its display stopped.
+func New() *CRT {
+	return &CRT{cols: 80, rows: 25, linesPerRow: 10, burstCount: 1}
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (c *CRT) Ports() int {
+	return 2
+}
+
+// In reads the status register, or a parameter after a read light pen
+// command. Reading the status clears the interrupt request and error flags.
+func (c *CRT) In(offset byte) byte {
+	if offset&1 == Param {
+		if len(c.readBack) == 0 {
+			c.status |= IC
+			return 0
+		}
+		v := c.readBack[0]
+		c.readBack = c.readBack[1:]
+		return v
+	}
+
+	s := c.status
+	c.status &^= IR | IC | DU | FO | LP
+	return s
+}
+
+// Out writes a command or one of its parameters.
+func (c *CRT) Out(offset, v byte) {
+	if offset&1 == Param {
+		if c.want == 0 {
+			c.status |= IC
+			return
+		}
+		c.params = append(c.params, v)
+		if c.want--; c.want == 0 {
+			c.execute()
+		}
+		return
+	}
+
+	c.cmd, c.params, c.want = v, nil, 0
+	switch v & cmdMask {
+	case cmdReset:
+		c.status &^= VE | IE
+		c.want = 4
+	case cmdStartDisplay:
+		c.status |= VE | IE
+		c.burstCount = 1 << (v & startCount)
+		c.burstSpace = 0
+		if s := int(v & startSpace >> startSpaceShift); s != 0 {
+			c.burstSpace = 8*s - 1
+		}
+	case cmdStopDisplay:
+		c.status &^= VE
+	case cmdReadLightPen:
+		c.readBack = []byte{byte(c.penCol), byte(c.penRow)}
+	case cmdLoadCursor:
+		c.want = 2
+	case cmdEnableInt:
+		c.status |= IE
+	case cmdDisableInt:
+		c.status &^= IE
+	case cmdPreset:
+		// Frames always start from the top left.
+	}
+}
+
+// execute carries out a command once all its parameters have arrived.
+func (c *CRT) execute() {
+	p := c.params
+	switch c.cmd & cmdMask {
+	case cmdReset:
+		c.cols = int(p[0]&resetCols) + 1
+		c.rows = int(p[1]&resetRows) + 1
+		c.linesPerRow = int(p[2]&resetLines) + 1
+		c.underline = int(p[2] & resetUnderline >> underlineShift)
+		c.offset = p[3]&resetOffset != 0
+		c.opaque = p[3]&resetOpaque != 0
+		c.cursorFmt = int(p[3] & resetCursor >> cursorShift)
+	case cmdLoadCursor:
+		c.cursorCol, c.cursorRow = int(p[0]&0x7f), int(p[1]&0x3f)
+	}
+}
+
+// Frame displays a frame, fetching its characters row by row with fetch. It
+// returns the number of characters fetched. If fetch fails part way the DMA
+// underrun flag is set and the display stops, as on the real controller. At
+// the end of the frame an interrupt is requested if enabled, which the
+// machine may act on through IRQ.
+func (c *CRT) Frame(fetch func() (byte, bool)) int {
+	c.screen = c.screen[:0]
+	if c.status&VE == 0 {
+		return 0
+	}
+	c.frames++
+
+	var (
+		n          int
+		attr       byte
+		endOfFrame bool
+	)
+	for r := 0; r < c.rows; r++ {
+		row := make([]Cell, c.cols)
+		if endOfFrame {
+			blank(row)
+		} else {
+			var ok bool
+			if attr, endOfFrame, ok = c.fill(row, attr, fetch, &n); !ok {
+				c.status |= DU
+				c.status &^= VE
+				c.screen = c.screen[:0]
+				return n
+			}
+		}
+		c.screen = append(c.screen, row)
+	}
+	if c.status&IE != 0 {
+		c.status |= IR
+	}
+
+	return n
+}
+
+// fill fills the row buffer with a row of characters, starting with the
+// given field attributes, counting the characters fetched in n. It returns
+// the field attributes at the end of the row, whether the row ended the
+// screen, and false if fetch failed.
+func (c *CRT) fill(row []Cell, attr byte, fetch func() (byte, bool), n *int) (byte, bool, bool) {
+	fifo := 0
+	for i := 0; i < len(row); {
+		b, ok := fetch()
+		if !ok {
+			return attr, false, false
+		}
+		*n++
+
+		switch {
+		case b < fieldAttribute:
+			row[i] = Cell{Code: b, Attr: attr}
+			i++
+
+		case b < charAttribute:
+			attr = b & fieldMask
+			if c.opaque {
+				row[i] = Cell{Attr: attr | Blanked}
+				i++
+			} else if fifo++; fifo > fifoSize {
+				c.status |= FO
+			}
+
+		case b >= special && b <= specialLast:
+			// The rest of the row is blank. Unless told to stop, the DMA
+			// carries on filling the row buffer.
+			blank(row[i:])
+			if b&specialStopDMA == 0 {
+				for i++; i < len(row); i++ {
+					if _, ok := fetch(); !ok {
+						return attr, false, false
+					}
+					*n++
+				}
+			}
+			return attr, b&specialScreen != 0, true
+
+		default:
+			code := b >> charAttrShift & charAttrCode
+			row[i] = Cell{Code: code, Attr: attr&^charAttrBits | b&charAttrBits | LineDrawing}
+			if code > lineDrawingLast {
+				row[i].Attr |= Blanked
+			}
+			i++
+		}
+	}
+
+	return attr, false, true
+}
+
+// blank blanks the cells of part of a row.
+func blank(cells []Cell) {
+	for i := range cells {
+		cells[i] = Cell{Attr: Blanked}
+	}
+}
+
+// IRQ returns true if the controller is requesting an interrupt.
+func (c *CRT) IRQ() bool {
+	return c.status&IR != 0
+}
+
+// LightPen emulates the light pen input seeing the beam at the given
+// character position, latching it for the read light pen command. The real
+// controller latches the position a few characters after the one the pen is
+// pointed at, which software corrects for; the position is latched here as
+// given.
+func (c *CRT) LightPen(col, row int) {
+	c.penCol, c.penRow = col, row
+	c.status |= LP
+}
+
+// Size returns the number of characters per row and rows per frame.
+func (c *CRT) Size() (cols, rows int) {
+	return c.cols, c.rows
+}
+
+// LinesPerRow returns the number of raster lines in each character row.
+func (c *CRT) LinesPerRow() int {
+	return c.linesPerRow
+}
+
+// Burst returns the number of characters the controller asks the DMA
+// controller for in each burst, and the number of character clocks between
+// bursts, as set by the start display command.
+func (c *CRT) Burst() (count, space int) {
+	return c.burstCount, c.burstSpace
+}
+
+// Cursor returns the cursor position.
+func (c *CRT) Cursor() (col, row int) {
+	return c.cursorCol, c.cursorRow
+}
+
+// Cells returns the positions displayed in the last frame, a slice per row.
+// It is empty if the display is stopped.
+func (c *CRT) Cells() [][]Cell {
+	return c.screen
+}
+
+// Characters returns the character codes displayed in the last frame, a
+// slice per row, with blanked positions and line drawing as spaces. It is
+// empty if the display is stopped.
+func (c *CRT) Characters() [][]byte {
+	var chars [][]byte
+	for _, row := range c.screen {
+		line := make([]byte, len(row))
+		for i, cell := range row {
+			line[i] = ' '
+			if cell.Attr&(Blanked|LineDrawing) == 0 {
+				line[i] = cell.Code
+			}
+		}
+		chars = append(chars, line)
+	}
+
+	return chars
+}
+
+// Text returns the last frame as lines of text, with codes outside printable
+// ASCII shown as spaces and trailing spaces removed.
+func (c *CRT) Text() string {
+	return c.TextFunc(func(b byte) rune {
+		if b < 0x20 || b >= 0x7f {
+			return ' '
+		}
+		return rune(b)
+	})
+}
+
+// TextFunc returns the last frame as lines of text, decoding each character
+// code with decode, for machines with their own character sets. Blanked
+// positions are shown as spaces and line drawing with box drawing
+// characters; attributes and the cursor are not shown.
+func (c *CRT) TextFunc(decode func(byte) rune) string {
+	var sb strings.Builder
+	box := []rune(boxRunes)
+
+	for _, row := range c.screen {
+		line := make([]rune, len(row))
+		for i, cell := range row {
+			switch {
+			case cell.Attr&Blanked != 0:
+				line[i] = ' '
+			case cell.Attr&LineDrawing != 0:
+				line[i] = box[cell.Code]
+			default:
+				line[i] = decode(cell.Code)
+			}
+		}
+		sb.WriteString(strings.TrimRight(string(line), " "))
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// Image renders the last frame as dots on black, each character cell width
+// dots wide and a dot for each raster line high. The character generator
+// glyph returns the dots of a character code on a line of its cell, given
+// the line count output by the controller, with the leftmost dot in bit 7.
+//
+// Highlighted characters are drawn brighter, and the reverse video,
+// underline, blink and cursor are drawn as the controller's outputs direct.
+// Line drawing is drawn through the middle of the cell and along the
+// underline line.
+func (c *CRT) Image(width int, glyph func(code byte, line int) byte) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, c.cols*width, c.rows*c.linesPerRow))
+
+	for r, row := range c.screen {
+		for col, cell := range row {
+			cursor := col == c.cursorCol && r == c.cursorRow
+			c.drawCell(img, col*width, r*c.linesPerRow, width, cell, cursor, glyph)
+		}
+	}
+
+	return img
+}
+
+// drawCell draws a cell with its top left corner at x, y.
+func (c *CRT) drawCell(img *image.Gray, x, y, width int, cell Cell, cursor bool, glyph func(byte, int) byte) {
+	dot := normalDot
+	if cell.Attr&Highlight != 0 {
+		dot = highlightDot
+	}
+	blinkOff := cell.Attr&Blink != 0 && c.frames%charBlink >= charBlink/2
+	blinking := c.cursorFmt == cursorBlinkBlock || c.cursorFmt == cursorBlinkUnderline
+	cursorOn := cursor && (!blinking || c.frames%cursorBlink < cursorBlink/2)
+	blockCursor := cursorOn && (c.cursorFmt == cursorBlinkBlock || c.cursorFmt == cursorBlock)
+
+	for line := 0; line < c.linesPerRow; line++ {
+		var dots byte
+		switch {
+		case cell.Attr&Blanked != 0 || blinkOff:
+		case c.underline > blankEdgesAbove && (line == 0 || line == c.linesPerRow-1):
+			// The top and bottom lines are blanked to leave room for
+			// the underline.
+		case cell.Attr&LineDrawing != 0:
+			dots = c.lineDots(cell.Code, line, width)
+		default:
+			dots = glyph(cell.Code, c.lineCount(line))
+		}
+		if line == c.underline && (cell.Attr&(Underline|Blanked) == Underline && !blinkOff || cursorOn && !blockCursor) {
+			dots = 0xff
+		}
+		if cell.Attr&(Reverse|Blanked) == Reverse {
+			dots = ^dots
+		}
+		if blockCursor {
+			dots = ^dots
+		}
+
+		for dx := 0; dx < width && dx < 8; dx++ {
+			if dots&(0x80>>uint(dx)) != 0 {
+				img.SetGray(x+dx, y+line, dot)
+			}
+		}
+	}
+}
+
+// lineCount returns the line count output for a raster line of a row: the
+// line itself, or in offset mode the line before it.
+func (c *CRT) lineCount(line int) int {
+	if c.offset {
+		return (line + c.linesPerRow - 1) % c.linesPerRow
+	}
+
+	return line
+}
+
+// lineDots returns the dots of a line drawing code on a raster line.
+func (c *CRT) lineDots(code byte, line, width int) byte {
+	segs := lines[code]
+	mid := width / 2
+	var dots byte
+
+	switch {
+	case line == c.underline:
+		if segs&left != 0 {
+			dots |= ^byte(0) << uint(8-mid)
+		}
+		if segs&right != 0 {
+			dots |= 0xff >> uint(mid)
+		}
+		if segs&(up|down) != 0 {
+			dots |= 0x80 >> uint(mid)
+		}
+	case line < c.underline && segs&up != 0, line > c.underline && segs&down != 0:
+		dots = 0x80 >> uint(mid)
+	}
+
+	return dots
+}
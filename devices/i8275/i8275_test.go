@@ -0,0 +1,214 @@
+package i8275
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestCRT(t *testing.T) {
+	c := New()
+
+	// Reset to 4 characters by 2 rows, with field attributes taking a
+	// place on the screen, then start the display.
+	c.Out(Command, cmdReset)
+	for _, v := range []byte{0x03, 0x01, 0x09, 0x40} {
+		c.Out(Param, v)
+	}
+	c.Out(Command, cmdLoadCursor)
+	c.Out(Param, 2)
+	c.Out(Param, 1)
+	c.Out(Command, cmdStartDisplay)
+
+	src := []byte("AB\x01\x80CD")
+	n := c.Frame(func() (byte, bool) {
+		b := src[0]
+		src = append(src[1:], b)
+		return b, true
+	})
+	if n != 8 {
+		t.Errorf("fetched %d, want 8", n)
+	}
+	if got, want := c.Text(), "AB\nCDAB\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if col, row := c.Cursor(); col != 2 || row != 1 {
+		t.Errorf("cursor = %d,%d, want 2,1", col, row)
+	}
+	if !c.IRQ() {
+		t.Error("no interrupt at the end of the frame")
+	}
+	if s := c.In(Command); s != VE|IE|IR {
+		t.Errorf("status = %02x, want %02x", s, VE|IE|IR)
+	}
+	if c.IRQ() {
+		t.Error("interrupt not cleared by status read")
+	}
+
+	// Running out of DMA stops the display.
+	c.Frame(func() (byte, bool) { return 0, false })
+	if s := c.In(Command); s != IE|DU {
+		t.Errorf("status = %02x, want %02x", s, IE|DU)
+	}
+	if got := c.Text(); got != "" {
+		t.Errorf("text = %q after underrun", got)
+	}
+
+	// A parameter with no command is improper.
+	c.Out(Param, 0)
+	if s := c.In(Command); s&IC == 0 {
+		t.Errorf("status = %02x, want IC set", s)
+	}
+}
+
+// reset resets the controller to the given format and starts the display.
+func reset(c *CRT, params ...byte) {
+	c.Out(Command, cmdReset)
+	for _, v := range params {
+		c.Out(Param, v)
+	}
+	c.Out(Command, cmdStartDisplay|0x0b)
+}
+
+// frame displays a frame of the given characters, returning the number
+// fetched.
+func frame(c *CRT, src string) int {
+	return c.Frame(func() (byte, bool) {
+		if src == "" {
+			return 0, false
+		}
+		b := src[0]
+		src = src[1:]
+		return b, true
+	})
+}
+
+func TestAttributes(t *testing.T) {
+	c := New()
+
+	// Transparent field attributes take no place, and last across rows.
+	reset(c, 0x03, 0x02, 0x09, 0x00)
+	if count, space := c.Burst(); count != 8 || space != 15 {
+		t.Errorf("burst of %d every %d, want 8 every 15", count, space)
+	}
+	if n := frame(c, "A\x91BCDE\x80FGHIJKL"); n != 14 {
+		t.Errorf("fetched %d, want 14", n)
+	}
+	if got, want := c.Text(), "ABCD\nEFGH\nIJKL\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	cells := c.Cells()
+	if cells[0][0].Attr != 0 || cells[0][1].Attr != Reverse|Highlight || cells[1][0].Attr != Reverse|Highlight {
+		t.Errorf("attributes % x, % x", cells[0], cells[1])
+	}
+	if cells[1][1].Attr != 0 {
+		t.Errorf("attribute %02x after reset field attribute", cells[1][1].Attr)
+	}
+
+	// Non-transparent field attributes are blank, and character
+	// attributes draw lines.
+	reset(c, 0x03, 0x00, 0x09, 0x40)
+	frame(c, "\xa0\xc0\xe1\xc4")
+	if got, want := c.Text(), " ┌─┐\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if cell := c.Cells()[0][2]; cell.Code != Horizontal || cell.Attr != LineDrawing|Underline|Highlight {
+		t.Errorf("cell %+v, want horizontal line underlined and highlighted", cell)
+	}
+
+	// Too many field attributes overrun the FIFO.
+	reset(c, 0x03, 0x00, 0x09, 0x00)
+	frame(c, strings.Repeat("\x80", 17)+"ABCD")
+	if s := c.In(Command); s&FO == 0 {
+		t.Errorf("status = %02x, want FO set", s)
+	}
+}
+
+func TestSpecialCodes(t *testing.T) {
+	c := New()
+	reset(c, 0x03, 0x03, 0x09, 0x00)
+
+	// End of row fills the row buffer, end of row stop DMA does not, and
+	// end of screen stop DMA ends the frame's DMA at once.
+	if n := frame(c, "A\xf0xxB\xf1C\xf3"); n != 8 {
+		t.Errorf("fetched %d, want 8", n)
+	}
+	if got, want := c.Text(), "A\nB\nC\n\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if cells := c.Cells(); cells[0][1].Attr != Blanked || cells[3][0].Attr != Blanked {
+		t.Error("rest of the row and screen not blanked")
+	}
+
+	// End of screen fills the row buffer first.
+	if n := frame(c, "\xf2xxx"); n != 4 {
+		t.Errorf("fetched %d, want 4", n)
+	}
+	if s := c.In(Command); s != VE|IE|IR {
+		t.Errorf("status = %02x, want %02x", s, VE|IE|IR)
+	}
+}
+
+func TestLightPen(t *testing.T) {
+	c := New()
+	c.LightPen(12, 3)
+	if s := c.In(Command); s&LP == 0 {
+		t.Errorf("status = %02x, want LP set", s)
+	}
+	c.Out(Command, cmdReadLightPen)
+	if col, row := c.In(Param), c.In(Param); col != 12 || row != 3 {
+		t.Errorf("light pen at %d,%d, want 12,3", col, row)
+	}
+}
+
+// glyph is a character generator drawing every character as a solid block
+// on its middle lines, and a space as nothing.
+func glyph(code byte, line int) byte {
+	if code == ' ' || line < 1 || line > 2 {
+		return 0
+	}
+
+	return 0xf0
+}
+
+// dots returns the dots of a line of an image as a string.
+func dots(img *image.Gray, y int) string {
+	var sb strings.Builder
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		switch img.GrayAt(x, y).Y {
+		case 0:
+			sb.WriteByte('.')
+		case highlightDot.Y:
+			sb.WriteByte('#')
+		default:
+			sb.WriteByte('+')
+		}
+	}
+
+	return sb.String()
+}
+
+func TestImage(t *testing.T) {
+	c := New()
+
+	// Four 5 dot cells by four lines, underline on line 3 and a steady
+	// underline cursor on the first cell.
+	reset(c, 0x03, 0x00, 0x33, 0x30)
+	frame(c, "A\x91B\xa0 \xe4")
+	img := c.Image(5, glyph)
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 4 {
+		t.Fatalf("image is %dx%d, want 20x4", b.Dx(), b.Dy())
+	}
+
+	want := []string{
+		".....#####.......+..",
+		"++++.....#.......+..",
+		"++++.....#.......+..",
+		"+++++#####++++++++++",
+	}
+	for y, w := range want {
+		if got := dots(img, y); got != w {
+			t.Errorf("line %d = %s, want %s", y, got, w)
+		}
+	}
+}
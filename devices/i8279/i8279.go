@@ -0,0 +1,279 @@
+// Package i8279 emulates the Intel 8279 programmable keyboard/display
+// interface.
+//
+// The controller scans a display from its 16 bytes of display RAM and a key
+// matrix into its 8 character FIFO. Scanning is not emulated: the machine
+// reports each key press with Key, as the debounced scan would, and reads the
+// display RAM with Display. In sensor matrix mode the machine sets the sensor
+// RAM with Sense instead.
+package i8279
+
+// Register offsets.
+const (
+	Data    = 0
+	Command = 1 // Command register when written, status when read.
+)
+
+// Commands, in the top three bits of the command register.
+const (
+	cmdMode        = 0x00
+	cmdClock       = 0x20
+	cmdReadFIFO    = 0x40
+	cmdReadDisplay = 0x60
+	cmdWrite       = 0x80
+	cmdInhibit     = 0xa0
+	cmdClear       = 0xc0
+	cmdEndInt      = 0xe0
+	cmdMask        = 0xe0
+
+	autoIncrement = 0x10
+)
+
+// Keyboard modes, from the low three bits of the mode set command.
+const (
+	modeSensor = 0x04
+	kbdMask    = 0x07
+
+	// Right entry display mode and 16 character display mode.
+	modeRightEntry = 0x10
+	mode16Chars    = 0x08
+)
+
+// Status register bits, above the count of characters in the FIFO.
+const (
+	Full       = 0x08
+	Underrun   = 0x10
+	Overrun    = 0x20
+	SensorErr  = 0x40 // Sensor closure, or an error in special error mode.
+	Unavail    = 0x80 // Display unavailable, while clearing.
+	countMask  = 0x07
+	fifoLength = 8
+)
+
+// KDC is an 8279.
+type KDC struct {
+	mode byte
+
+	fifo   []byte
+	sensor [8]byte
+	status byte
+
+	display [16]byte
+
+	// Address and auto increment flags for reading the FIFO or sensor RAM,
+	// and for reading and writing the display RAM.
+	readAddr, dispAddr byte
+	readAI, dispAI     bool
+	readDisplay        bool
+
+	// Display nibbles inhibited from writes, and blanked: bit 0 for the B
+	// (low) nibble and bit 1 for the A (high) nibble.
+	inhibit, blank byte
+
+	irq bool
+}
+
+// New returns a controller in its reset state: a 16 character left entry
+// display and an encoded scan keyboard with 2-key lockout.
+func New() *KDC {
+	return &KDC{mode: mode16Chars}
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (k *KDC) Ports() int {
+	return 2
+}
+
+// In reads the status register, or the data register: the FIFO, the sensor
+// RAM or the display RAM, according to the last read command.
+func (k *KDC) In(offset byte) byte {
+	if offset&1 == Command {
+		return k.status | byte(len(k.fifo))&countMask
+	}
+
+	if k.readDisplay {
+		v := k.display[k.dispAddr&0x0f]
+		if k.dispAI {
+			k.dispAddr++
+		}
+		return v
+	}
+
+	if k.sensorMode() {
+		v := k.sensor[k.readAddr&0x07]
+		if k.readAI {
+			k.readAddr++
+		}
+		k.irq = false
+		return v
+	}
+
+	if len(k.fifo) == 0 {
+		k.status |= Underrun
+		return 0
+	}
+	v := k.fifo[0]
+	k.fifo = k.fifo[1:]
+	k.status &^= Full
+	k.irq = len(k.fifo) > 0
+
+	return v
+}
+
+// Out writes a command, or a character to the display RAM.
+func (k *KDC) Out(offset, v byte) {
+	if offset&1 == Data {
+		k.writeDisplay(v)
+		return
+	}
+
+	switch v & cmdMask {
+	case cmdMode:
+		k.mode = v & 0x1f
+	case cmdClock:
+		// The scan rate is not emulated.
+	case cmdReadFIFO:
+		k.readDisplay = false
+		k.readAddr, k.readAI = v&0x07, v&autoIncrement != 0
+	case cmdReadDisplay:
+		k.readDisplay = true
+		k.dispAddr, k.dispAI = v&0x0f, v&autoIncrement != 0
+	case cmdWrite:
+		k.readDisplay = false
+		k.dispAddr, k.dispAI = v&0x0f, v&autoIncrement != 0
+	case cmdInhibit:
+		k.inhibit = v >> 2 & 0x03
+		k.blank = v & 0x03
+	case cmdClear:
+		k.clear(v)
+	case cmdEndInt:
+		k.irq = false
+		k.status &^= SensorErr
+	}
+}
+
+// Key enters a key into the FIFO, as the controller does when it scans a
+// newly pressed key in a scanned keyboard or strobed input mode. code is the
+// FIFO character: the control and shift inputs in bits 7 and 6, the scan row
+// in bits 5-3 and the return line in bits 2-0. The interrupt request is set.
+// If the FIFO is full the key is lost and the overrun flag set.
+func (k *KDC) Key(code byte) {
+	if k.sensorMode() {
+		return
+	}
+	if len(k.fifo) == fifoLength {
+		k.status |= Overrun
+		return
+	}
+
+	k.fifo = append(k.fifo, code)
+	if len(k.fifo) == fifoLength {
+		k.status |= Full
+	}
+	k.irq = true
+}
+
+// Sense sets a row of the sensor RAM in sensor matrix mode. A change
+// requests an interrupt.
+func (k *KDC) Sense(row int, v byte) {
+	if !k.sensorMode() || k.sensor[row] == v {
+		return
+	}
+
+	k.sensor[row] = v
+	k.status |= SensorErr
+	k.irq = true
+}
+
+// Pending returns the number of characters in the FIFO.
+func (k *KDC) Pending() int {
+	return len(k.fifo)
+}
+
+// IRQ returns true if the controller is requesting an interrupt: while the
+// FIFO holds a character, or after a sensor change until an end interrupt
+// command or a read of the sensor RAM.
+func (k *KDC) IRQ() bool {
+	return k.irq
+}
+
+// Display returns the display RAM as it is shown, with blanked nibbles as
+// zero. An 8 character display shows only the first 8 bytes.
+func (k *KDC) Display() []byte {
+	d := make([]byte, k.width())
+	for i := range d {
+		d[i] = k.display[i]
+		if k.blank&0x01 != 0 {
+			d[i] &^= 0x0f
+		}
+		if k.blank&0x02 != 0 {
+			d[i] &^= 0xf0
+		}
+	}
+
+	return d
+}
+
+// writeDisplay writes a character to the display RAM, respecting the write
+// inhibits. In right entry mode the display shifts left and the character
+// enters at the right.
+func (k *KDC) writeDisplay(v byte) {
+	var keep byte
+	if k.inhibit&0x01 != 0 {
+		keep |= 0x0f
+	}
+	if k.inhibit&0x02 != 0 {
+		keep |= 0xf0
+	}
+
+	if k.mode&modeRightEntry != 0 {
+		n := k.width()
+		copy(k.display[:n-1], k.display[1:n])
+		k.display[n-1] = k.display[n-1]&keep | v&^keep
+		return
+	}
+
+	i := k.dispAddr & 0x0f
+	k.display[i] = k.display[i]&keep | v&^keep
+	if k.dispAI {
+		k.dispAddr++
+	}
+}
+
+// clear carries out a clear command: bit 4 clears the display RAM to the code
+// in bits 3-2, bit 1 empties the FIFO and bit 0 does both.
+func (k *KDC) clear(v byte) {
+	var code byte
+	switch v >> 2 & 0x03 {
+	case 2:
+		code = 0x20
+	case 3:
+		code = 0xff
+	}
+
+	if v&0x10 != 0 || v&0x01 != 0 {
+		for i := range k.display {
+			k.display[i] = code
+		}
+		k.dispAddr = 0
+	}
+	if v&0x02 != 0 || v&0x01 != 0 {
+		k.fifo = k.fifo[:0]
+		k.status = 0
+		k.irq = false
+	}
+}
+
+// width returns the number of characters in the display.
+func (k *KDC) width() int {
+	if k.mode&mode16Chars != 0 {
+		return 16
+	}
+
+	return 8
+}
+
+// sensorMode returns true in sensor matrix mode.
+func (k *KDC) sensorMode() bool {
+	return k.mode&kbdMask == modeSensor
+}
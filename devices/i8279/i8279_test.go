@@ -0,0 +1,81 @@
+package i8279
+
+import "testing"
+
+func TestKeyboard(t *testing.T) {
+	k := New()
+	if k.IRQ() {
+		t.Error("IRQ after reset")
+	}
+
+	for i := 0; i < fifoLength+1; i++ {
+		k.Key(byte(i))
+	}
+	if s := k.In(Command); s != Full|Overrun|fifoLength&countMask {
+		t.Errorf("status = %02x, want %02x", s, Full|Overrun)
+	}
+	if !k.IRQ() {
+		t.Error("no IRQ with keys in the FIFO")
+	}
+	for i := 0; i < fifoLength; i++ {
+		if v := k.In(Data); v != byte(i) {
+			t.Errorf("key %d = %02x", i, v)
+		}
+	}
+	if k.IRQ() {
+		t.Error("IRQ with the FIFO empty")
+	}
+	k.In(Data)
+	if s := k.In(Command); s&Underrun == 0 {
+		t.Errorf("status = %02x, want underrun", s)
+	}
+
+	// Clear the FIFO and status.
+	k.Key(0x12)
+	k.Out(Command, cmdClear|0x02)
+	if s := k.In(Command); s != 0 || k.IRQ() {
+		t.Errorf("status = %02x, IRQ %v after clear", s, k.IRQ())
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	k := New()
+
+	// 8 character left entry, write from 2 with auto increment.
+	k.Out(Command, cmdMode)
+	k.Out(Command, cmdWrite|autoIncrement|2)
+	k.Out(Data, 0x12)
+	k.Out(Data, 0x34)
+
+	// Inhibit writes to the high nibble and blank the low one.
+	k.Out(Command, cmdInhibit|0x08|0x01)
+	k.Out(Command, cmdWrite|4)
+	k.Out(Data, 0xff)
+
+	want := []byte{0, 0, 0x10, 0x30, 0x00, 0, 0, 0}
+	if got := k.Display(); string(got) != string(want) {
+		t.Errorf("display = % x, want % x", got, want)
+	}
+
+	k.Out(Command, cmdInhibit)
+	k.Out(Command, cmdReadDisplay|autoIncrement|3)
+	for _, want := range []byte{0x34, 0x0f} {
+		if v := k.In(Data); v != want {
+			t.Errorf("display RAM = %02x, want %02x", v, want)
+		}
+	}
+
+	// Right entry shifts the display left.
+	k.Out(Command, cmdMode|modeRightEntry)
+	k.Out(Data, 0x56)
+	if got := k.Display(); got[6] != 0 || got[7] != 0x56 || got[1] != 0x12 {
+		t.Errorf("right entry display = % x", got)
+	}
+
+	k.Out(Command, cmdClear|0x10|0x0c)
+	for i, v := range k.Display() {
+		if v != 0xff {
+			t.Errorf("display[%d] = %02x after clear, want ff", i, v)
+		}
+	}
+}
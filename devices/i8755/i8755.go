@@ -0,0 +1,109 @@
+// Package i8755 emulates the Intel 8755A EPROM and 8355 ROM with I/O ports.
+//
+// The chip holds 2K of program memory, reached with Read, and two 8-bit ports
+// whose pins are each programmed as an input or an output by a data direction
+// register. Programming the EPROM is not emulated.
+package i8755
+
+// Register offsets.
+const (
+	A    = 0
+	B    = 1
+	DDRA = 2 // Data direction register for port A; a set bit is an output.
+	DDRB = 3 // Data direction register for port B.
+)
+
+// ROMSize is the size of the ROM.
+const ROMSize = 0x800
+
+type (
+	// ROMIO is an 8755A or 8355.
+	ROMIO struct {
+		rom   [ROMSize]byte
+		latch [2]byte
+		ddr   [2]byte
+		in    [2]func() byte
+		out   [2]func(byte)
+	}
+
+	// Option is a functional option that modifies a field on the chip.
+	Option func(*ROMIO)
+)
+
+// WithInput sets the function which supplies the levels on the pins of the
+// given port, A or B, when it is read.
+func WithInput(port int, fn func() byte) Option {
+	return func(r *ROMIO) {
+		r.in[port] = fn
+	}
+}
+
+// WithOutput sets the function called with the levels on the output pins of
+// the given port whenever its latch or data direction register is written.
+// Pins programmed as inputs are passed as set, as if pulled up.
+func WithOutput(port int, fn func(v byte)) Option {
+	return func(r *ROMIO) {
+		r.out[port] = fn
+	}
+}
+
+// New returns a chip holding the given ROM, truncated or padded with 0FFH to
+// 2K, with both ports programmed as inputs.
+func New(rom []byte, opts ...Option) *ROMIO {
+	r := &ROMIO{}
+	for i := range r.rom {
+		r.rom[i] = 0xff
+	}
+	copy(r.rom[:], rom)
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Read returns the byte of ROM at the given address, which is taken modulo
+// 2K.
+func (r *ROMIO) Read(addr uint16) byte {
+	return r.rom[addr%ROMSize]
+}
+
+// Ports returns the number of I/O registers decoded by the chip.
+func (r *ROMIO) Ports() int {
+	return 4
+}
+
+// In reads a port or data direction register. Pins programmed as outputs
+// read back the latch.
+func (r *ROMIO) In(offset byte) byte {
+	switch offset {
+	case A, B:
+		in := byte(0xff)
+		if r.in[offset] != nil {
+			in = r.in[offset]()
+		}
+		return r.latch[offset]&r.ddr[offset] | in&^r.ddr[offset]
+	case DDRA, DDRB:
+		return r.ddr[offset-DDRA]
+	}
+
+	return 0xff
+}
+
+// Out writes a port latch or data direction register.
+func (r *ROMIO) Out(offset, v byte) {
+	port := offset & 1
+	switch offset {
+	case A, B:
+		r.latch[port] = v
+	case DDRA, DDRB:
+		r.ddr[port] = v
+	default:
+		return
+	}
+
+	if r.out[port] != nil {
+		r.out[port](r.latch[port] | ^r.ddr[port])
+	}
+}
@@ -0,0 +1,34 @@
+package i8755
+
+import "testing"
+
+func TestROMIO(t *testing.T) {
+	var outB []byte
+	r := New([]byte{0x3e, 0x01},
+		WithInput(A, func() byte { return 0xa5 }),
+		WithOutput(B, func(v byte) { outB = append(outB, v) }),
+	)
+
+	if v := r.Read(0x801); v != 0x01 {
+		t.Errorf("ROM mirror = %02x, want 01", v)
+	}
+	if v := r.Read(0x10); v != 0xff {
+		t.Errorf("unprogrammed ROM = %02x, want ff", v)
+	}
+
+	// The low nibble of port A is output.
+	r.Out(DDRA, 0x0f)
+	r.Out(A, 0x00)
+	if v := r.In(A); v != 0xa0 {
+		t.Errorf("port A = %02x, want a0", v)
+	}
+	if v := r.In(DDRA); v != 0x0f {
+		t.Errorf("DDR A = %02x, want 0f", v)
+	}
+
+	r.Out(DDRB, 0xff)
+	r.Out(B, 0x12)
+	if want := []byte{0x00, 0x12}; string(outB) != string(want) {
+		t.Errorf("port B output = % x, want % x", outB, want)
+	}
+}
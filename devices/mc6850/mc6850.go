@@ -0,0 +1,121 @@
+// Package mc6850 emulates the Motorola MC6850 asynchronous communications
+// interface adapter (ACIA).
+//
+// Characters are transferred as soon as they are written or arrive; baud
+// rate, word format and the modem control lines are accepted but not timed
+// or modelled, and /DCD and /CTS always read as asserted.
+package mc6850
+
+import (
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// Register offsets. Reading the status register and writing the control
+// register share an address, as do the receive and transmit data registers.
+const (
+	StatusControl = 0
+	Data          = 1
+)
+
+// Status register bits.
+const (
+	RDRF = 1 << iota // Receive data register full.
+	TDRE             // Transmit data register empty.
+	DCD              // Data carrier lost.
+	CTS              // Clear to send negated.
+	FE               // Framing error.
+	OVRN             // Receiver overrun.
+	PE               // Parity error.
+	IRQ              // Interrupt request.
+)
+
+// Control register fields.
+const (
+	masterReset = 0x03 // Counter divide select value which resets the ACIA.
+	divideMask  = 0x03
+	txIRQMask   = 0x60
+	txIRQEnable = 0x20
+	rxIRQEnable = 0x80
+)
+
+// ACIA is an MC6850 connected to a serial line.
+type ACIA struct {
+	line *serial.Line
+
+	control byte
+	rx      byte
+	rxFull  bool
+}
+
+// New returns an ACIA connected to the given line.
+func New(line *serial.Line) *ACIA {
+	return &ACIA{line: line}
+}
+
+// Ports returns the number of ports decoded by the ACIA.
+func (a *ACIA) Ports() int {
+	return 2
+}
+
+// In reads the status or receive data register.
+func (a *ACIA) In(offset byte) byte {
+	if offset&1 == Data {
+		a.rxFull = false
+		return a.rx
+	}
+
+	return a.Status()
+}
+
+// Out writes the control or transmit data register.
+func (a *ACIA) Out(offset, v byte) {
+	if offset&1 == Data {
+		a.line.Transmit(v)
+		return
+	}
+
+	a.control = v
+	if v&divideMask == masterReset {
+		a.rxFull = false
+	}
+}
+
+// Status returns the status register, first receiving a character from the
+// line if the receive register is empty.
+func (a *ACIA) Status() byte {
+	a.receive()
+
+	s := byte(TDRE)
+	if a.rxFull {
+		s |= RDRF
+	}
+	if a.irq() {
+		s |= IRQ
+	}
+
+	return s
+}
+
+// IRQ returns true if the ACIA is requesting an interrupt: a character has
+// been received with receive interrupts enabled, or transmit interrupts are
+// enabled. It is up to the machine to act on it.
+func (a *ACIA) IRQ() bool {
+	a.receive()
+
+	return a.irq()
+}
+
+// receive fills the receive register from the line if it is empty.
+func (a *ACIA) receive() {
+	if a.rxFull {
+		return
+	}
+	if b, ok := a.line.Receive(); ok {
+		a.rx, a.rxFull = b, true
+	}
+}
+
+// irq returns the interrupt request without polling the line.
+func (a *ACIA) irq() bool {
+	return a.rxFull && a.control&rxIRQEnable != 0 || a.control&txIRQMask == txIRQEnable
+}
@@ -0,0 +1,64 @@
+package mc6850
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// status polls the ACIA until its status has any of the given bits set.
+func status(t *testing.T, a *ACIA, bits byte) byte {
+	t.Helper()
+
+	for i := 0; i < 1000000; i++ {
+		if s := a.In(StatusControl); s&bits != 0 {
+			return s
+		}
+	}
+	t.Fatalf("status bits %02x never set", bits)
+
+	return 0
+}
+
+func TestACIA(t *testing.T) {
+	var out bytes.Buffer
+	line := serial.NewLine(strings.NewReader("AB"), &out)
+	a := New(line)
+
+	a.Out(StatusControl, masterReset)
+	a.Out(StatusControl, 0x15)
+	if s := status(t, a, RDRF); s != RDRF|TDRE {
+		t.Errorf("status = %02x, want %02x", s, RDRF|TDRE)
+	}
+	if a.IRQ() {
+		t.Error("IRQ with interrupts disabled")
+	}
+
+	// Enable receive interrupts.
+	a.Out(StatusControl, 0x95)
+	if s := a.In(StatusControl); s != RDRF|TDRE|IRQ {
+		t.Errorf("status = %02x, want %02x", s, RDRF|TDRE|IRQ)
+	}
+	if b := a.In(Data); b != 'A' {
+		t.Errorf("data = %q, want 'A'", b)
+	}
+	status(t, a, RDRF)
+	if b := a.In(Data); b != 'B' {
+		t.Errorf("data = %q, want 'B'", b)
+	}
+	if a.IRQ() {
+		t.Error("IRQ with receive register empty")
+	}
+
+	// Enable transmit interrupts.
+	a.Out(StatusControl, 0x35)
+	if !a.IRQ() {
+		t.Error("no IRQ with transmit interrupts enabled")
+	}
+	a.Out(Data, 'C')
+	if got := out.String(); got != "C" {
+		t.Errorf("output = %q, want %q", got, "C")
+	}
+}
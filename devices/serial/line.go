@@ -0,0 +1,116 @@
+// Package serial connects emulated serial interfaces to the host.
+package serial
+
+import (
+	"io"
+)
+
+// Line is the host end of a serial line: characters received by the emulated
+// interface come from an io.Reader and those it transmits go to an
+// io.Writer.
+//
+// Input is read by a separate goroutine so that the interface can poll for a
+// character without blocking, as programs do while waiting for a key.
+type Line struct {
+	in  chan byte
+	out io.Writer
+
+	// Set once the input has been exhausted.
+	eof bool
+}
+
+// NewLine returns a line receiving from r and transmitting to w. Either may
+// be nil, for a line with no input or whose output is discarded.
+func NewLine(r io.Reader, w io.Writer) *Line {
+	l := &Line{in: make(chan byte, 256), out: w}
+	if r == nil {
+		close(l.in)
+		return l
+	}
+
+	go func() {
+		defer close(l.in)
+
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			for _, b := range buf[:n] {
+				l.in <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return l
+}
+
+// Receive returns the next character of input if one has arrived.
+func (l *Line) Receive() (byte, bool) {
+	if l.eof {
+		return 0, false
+	}
+
+	select {
+	case b, ok := <-l.in:
+		if !ok {
+			l.eof = true
+		}
+		return b, ok
+	default:
+		return 0, false
+	}
+}
+
+// Read receives characters, waiting for at least one to arrive, so that a
+// line can be given to anything taking an io.Reader.
+func (l *Line) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if l.eof {
+		return 0, io.EOF
+	}
+
+	b, ok := <-l.in
+	if !ok {
+		l.eof = true
+		return 0, io.EOF
+	}
+	p[0] = b
+
+	n := 1
+	for ; n < len(p); n++ {
+		b, ok := l.Receive()
+		if !ok {
+			break
+		}
+		p[n] = b
+	}
+
+	return n, nil
+}
+
+// Write transmits characters, so that a line can be given to anything taking
+// an io.Writer.
+func (l *Line) Write(p []byte) (int, error) {
+	if l.out == nil {
+		return len(p), nil
+	}
+
+	return l.out.Write(p)
+}
+
+// Transmit sends a character to the output.
+func (l *Line) Transmit(b byte) {
+	if l.out != nil {
+		l.out.Write([]byte{b})
+	}
+}
+
+// Exhausted returns true once all the input has been received. This lets a
+// test harness stop a machine which would otherwise wait for input forever.
+func (l *Line) Exhausted() bool {
+	return l.eof
+}
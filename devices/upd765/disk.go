@@ -0,0 +1,528 @@
+package upd765
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// IMD track modes: the data rate and recording method.
+const (
+	Mode500FM  = 0
+	Mode300FM  = 1
+	Mode250FM  = 2
+	Mode500MFM = 3
+	Mode300MFM = 4
+	Mode250MFM = 5
+)
+
+// IMD sector data record types.
+const (
+	imdUnavailable = iota
+	imdNormal
+	imdCompressed
+	imdDeleted
+	imdDeletedCompressed
+	imdError
+	imdErrorCompressed
+	imdDeletedError
+	imdDeletedErrorCompressed
+)
+
+// IMD head byte flags, and the sector size code for a table of sizes.
+const (
+	imdCylinderMap = 0x80
+	imdHeadMap     = 0x40
+	imdSizeTable   = 0xff
+)
+
+// imdEOF ends the comment at the start of an IMD file.
+const imdEOF = 0x1a
+
+// maxSizeCode is the largest sector size code, for 8192 byte sectors.
+const maxSizeCode = 6
+
+// IBM3740 is the geometry of a single sided, single density 8" disk in the
+// IBM 3740 format, the standard CP/M distribution format.
+var IBM3740 = Geometry{
+	Cylinders:   77,
+	Heads:       1,
+	Sectors:     26,
+	SectorSize:  128,
+	FirstSector: 1,
+}
+
+type (
+	// Geometry describes the uniform layout of a raw disk image: every track
+	// has the same number of sectors of the same size, numbered from
+	// FirstSector, recorded in FM or MFM.
+	Geometry struct {
+		Cylinders, Heads int
+		Sectors          int
+		SectorSize       int
+		FirstSector      int
+		MFM              bool
+	}
+
+	// Sector is a sector on a track: its ID field and data. A sector whose
+	// data could not be read when it was imaged has nil Data and no data
+	// field.
+	Sector struct {
+		C, H, R, N byte
+		Data       []byte
+
+		// Deleted is set for data written with a deleted data address mark,
+		// and DataError for data with a CRC error.
+		Deleted   bool
+		DataError bool
+	}
+
+	// Track is the sectors on one side of a cylinder, in the order in which
+	// they pass the head, and the IMD mode it was recorded in.
+	Track struct {
+		Mode    byte
+		Sectors []Sector
+	}
+
+	// Disk is a floppy disk image, held in memory. Tracks which have never
+	// been formatted are nil.
+	Disk struct {
+		cylinders, heads int
+		tracks           []*Track
+
+		// WriteProtected disks cannot be written or formatted.
+		WriteProtected bool
+
+		// The file the disk was opened from and the function saving it back
+		// there, and whether it has been written since.
+		path     string
+		save     func(io.Writer) error
+		modified bool
+
+		// The comment at the start of an IMD image.
+		comment []byte
+	}
+)
+
+// MFM returns true if the track is recorded in MFM, or double density.
+func (t *Track) MFM() bool {
+	return t.Mode >= Mode500MFM
+}
+
+// NewDisk returns an unformatted disk with the given number of cylinders and
+// heads.
+func NewDisk(cylinders, heads int) *Disk {
+	return &Disk{
+		cylinders: cylinders,
+		heads:     heads,
+		tracks:    make([]*Track, cylinders*heads),
+	}
+}
+
+// NewRawDisk returns a disk with the given geometry holding the sectors read
+// from r, track by track, in order of cylinder, head and sector number.
+// Sectors past the end of a short image read as freshly formatted, filled
+// with E5H.
+func NewRawDisk(r io.Reader, g Geometry) (*Disk, error) {
+	n, ok := sizeCode(g.SectorSize)
+	if !ok {
+		return nil, fmt.Errorf("upd765: invalid sector size %d", g.SectorSize)
+	}
+	mode := byte(Mode500FM)
+	if g.MFM {
+		mode = Mode500MFM
+	}
+
+	d := NewDisk(g.Cylinders, g.Heads)
+	br := bufio.NewReader(r)
+	for c := 0; c < g.Cylinders; c++ {
+		for h := 0; h < g.Heads; h++ {
+			t := &Track{Mode: mode}
+			for s := 0; s < g.Sectors; s++ {
+				data := bytes.Repeat([]byte{0xe5}, g.SectorSize)
+				if _, err := io.ReadFull(br, data); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+					return nil, err
+				}
+				t.Sectors = append(t.Sectors, Sector{
+					C:    byte(c),
+					H:    byte(h),
+					R:    byte(g.FirstSector + s),
+					N:    n,
+					Data: data,
+				})
+			}
+			d.tracks[c*g.Heads+h] = t
+		}
+	}
+
+	return d, nil
+}
+
+// ReadIMD returns the disk held in an ImageDisk (IMD) image read from r.
+func ReadIMD(r io.Reader) (*Disk, error) {
+	br := bufio.NewReader(r)
+	comment, err := br.ReadBytes(imdEOF)
+	if err != nil || !bytes.HasPrefix(comment, []byte("IMD ")) {
+		return nil, errors.New("upd765: not an IMD image")
+	}
+
+	type location struct{ c, h int }
+	tracks := make(map[location]*Track)
+	var cylinders, heads int
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(br, hdr[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("upd765: IMD track header: %v", err)
+		}
+		mode, c, h, count, size := hdr[0], hdr[1], hdr[2], int(hdr[3]), hdr[4]
+		if mode > Mode250MFM || h&^(imdCylinderMap|imdHeadMap) > 1 {
+			return nil, fmt.Errorf("upd765: invalid IMD track header % x", hdr)
+		}
+
+		ids := make([][4]byte, count)
+		maps := []struct {
+			present bool
+			field   int
+		}{{true, 2}, {h&imdCylinderMap != 0, 0}, {h&imdHeadMap != 0, 1}}
+		for _, m := range maps {
+			if !m.present {
+				continue
+			}
+			for i := range ids {
+				b, err := br.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("upd765: IMD sector map: %v", err)
+				}
+				ids[i][m.field] = b
+			}
+		}
+		for i := range ids {
+			if h&imdCylinderMap == 0 {
+				ids[i][0] = c
+			}
+			if h&imdHeadMap == 0 {
+				ids[i][1] = h & 1
+			}
+			ids[i][3] = size
+		}
+		if size == imdSizeTable {
+			for i := range ids {
+				var length uint16
+				if err := binary.Read(br, binary.LittleEndian, &length); err != nil {
+					return nil, fmt.Errorf("upd765: IMD sector sizes: %v", err)
+				}
+				n, ok := sizeCode(int(length))
+				if !ok {
+					return nil, fmt.Errorf("upd765: invalid IMD sector size %d", length)
+				}
+				ids[i][3] = n
+			}
+		} else if size > maxSizeCode {
+			return nil, fmt.Errorf("upd765: invalid IMD sector size code %d", size)
+		}
+
+		t := &Track{Mode: mode}
+		for _, id := range ids {
+			s, err := readIMDSector(br, id)
+			if err != nil {
+				return nil, err
+			}
+			t.Sectors = append(t.Sectors, s)
+		}
+
+		loc := location{int(c), int(h & 1)}
+		tracks[loc] = t
+		if loc.c >= cylinders {
+			cylinders = loc.c + 1
+		}
+		if loc.h >= heads {
+			heads = loc.h + 1
+		}
+	}
+
+	d := NewDisk(cylinders, heads)
+	d.comment = comment[:len(comment)-1]
+	for loc, t := range tracks {
+		d.tracks[loc.c*heads+loc.h] = t
+	}
+
+	return d, nil
+}
+
+// readIMDSector reads the data record of the sector with the given ID.
+func readIMDSector(br *bufio.Reader, id [4]byte) (Sector, error) {
+	s := Sector{C: id[0], H: id[1], R: id[2], N: id[3]}
+
+	typ, err := br.ReadByte()
+	if err != nil {
+		return s, fmt.Errorf("upd765: IMD sector data: %v", err)
+	}
+	if typ > imdDeletedErrorCompressed {
+		return s, fmt.Errorf("upd765: invalid IMD sector data record type %d", typ)
+	}
+	if typ == imdUnavailable {
+		return s, nil
+	}
+
+	s.Data = make([]byte, 128<<s.N)
+	if typ%2 == 0 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return s, fmt.Errorf("upd765: IMD sector data: %v", err)
+		}
+		for i := range s.Data {
+			s.Data[i] = b
+		}
+	} else if _, err := io.ReadFull(br, s.Data); err != nil {
+		return s, fmt.Errorf("upd765: IMD sector data: %v", err)
+	}
+
+	switch typ {
+	case imdDeleted, imdDeletedCompressed:
+		s.Deleted = true
+	case imdError, imdErrorCompressed:
+		s.DataError = true
+	case imdDeletedError, imdDeletedErrorCompressed:
+		s.Deleted, s.DataError = true, true
+	}
+
+	return s, nil
+}
+
+// OpenRaw opens the raw disk image file at path, with the given geometry. A
+// file which cannot be opened for writing is opened write protected. Close
+// writes the disk back to the file if it has been changed.
+func OpenRaw(path string, g Geometry) (*Disk, error) {
+	return open(path, func(r io.Reader) (*Disk, error) {
+		return NewRawDisk(r, g)
+	}, (*Disk).WriteRaw)
+}
+
+// OpenIMD opens the IMD image file at path. A file which cannot be opened for
+// writing is opened write protected. Close writes the disk back to the file
+// if it has been changed.
+func OpenIMD(path string) (*Disk, error) {
+	return open(path, ReadIMD, (*Disk).WriteIMD)
+}
+
+// open opens an image file with the given reader and writer.
+func open(path string, read func(io.Reader) (*Disk, error), write func(*Disk, io.Writer) error) (*Disk, error) {
+	protected := false
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		protected = true
+	}
+	defer file.Close()
+
+	d, err := read(file)
+	if err != nil {
+		return nil, err
+	}
+	d.WriteProtected = protected
+	d.path = path
+	d.save = func(w io.Writer) error { return write(d, w) }
+
+	return d, nil
+}
+
+// Close writes a disk opened with OpenRaw or OpenIMD back to its file, if it
+// has been changed.
+func (d *Disk) Close() error {
+	if d.save == nil || !d.modified {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := d.save(&buf); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(d.path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	d.modified = false
+
+	return nil
+}
+
+// Cylinders returns the number of cylinders on the disk.
+func (d *Disk) Cylinders() int {
+	return d.cylinders
+}
+
+// Heads returns the number of sides of the disk.
+func (d *Disk) Heads() int {
+	return d.heads
+}
+
+// Track returns the track at the given cylinder and head, or nil if it has
+// never been formatted or is off the disk.
+func (d *Disk) Track(cylinder, head int) *Track {
+	if cylinder < 0 || cylinder >= d.cylinders || head < 0 || head >= d.heads {
+		return nil
+	}
+
+	return d.tracks[cylinder*d.heads+head]
+}
+
+// SetTrack replaces the track at the given cylinder and head, as formatting
+// it does. It is an error for the track to be off the disk.
+func (d *Disk) SetTrack(cylinder, head int, t *Track) error {
+	if cylinder < 0 || cylinder >= d.cylinders || head < 0 || head >= d.heads {
+		return fmt.Errorf("upd765: cylinder %d head %d is off the disk", cylinder, head)
+	}
+
+	d.tracks[cylinder*d.heads+head] = t
+	d.modified = true
+
+	return nil
+}
+
+// WriteRaw writes the disk as a raw image: the data of each track's sectors
+// in order of sector number, track by track. Unformatted tracks and missing
+// data are written as E5H, as long as the longest formatted track.
+func (d *Disk) WriteRaw(w io.Writer) error {
+	var size, sectors int
+	for _, t := range d.tracks {
+		if t == nil {
+			continue
+		}
+		if len(t.Sectors) > sectors {
+			sectors = len(t.Sectors)
+		}
+		for _, s := range t.Sectors {
+			if n := 128 << s.N; n > size {
+				size = n
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	blank := bytes.Repeat([]byte{0xe5}, size)
+	for _, t := range d.tracks {
+		var ss []Sector
+		if t != nil {
+			ss = append(ss, t.Sectors...)
+		}
+		sort.SliceStable(ss, func(i, j int) bool { return ss[i].R < ss[j].R })
+		for i := 0; i < sectors; i++ {
+			data := blank
+			if i < len(ss) && ss[i].Data != nil {
+				data = ss[i].Data
+			}
+			if _, err := bw.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteIMD writes the disk as an IMD image, compressing sectors filled with a
+// single value. Unformatted tracks are left out.
+func (d *Disk) WriteIMD(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	comment := d.comment
+	if comment == nil {
+		comment = []byte("IMD 1.18: " + time.Now().Format("02/01/2006 15:04:05") + "\r\n")
+	}
+	bw.Write(comment)
+	bw.WriteByte(imdEOF)
+
+	for i, t := range d.tracks {
+		if t == nil {
+			continue
+		}
+		c, h := i/d.heads, i%d.heads
+		writeIMDTrack(bw, t, byte(c), byte(h))
+	}
+
+	return bw.Flush()
+}
+
+// writeIMDTrack writes a track record of an IMD image.
+func writeIMDTrack(bw *bufio.Writer, t *Track, c, h byte) {
+	head := h
+	size := byte(0)
+	if len(t.Sectors) > 0 {
+		size = t.Sectors[0].N
+	}
+	for _, s := range t.Sectors {
+		if s.C != c {
+			head |= imdCylinderMap
+		}
+		if s.H != h {
+			head |= imdHeadMap
+		}
+		if s.N != size {
+			size = imdSizeTable
+		}
+	}
+
+	bw.Write([]byte{t.Mode, c, head, byte(len(t.Sectors)), size})
+	for _, s := range t.Sectors {
+		bw.WriteByte(s.R)
+	}
+	if head&imdCylinderMap != 0 {
+		for _, s := range t.Sectors {
+			bw.WriteByte(s.C)
+		}
+	}
+	if head&imdHeadMap != 0 {
+		for _, s := range t.Sectors {
+			bw.WriteByte(s.H)
+		}
+	}
+	if size == imdSizeTable {
+		for _, s := range t.Sectors {
+			binary.Write(bw, binary.LittleEndian, uint16(128)<<s.N)
+		}
+	}
+
+	for _, s := range t.Sectors {
+		if s.Data == nil {
+			bw.WriteByte(imdUnavailable)
+			continue
+		}
+
+		typ := byte(imdNormal)
+		switch {
+		case s.Deleted && s.DataError:
+			typ = imdDeletedError
+		case s.Deleted:
+			typ = imdDeleted
+		case s.DataError:
+			typ = imdError
+		}
+		if len(s.Data) > 0 && bytes.Count(s.Data, s.Data[:1]) == len(s.Data) {
+			bw.Write([]byte{typ + 1, s.Data[0]})
+			continue
+		}
+		bw.WriteByte(typ)
+		bw.Write(s.Data)
+	}
+}
+
+// sizeCode returns the size code N for a sector of the given size.
+func sizeCode(size int) (byte, bool) {
+	for n := byte(0); n <= maxSizeCode; n++ {
+		if 128<<n == size {
+			return n, true
+		}
+	}
+
+	return 0, false
+}
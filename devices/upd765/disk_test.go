@@ -0,0 +1,165 @@
+package upd765
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// small is the geometry of the test disks.
+var small = Geometry{
+	Cylinders:   2,
+	Heads:       2,
+	Sectors:     4,
+	SectorSize:  128,
+	FirstSector: 1,
+}
+
+// image returns a raw image of the given number of sectors, each filled with
+// its index.
+func image(sectors int) []byte {
+	var b []byte
+	for i := 0; i < sectors; i++ {
+		b = append(b, bytes.Repeat([]byte{byte(i)}, 128)...)
+	}
+
+	return b
+}
+
+func TestRaw(t *testing.T) {
+	// The last sector is missing from the image.
+	d, err := NewRawDisk(bytes.NewReader(image(15)), small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Cylinders() != 2 || d.Heads() != 2 {
+		t.Fatalf("%d cylinders and %d heads, want 2 and 2", d.Cylinders(), d.Heads())
+	}
+
+	tr := d.Track(1, 0)
+	if tr.MFM() || len(tr.Sectors) != 4 {
+		t.Fatalf("track 1/0: MFM %v with %d sectors", tr.MFM(), len(tr.Sectors))
+	}
+	if s := tr.Sectors[2]; s.C != 1 || s.H != 0 || s.R != 3 || s.N != 0 || s.Data[0] != 10 {
+		t.Errorf("sector 1/0/3: ID %d/%d/%d/%d with data %02x", s.C, s.H, s.R, s.N, s.Data[0])
+	}
+	if s := d.Track(1, 1).Sectors[3]; s.Data[0] != 0xe5 {
+		t.Errorf("missing sector reads %02x, want e5", s.Data[0])
+	}
+	if d.Track(2, 0) != nil || d.Track(0, 2) != nil {
+		t.Error("track off the disk")
+	}
+
+	var buf bytes.Buffer
+	if err := d.WriteRaw(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := append(image(15), bytes.Repeat([]byte{0xe5}, 128)...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Error("raw image not written back unchanged")
+	}
+
+	if _, err := NewRawDisk(bytes.NewReader(nil), Geometry{SectorSize: 100}); err == nil {
+		t.Error("no error for 100 byte sectors")
+	}
+}
+
+func TestIMD(t *testing.T) {
+	d := NewDisk(3, 2)
+	d.comment = []byte("IMD 1.18: test\r\n")
+	d.SetTrack(0, 0, &Track{Mode: Mode250FM, Sectors: []Sector{
+		{C: 0, H: 0, R: 1, N: 0, Data: image(1)},
+		{C: 0, H: 0, R: 3, N: 0, Data: bytes.Repeat([]byte{0xe5}, 128), Deleted: true},
+		{C: 0, H: 0, R: 2, N: 0, Data: bytes.Repeat([]byte{0x01}, 128), DataError: true},
+		{C: 0, H: 0, R: 4, N: 0},
+	}})
+
+	// Mixed sizes, and IDs which do not match the track.
+	d.SetTrack(2, 1, &Track{Mode: Mode250MFM, Sectors: []Sector{
+		{C: 7, H: 0, R: 1, N: 1, Data: image(2), Deleted: true, DataError: true},
+		{C: 2, H: 1, R: 2, N: 2, Data: image(4)},
+	}})
+
+	var buf bytes.Buffer
+	if err := d.WriteIMD(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The comment, the first track's header, sector numbering and four
+	// data records, three of them compressed, and the second track's
+	// header, sector numbering, cylinder and head maps, size table and data.
+	size := 17 + (5 + 4 + 3*2 + 1) + (5 + 3*2 + 2*2 + 1 + 256 + 1 + 512)
+	if buf.Len() != size {
+		t.Errorf("image is %d bytes, want %d", buf.Len(), size)
+	}
+
+	got, err := ReadIMD(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.modified = false
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("read back %+v, want %+v", got, d)
+	}
+
+	if _, err := ReadIMD(bytes.NewReader(image(1))); err == nil {
+		t.Error("no error for a raw image")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upd765")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.img")
+	if err := ioutil.WriteFile(path, image(16), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenRaw(path, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.WriteProtected {
+		t.Error("writable file opened write protected")
+	}
+
+	tr := d.Track(0, 1)
+	tr.Sectors[0].Data = bytes.Repeat([]byte{0xaa}, 128)
+	d.SetTrack(0, 1, tr)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image(16)
+	copy(want[4*128:], bytes.Repeat([]byte{0xaa}, 128))
+	if !bytes.Equal(b, want) {
+		t.Error("changed sector not written back")
+	}
+
+	// An IMD image written from the raw disk opens the same.
+	path = filepath.Join(dir, "disk.imd")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteIMD(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	imd, err := OpenIMD(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imd.tracks, d.tracks) {
+		t.Error("IMD image does not match the raw image")
+	}
+}
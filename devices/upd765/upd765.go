@@ -0,0 +1,766 @@
+// Package upd765 emulates the NEC uPD765 floppy disk controller, also made by
+// Intel as the 8272.
+//
+// The controller drives up to four drives. Disks are held in memory and
+// loaded from raw or ImageDisk (IMD) image files; see Disk. The CPU writes
+// each command's bytes to the data register, transfers the sector data in the
+// execution phase and reads the result bytes back, pacing itself by the main
+// status register.
+//
+// Sector data is transferred by DMA when a DMA channel is connected with
+// WithDMA and the Specify command selects DMA mode; each transfer then
+// completes as soon as its command is written. Otherwise the CPU transfers
+// each byte through the data register, with INT requested for each, and ends
+// the transfer by pulsing TC with TerminalCount. A transfer which is not
+// ended by TC runs to the end of the cylinder and ends abnormally.
+//
+// Seeks complete at once, and the rotation of the disk and the timing of
+// each byte are not emulated. The scan commands are not implemented and are
+// rejected as invalid.
+package upd765
+
+// Register offsets: the A0 input is wired to the low address bit.
+const (
+	MainStatus = 0
+	Data       = 1
+)
+
+// Main status register bits.
+const (
+	CB  = 0x10 // A command is in progress.
+	EXM = 0x20 // Execution phase of a non-DMA transfer.
+	DIO = 0x40 // The data register is to be read rather than written.
+	RQM = 0x80 // The data register is ready.
+)
+
+// Status register 0 bits.
+const (
+	st0Abnormal    = 0x40
+	st0Invalid     = 0x80
+	st0ReadyChange = 0xc0
+	st0SeekEnd     = 0x20
+	st0EquipCheck  = 0x10
+	st0NotReady    = 0x08
+)
+
+// Status register 1 bits.
+const (
+	st1EndOfCylinder = 0x80
+	st1DataError     = 0x20
+	st1NoData        = 0x04
+	st1NotWritable   = 0x02
+	st1MissingAM     = 0x01
+)
+
+// Status register 2 bits.
+const (
+	st2ControlMark = 0x40
+	st2DataError   = 0x20
+	st2WrongCyl    = 0x10
+	st2BadCyl      = 0x02
+	st2MissingDAM  = 0x01
+)
+
+// Status register 3 bits.
+const (
+	st3WriteProtect = 0x40
+	st3Ready        = 0x20
+	st3Track0       = 0x10
+	st3TwoSide      = 0x08
+)
+
+// Commands, in the low five bits of the first byte, and the flags above
+// them.
+const (
+	cmdReadTrack      = 0x02
+	cmdSpecify        = 0x03
+	cmdSenseDrive     = 0x04
+	cmdWriteData      = 0x05
+	cmdReadData       = 0x06
+	cmdRecalibrate    = 0x07
+	cmdSenseInterrupt = 0x08
+	cmdWriteDeleted   = 0x09
+	cmdReadID         = 0x0a
+	cmdReadDeleted    = 0x0c
+	cmdFormatTrack    = 0x0d
+	cmdSeek           = 0x0f
+	cmdMask           = 0x1f
+
+	flagMT  = 0x80
+	flagMFM = 0x40
+	flagSK  = 0x20
+)
+
+// Drive select byte fields, and the non-DMA bit of the Specify command.
+const (
+	selDrive  = 0x03
+	selHead   = 0x04
+	headShift = 2
+	specifyND = 0x01
+	drives    = 4
+)
+
+// commandLength is the number of bytes in each command, including the first.
+var commandLength = map[byte]int{
+	cmdReadTrack:      9,
+	cmdSpecify:        3,
+	cmdSenseDrive:     2,
+	cmdWriteData:      9,
+	cmdReadData:       9,
+	cmdRecalibrate:    2,
+	cmdSenseInterrupt: 1,
+	cmdWriteDeleted:   9,
+	cmdReadID:         2,
+	cmdReadDeleted:    9,
+	cmdFormatTrack:    6,
+	cmdSeek:           3,
+}
+
+// Command phases.
+const (
+	phaseCommand = iota
+	phaseExecution
+	phaseResult
+)
+
+type (
+	// DMA is a DMA channel serving the controller. Read returns the next
+	// byte to be written to the disk and Write stores a byte read from it;
+	// each returns tc set with the last byte of the transfer, when the DMA
+	// controller raises its terminal count.
+	DMA interface {
+		Read() (v byte, tc bool)
+		Write(v byte) (tc bool)
+	}
+
+	// FDC is a uPD765.
+	FDC struct {
+		drives [drives]drive
+		dma    DMA
+		nonDMA bool
+
+		phase  int
+		cmd    []byte
+		result []byte
+
+		// The transfer in progress: the command and its flags, the drive
+		// and head, the sector ID and the status so far.
+		op          byte
+		mt, mfm, sk bool
+		unit, head  int
+		c, h, r, n  byte
+		eot, dtl    byte
+		st0         byte
+		st1, st2    byte
+
+		// The sector being transferred and the buffer holding its data.
+		// done is set once the buffer has been transferred, and last if the
+		// transfer must end after it, at a deleted mark or data error. count
+		// is the number of sectors transferred by Read Track.
+		sector *Sector
+		buf    []byte
+		pos    int
+		done   bool
+		last   bool
+		count  int
+
+		// The INT output for the result phase, and the seek end or ready
+		// change statuses waiting for Sense Interrupt Status.
+		intr    bool
+		pending [drives]bool
+		status  [drives]byte
+	}
+
+	// Option is a functional option that modifies a field on the controller.
+	Option func(*FDC)
+
+	// drive is a disk drive.
+	drive struct {
+		disk *Disk
+
+		// The cylinder the head is over, and the position of the disk: the
+		// index on the track of the next sector to pass the head.
+		pcn   byte
+		index int
+	}
+)
+
+// WithDMA connects a DMA channel to the controller's DMA request and
+// acknowledge lines.
+func WithDMA(ch DMA) Option {
+	return func(f *FDC) {
+		f.dma = ch
+	}
+}
+
+// New returns a controller with no disks inserted, in DMA mode.
+func New(opts ...Option) *FDC {
+	f := &FDC{}
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+// Insert inserts a disk into the given drive, 0 to 3. A nil disk empties the
+// drive.
+func (f *FDC) Insert(unit int, d *Disk) {
+	f.drives[unit].disk = d
+	f.drives[unit].index = 0
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (f *FDC) Ports() int {
+	return 2
+}
+
+// In reads the main status register or the data register.
+func (f *FDC) In(offset byte) byte {
+	if offset&1 == MainStatus {
+		return f.Status()
+	}
+
+	switch f.phase {
+	case phaseResult:
+		f.intr = false
+		v := f.result[0]
+		if f.result = f.result[1:]; len(f.result) == 0 {
+			f.phase = phaseCommand
+		}
+		return v
+	case phaseExecution:
+		if f.reading() && !f.done {
+			v := f.buf[f.pos]
+			f.advance()
+			return v
+		}
+	}
+
+	return 0xff
+}
+
+// Out writes the data register. The main status register cannot be written.
+func (f *FDC) Out(offset, v byte) {
+	if offset&1 == MainStatus {
+		return
+	}
+
+	switch f.phase {
+	case phaseCommand:
+		f.cmd = append(f.cmd, v)
+		n, ok := commandLength[f.cmd[0]&cmdMask]
+		if !ok {
+			f.invalid()
+			return
+		}
+		if len(f.cmd) == n {
+			f.execute()
+		}
+	case phaseExecution:
+		if !f.reading() && !f.done {
+			f.buf[f.pos] = v
+			f.advance()
+		}
+	}
+}
+
+// Status returns the main status register. Between the sectors of a non-DMA
+// transfer not ended by TC, reading it moves on to the next sector.
+func (f *FDC) Status() byte {
+	switch f.phase {
+	case phaseExecution:
+		if f.done {
+			f.nextSector()
+			return f.Status()
+		}
+		s := byte(RQM | EXM | CB)
+		if f.reading() {
+			s |= DIO
+		}
+		return s
+	case phaseResult:
+		return RQM | DIO | CB
+	}
+
+	if len(f.cmd) > 0 {
+		return RQM | CB
+	}
+
+	return RQM
+}
+
+// TerminalCount pulses the TC input, ending the transfer in progress. Any
+// part of a sector not yet written is filled with zeros.
+func (f *FDC) TerminalCount() {
+	if f.phase != phaseExecution {
+		return
+	}
+
+	if f.op == cmdFormatTrack {
+		f.finish()
+		return
+	}
+	if !f.done && !f.reading() {
+		for ; f.pos < len(f.buf); f.pos++ {
+			f.buf[f.pos] = 0
+		}
+		f.commit()
+	}
+	f.nextID()
+	f.finish()
+}
+
+// Reset emulates the RESET input. The controller returns to waiting for a
+// command, and Sense Interrupt Status reports a ready change for each drive.
+func (f *FDC) Reset() {
+	drives := f.drives
+	*f = FDC{drives: drives, dma: f.dma}
+	for i := range f.pending {
+		f.pending[i] = true
+		f.status[i] = st0ReadyChange | byte(i)
+	}
+}
+
+// IRQ returns the level of the INT output, raised for the result phase of a
+// transfer, for each byte of a non-DMA transfer and when a seek ends or the
+// controller is reset, until the status is sensed.
+func (f *FDC) IRQ() bool {
+	if f.intr || f.phase == phaseExecution && !f.done {
+		return true
+	}
+	for _, p := range f.pending {
+		if p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// execute executes a command once all its bytes have been written.
+func (f *FDC) execute() {
+	cmd := f.cmd
+	f.cmd = nil
+	f.op = cmd[0] & cmdMask
+	f.mt, f.mfm, f.sk = cmd[0]&flagMT != 0, cmd[0]&flagMFM != 0, cmd[0]&flagSK != 0
+	if len(cmd) > 1 {
+		f.unit, f.head = int(cmd[1]&selDrive), int(cmd[1]&selHead>>headShift)
+	}
+
+	switch f.op {
+	case cmdSpecify:
+		f.nonDMA = cmd[2]&specifyND != 0
+	case cmdSenseDrive:
+		f.respond(f.st3())
+	case cmdRecalibrate:
+		f.seek(0)
+	case cmdSeek:
+		f.seek(cmd[2])
+	case cmdSenseInterrupt:
+		f.senseInterrupt()
+	case cmdReadID:
+		f.readID()
+	case cmdFormatTrack:
+		f.formatTrack(cmd)
+	default:
+		f.c, f.h, f.r, f.n = cmd[2], cmd[3], cmd[4], cmd[5]
+		f.eot, f.dtl = cmd[6], cmd[8]
+		f.transfer()
+	}
+}
+
+// invalid rejects an invalid command.
+func (f *FDC) invalid() {
+	f.cmd = nil
+	f.respond(st0Invalid)
+}
+
+// respond enters the result phase with the given result bytes.
+func (f *FDC) respond(result ...byte) {
+	f.phase, f.result = phaseResult, result
+}
+
+// seek moves the head of the selected drive to a cylinder, leaving the seek
+// end status to be sensed.
+func (f *FDC) seek(cylinder byte) {
+	d := &f.drives[f.unit]
+	d.pcn, d.index = cylinder, 0
+
+	st0 := st0SeekEnd | f.select0()
+	if d.disk == nil {
+		st0 |= st0Abnormal | st0NotReady
+	}
+	f.pending[f.unit], f.status[f.unit] = true, st0
+}
+
+// senseInterrupt reports the seek end or ready change status of a drive, or
+// rejects the command if there is none.
+func (f *FDC) senseInterrupt() {
+	for i, p := range f.pending {
+		if p {
+			f.pending[i] = false
+			f.respond(f.status[i], f.drives[i].pcn)
+			return
+		}
+	}
+
+	f.respond(st0Invalid)
+}
+
+// readID reports the ID of the next sector to pass the head.
+func (f *FDC) readID() {
+	f.st0, f.st1, f.st2 = f.select0(), 0, 0
+	d := &f.drives[f.unit]
+	t, ok := f.track()
+	if !ok {
+		f.finish()
+		return
+	}
+
+	s := &t.Sectors[d.index%len(t.Sectors)]
+	d.index = (d.index + 1) % len(t.Sectors)
+	f.c, f.h, f.r, f.n = s.C, s.H, s.R, s.N
+	f.finish()
+}
+
+// formatTrack starts formatting a track, taking the ID of each sector in the
+// execution phase. A track of no sectors, or of sectors larger than the
+// controller can record, is rejected as invalid.
+func (f *FDC) formatTrack(cmd []byte) {
+	f.st0, f.st1, f.st2 = f.select0(), 0, 0
+	f.n = cmd[2]
+	if f.n > maxSizeCode || cmd[3] == 0 {
+		f.respond(st0Invalid)
+		return
+	}
+	if !f.writable() {
+		f.finish()
+		return
+	}
+
+	f.buf, f.pos, f.done = make([]byte, 4*int(cmd[3])), 0, false
+	f.dtl = cmd[5]
+	f.run()
+}
+
+// transfer starts a Read Data, Read Deleted Data, Write Data, Write Deleted
+// Data or Read Track command. Sectors larger than the controller can record,
+// and a data length of zero for 128 byte sectors, are rejected as invalid.
+func (f *FDC) transfer() {
+	f.st0, f.st1, f.st2 = f.select0(), 0, 0
+	f.count, f.last = 0, false
+	if f.n > maxSizeCode || f.n == 0 && f.dtl == 0 {
+		f.respond(st0Invalid)
+		return
+	}
+	if !f.reading() && !f.writable() {
+		f.finish()
+		return
+	}
+	if !f.load() {
+		f.finish()
+		return
+	}
+	f.run()
+}
+
+// run runs the execution phase: to completion by DMA, or else a byte at a
+// time through the data register.
+func (f *FDC) run() {
+	f.phase = phaseExecution
+	if f.dma == nil || f.nonDMA {
+		return
+	}
+
+	for f.phase == phaseExecution {
+		var tc bool
+		if f.reading() {
+			tc = f.dma.Write(f.buf[f.pos])
+		} else {
+			f.buf[f.pos], tc = f.dma.Read()
+		}
+		f.advance()
+		switch {
+		case f.phase != phaseExecution:
+		case tc:
+			f.TerminalCount()
+		case f.done:
+			f.nextSector()
+		}
+	}
+}
+
+// advance moves on to the next byte of the sector being transferred, writing
+// a completed sector to the disk.
+func (f *FDC) advance() {
+	if f.pos++; f.pos < len(f.buf) {
+		return
+	}
+
+	f.done = true
+	if f.op == cmdFormatTrack {
+		f.format()
+		return
+	}
+	if !f.reading() {
+		f.commit()
+	}
+}
+
+// nextSector moves the transfer on to the next sector once the last has been
+// transferred, or ends it.
+func (f *FDC) nextSector() {
+	if f.last {
+		f.finish()
+		return
+	}
+	if f.nextID() {
+		f.st1 |= st1EndOfCylinder
+		f.finish()
+		return
+	}
+	if !f.load() {
+		f.finish()
+	}
+}
+
+// nextID moves the sector ID on to the next sector, returning true at the end
+// of the cylinder: after sector EOT, or after it on the second side in
+// multi-track mode.
+func (f *FDC) nextID() bool {
+	if f.r != f.eot {
+		f.r++
+		return false
+	}
+
+	f.r = 1
+	if f.mt && f.h&1 == 0 {
+		f.h ^= 1
+		f.head ^= 1
+		return false
+	}
+	if f.mt {
+		f.h ^= 1
+	}
+	f.c++
+
+	return true
+}
+
+// load finds the sector for the current ID and fills the buffer from it, or
+// readies the buffer for writing it. It returns false if the transfer must
+// end, with the status set.
+func (f *FDC) load() bool {
+	for {
+		t, ok := f.track()
+		if !ok {
+			return false
+		}
+
+		s := f.find(t)
+		if s == nil {
+			return false
+		}
+		f.sector, f.pos, f.done = s, 0, false
+
+		size := 128 << f.n
+		if f.n == 0 && f.dtl < 128 {
+			size = int(f.dtl)
+		}
+		f.buf = make([]byte, size)
+		if !f.reading() {
+			return true
+		}
+
+		if s.Data == nil {
+			f.st1 |= st1MissingAM
+			f.st2 |= st2MissingDAM
+			return false
+		}
+
+		// Read Data skips or stops at deleted data, and Read Deleted
+		// Data at normal data.
+		if f.op != cmdReadTrack && s.Deleted != (f.op == cmdReadDeleted) {
+			if f.sk {
+				if f.nextID() {
+					f.st1 |= st1EndOfCylinder
+					return false
+				}
+				continue
+			}
+			f.st2 |= st2ControlMark
+			f.last = true
+		}
+
+		copy(f.buf, s.Data)
+		if s.DataError {
+			f.st1 |= st1DataError
+			f.st2 |= st2DataError
+			f.last = true
+		}
+		return true
+	}
+}
+
+// track returns the track under the head of the selected drive, setting the
+// status if there is none in the command's recording mode.
+func (f *FDC) track() (*Track, bool) {
+	d := &f.drives[f.unit]
+	if d.disk == nil {
+		f.st0 |= st0NotReady
+		return nil, false
+	}
+
+	t := d.disk.Track(int(d.pcn), f.head)
+	if t == nil || len(t.Sectors) == 0 || t.MFM() != f.mfm {
+		f.st1 |= st1MissingAM
+		return nil, false
+	}
+
+	return t, true
+}
+
+// find returns the sector on the track matching the current ID, setting the
+// status if there is none. Read Track takes the sectors in the order they
+// pass the head, whatever their IDs.
+func (f *FDC) find(t *Track) *Sector {
+	if f.op == cmdReadTrack {
+		if f.count == len(t.Sectors) {
+			f.st1 |= st1NoData
+			return nil
+		}
+		s := &t.Sectors[f.count]
+		f.count++
+		if s.C != f.c || s.H != f.h || s.R != f.r || s.N != f.n {
+			f.st1 |= st1NoData
+		}
+		return s
+	}
+
+	d := &f.drives[f.unit]
+	for i := range t.Sectors {
+		s := &t.Sectors[(d.index+i)%len(t.Sectors)]
+		if s.R != f.r || s.H != f.h || s.N != f.n {
+			continue
+		}
+		if s.C != f.c {
+			f.st2 |= st2WrongCyl
+			if s.C == 0xff {
+				f.st2 |= st2BadCyl
+			}
+			continue
+		}
+		d.index = (d.index + i + 1) % len(t.Sectors)
+		f.st2 &^= st2WrongCyl | st2BadCyl
+		return s
+	}
+
+	f.st1 |= st1NoData
+	return nil
+}
+
+// commit writes the buffer to the sector being written.
+func (f *FDC) commit() {
+	data := make([]byte, 128<<f.n)
+	copy(data, f.buf)
+	f.sector.Data = data
+	f.sector.Deleted = f.op == cmdWriteDeleted
+	f.sector.DataError = false
+	f.drives[f.unit].disk.modified = true
+}
+
+// format builds the formatted track from the sector IDs written.
+func (f *FDC) format() {
+	d := &f.drives[f.unit]
+	mode := byte(Mode500FM)
+	if old := d.disk.Track(int(d.pcn), f.head); old != nil {
+		mode = old.Mode % Mode500MFM
+	}
+	if f.mfm {
+		mode += Mode500MFM
+	}
+
+	t := &Track{Mode: mode}
+	for i := 0; i < len(f.buf); i += 4 {
+		data := make([]byte, 128<<f.n)
+		for j := range data {
+			data[j] = f.dtl
+		}
+		t.Sectors = append(t.Sectors, Sector{
+			C:    f.buf[i],
+			H:    f.buf[i+1],
+			R:    f.buf[i+2],
+			N:    f.buf[i+3],
+			Data: data,
+		})
+		f.c, f.h, f.r = f.buf[i], f.buf[i+1], f.buf[i+2]
+	}
+	if err := d.disk.SetTrack(int(d.pcn), f.head, t); err != nil {
+		f.st0 |= st0EquipCheck
+	}
+	d.index = 0
+	f.finish()
+}
+
+// finish ends the command, entering the result phase with the status and the
+// sector ID, and raises INT.
+func (f *FDC) finish() {
+	if f.st0&st0NotReady != 0 || f.st1 != 0 || f.st2&^st2ControlMark != 0 || f.st0&st0EquipCheck != 0 {
+		f.st0 |= st0Abnormal
+	}
+	f.buf, f.sector = nil, nil
+	f.intr = true
+	f.respond(f.st0, f.st1, f.st2, f.c, f.h, f.r, f.n)
+}
+
+// writable returns true if the selected drive's disk can be written, setting
+// the status if not.
+func (f *FDC) writable() bool {
+	d := f.drives[f.unit].disk
+	switch {
+	case d == nil:
+		f.st0 |= st0NotReady
+		return false
+	case d.WriteProtected:
+		f.st1 |= st1NotWritable
+		return false
+	}
+
+	return true
+}
+
+// reading returns true if the command transfers data from the disk.
+func (f *FDC) reading() bool {
+	switch f.op {
+	case cmdReadData, cmdReadDeleted, cmdReadTrack:
+		return true
+	}
+
+	return false
+}
+
+// select0 returns the drive and head bits of status register 0.
+func (f *FDC) select0() byte {
+	return byte(f.unit) | byte(f.head)<<headShift
+}
+
+// st3 returns status register 3 for the selected drive.
+func (f *FDC) st3() byte {
+	d := &f.drives[f.unit]
+	s := f.select0()
+	if d.pcn == 0 {
+		s |= st3Track0
+	}
+	if d.disk != nil {
+		s |= st3Ready
+		if d.disk.WriteProtected {
+			s |= st3WriteProtect
+		}
+		if d.disk.Heads() > 1 {
+			s |= st3TwoSide
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,344 @@
+package upd765
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danmrichards/go8080/devices/i8257"
+)
+
+// ram is a flat 64K memory.
+type ram []byte
+
+func (r ram) Read(addr uint16) byte     { return r[addr] }
+func (r ram) ReadAll() []byte           { return r }
+func (r ram) Write(addr uint16, v byte) { r[addr] = v }
+
+// command writes a command's bytes, checking the controller is ready for
+// each.
+func command(t *testing.T, f *FDC, cmd ...byte) {
+	t.Helper()
+
+	for _, v := range cmd {
+		if s := f.Status(); s&(RQM|DIO) != RQM {
+			t.Fatalf("status %02x writing command %02x", s, cmd)
+		}
+		f.Out(Data, v)
+	}
+}
+
+// result reads the bytes of the result phase.
+func result(t *testing.T, f *FDC) []byte {
+	t.Helper()
+
+	var r []byte
+	for f.Status()&(RQM|DIO) == RQM|DIO {
+		r = append(r, f.In(Data))
+	}
+	if s := f.Status(); s != RQM {
+		t.Fatalf("status %02x after the result phase", s)
+	}
+
+	return r
+}
+
+// expect reads the result phase and checks it.
+func expect(t *testing.T, f *FDC, want ...byte) {
+	t.Helper()
+
+	if r := result(t, f); !bytes.Equal(r, want) {
+		t.Errorf("result % x, want % x", r, want)
+	}
+}
+
+// controller returns a non-DMA controller with a small disk in drive 0.
+func controller(t *testing.T, opts ...Option) (*FDC, *Disk) {
+	t.Helper()
+
+	d, err := NewRawDisk(bytes.NewReader(image(16)), small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(opts...)
+	f.Insert(0, d)
+	command(t, f, cmdSpecify, 0xdf, 0x03)
+
+	return f, d
+}
+
+// read reads the data of a non-DMA transfer, byte by byte.
+func read(t *testing.T, f *FDC, n int) []byte {
+	t.Helper()
+
+	var b []byte
+	for i := 0; i < n; i++ {
+		if s := f.Status(); s != RQM|DIO|EXM|CB {
+			t.Fatalf("status %02x reading byte %d", s, i)
+		}
+		if !f.IRQ() {
+			t.Fatalf("no interrupt for byte %d", i)
+		}
+		b = append(b, f.In(Data))
+	}
+
+	return b
+}
+
+func TestSeek(t *testing.T) {
+	f, _ := controller(t)
+
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0Invalid)
+
+	command(t, f, cmdSeek, 0x00, 5)
+	if !f.IRQ() {
+		t.Error("no interrupt at the end of the seek")
+	}
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0SeekEnd, 5)
+	if f.IRQ() {
+		t.Error("interrupt after sensing it")
+	}
+
+	command(t, f, cmdSenseDrive, 0x04)
+	expect(t, f, st3Ready|st3TwoSide|0x04)
+	command(t, f, cmdRecalibrate, 0x00)
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0SeekEnd, 0)
+	command(t, f, cmdSenseDrive, 0x00)
+	expect(t, f, st3Ready|st3TwoSide|st3Track0)
+
+	// Drive 1 is empty.
+	command(t, f, cmdRecalibrate, 0x01)
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0Abnormal|st0SeekEnd|st0NotReady|1, 0)
+
+	f.Reset()
+	for i := byte(0); i < 4; i++ {
+		command(t, f, cmdSenseInterrupt)
+		expect(t, f, st0ReadyChange|i, 0)
+	}
+
+	command(t, f, 0x1f)
+	expect(t, f, st0Invalid)
+}
+
+func TestRead(t *testing.T) {
+	f, _ := controller(t)
+
+	// Sectors 2 and 3 of cylinder 0 head 1, ended by TC.
+	command(t, f, cmdReadData, 0x04, 0, 1, 2, 0, 4, 0x07, 0xff)
+	got := read(t, f, 256)
+	if !bytes.Equal(got, image(8)[5*128:7*128]) {
+		t.Error("wrong data read")
+	}
+	f.TerminalCount()
+	if !f.IRQ() {
+		t.Error("no interrupt for the result phase")
+	}
+	expect(t, f, 0x04, 0, 0, 0, 1, 4, 0)
+
+	// Multi-track from sector 4 of head 0 runs on to head 1, and without TC
+	// to the end of the cylinder.
+	command(t, f, flagMT|cmdReadData, 0x00, 0, 0, 4, 0, 4, 0x07, 0xff)
+	got = read(t, f, 5*128)
+	if !bytes.Equal(got, image(8)[3*128:]) {
+		t.Error("wrong data read across heads")
+	}
+	if s := f.Status(); s&DIO == 0 || s&EXM != 0 {
+		t.Fatalf("status %02x after the end of the cylinder", s)
+	}
+	expect(t, f, st0Abnormal, st1EndOfCylinder, 0, 1, 0, 1, 0)
+
+	// Cylinder 1 until the head has been moved there.
+	command(t, f, cmdReadData, 0x00, 1, 0, 1, 0, 4, 0x07, 0xff)
+	expect(t, f, st0Abnormal, st1NoData, st2WrongCyl, 1, 0, 1, 0)
+
+	// A sector which is not on the track, and an empty drive.
+	command(t, f, cmdReadData, 0x00, 0, 0, 9, 0, 9, 0x07, 0xff)
+	expect(t, f, st0Abnormal, st1NoData, 0, 0, 0, 9, 0)
+	command(t, f, cmdReadData, 0x02, 0, 0, 1, 0, 4, 0x07, 0xff)
+	expect(t, f, st0Abnormal|st0NotReady|2, 0, 0, 0, 0, 1, 0)
+
+	// Reading MFM from an FM track.
+	command(t, f, flagMFM|cmdReadData, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	expect(t, f, st0Abnormal, st1MissingAM, 0, 0, 0, 1, 0)
+
+	// Short sectors set by DTL.
+	command(t, f, cmdReadData, 0x00, 0, 0, 1, 0, 4, 0x07, 0x10)
+	read(t, f, 0x10)
+	f.TerminalCount()
+	expect(t, f, 0, 0, 0, 0, 0, 2, 0)
+}
+
+func TestWrite(t *testing.T) {
+	f, d := controller(t)
+
+	command(t, f, cmdWriteData, 0x00, 0, 0, 2, 0, 4, 0x07, 0xff)
+	for i := 0; i < 128+64; i++ {
+		if s := f.Status(); s != RQM|EXM|CB {
+			t.Fatalf("status %02x writing byte %d", s, i)
+		}
+		f.Out(Data, 0xaa)
+	}
+	f.TerminalCount()
+	expect(t, f, 0, 0, 0, 0, 0, 4, 0)
+
+	tr := d.Track(0, 0)
+	want := append(bytes.Repeat([]byte{0xaa}, 64), make([]byte, 64)...)
+	if !bytes.Equal(tr.Sectors[1].Data, bytes.Repeat([]byte{0xaa}, 128)) || !bytes.Equal(tr.Sectors[2].Data, want) {
+		t.Error("wrong data written")
+	}
+	if !d.modified {
+		t.Error("disk not marked modified")
+	}
+
+	// Deleted data is read with Read Deleted Data, skipped or ending Read
+	// Data with the control mark set.
+	command(t, f, cmdWriteDeleted, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	for i := 0; i < 128; i++ {
+		f.Out(Data, 0x55)
+	}
+	f.TerminalCount()
+	expect(t, f, 0, 0, 0, 0, 0, 2, 0)
+
+	command(t, f, cmdReadDeleted, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	if b := read(t, f, 128); b[0] != 0x55 {
+		t.Errorf("deleted data read %02x, want 55", b[0])
+	}
+	f.TerminalCount()
+	expect(t, f, 0, 0, 0, 0, 0, 2, 0)
+
+	command(t, f, cmdReadData, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	read(t, f, 128)
+	expect(t, f, 0, 0, st2ControlMark, 0, 0, 1, 0)
+
+	command(t, f, flagSK|cmdReadData, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	if b := read(t, f, 128); b[0] != 0xaa {
+		t.Errorf("skipping deleted data read %02x, want aa", b[0])
+	}
+	f.TerminalCount()
+	expect(t, f, 0, 0, 0, 0, 0, 3, 0)
+
+	d.WriteProtected = true
+	command(t, f, cmdWriteData, 0x00, 0, 0, 1, 0, 4, 0x07, 0xff)
+	expect(t, f, st0Abnormal, st1NotWritable, 0, 0, 0, 1, 0)
+	command(t, f, cmdSenseDrive, 0x00)
+	expect(t, f, st3WriteProtect|st3Ready|st3TwoSide|st3Track0)
+}
+
+func TestFormat(t *testing.T) {
+	f, d := controller(t)
+
+	command(t, f, cmdSeek, 0x00, 1)
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0SeekEnd, 1)
+
+	// Three 256 byte sectors in MFM, interleaved.
+	command(t, f, flagMFM|cmdFormatTrack, 0x04, 1, 3, 0x36, 0x4e)
+	for _, r := range []byte{1, 3, 2} {
+		for _, v := range []byte{1, 1, r, 1} {
+			if s := f.Status(); s != RQM|EXM|CB {
+				t.Fatalf("status %02x formatting", s)
+			}
+			f.Out(Data, v)
+		}
+	}
+	expect(t, f, 0x04, 0, 0, 1, 1, 2, 1)
+
+	tr := d.Track(1, 1)
+	if !tr.MFM() || len(tr.Sectors) != 3 || tr.Sectors[1].R != 3 {
+		t.Fatalf("formatted track %+v", tr)
+	}
+	if !bytes.Equal(tr.Sectors[2].Data, bytes.Repeat([]byte{0x4e}, 256)) {
+		t.Error("sectors not filled")
+	}
+
+	// Read ID returns each sector in turn as the disk turns.
+	for _, r := range []byte{1, 3, 2, 1} {
+		command(t, f, flagMFM|cmdReadID, 0x04)
+		expect(t, f, 0x04, 0, 0, 1, 1, r, 1)
+	}
+	command(t, f, cmdReadID, 0x04)
+	expect(t, f, st0Abnormal|0x04, st1MissingAM, 0, 1, 1, 1, 1)
+
+	// Read Track reads the sectors in the order they pass the head.
+	command(t, f, flagMFM|cmdReadTrack, 0x04, 1, 1, 1, 1, 3, 0x0e, 0xff)
+	read(t, f, 3*256)
+	expect(t, f, st0Abnormal|0x04, st1EndOfCylinder|st1NoData, 0, 2, 1, 1, 1)
+
+	d.WriteProtected = true
+	command(t, f, flagMFM|cmdFormatTrack, 0x04, 1, 3, 0x36, 0x4e)
+	expect(t, f, st0Abnormal|0x04, st1NotWritable, 0, 2, 1, 1, 1)
+}
+
+func TestDMA(t *testing.T) {
+	mem := make(ram, 0x10000)
+	dma := i8257.New()
+	f, d := controller(t, WithDMA(dma.Channel(1, mem)))
+
+	// Select DMA mode.
+	command(t, f, cmdSpecify, 0xdf, 0x02)
+
+	// Channel 1 writes 384 bytes to memory at 1000H.
+	program := func(typ, mode byte) {
+		dma.Out(2, 0x00)
+		dma.Out(2, 0x10)
+		dma.Out(3, 0x7f)
+		dma.Out(3, typ<<6|0x01)
+		dma.Out(i8257.Mode, mode)
+	}
+	program(i8257.WriteMem, 0x42)
+	command(t, f, cmdReadData, 0x00, 0, 0, 2, 0, 4, 0x07, 0xff)
+	if !f.IRQ() {
+		t.Error("no interrupt at the end of the transfer")
+	}
+	expect(t, f, 0, 0, 0, 1, 0, 1, 0)
+	if !bytes.Equal(mem[0x1000:0x1180], image(4)[128:]) {
+		t.Error("wrong data transferred to memory")
+	}
+
+	// And reads it back to cylinder 1.
+	command(t, f, cmdSeek, 0x00, 1)
+	command(t, f, cmdSenseInterrupt)
+	expect(t, f, st0SeekEnd, 1)
+	program(i8257.ReadMem, 0x42)
+	command(t, f, cmdWriteData, 0x00, 1, 0, 1, 0, 4, 0x07, 0xff)
+	expect(t, f, 0, 0, 0, 1, 0, 4, 0)
+	for i, r := range []int{1, 2, 3} {
+		if !bytes.Equal(d.Track(1, 0).Sectors[i].Data, image(4)[r*128:(r+1)*128]) {
+			t.Errorf("wrong data in sector %d", i+1)
+		}
+	}
+
+	// With the channel disabled the transfer ends at once.
+	command(t, f, cmdReadData, 0x00, 1, 0, 4, 0, 4, 0x07, 0xff)
+	expect(t, f, 0, 0, 0, 2, 0, 1, 0)
+}
+
+func TestInvalidSizes(t *testing.T) {
+	mem := make(ram, 0x10000)
+	dma := i8257.New()
+	for _, mode := range []byte{0x02, 0x03} {
+		f, _ := controller(t, WithDMA(dma.Channel(1, mem)))
+		command(t, f, cmdSpecify, 0xdf, mode)
+		dma.Out(i8257.Mode, 0x02)
+
+		// Formatting no sectors, or sectors too large to record.
+		command(t, f, cmdFormatTrack, 0x00, 0, 0, 0x1b, 0xe5)
+		expect(t, f, st0Invalid)
+		command(t, f, cmdFormatTrack, 0x00, 7, 1, 0x1b, 0xe5)
+		expect(t, f, st0Invalid)
+
+		// Read Track takes sectors whatever their size, so the size in
+		// the command is checked first.
+		for _, n := range []byte{0x07, 0x19, 0x39} {
+			command(t, f, cmdReadTrack, 0x00, 0, 0, 1, n, 4, 0x07, 0xff)
+			expect(t, f, st0Invalid)
+			command(t, f, cmdWriteData, 0x00, 0, 0, 1, n, 4, 0x07, 0xff)
+			expect(t, f, st0Invalid)
+		}
+		command(t, f, cmdReadData, 0x00, 0, 0, 1, 0, 4, 0x07, 0x00)
+		expect(t, f, st0Invalid)
+	}
+}
@@ -0,0 +1,413 @@
+// Package disasm implements a flow-following disassembler for Intel 8080
+// machine code.
+//
+// Unlike a linear sweep, which decodes every byte of a ROM as if it were an
+// instruction, the disassembler starts at a set of entry points and follows
+// the control flow of the program. Bytes reached this way are treated as code
+// and everything else is treated as data, which keeps lookup tables and text
+// from being mangled into nonsense instructions.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/danmrichards/disassemble8080/pkg/dasm"
+)
+
+type (
+	// Disassembler separates the code and data in a ROM image and renders it
+	// as a re-assemblable source listing.
+	Disassembler struct {
+		// The ROM image being disassembled.
+		rom []byte
+
+		// Address at which the first byte of the ROM is loaded.
+		org uint16
+
+		// Addresses at which tracing starts.
+		entries []uint16
+
+		// If set to true the reset and RST vectors are used as entry points.
+		vectors bool
+
+		// Length of the instruction starting at each offset in the ROM, zero
+		// if the offset is not the start of an instruction.
+		ins []int
+
+		// Set for every offset in the ROM which belongs to an instruction.
+		code []bool
+
+		// Addresses referenced by instructions, keyed by address.
+		refs map[uint16]bool
+	}
+
+	// Option is a functional option that modifies a field on the disassembler.
+	Option func(*Disassembler)
+)
+
+// WithOrigin sets the address at which the first byte of the ROM is loaded.
+func WithOrigin(addr uint16) Option {
+	return func(d *Disassembler) {
+		d.org = addr
+	}
+}
+
+// WithEntryPoints adds the given addresses to the points from which the flow
+// of the program is followed.
+//
+// This is typically needed for code which is only reachable through jump
+// tables or PCHL, which cannot be followed statically.
+func WithEntryPoints(addrs ...uint16) Option {
+	return func(d *Disassembler) {
+		d.entries = append(d.entries, addrs...)
+	}
+}
+
+// WithoutVectors prevents the reset and RST vectors being used as entry
+// points.
+func WithoutVectors() Option {
+	return func(d *Disassembler) {
+		d.vectors = false
+	}
+}
+
+// New returns a disassembler for the given ROM image, with the flow of the
+// program already traced from the configured entry points.
+func New(rom []byte, opts ...Option) *Disassembler {
+	d := &Disassembler{
+		rom:     rom,
+		vectors: true,
+		ins:     make([]int, len(rom)),
+		code:    make([]bool, len(rom)),
+		refs:    make(map[uint16]bool),
+	}
+
+	for _, o := range opts {
+		o(d)
+	}
+
+	entries := d.entries
+	if d.vectors {
+		// The reset vector and the eight restart vectors.
+		for v := 0; v < 8; v++ {
+			entries = append(entries, uint16(v*8))
+		}
+	}
+	for _, e := range entries {
+		if d.contains(e) {
+			d.refs[e] = true
+			d.trace(e)
+		}
+	}
+
+	return d
+}
+
+// IsCode returns true if the byte at the given address was reached by
+// following the flow of the program.
+func (d *Disassembler) IsCode(addr uint16) bool {
+	if !d.contains(addr) {
+		return false
+	}
+	return d.code[addr-d.org]
+}
+
+// Labels returns the generated label for each address which is referenced by
+// the program, keyed by address.
+func (d *Disassembler) Labels() map[uint16]string {
+	labels := make(map[uint16]string, len(d.refs))
+	for addr := range d.refs {
+		if l, ok := d.label(addr); ok {
+			labels[addr] = l
+		}
+	}
+
+	return labels
+}
+
+// contains returns true if the given address lies within the ROM.
+func (d *Disassembler) contains(addr uint16) bool {
+	return int(addr) >= int(d.org) && int(addr)-int(d.org) < len(d.rom)
+}
+
+// trace follows the flow of the program from the given address, marking each
+// instruction it reaches as code.
+func (d *Disassembler) trace(start uint16) {
+	pending := []uint16{start}
+
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for d.contains(addr) {
+			off := int(addr - d.org)
+			if d.ins[off] != 0 {
+				// Already traced from here.
+				break
+			}
+
+			opc := d.rom[off]
+			_, n := decode(d.rom, off)
+			if n == 0 || off+n > len(d.rom) || d.overlaps(off, n) {
+				// Either an undefined opcode or an instruction which runs off
+				// the end of the ROM, neither of which can be real code.
+				break
+			}
+
+			d.ins[off] = n
+			for j := off; j < off+n; j++ {
+				d.code[j] = true
+			}
+
+			if n == 3 && refersToAddress(opc) {
+				target := uint16(d.rom[off+1]) | uint16(d.rom[off+2])<<8
+				d.refs[target] = true
+
+				if isBranch(opc) && d.contains(target) {
+					pending = append(pending, target)
+				}
+			}
+			if opc&0xc7 == 0xc7 {
+				// RST n calls the fixed address n*8.
+				target := uint16(opc & 0x38)
+				d.refs[target] = true
+				if d.contains(target) {
+					pending = append(pending, target)
+				}
+			}
+
+			if !fallsThrough(opc) {
+				break
+			}
+			addr += uint16(n)
+		}
+	}
+}
+
+// overlaps returns true if any of the n bytes at the given offset are already
+// part of a different instruction.
+func (d *Disassembler) overlaps(off, n int) bool {
+	for j := off; j < off+n; j++ {
+		if d.code[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns the label for the given address, if the address lies at the
+// start of a line in the listing.
+func (d *Disassembler) label(addr uint16) (string, bool) {
+	if !d.contains(addr) {
+		return "", false
+	}
+
+	off := int(addr - d.org)
+	switch {
+	case d.ins[off] != 0:
+		return fmt.Sprintf("L%04X", addr), true
+	case !d.code[off]:
+		return fmt.Sprintf("D%04X", addr), true
+	}
+
+	// The address is in the middle of an instruction.
+	return "", false
+}
+
+// decode returns the mnemonic and operands of the instruction at the given
+// offset along with its length in bytes, or a length of zero if the opcode
+// is undefined.
+func decode(rom []byte, off int) (string, int) {
+	// The decoder reads operand bytes without checking bounds, so give it a
+	// copy of the instruction which is always long enough.
+	var buf [3]byte
+	copy(buf[:], rom[off:])
+
+	asm, n := dasm.Disassemble(buf[:], 0)
+
+	// Drop the address which the decoder prefixes to its output.
+	asm = strings.TrimSpace(asm[strings.IndexByte(asm, ' ')+1:])
+	if asm == "-" {
+		return "", 0
+	}
+
+	return asm, int(n)
+}
+
+// refersToAddress returns true if the 16-bit operand of the given three byte
+// opcode is a memory address rather than a constant.
+func refersToAddress(opc byte) bool {
+	switch opc {
+	case 0x22, 0x2a, 0x32, 0x3a:
+		// SHLD, LHLD, STA and LDA.
+		return true
+	case 0x01, 0x11, 0x21, 0x31:
+		// LXI is often used to load addresses of tables and buffers.
+		return true
+	}
+	return isBranch(opc)
+}
+
+// isBranch returns true if the given opcode is a jump or a call to an
+// immediate address.
+func isBranch(opc byte) bool {
+	switch {
+	case opc == 0xc3, opc == 0xcd:
+		// JMP and CALL.
+		return true
+	case opc&0xc7 == 0xc2, opc&0xc7 == 0xc4:
+		// Conditional jumps and calls.
+		return true
+	}
+	return false
+}
+
+// fallsThrough returns true if execution may continue with the instruction
+// following the given opcode.
+func fallsThrough(opc byte) bool {
+	switch opc {
+	case 0xc3, 0xc9, 0xe9:
+		// JMP, RET and PCHL.
+		return false
+	}
+	return true
+}
+
+// Listing writes a source listing of the ROM to w which can be assembled back
+// into an identical image.
+//
+// Every referenced address which starts an instruction or a run of data is
+// given a generated label; code labels are prefixed with 'L' and data labels
+// with 'D'.
+func (d *Disassembler) Listing(w io.Writer) error {
+	ew := &errWriter{w: w}
+
+	ew.printf("; Disassembly of %d bytes at %s\n", len(d.rom), hex16(d.org))
+	ew.printf("\tORG\t%s\n", hex16(d.org))
+
+	for off := 0; off < len(d.rom); {
+		addr := d.org + uint16(off)
+
+		if l, ok := d.lineLabel(addr); ok {
+			ew.printf("%s:", l)
+		}
+
+		if n := d.ins[off]; n != 0 {
+			ew.printf("\t%s\t; %s\n", d.instruction(off), bytesComment(addr, d.rom[off:off+n]))
+			off += n
+			continue
+		}
+
+		// Gather a run of data, stopping at the next instruction or label.
+		end := off + 1
+		for end < len(d.rom) && end-off < 8 && d.ins[end] == 0 && !d.code[end] && !d.refs[d.org+uint16(end)] {
+			end++
+		}
+
+		vals := make([]string, 0, end-off)
+		for _, b := range d.rom[off:end] {
+			vals = append(vals, hex8(b))
+		}
+		ew.printf("\tDB\t%s\t; %s\n", strings.Join(vals, ","), bytesComment(addr, d.rom[off:end]))
+
+		off = end
+	}
+
+	ew.printf("\tEND\n")
+
+	return ew.err
+}
+
+// lineLabel returns the label to print at the start of the line for the given
+// address, if it is referenced.
+func (d *Disassembler) lineLabel(addr uint16) (string, bool) {
+	if !d.refs[addr] {
+		return "", false
+	}
+	return d.label(addr)
+}
+
+// instruction returns the Intel syntax source for the instruction at the given
+// offset, with addresses replaced by labels where possible.
+func (d *Disassembler) instruction(off int) string {
+	asm, n := decode(d.rom, off)
+
+	mn, ops := asm, ""
+	if sp := strings.IndexByte(asm, ' '); sp != -1 {
+		mn, ops = asm[:sp], asm[sp+1:]
+	}
+
+	switch n {
+	case 2:
+		ops = replaceOperand(ops, hex8(d.rom[off+1]))
+	case 3:
+		target := uint16(d.rom[off+1]) | uint16(d.rom[off+2])<<8
+		v := hex16(target)
+		if l, ok := d.label(target); ok && d.refs[target] {
+			v = l
+		}
+		ops = replaceOperand(ops, v)
+	}
+
+	if ops == "" {
+		return mn
+	}
+	return mn + "\t" + ops
+}
+
+// replaceOperand replaces the numeric operand, written by the decoder in the
+// form "#$xx" or "$xxxx", with v.
+func replaceOperand(ops, v string) string {
+	i := strings.IndexAny(ops, "#$")
+	if i == -1 {
+		return ops
+	}
+	return ops[:i] + v
+}
+
+// hex8 returns b formatted as an Intel style hexadecimal number.
+func hex8(b byte) string {
+	return intelHex(fmt.Sprintf("%02X", b))
+}
+
+// hex16 returns w formatted as an Intel style hexadecimal number.
+func hex16(w uint16) string {
+	return intelHex(fmt.Sprintf("%04X", w))
+}
+
+// intelHex adds the 'H' suffix to the hexadecimal digits s, and a leading zero
+// if needed to stop the number being mistaken for a symbol.
+func intelHex(s string) string {
+	if s[0] >= 'A' {
+		s = "0" + s
+	}
+	return s + "H"
+}
+
+// bytesComment returns a comment showing the address and raw bytes of a line.
+func bytesComment(addr uint16, b []byte) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%04X:", addr)
+	for _, v := range b {
+		fmt.Fprintf(&sb, " %02X", v)
+	}
+	return sb.String()
+}
+
+// errWriter wraps an io.Writer, remembering the first error so that a listing
+// can be written without checking every call.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+// printf writes the formatted string to the underlying writer, unless a
+// previous write has failed.
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
@@ -0,0 +1,69 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// prog jumps over a data table, calls a subroutine which reads the table and
+// then halts.
+var prog = []byte{
+	0xc3, 0x07, 0x01, // 0100: JMP 0107
+	0x48, 0x49, 0x21, 0x00, // 0103: DB 'H','I','!',0
+	0x21, 0x03, 0x01, // 0107: LXI H,0103
+	0xcd, 0x0e, 0x01, // 010a: CALL 010e
+	0x76,       // 010d: HLT
+	0x7e,       // 010e: MOV A,M
+	0xfe, 0x00, // 010f: CPI 00
+	0xc8,             // 0111: RZ
+	0x23,             // 0112: INX H
+	0xc3, 0x0e, 0x01, // 0113: JMP 010e
+	0xff, 0xff, // 0116: DB 0ffh,0ffh
+}
+
+func TestTrace(t *testing.T) {
+	d := New(prog, WithOrigin(0x100), WithEntryPoints(0x100))
+
+	for addr := uint16(0x100); addr < 0x118; addr++ {
+		want := addr < 0x103 || (addr >= 0x107 && addr < 0x116)
+		if got := d.IsCode(addr); got != want {
+			t.Errorf("IsCode(%04x) = %v, want %v", addr, got, want)
+		}
+	}
+
+	labels := d.Labels()
+	for addr, want := range map[uint16]string{
+		0x0100: "L0100",
+		0x0103: "D0103",
+		0x0107: "L0107",
+		0x010e: "L010E",
+	} {
+		if got := labels[addr]; got != want {
+			t.Errorf("label for %04x = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestListing(t *testing.T) {
+	d := New(prog, WithOrigin(0x100), WithEntryPoints(0x100))
+
+	var sb strings.Builder
+	if err := d.Listing(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"\tORG\t0100H\n",
+		"L0100:\tJMP\tL0107\t; 0100: C3 07 01\n",
+		"D0103:\tDB\t48H,49H,21H,00H\t; 0103: 48 49 21 00\n",
+		"L0107:\tLXI\tH,D0103\t",
+		"\tCALL\tL010E\t",
+		"\tCPI\t00H\t",
+		"\tDB\t0FFH,0FFH\t",
+		"\tEND\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("listing does not contain %q:\n%s", want, sb.String())
+		}
+	}
+}
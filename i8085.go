@@ -0,0 +1,136 @@
+package go8080
+
+// Interrupt inputs of the 8085, other than TRAP.
+const (
+	RST55 = iota
+	RST65
+	RST75
+)
+
+// maskAll masks all three of the 8085's restart interrupts, as after reset.
+const maskAll = 0x07
+
+// Bits of the accumulator for RIM and SIM.
+const (
+	simMaskEnable = 0x08
+	simReset75    = 0x10
+	simSODEnable  = 0x40
+	simSOD        = 0x80
+
+	rimIE  = 0x08
+	rimI55 = 0x10
+	rimI65 = 0x20
+	rimI75 = 0x40
+	rimSID = 0x80
+)
+
+// Restart addresses of the 8085's interrupt inputs.
+const (
+	trapAddr  = 0x24
+	rst55Addr = 0x2c
+	rst65Addr = 0x34
+	rst75Addr = 0x3c
+)
+
+// WithSerialInput sets the function which reads the 8085's SID input, as read
+// by RIM.
+func WithSerialInput(sid func() bool) Option {
+	return func(i *Intel8080) {
+		i.sid = sid
+	}
+}
+
+// WithSerialOutput sets the function called with the level of the 8085's SOD
+// output whenever SIM sets it.
+func WithSerialOutput(sod func(bool)) Option {
+	return func(i *Intel8080) {
+		i.sod = sod
+	}
+}
+
+// SetInterruptInput sets the level on one of the 8085's RST 5.5, 6.5 or 7.5
+// inputs. RST 5.5 and 6.5 are level sensitive and are requested for as long
+// as they are high; a rising edge on RST 7.5 sets a flip-flop which holds the
+// request until it is serviced or cleared by SIM. A request is serviced before
+// the next instruction if interrupts are enabled and it is not masked.
+func (i *Intel8080) SetInterruptInput(input int, level bool) {
+	switch input {
+	case RST55:
+		i.rst55 = level
+	case RST65:
+		i.rst65 = level
+	case RST75:
+		if level && !i.rst75Level {
+			i.rst75 = true
+		}
+		i.rst75Level = level
+	}
+}
+
+// Trap emulates a rising edge on the 8085's TRAP input: a non-maskable
+// interrupt to 0024H, taken even when interrupts are disabled.
+func (i *Intel8080) Trap() {
+	i.interrupt(trapAddr, i.timing.op[0xff])
+}
+
+// restartInterrupt services the highest priority pending restart interrupt,
+// if interrupts are enabled.
+func (i *Intel8080) restartInterrupt() {
+	if !i.ie {
+		return
+	}
+
+	switch {
+	case i.rst75 && i.mask&0x04 == 0:
+		i.rst75 = false
+		i.interrupt(rst75Addr, i.timing.op[0xff])
+	case i.rst65 && i.mask&0x02 == 0:
+		i.interrupt(rst65Addr, i.timing.op[0xff])
+	case i.rst55 && i.mask&0x01 == 0:
+		i.interrupt(rst55Addr, i.timing.op[0xff])
+	}
+}
+
+// rim is the "Read Interrupt Mask" handler.
+//
+// The accumulator is loaded with the SID input, the pending restart
+// interrupts, the interrupt enable flag and the interrupt masks.
+func (i *Intel8080) rim() {
+	a := i.mask
+	if i.ie {
+		a |= rimIE
+	}
+	if i.rst55 {
+		a |= rimI55
+	}
+	if i.rst65 {
+		a |= rimI65
+	}
+	if i.rst75 {
+		a |= rimI75
+	}
+	if i.sid != nil && i.sid() {
+		a |= rimSID
+	}
+
+	i.r[A] = a
+}
+
+// sim is the "Set Interrupt Mask" handler.
+//
+// Depending on the enable bits in the accumulator, the interrupt masks are
+// set, the RST 7.5 flip-flop is cleared and the SOD output is set.
+func (i *Intel8080) sim() {
+	a := i.r[A]
+	if a&simMaskEnable != 0 {
+		i.mask = a & maskAll
+	}
+	if a&simReset75 != 0 {
+		i.rst75 = false
+	}
+	if a&simSODEnable != 0 {
+		if i.sod != nil {
+			i.sod(a&simSOD != 0)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package go8080_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+)
+
+// ram is a flat 64K memory.
+type ram []byte
+
+func (r ram) Read(addr uint16) byte     { return r[addr] }
+func (r ram) ReadAll() []byte           { return r }
+func (r ram) Write(addr uint16, v byte) { r[addr] = v }
+
+// A program which unmasks RST 7.5, raises SOD and waits for interrupts.
+// Each interrupt handler counts itself in memory.
+const program8085 = `
+	ORG	0
+	LXI	SP,1000H
+	MVI	A,0CBH
+	SIM
+	EI
+	HLT
+	RIM
+	STA	2000H
+	HLT
+	ORG	24H
+	LXI	H,2024H
+	INR	M
+	RET
+	ORG	3CH
+	LXI	H,203CH
+	INR	M
+	EI
+	RET
+`
+
+func TestIntel8085(t *testing.T) {
+	prog, err := asm.Assemble("8085.asm", strings.NewReader(program8085))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := make(ram, 0x10000)
+	copy(mem, prog.Binary())
+
+	var sod []bool
+	cpu := go8080.NewIntel8080(mem,
+		go8080.WithVariant(go8080.Intel8085),
+		go8080.WithSerialInput(func() bool { return true }),
+		go8080.WithSerialOutput(func(v bool) { sod = append(sod, v) }),
+	)
+	step := func(n int) {
+		t.Helper()
+		for ; n > 0; n-- {
+			if err := cpu.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// LXI, MVI, SIM and EI, then the HLT.
+	step(5)
+	if cpu.Running() {
+		t.Fatal("not halted")
+	}
+	if got, want := cpu.Cycles(), uint32(10+7+4+4+5); got != want {
+		t.Errorf("cycles = %d, want %d", got, want)
+	}
+	if len(sod) != 1 || !sod[0] {
+		t.Errorf("SOD = %v, want [true]", sod)
+	}
+
+	// RST 5.5 and 6.5 are masked; RST 7.5 is latched on its edge.
+	cpu.SetInterruptInput(go8080.RST55, true)
+	cpu.SetInterruptInput(go8080.RST75, true)
+	step(5)
+	if mem[0x203c] != 1 {
+		t.Errorf("RST 7.5 count = %d, want 1", mem[0x203c])
+	}
+
+	// Holding RST 7.5 high does not request it again. RIM shows SID, the
+	// pending RST 5.5 but not RST 7.5, interrupts enabled and the masks.
+	cpu.SetInterruptInput(go8080.RST75, true)
+	step(2)
+	if got, want := mem[0x2000], byte(0x80|0x10|0x08|0x03); got != want {
+		t.Errorf("RIM = %02x, want %02x", got, want)
+	}
+
+	// TRAP is taken with interrupts disabled.
+	cpu.Trap()
+	step(3)
+	if mem[0x2024] != 1 {
+		t.Errorf("TRAP count = %d, want 1", mem[0x2024])
+	}
+	if cpu.InterruptsEnabled() {
+		t.Error("interrupts enabled after TRAP")
+	}
+}
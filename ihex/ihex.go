@@ -0,0 +1,221 @@
+// Package ihex reads and writes memory images in the Intel HEX format.
+//
+// Data records (type 00) are loaded directly into memory, with the extended
+// segment and linear address records (types 02 and 04) applied to their
+// addresses and the result truncated to the 16-bit address space of the 8080.
+// The start address records (types 03 and 05) are returned to the caller so
+// that the program counter can be set.
+package ihex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/danmrichards/go8080"
+)
+
+// Record types.
+const (
+	Data                   = 0x00
+	EndOfFile              = 0x01
+	ExtendedSegmentAddress = 0x02
+	StartSegmentAddress    = 0x03
+	ExtendedLinearAddress  = 0x04
+	StartLinearAddress     = 0x05
+)
+
+// recordSize is the number of data bytes written per record.
+const recordSize = 16
+
+type (
+	// Start is the start address given by a file.
+	Start struct {
+		// Addr is the address at which execution should begin. For a start
+		// segment address record this is the IP register, as the segment has
+		// no meaning for the 8080.
+		Addr uint16
+
+		// OK is set if the file contained a start address record.
+		OK bool
+	}
+
+	// Writer writes records to a HEX file.
+	Writer struct {
+		w   *bufio.Writer
+		err error
+	}
+
+	// Error describes a malformed line in a HEX file.
+	Error struct {
+		Line int
+		Msg  string
+	}
+)
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("ihex: line %d: %s", e.Line, e.Msg)
+}
+
+// Read loads the HEX file read from r into mem, returning the start address
+// if the file gives one.
+//
+// Reading stops at the end of file record. Every record's checksum is
+// verified before it is loaded.
+func Read(r io.Reader, mem go8080.MemWriter) (Start, error) {
+	var (
+		start Start
+		base  uint32
+	)
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+
+		fail := func(format string, args ...interface{}) (Start, error) {
+			return start, &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		if text[0] != ':' {
+			return fail("record does not start with ':'")
+		}
+		b, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return fail("invalid hex digits")
+		}
+		if len(b) < 5 {
+			return fail("record too short")
+		}
+
+		n := int(b[0])
+		if len(b) != n+5 {
+			return fail("record length %d does not match byte count %d", len(b)-5, n)
+		}
+
+		var sum byte
+		for _, v := range b {
+			sum += v
+		}
+		if sum != 0 {
+			want := b[len(b)-1] - sum
+			return fail("checksum is %02X, expected %02X", b[len(b)-1], want)
+		}
+
+		addr := uint16(b[1])<<8 | uint16(b[2])
+		typ, data := b[3], b[4:4+n]
+
+		switch typ {
+		case Data:
+			for i, v := range data {
+				mem.Write(uint16(base+uint32(addr)+uint32(i)), v)
+			}
+
+		case EndOfFile:
+			return start, nil
+
+		case ExtendedSegmentAddress, ExtendedLinearAddress:
+			if n != 2 {
+				return fail("type %02X record has %d data bytes, expected 2", typ, n)
+			}
+			base = uint32(data[0])<<8 | uint32(data[1])
+			if typ == ExtendedSegmentAddress {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+
+		case StartSegmentAddress, StartLinearAddress:
+			if n != 4 {
+				return fail("type %02X record has %d data bytes, expected 4", typ, n)
+			}
+			// Only the low word is meaningful: the IP register of a segment
+			// start address, or the low 16 bits of a linear one.
+			start = Start{Addr: uint16(data[2])<<8 | uint16(data[3]), OK: true}
+
+		default:
+			return fail("unknown record type %02X", typ)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return start, err
+	}
+
+	return start, errors.New("ihex: missing end of file record")
+}
+
+// Write writes the n bytes of mem starting at addr to w in HEX format. If
+// start is set a start linear address record is written too.
+func Write(w io.Writer, mem go8080.MemReader, addr uint16, n int, start Start) error {
+	if n < 0 || int(addr)+n > 0x10000 {
+		return fmt.Errorf("ihex: range %04X+%d exceeds the address space", addr, n)
+	}
+
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = mem.Read(addr + uint16(i))
+	}
+
+	hw := NewWriter(w)
+	hw.WriteData(addr, data)
+	if start.OK {
+		hw.WriteStart(start.Addr)
+	}
+
+	return hw.Close()
+}
+
+// NewWriter returns a writer which writes records to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteData writes data records for the given bytes, to be loaded at addr.
+func (hw *Writer) WriteData(addr uint16, data []byte) {
+	for off := 0; off < len(data); off += recordSize {
+		end := off + recordSize
+		if end > len(data) {
+			end = len(data)
+		}
+		hw.record(Data, addr+uint16(off), data[off:end])
+	}
+}
+
+// WriteStart writes a start linear address record.
+func (hw *Writer) WriteStart(addr uint16) {
+	hw.record(StartLinearAddress, 0, []byte{0, 0, byte(addr >> 8), byte(addr)})
+}
+
+// Close writes the end of file record and flushes the output. It does not
+// close the underlying writer.
+func (hw *Writer) Close() error {
+	hw.record(EndOfFile, 0, nil)
+	if hw.err != nil {
+		return hw.err
+	}
+	return hw.w.Flush()
+}
+
+// record writes a single record, unless a previous write has failed.
+func (hw *Writer) record(typ byte, addr uint16, data []byte) {
+	if hw.err != nil {
+		return
+	}
+
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+	line := make([]byte, 0, 11+2*len(data))
+	line = append(line, fmt.Sprintf(":%02X%04X%02X", len(data), addr, typ)...)
+	for _, b := range data {
+		line = append(line, fmt.Sprintf("%02X", b)...)
+		sum += b
+	}
+	line = append(line, fmt.Sprintf("%02X\n", -sum)...)
+
+	_, hw.err = hw.w.Write(line)
+}
@@ -0,0 +1,113 @@
+package ihex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+)
+
+type mem []byte
+
+// Read returns the value from memory at the given address.
+func (m mem) Read(addr uint16) byte {
+	return m[addr]
+}
+
+// ReadAll returns the full memory contents.
+func (m mem) ReadAll() []byte {
+	return m
+}
+
+// Write writes the value v into memory at the given address.
+func (m mem) Write(addr uint16, v byte) {
+	m[addr] = v
+}
+
+func TestRead(t *testing.T) {
+	src := strings.Join([]string{
+		":03010000C3000138",
+		":020000021000EC",     // Segment 1000H, i.e. a base of 10000H.
+		":020010001122BB",     // Truncated to 0010H.
+		":0400000500000100F6", // Start linear address 0100H.
+		":00000001FF",
+		":01000000FF00", // After the end of file record, so ignored.
+	}, "\n")
+
+	m := make(mem, 0x10000)
+	start, err := Read(strings.NewReader(src), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(m[0x100:0x103], []byte{0xc3, 0x00, 0x01}) {
+		t.Errorf("mem[0100] = % x, want c3 00 01", m[0x100:0x103])
+	}
+	if !bytes.Equal(m[0x10:0x12], []byte{0x11, 0x22}) {
+		t.Errorf("mem[0010] = % x, want 11 22", m[0x10:0x12])
+	}
+	if m[0] != 0 {
+		t.Errorf("mem[0000] = %02x, want 00", m[0])
+	}
+	if !start.OK || start.Addr != 0x100 {
+		t.Errorf("start = %+v, want 0100", start)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"03010000C3000139", "ihex: line 1: record does not start with ':'"},
+		{":00000001FF\n", ""},
+		{":03010000C3000139", "ihex: line 1: checksum is 39, expected 38"},
+		{":0301C3000139", "ihex: line 1: record length 1 does not match byte count 3"},
+		{":03010000C300013Z", "ihex: line 1: invalid hex digits"},
+		{":00000006FA", "ihex: line 1: unknown record type 06"},
+		{":03010000C3000138", "ihex: missing end of file record"},
+	}
+	for _, tt := range tests {
+		_, err := Read(strings.NewReader(tt.src), make(mem, 0x10000))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("Read(%q) error = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := make(mem, 0x10000)
+	for i := 0; i < 40; i++ {
+		m[0xfff0+i%16] = byte(i)
+		m[0x200+i] = byte(i * 3)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m, 0x200, 40, Start{Addr: 0x200, OK: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(mem, 0x10000)
+	start, err := Read(&buf, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[0x200:0x228], m[0x200:0x228]) {
+		t.Errorf("round trip = % x, want % x", got[0x200:0x228], m[0x200:0x228])
+	}
+	if start != (Start{Addr: 0x200, OK: true}) {
+		t.Errorf("start = %+v, want 0200", start)
+	}
+
+	// Check that the loaded start address can drive the CPU.
+	cpu := go8080.NewIntel8080(got)
+	cpu.SetProgramCounter(start.Addr)
+	if cpu.ProgramCounter() != 0x200 {
+		t.Errorf("program counter = %04x, want 0200", cpu.ProgramCounter())
+	}
+}
@@ -22,3 +22,16 @@ type MemReadWriter interface {
 	MemReader
 	MemWriter
 }
+
+// InterruptController is the interface of a device driving the CPU's INTR
+// input, such as an 8259.
+//
+// Requesting returns the level of INTR.
+//
+// Acknowledge returns the byte the device puts on the data bus in an
+// interrupt acknowledge (INTA) cycle. The first byte is the opcode of the
+// instruction to execute; a CALL takes two more INTA cycles for its address.
+type InterruptController interface {
+	Requesting() bool
+	Acknowledge() byte
+}
@@ -8,6 +8,9 @@ func (i *Intel8080) ana(v uint8) {
 	r := i.r[A] & v
 	i.cc.cy = false
 	i.cc.ac = ((i.r[A] | v) & 0x08) != 0
+	if i.variant == Intel8085 {
+		i.cc.ac = true
+	}
 	i.cc.z = r == 0
 	i.cc.s = r&0x80 != 0
 	i.cc.setParity(r)
@@ -0,0 +1,240 @@
+// Package altair emulates the MITS Altair 8800 and its front panel.
+//
+// The machine is driven through the panel as an operator would: set the
+// switches, EXAMINE and DEPOSIT memory, then RUN. Execution only advances
+// when Execute or SingleStep is called, so a sequence of panel operations
+// always produces the same lights.
+package altair
+
+import (
+	"errors"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/bus"
+)
+
+const (
+	// MaxMemory is the size of the 8080 address space.
+	MaxMemory = 0x10000
+
+	// Clock is the CPU clock rate in Hz.
+	Clock = 2000000
+
+	// SenseSwitchPort is the input port which reads the upper eight address
+	// switches.
+	SenseSwitchPort = 0xff
+
+	// floating is read from unpopulated memory.
+	floating = 0xff
+)
+
+var (
+	// ErrRunning is returned by panel operations which need the machine to be
+	// stopped.
+	ErrRunning = errors.New("altair: machine is running")
+
+	// ErrHalted is returned by EXAMINE and DEPOSIT while the CPU is halted.
+	ErrHalted = errors.New("altair: CPU is halted")
+)
+
+type (
+	// Machine is an Altair 8800 with its front panel.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem *memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		// I/O boards and the ports they are to be attached at.
+		ports   bus.Ports
+		devices []device
+
+		// Address and data switches.
+		switches uint16
+
+		// Set while the RUN switch is in effect.
+		running bool
+
+		// The bus cycle latched by the lights while running.
+		last busCycle
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the Altair address space, populated with RAM from address 0
+	// up to its size and with any ROMs.
+	memory struct {
+		data []byte
+		size int
+		rom  []bool
+	}
+
+	// device is an I/O board and its base port.
+	device struct {
+		base byte
+		dev  bus.Device
+	}
+)
+
+// Read returns the value from memory at the given address.
+func (m *memory) Read(addr uint16) byte {
+	if int(addr) >= m.size && !m.rom[addr] {
+		return floating
+	}
+
+	return m.data[addr]
+}
+
+// ReadAll returns the full memory contents.
+func (m *memory) ReadAll() []byte {
+	return m.data
+}
+
+// Write writes the value v into memory at the given address, unless there is
+// no RAM there.
+func (m *memory) Write(addr uint16, v byte) {
+	if int(addr) < m.size && !m.rom[addr] {
+		m.data[addr] = v
+	}
+}
+
+// WithMemory sets the amount of RAM fitted, in bytes. The default is the full
+// 64K.
+func WithMemory(size int) Option {
+	return func(m *Machine) {
+		if size > MaxMemory {
+			size = MaxMemory
+		}
+		m.mem.size = size
+	}
+}
+
+// WithROM fits a ROM holding data at addr, such as the 88-DBL disk boot
+// loader PROM at 0FF00H. Its contents read back even above the RAM fitted.
+func WithROM(addr uint16, data []byte) Option {
+	return func(m *Machine) {
+		for i, b := range data {
+			a := addr + uint16(i)
+			m.mem.data[a], m.mem.rom[a] = b, true
+		}
+	}
+}
+
+// WithDevice plugs in an I/O board decoding the ports from base, such as an
+// 88-SIO.
+func WithDevice(base byte, d bus.Device) Option {
+	return func(m *Machine) {
+		m.devices = append(m.devices, device{base, d})
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// New returns a stopped Altair 8800 with cleared memory. It is an error for
+// the ports of two devices to overlap.
+func New(opts ...Option) (*Machine, error) {
+	m := &Machine{
+		mem: &memory{
+			data: make([]byte, MaxMemory),
+			size: MaxMemory,
+			rom:  make([]bool, MaxMemory),
+		},
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	for _, d := range m.devices {
+		if err := m.ports.Attach(d.base, d.dev); err != nil {
+			return nil, err
+		}
+	}
+
+	m.cpu = go8080.NewIntel8080(m.mem, append([]go8080.Option{
+		go8080.WithInput(m.input),
+		go8080.WithOutput(m.output),
+	}, m.cpuOpts...)...)
+
+	return m, nil
+}
+
+// CPU returns the machine's CPU.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the machine's memory.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// Load copies data into memory starting at addr, as a faster alternative to
+// toggling it in with DEPOSIT.
+func (m *Machine) Load(addr uint16, data []byte) {
+	for i, b := range data {
+		m.mem.Write(addr+uint16(i), b)
+	}
+}
+
+// Execute runs the machine for at least the given number of CPU cycles, if
+// the RUN switch is in effect. A halted CPU keeps consuming cycles until it
+// is interrupted or reset.
+func (m *Machine) Execute(cycles uint32) error {
+	for end := m.cpu.Cycles() + cycles; m.running && int32(m.cpu.Cycles()-end) < 0; {
+		if err := m.step(); err != nil {
+			m.running = false
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Interrupt raises an interrupt with the given RST number, 0 to 7. It returns
+// false if the CPU has interrupts disabled, or has just enabled them with EI
+// and not yet executed the following instruction.
+func (m *Machine) Interrupt(rst byte) bool {
+	if !m.cpu.Interrupt(uint16(rst&7) * 8) {
+		return false
+	}
+	m.last = busCycle{
+		addr:   m.cpu.ProgramCounter(),
+		data:   0xc7 | (rst&7)<<3,
+		status: INT | M1 | WO,
+	}
+
+	return true
+}
+
+// step executes one instruction, latching its final bus cycle for the
+// lights.
+func (m *Machine) step() error {
+	before := m.state()
+	if err := m.cpu.Step(); err != nil {
+		return err
+	}
+	m.last = m.lastCycle(before)
+
+	return nil
+}
+
+// input handles the IN instruction. The sense switches take priority over any
+// device at their port.
+func (m *Machine) input(port byte) byte {
+	if port == SenseSwitchPort {
+		return byte(m.switches >> 8)
+	}
+
+	return m.ports.In(port)
+}
+
+// output handles the OUT instruction.
+func (m *Machine) output(port byte) {
+	m.ports.Out(port, m.cpu.Accumulator())
+}
@@ -0,0 +1,181 @@
+package altair
+
+import (
+	"testing"
+)
+
+func newMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	m, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// toggle deposits program at address 0 through the front panel.
+func toggle(t *testing.T, m *Machine, program ...byte) {
+	t.Helper()
+
+	m.SetSwitches(0)
+	if err := m.Examine(); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range program {
+		m.SetSwitches(uint16(b))
+		deposit := m.DepositNext
+		if i == 0 {
+			deposit = m.Deposit
+		}
+		if err := deposit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkLights(t *testing.T, m *Machine, want Lights) {
+	t.Helper()
+
+	if got := m.Lights(); got != want {
+		t.Errorf("lights = %04x %02x %v, want %04x %02x %v",
+			got.Address, got.Data, got.Status, want.Address, want.Data, want.Status)
+	}
+}
+
+func TestPanel(t *testing.T) {
+	m := newMachine(t)
+
+	// IN 0FFH; STA 0020H; HLT
+	toggle(t, m, 0xdb, 0xff, 0x32, 0x20, 0x00, 0x76)
+	checkLights(t, m, Lights{0x0005, 0x76, MEMR | M1 | WO | WAIT})
+
+	m.SetSwitches(0)
+	if err := m.Examine(); err != nil {
+		t.Fatal(err)
+	}
+	checkLights(t, m, Lights{0x0000, 0xdb, MEMR | M1 | WO | WAIT})
+	if err := m.ExamineNext(); err != nil {
+		t.Fatal(err)
+	}
+	checkLights(t, m, Lights{0x0001, 0xff, MEMR | M1 | WO | WAIT})
+
+	m.Reset()
+	m.SetSwitches(0xa500)
+	m.Run()
+	if err := m.Examine(); err != ErrRunning {
+		t.Errorf("Examine while running = %v, want %v", err, ErrRunning)
+	}
+	if err := m.Execute(100); err != nil {
+		t.Fatal(err)
+	}
+	checkLights(t, m, Lights{0x0006, 0x00, MEMR | HLTA | WO})
+
+	m.Stop()
+	checkLights(t, m, Lights{0x0006, 0x00, MEMR | HLTA | WO | WAIT})
+	if err := m.Examine(); err != ErrHalted {
+		t.Errorf("Examine while halted = %v, want %v", err, ErrHalted)
+	}
+
+	m.Reset()
+	m.SetSwitches(0x0020)
+	if err := m.Examine(); err != nil {
+		t.Fatal(err)
+	}
+	checkLights(t, m, Lights{0x0020, 0xa5, MEMR | M1 | WO | WAIT})
+}
+
+func TestSingleStep(t *testing.T) {
+	m := newMachine(t)
+
+	// EI; LXI SP,0100H; MVI A,42H; OUT 10H; PUSH PSW; HLT
+	toggle(t, m, 0xfb, 0x31, 0x00, 0x01, 0x3e, 0x42, 0xd3, 0x10, 0xf5, 0x76)
+	m.Reset()
+
+	steps := []Lights{
+		{0x0001, 0x31, INTE | MEMR | M1 | WO | WAIT},
+		{0x0004, 0x3e, INTE | MEMR | M1 | WO | WAIT},
+		{0x0006, 0xd3, INTE | MEMR | M1 | WO | WAIT},
+		{0x0008, 0xf5, INTE | MEMR | M1 | WO | WAIT},
+		{0x0009, 0x76, INTE | MEMR | M1 | WO | WAIT},
+		{0x000a, 0x00, INTE | MEMR | HLTA | WO | WAIT},
+	}
+	for i, want := range steps {
+		if err := m.SingleStep(); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Lights(); got != want {
+			t.Errorf("step %d: lights = %04x %02x %v, want %04x %02x %v",
+				i, got.Address, got.Data, got.Status, want.Address, want.Data, want.Status)
+		}
+	}
+}
+
+func TestRunningLights(t *testing.T) {
+	m := newMachine(t, WithMemory(0x1000))
+
+	// LXI SP,0100H; MVI A,42H; OUT 10H; PUSH PSW; POP B; LXI H,0800H;
+	// MOV M,A; MOV E,M; JMP 0000H
+	m.Load(0, []byte{
+		0x31, 0x00, 0x01, 0x3e, 0x42, 0xd3, 0x10, 0xf5, 0xc1,
+		0x21, 0x00, 0x08, 0x77, 0x5e, 0xc3, 0x00, 0x00,
+	})
+	m.Run()
+
+	cycles := []Lights{
+		{0x0000, 0x31, MEMR | M1 | WO},
+		{0x0003, 0x3e, MEMR | M1 | WO},
+		{0x1010, 0x42, OUT},
+		{0x00fe, 0x02, STACK},
+		{0x00ff, 0x42, MEMR | STACK | WO},
+		{0x0009, 0x21, MEMR | M1 | WO},
+		{0x0800, 0x42, 0},
+		{0x0800, 0x42, MEMR | WO},
+	}
+	for i, want := range cycles {
+		// Each instruction takes at least four cycles.
+		if err := m.Execute(1); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Lights(); got != want {
+			t.Errorf("instruction %d: lights = %04x %02x %v, want %04x %02x %v",
+				i, got.Address, got.Data, got.Status, want.Address, want.Data, want.Status)
+		}
+	}
+
+	// Memory above 4K is not fitted.
+	if got := m.Memory().Read(0x1000); got != floating {
+		t.Errorf("unfitted memory = %02x, want %02x", got, floating)
+	}
+}
+
+func TestInterruptFromHalt(t *testing.T) {
+	m := newMachine(t)
+
+	// EI; HLT; MVI A,1; HLT, with RST 7 returning immediately.
+	m.Load(0, []byte{0xfb, 0x76, 0x3e, 0x01, 0x76})
+	m.Load(0x38, []byte{0xc9})
+	m.CPU().SetStackPointer(0x100)
+	m.Run()
+	if err := m.Execute(50); err != nil {
+		t.Fatal(err)
+	}
+	if m.CPU().Running() {
+		t.Fatal("CPU not halted")
+	}
+
+	if !m.Interrupt(7) {
+		t.Fatal("interrupt not accepted")
+	}
+	checkLights(t, m, Lights{0x0038, 0xff, INT | M1 | WO})
+	if err := m.Execute(50); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.CPU().Accumulator(); got != 1 {
+		t.Errorf("A = %02x, want 01", got)
+	}
+	if m.Interrupt(7) {
+		t.Error("interrupt accepted with interrupts disabled")
+	}
+}
@@ -0,0 +1,300 @@
+package altair
+
+import (
+	"io"
+	"os"
+)
+
+// DCDDPort is the base port of the 88-DCDD floppy disk controller.
+const DCDDPort = 0x08
+
+// Geometry of an 8" disk in the 88-DCDD format. Image files hold the sectors
+// in order, with no header.
+const (
+	DiskTracks     = 77
+	DiskSectors    = 32
+	DiskSectorSize = 137
+	DiskImageSize  = DiskTracks * DiskSectors * DiskSectorSize
+)
+
+// DCDD registers, as offsets from the base port.
+const (
+	dcddStatus = iota // IN: status; OUT: drive select.
+	dcddSector        // IN: sector position; OUT: drive control.
+	dcddData          // IN: read data; OUT: write data.
+)
+
+// DCDD status flags. They are held active high here and complemented when
+// read, as the hardware signals are active low.
+const (
+	flagENWD     = 0x01 // Ready for the next byte to write.
+	flagMoveHead = 0x02 // Head may be stepped.
+	flagHead     = 0x04 // Head loaded.
+	flagUnused   = 0x18 // Always read as zero.
+	flagINTE     = 0x20 // Interrupts enabled.
+	flagTrack0   = 0x40 // Head is on track 0.
+	flagNRDA     = 0x80 // A byte is ready to read.
+)
+
+// DCDD drive control bits.
+const (
+	ctrlStepIn      = 0x01
+	ctrlStepOut     = 0x02
+	ctrlHeadLoad    = 0x04
+	ctrlHeadUnload  = 0x08
+	ctrlIntEnable   = 0x10
+	ctrlIntDisable  = 0x20
+	ctrlWriteEnable = 0x80
+	selectDisable   = 0x80
+	driveMask       = 0x0f
+)
+
+type (
+	// ReadWriterAt is the storage behind a disk image.
+	ReadWriterAt interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+
+	// Disk is an 88-DCDD disk image.
+	Disk struct {
+		rw     ReadWriterAt
+		closer io.Closer
+
+		// WriteProtected disks ignore writes.
+		WriteProtected bool
+
+		// Head position.
+		track int
+	}
+
+	// DCDD is the MITS 88-DCDD floppy disk controller with up to sixteen
+	// drives.
+	//
+	// The sector position advances each time it is read rather than with the
+	// rotation of the disk, which suits the polling loops of the MITS and
+	// CP/M disk software. Head settling and sector-true interrupts are not
+	// modelled; the interrupt enable only shows in the status.
+	DCDD struct {
+		drives [16]*Disk
+
+		// Selected drive, or nil.
+		disk  *Disk
+		flags byte
+
+		// Sector under the head, and the position of the next byte within it.
+		// The buffer is filled from the disk on the first read of a sector.
+		sector  int
+		pos     int
+		buf     [DiskSectorSize]byte
+		writing bool
+
+		// The first error from a disk image.
+		err error
+	}
+)
+
+// NewDisk returns a disk image stored in rw.
+func NewDisk(rw ReadWriterAt) *Disk {
+	return &Disk{rw: rw}
+}
+
+// OpenDisk opens the disk image file at path. A file which cannot be opened
+// for writing is opened write protected.
+func OpenDisk(path string) (*Disk, error) {
+	d := &Disk{}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		d.WriteProtected = true
+	}
+	d.rw, d.closer = file, file
+
+	return d, nil
+}
+
+// Close closes the image file, if the disk was opened with OpenDisk.
+func (d *Disk) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+
+	return d.closer.Close()
+}
+
+// offset returns the offset of a sector within the image.
+func (d *Disk) offset(sector int) int64 {
+	return int64(d.track*DiskSectors+sector) * DiskSectorSize
+}
+
+// NewDCDD returns a disk controller with no disks inserted.
+func NewDCDD() *DCDD {
+	return &DCDD{}
+}
+
+// Insert inserts a disk into the given drive, 0 to 15. A nil disk empties
+// the drive.
+func (c *DCDD) Insert(drive int, d *Disk) {
+	if c.disk != nil && c.disk == c.drives[drive] {
+		c.deselect()
+	}
+	c.drives[drive] = d
+}
+
+// Err returns the first error reading or writing a disk image. The
+// controller has no way to report errors to the CPU.
+func (c *DCDD) Err() error {
+	return c.err
+}
+
+// Ports returns the number of ports decoded by the controller.
+func (c *DCDD) Ports() int {
+	return 3
+}
+
+// In reads the status, sector position or data register.
+func (c *DCDD) In(offset byte) byte {
+	if c.disk == nil {
+		if offset == dcddData {
+			return 0
+		}
+		return 0xff
+	}
+
+	switch offset {
+	case dcddStatus:
+		return ^c.flags
+
+	case dcddSector:
+		if c.flags&flagHead == 0 {
+			return 0xff
+		}
+		c.flush()
+		c.sector = (c.sector + 1) % DiskSectors
+		c.pos = DiskSectorSize
+
+		// Bit 0 is the active low sector true signal.
+		return 0xc0 | byte(c.sector)<<1
+
+	default:
+		if c.pos >= DiskSectorSize {
+			c.readSector()
+		}
+		b := c.buf[c.pos]
+		c.pos++
+		return b
+	}
+}
+
+// Out selects a drive, controls it or writes data.
+func (c *DCDD) Out(offset, v byte) {
+	if offset == dcddStatus {
+		c.deselect()
+		if d := c.drives[v&driveMask]; v&selectDisable == 0 && d != nil {
+			c.disk = d
+			c.flags = flagMoveHead | flagUnused
+			c.sector, c.pos = DiskSectors-1, DiskSectorSize
+			c.updateTrack0()
+		}
+		return
+	}
+	if c.disk == nil {
+		return
+	}
+
+	if offset == dcddData {
+		c.write(v)
+		return
+	}
+
+	if v&(ctrlStepIn|ctrlStepOut) != 0 {
+		c.flush()
+		if v&ctrlStepIn != 0 && c.disk.track < DiskTracks-1 {
+			c.disk.track++
+		}
+		if v&ctrlStepOut != 0 && c.disk.track > 0 {
+			c.disk.track--
+		}
+		c.pos = DiskSectorSize
+		c.updateTrack0()
+	}
+	if v&ctrlHeadLoad != 0 {
+		c.flags |= flagHead | flagNRDA
+	}
+	if v&ctrlHeadUnload != 0 {
+		c.flags &^= flagHead | flagNRDA
+	}
+	if v&ctrlIntEnable != 0 {
+		c.flags |= flagINTE
+	}
+	if v&ctrlIntDisable != 0 {
+		c.flags &^= flagINTE
+	}
+	if v&ctrlWriteEnable != 0 {
+		c.writing, c.pos = true, 0
+		c.buf = [DiskSectorSize]byte{}
+		c.flags |= flagENWD
+	}
+}
+
+// deselect writes any partly written sector and deselects the drive.
+func (c *DCDD) deselect() {
+	c.flush()
+	c.disk, c.flags = nil, 0
+}
+
+// updateTrack0 sets the track 0 flag from the head position.
+func (c *DCDD) updateTrack0() {
+	c.flags &^= flagTrack0
+	if c.disk.track == 0 {
+		c.flags |= flagTrack0
+	}
+}
+
+// readSector fills the buffer from the sector under the head. The part of a
+// sector beyond the end of a short image reads as zero.
+func (c *DCDD) readSector() {
+	c.buf = [DiskSectorSize]byte{}
+	c.pos = 0
+
+	n, err := c.disk.rw.ReadAt(c.buf[:], c.disk.offset(c.sector))
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil && n < DiskSectorSize && c.err == nil {
+		c.err = err
+	}
+}
+
+// write adds a byte to the sector being written, writing the sector to the
+// disk once it is complete.
+func (c *DCDD) write(b byte) {
+	if !c.writing {
+		return
+	}
+
+	c.buf[c.pos] = b
+	if c.pos++; c.pos == DiskSectorSize {
+		c.flush()
+	}
+}
+
+// flush writes the sector being written to the disk. A partly written sector
+// is completed with zeros.
+func (c *DCDD) flush() {
+	if !c.writing {
+		return
+	}
+	c.writing = false
+	c.flags &^= flagENWD
+
+	if c.disk.WriteProtected {
+		return
+	}
+	if _, err := c.disk.rw.WriteAt(c.buf[:], c.disk.offset(c.sector)); err != nil && c.err == nil {
+		c.err = err
+	}
+}
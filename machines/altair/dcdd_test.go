@@ -0,0 +1,168 @@
+package altair
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/asm"
+)
+
+// Read sector 2 of track 1 of drive 0, then write it back to sector 3 with
+// its first byte changed.
+const dcddSrc = `
+	ORG	0
+	LXI	SP,100H
+	XRA	A
+	OUT	08H
+	MVI	A,04H
+	OUT	09H
+	MVI	A,01H
+	OUT	09H
+	MVI	C,2
+	CALL	SEEK
+	LXI	H,1000H
+	MVI	B,137
+READ:	IN	08H
+	ORA	A
+	JM	READ
+	IN	0AH
+	MOV	M,A
+	INX	H
+	DCR	B
+	JNZ	READ
+	MVI	A,'J'
+	STA	1000H
+	MVI	C,3
+	CALL	SEEK
+	MVI	A,80H
+	OUT	09H
+	LXI	H,1000H
+	MVI	B,137
+WRITE:	IN	08H
+	RAR
+	JC	WRITE
+	MOV	A,M
+	OUT	0AH
+	INX	H
+	DCR	B
+	JNZ	WRITE
+	HLT
+SEEK:	IN	09H
+	RAR
+	JC	SEEK
+	ANI	1FH
+	CMP	C
+	JNZ	SEEK
+	RET
+`
+
+func TestDCDD(t *testing.T) {
+	for _, protect := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "altair")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// A short image, with only the first two tracks.
+		path := filepath.Join(dir, "disk.dsk")
+		img := make([]byte, 2*DiskSectors*DiskSectorSize)
+		copy(img[(DiskSectors+2)*DiskSectorSize:], "HELLO")
+		if err := ioutil.WriteFile(path, img, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := OpenDisk(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.WriteProtected = protect
+		c := NewDCDD()
+		c.Insert(0, d)
+
+		prog, err := asm.Assemble("dcdd.asm", strings.NewReader(dcddSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := newMachine(t, WithDevice(DCDDPort, c))
+		m.Load(0, prog.Binary())
+		m.Run()
+		if err := m.Execute(100000); err != nil {
+			t.Fatal(err)
+		}
+		if m.CPU().Running() {
+			t.Fatal("program did not finish")
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "JELLO"
+		if protect {
+			want = "\x00\x00\x00\x00\x00"
+		}
+		if got := string(b[(DiskSectors+3)*DiskSectorSize:][:5]); got != want {
+			t.Errorf("write protected %v: sector 3 = %q, want %q", protect, got, want)
+		}
+		if !bytes.Equal(b[(DiskSectors+2)*DiskSectorSize:][:5], []byte("HELLO")) {
+			t.Errorf("write protected %v: sector 2 changed", protect)
+		}
+	}
+}
+
+func TestDCDDStatus(t *testing.T) {
+	c := NewDCDD()
+	c.Insert(1, NewDisk(nil))
+
+	if got := c.In(dcddStatus); got != 0xff {
+		t.Errorf("status with no drive selected = %02x, want ff", got)
+	}
+
+	// Drive 0 is empty.
+	c.Out(dcddStatus, 0)
+	if got := c.In(dcddStatus); got != 0xff {
+		t.Errorf("status of empty drive = %02x, want ff", got)
+	}
+
+	c.Out(dcddStatus, 1)
+	if got := c.In(dcddStatus); got != 0xa5 {
+		t.Errorf("status = %02x, want a5", got)
+	}
+	if got := c.In(dcddSector); got != 0xff {
+		t.Errorf("sector position with head unloaded = %02x, want ff", got)
+	}
+
+	c.Out(dcddSector, ctrlHeadLoad|ctrlStepIn)
+	if got := c.In(dcddStatus); got != 0x61 {
+		t.Errorf("status = %02x, want 61", got)
+	}
+	for want := byte(0xc0); want < 0xc6; want += 2 {
+		if got := c.In(dcddSector); got != want {
+			t.Errorf("sector position = %02x, want %02x", got, want)
+		}
+	}
+
+	c.Out(dcddStatus, selectDisable)
+	if got := c.In(dcddStatus); got != 0xff {
+		t.Errorf("status after deselect = %02x, want ff", got)
+	}
+}
+
+func TestROM(t *testing.T) {
+	m := newMachine(t, WithMemory(0x1000), WithROM(0xff00, []byte{0x76}))
+	m.Memory().Write(0xff00, 0)
+	if got := m.Memory().Read(0xff00); got != 0x76 {
+		t.Errorf("ROM = %02x, want 76", got)
+	}
+}
@@ -0,0 +1,293 @@
+package altair
+
+import (
+	"strings"
+
+	"github.com/danmrichards/go8080"
+)
+
+// Status is the set of lit status lights.
+type Status uint16
+
+// The status lights, in front panel order. Most mirror the status word the
+// 8080 puts on the data bus at the start of each machine cycle; INTE is the
+// CPU's interrupt enable output, WAIT is lit while the machine is stopped and
+// HLDA, the DMA hold acknowledge, is never lit as no DMA devices are fitted.
+const (
+	INTE Status = 1 << iota
+	MEMR
+	INP
+	M1
+	OUT
+	HLTA
+	STACK
+	WO
+	INT
+	WAIT
+	HLDA
+)
+
+var statusNames = []string{
+	"INTE", "MEMR", "INP", "M1", "OUT", "HLTA", "STACK", "WO", "INT", "WAIT", "HLDA",
+}
+
+// String returns the names of the lit status lights, separated by spaces.
+func (s Status) String() string {
+	var names []string
+	for i, name := range statusNames {
+		if s&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, " ")
+}
+
+type (
+	// Lights is the state of the front panel lights.
+	Lights struct {
+		Address uint16
+		Data    byte
+		Status  Status
+	}
+
+	// busCycle is a machine cycle as latched by the lights.
+	busCycle struct {
+		addr   uint16
+		data   byte
+		status Status
+	}
+
+	// state is the CPU state before an instruction, from which its final bus
+	// cycle is worked out.
+	state struct {
+		pc, sp     uint16
+		bc, de, hl uint16
+		opc        byte
+	}
+)
+
+// SetSwitches sets the sixteen address/data switches. The lower eight are the
+// data switches used by DEPOSIT, and the upper eight are also the sense
+// switches read from port 0FFH.
+func (m *Machine) SetSwitches(v uint16) {
+	m.switches = v
+}
+
+// Switches returns the setting of the address/data switches.
+func (m *Machine) Switches() uint16 {
+	return m.switches
+}
+
+// Examine loads the address switches into the program counter, showing the
+// contents of that address on the data lights.
+func (m *Machine) Examine() error {
+	if err := m.stopped(); err != nil {
+		return err
+	}
+	m.cpu.SetProgramCounter(m.switches)
+
+	return nil
+}
+
+// ExamineNext advances to and shows the next address.
+func (m *Machine) ExamineNext() error {
+	if err := m.stopped(); err != nil {
+		return err
+	}
+	m.cpu.SetProgramCounter(m.cpu.ProgramCounter() + 1)
+
+	return nil
+}
+
+// Deposit writes the data switches to the address shown.
+func (m *Machine) Deposit() error {
+	if err := m.stopped(); err != nil {
+		return err
+	}
+	m.mem.Write(m.cpu.ProgramCounter(), byte(m.switches))
+
+	return nil
+}
+
+// DepositNext advances to the next address and writes the data switches to
+// it.
+func (m *Machine) DepositNext() error {
+	if err := m.ExamineNext(); err != nil {
+		return err
+	}
+
+	return m.Deposit()
+}
+
+// Run starts execution at the address shown. Instructions are executed by
+// Execute.
+func (m *Machine) Run() {
+	if !m.running {
+		m.running = true
+		m.last = m.fetch()
+	}
+}
+
+// Stop stops execution before the next instruction is fetched.
+func (m *Machine) Stop() {
+	m.running = false
+}
+
+// Running returns true if the RUN switch is in effect.
+func (m *Machine) Running() bool {
+	return m.running
+}
+
+// SingleStep executes one instruction on a stopped machine.
+func (m *Machine) SingleStep() error {
+	if m.running {
+		return ErrRunning
+	}
+
+	return m.step()
+}
+
+// Reset resets the CPU, leaving memory untouched. A running machine carries
+// on running from address 0.
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+	m.last = m.fetch()
+}
+
+// stopped returns an error unless the panel can examine and deposit. As on
+// the real machine, a halted CPU does not respond until it is reset.
+func (m *Machine) stopped() error {
+	switch {
+	case m.running:
+		return ErrRunning
+	case !m.cpu.Running():
+		return ErrHalted
+	}
+
+	return nil
+}
+
+// Lights returns the state of the front panel lights.
+//
+// A stopped machine waits in the fetch of the next instruction, as the real
+// panel does, so the lights show that fetch with WAIT lit. While running the
+// lights show the final machine cycle of the last instruction executed: an
+// IN or OUT shows the port on both halves of the address and INP or OUT lit,
+// a stack or memory access shows its address and data, and other
+// instructions show their own fetch.
+func (m *Machine) Lights() Lights {
+	c := m.last
+	if !m.running {
+		c = m.fetch()
+		c.status |= WAIT
+	}
+	if m.cpu.InterruptsEnabled() {
+		c.status |= INTE
+	}
+
+	return Lights{
+		Address: c.addr,
+		Data:    c.data,
+		Status:  c.status,
+	}
+}
+
+// fetch returns the next cycle of a stopped CPU: the opcode fetch at the
+// program counter, or the halt acknowledge after a HLT.
+func (m *Machine) fetch() busCycle {
+	pc := m.cpu.ProgramCounter()
+	if !m.cpu.Running() {
+		return m.memCycle(pc+1, MEMR|HLTA|WO)
+	}
+
+	return m.memCycle(pc, MEMR|M1|WO)
+}
+
+// memCycle returns a memory cycle at addr with the given status.
+func (m *Machine) memCycle(addr uint16, status Status) busCycle {
+	return busCycle{
+		addr:   addr,
+		data:   m.mem.Read(addr),
+		status: status,
+	}
+}
+
+// state returns the CPU state ahead of the next instruction.
+func (m *Machine) state() state {
+	pair := func(hi, lo int) uint16 {
+		return uint16(m.cpu.Register(hi))<<8 | uint16(m.cpu.Register(lo))
+	}
+	pc := m.cpu.ProgramCounter()
+
+	return state{
+		pc:  pc,
+		sp:  m.cpu.StackPointer(),
+		bc:  pair(go8080.B, go8080.C),
+		de:  pair(go8080.D, go8080.E),
+		hl:  pair(go8080.H, go8080.L),
+		opc: m.mem.Read(pc),
+	}
+}
+
+// lastCycle returns the final machine cycle of the instruction executed from
+// state s.
+func (m *Machine) lastCycle(s state) busCycle {
+	imm := uint16(m.mem.Read(s.pc+2))<<8 | uint16(m.mem.Read(s.pc+1))
+	sp := m.cpu.StackPointer()
+
+	switch opc := s.opc; {
+	case opc == 0xdb, opc == 0xd3:
+		port := m.mem.Read(s.pc + 1)
+		c := busCycle{
+			addr:   uint16(port)<<8 | uint16(port),
+			data:   m.cpu.Accumulator(),
+			status: INP | WO,
+		}
+		if opc == 0xd3 {
+			c.status = OUT
+		}
+		return c
+
+	case opc == 0x76:
+		return m.fetch()
+
+	case opc == 0xe3:
+		return m.memCycle(sp, STACK)
+
+	case sp != s.sp && opc != 0x31 && opc != 0x33 && opc != 0x3b && opc != 0xf9:
+		// PUSH, POP and taken CALLs, RETs and RSTs.
+		if int16(sp-s.sp) < 0 {
+			return m.memCycle(sp, STACK)
+		}
+		return m.memCycle(sp-1, MEMR|STACK|WO)
+
+	case opc == 0x02:
+		return m.memCycle(s.bc, 0)
+	case opc == 0x12:
+		return m.memCycle(s.de, 0)
+	case opc == 0x22:
+		return m.memCycle(imm+1, 0)
+	case opc == 0x32:
+		return m.memCycle(imm, 0)
+	case opc >= 0x34 && opc <= 0x36, opc >= 0x70 && opc <= 0x77:
+		return m.memCycle(s.hl, 0)
+
+	case opc == 0x0a:
+		return m.memCycle(s.bc, MEMR|WO)
+	case opc == 0x1a:
+		return m.memCycle(s.de, MEMR|WO)
+	case opc == 0x2a:
+		return m.memCycle(imm+1, MEMR|WO)
+	case opc == 0x3a:
+		return m.memCycle(imm, MEMR|WO)
+	case opc >= 0x40 && opc < 0xc0 && opc&0x07 == 0x06:
+		return m.memCycle(s.hl, MEMR|WO)
+	}
+
+	return busCycle{
+		addr:   s.pc,
+		data:   s.opc,
+		status: MEMR | M1 | WO,
+	}
+}
@@ -0,0 +1,102 @@
+package altair
+
+import (
+	"github.com/danmrichards/go8080/devices/mc6850"
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// Conventional base ports of the serial boards, as expected by Altair BASIC
+// and the MITS monitors.
+const (
+	SIOPort    = 0x00
+	TwoSIOPort = 0x10
+)
+
+// sioInputReady is the active low 88-SIO status bit clear when a character
+// has been received. The output ready bit, 80H, is also active low and always
+// reads as ready.
+const sioInputReady = 0x01
+
+type (
+	// SIO is the single port MITS 88-SIO board, with its status register at
+	// the base port and data register at the next.
+	//
+	// Characters are transferred as soon as they are written or arrive; the
+	// error bits are never set and the interrupt enables written to the
+	// control register are ignored.
+	SIO struct {
+		line   *serial.Line
+		rx     byte
+		rxFull bool
+	}
+
+	// TwoSIO is the MITS 88-2SIO board: two Motorola 6850 ACIAs, the first
+	// at the base port and the second two ports above.
+	TwoSIO struct {
+		A, B *mc6850.ACIA
+	}
+)
+
+// NewSIO returns an 88-SIO connected to the given line.
+func NewSIO(line *serial.Line) *SIO {
+	return &SIO{line: line}
+}
+
+// Ports returns the number of ports decoded by the board.
+func (s *SIO) Ports() int {
+	return 2
+}
+
+// In reads the status or data register.
+func (s *SIO) In(offset byte) byte {
+	if offset == 1 {
+		s.rxFull = false
+		return s.rx
+	}
+
+	if !s.rxFull {
+		if b, ok := s.line.Receive(); ok {
+			s.rx, s.rxFull = b, true
+		}
+	}
+	if s.rxFull {
+		return 0
+	}
+
+	return sioInputReady
+}
+
+// Out writes the control or data register.
+func (s *SIO) Out(offset, v byte) {
+	if offset == 1 {
+		s.line.Transmit(v)
+	}
+}
+
+// NewTwoSIO returns an 88-2SIO whose ports are connected to lines a and b.
+func NewTwoSIO(a, b *serial.Line) *TwoSIO {
+	return &TwoSIO{A: mc6850.New(a), B: mc6850.New(b)}
+}
+
+// Ports returns the number of ports decoded by the board.
+func (t *TwoSIO) Ports() int {
+	return 4
+}
+
+// In reads a register of either ACIA.
+func (t *TwoSIO) In(offset byte) byte {
+	return t.acia(offset).In(offset & 1)
+}
+
+// Out writes a register of either ACIA.
+func (t *TwoSIO) Out(offset, v byte) {
+	t.acia(offset).Out(offset&1, v)
+}
+
+func (t *TwoSIO) acia(offset byte) *mc6850.ACIA {
+	if offset >= 2 {
+		return t.B
+	}
+
+	return t.A
+}
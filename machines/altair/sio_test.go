@@ -0,0 +1,67 @@
+package altair
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// echo runs program until all of input has been received and echoed back
+// through line.
+func echo(t *testing.T, m *Machine, line *serial.Line, program []byte) {
+	t.Helper()
+
+	m.Load(0, program)
+	m.Run()
+	for i := 0; !line.Exhausted(); i++ {
+		if i == 10000 {
+			t.Fatal("input not consumed")
+		}
+		if err := m.Execute(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSIO(t *testing.T) {
+	var out bytes.Buffer
+	line := serial.NewLine(strings.NewReader("HELLO\r"), &out)
+	m := newMachine(t, WithDevice(SIOPort, NewSIO(line)))
+
+	// LOOP: IN 0; RRC; JC LOOP; IN 1; OUT 1; JMP LOOP
+	echo(t, m, line, []byte{
+		0xdb, 0x00, 0x0f, 0xda, 0x00, 0x00,
+		0xdb, 0x01, 0xd3, 0x01, 0xc3, 0x00, 0x00,
+	})
+	if got := out.String(); got != "HELLO\r" {
+		t.Errorf("output = %q, want %q", got, "HELLO\r")
+	}
+}
+
+func TestTwoSIO(t *testing.T) {
+	var out bytes.Buffer
+	line := serial.NewLine(strings.NewReader("HELLO\r"), &out)
+	m := newMachine(t, WithDevice(TwoSIOPort, NewTwoSIO(serial.NewLine(nil, nil), line)))
+
+	// Reset the second ACIA and set 8N1, divide by 16, then echo.
+	//
+	//	MVI A,03H; OUT 12H; MVI A,15H; OUT 12H
+	// LOOP: IN 12H; RRC; JNC LOOP; IN 13H; OUT 13H; JMP LOOP
+	echo(t, m, line, []byte{
+		0x3e, 0x03, 0xd3, 0x12, 0x3e, 0x15, 0xd3, 0x12,
+		0xdb, 0x12, 0x0f, 0xd2, 0x08, 0x00,
+		0xdb, 0x13, 0xd3, 0x13, 0xc3, 0x08, 0x00,
+	})
+	if got := out.String(); got != "HELLO\r" {
+		t.Errorf("output = %q, want %q", got, "HELLO\r")
+	}
+}
+
+func TestDeviceOverlap(t *testing.T) {
+	line := serial.NewLine(nil, nil)
+	if _, err := New(WithDevice(0x10, NewSIO(line)), WithDevice(0x11, NewSIO(line))); err == nil {
+		t.Error("New succeeded with overlapping devices")
+	}
+}
@@ -0,0 +1,152 @@
+// Package imsai emulates the IMSAI 8080.
+//
+// The IMSAI is an S-100 machine built on the Altair's design, and is
+// operated through its front panel in the same way as the machines in
+// package altair. It adds a programmed output port, whose eight lights sit
+// above the data lights, and a RUN light; the sense switches are read from
+// port 0FFH as on the Altair.
+package imsai
+
+import (
+	"github.com/danmrichards/go8080/bus"
+	"github.com/danmrichards/go8080/devices/i8251"
+	"github.com/danmrichards/go8080/devices/serial"
+	"github.com/danmrichards/go8080/machines/altair"
+)
+
+const (
+	// ProgrammedOutputPort is the output port driving the programmed output
+	// lights.
+	ProgrammedOutputPort = 0xff
+
+	// MPUAROMAddr is the address of the PROM sockets on the MPU-A processor
+	// board, and MPUAROMSize the most they hold.
+	MPUAROMAddr = 0xd800
+	MPUAROMSize = 0x800
+
+	// SIO2Port is the conventional base port of the SIO-2 serial board.
+	SIO2Port = 0x02
+)
+
+type (
+	// Machine is an IMSAI 8080.
+	//
+	// The panel's EXAMINE, DEPOSIT, RUN, STOP, SINGLE STEP and RESET switches
+	// work as the Altair's do, so they are the Altair's operations; only the
+	// programmed output and RUN lights are added. The rest of the IMSAI
+	// panel is not emulated. EXT CLR pulses the S-100 clear line, which none
+	// of the emulated boards responds to, so it would do nothing. The two AUX
+	// switches are not wired to anything on a stock machine. The HOLD light
+	// is never lit, like the Altair's HLDA, as no DMA boards are fitted.
+	Machine struct {
+		*altair.Machine
+
+		po *programmedOutput
+	}
+
+	// Option is a functional option that modifies a field on the machine. The
+	// options of package altair apply unchanged.
+	Option = altair.Option
+
+	// Lights is the state of the front panel lights.
+	Lights struct {
+		altair.Lights
+
+		// Output has a bit set for each lit programmed output light.
+		Output byte
+
+		// Run is lit while the machine is running.
+		Run bool
+	}
+
+	// programmedOutput is the latch behind the programmed output lights.
+	programmedOutput struct {
+		v byte
+	}
+
+	// SIO2 is the IMSAI SIO-2 serial board: two 8251 USARTs, each with its
+	// data register at the lower port and control register at the next.
+	//
+	// The board's interrupt and carrier detect control port is not
+	// emulated.
+	SIO2 struct {
+		A, B *i8251.USART
+	}
+)
+
+// WithMPUA fits the MPU-A processor board with the given PROM contents,
+// such as a monitor. Anything beyond MPUAROMSize is ignored.
+func WithMPUA(rom []byte) Option {
+	if len(rom) > MPUAROMSize {
+		rom = rom[:MPUAROMSize]
+	}
+
+	return altair.WithROM(MPUAROMAddr, rom)
+}
+
+// New returns a stopped IMSAI 8080 with cleared memory. It is an error for
+// the ports of two devices to overlap, including the programmed output
+// port.
+func New(opts ...Option) (*Machine, error) {
+	// The lights are lit by zero bits and are off at power on.
+	po := &programmedOutput{v: 0xff}
+
+	am, err := altair.New(append([]Option{altair.WithDevice(ProgrammedOutputPort, po)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Machine{Machine: am, po: po}, nil
+}
+
+// Lights returns the state of the front panel lights.
+func (m *Machine) Lights() Lights {
+	return Lights{
+		Lights: m.Machine.Lights(),
+		Output: ^m.po.v,
+		Run:    m.Running(),
+	}
+}
+
+// Ports returns the number of ports decoded by the latch.
+func (p *programmedOutput) Ports() int {
+	return 1
+}
+
+// In returns a floating bus; reads of the port return the sense switches.
+func (p *programmedOutput) In(offset byte) byte {
+	return bus.Floating
+}
+
+// Out latches the value for the lights.
+func (p *programmedOutput) Out(offset, v byte) {
+	p.v = v
+}
+
+// NewSIO2 returns an SIO-2 whose channels are connected to lines a and b.
+func NewSIO2(a, b *serial.Line) *SIO2 {
+	return &SIO2{A: i8251.New(a), B: i8251.New(b)}
+}
+
+// Ports returns the number of ports decoded by the board.
+func (s *SIO2) Ports() int {
+	return 4
+}
+
+// In reads a register of either USART.
+func (s *SIO2) In(offset byte) byte {
+	return s.usart(offset).In(offset & 1)
+}
+
+// Out writes a register of either USART.
+func (s *SIO2) Out(offset, v byte) {
+	s.usart(offset).Out(offset&1, v)
+}
+
+func (s *SIO2) usart(offset byte) *i8251.USART {
+	if offset >= 2 {
+		return s.B
+	}
+
+	return s.A
+}
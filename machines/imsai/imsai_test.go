@@ -0,0 +1,85 @@
+package imsai
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/devices/serial"
+	"github.com/danmrichards/go8080/machines/altair"
+)
+
+func newMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	m, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestProgrammedOutput(t *testing.T) {
+	// IN 0FFH; CMA; OUT 0FFH; HLT, from the monitor PROM.
+	m := newMachine(t, WithMPUA([]byte{0xdb, 0xff, 0x2f, 0xd3, 0xff, 0x76}))
+	if l := m.Lights(); l.Output != 0 || l.Run {
+		t.Errorf("lights at power on = %+v, want all programmed output lights off and RUN off", l)
+	}
+
+	m.SetSwitches(MPUAROMAddr)
+	if err := m.Examine(); err != nil {
+		t.Fatal(err)
+	}
+	m.SetSwitches(0x5a00)
+	m.Run()
+	if !m.Lights().Run {
+		t.Error("RUN not lit while running")
+	}
+	if err := m.Execute(100); err != nil {
+		t.Fatal(err)
+	}
+
+	// The complemented switches are written out, lighting the set switches.
+	l := m.Lights()
+	if l.Output != 0x5a {
+		t.Errorf("programmed output = %02x, want 5a", l.Output)
+	}
+	if l.Status&altair.HLTA == 0 {
+		t.Errorf("status = %v, want HLTA lit", l.Status)
+	}
+
+	// The PROM cannot be written.
+	m.Memory().Write(MPUAROMAddr, 0)
+	if got := m.Memory().Read(MPUAROMAddr); got != 0xdb {
+		t.Errorf("PROM = %02x, want db", got)
+	}
+}
+
+func TestSIO2(t *testing.T) {
+	var out bytes.Buffer
+	line := serial.NewLine(strings.NewReader("HELLO\r"), &out)
+	m := newMachine(t, altair.WithDevice(SIO2Port, NewSIO2(line, serial.NewLine(nil, nil))))
+
+	// Set channel A to 8N1 with a x16 clock, enable it, then echo.
+	//
+	//	MVI A,4EH; OUT 03H; MVI A,37H; OUT 03H
+	// LOOP: IN 03H; ANI 02H; JZ LOOP; IN 02H; OUT 02H; JMP LOOP
+	m.Load(0, []byte{
+		0x3e, 0x4e, 0xd3, 0x03, 0x3e, 0x37, 0xd3, 0x03,
+		0xdb, 0x03, 0xe6, 0x02, 0xca, 0x08, 0x00,
+		0xdb, 0x02, 0xd3, 0x02, 0xc3, 0x08, 0x00,
+	})
+	m.Run()
+	for i := 0; !line.Exhausted(); i++ {
+		if i == 10000 {
+			t.Fatal("input not consumed")
+		}
+		if err := m.Execute(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := out.String(); got != "HELLO\r" {
+		t.Errorf("output = %q, want %q", got, "HELLO\r")
+	}
+}
@@ -0,0 +1,66 @@
+package invaders
+
+// Key is a button or switch on the cabinet.
+type Key int
+
+// Cabinet controls.
+const (
+	Coin Key = iota
+	P1Start
+	P2Start
+	P1Fire
+	P1Left
+	P1Right
+	P2Fire
+	P2Left
+	P2Right
+	Tilt
+)
+
+// bit returns the input port and bit of the key.
+func (k Key) bit() (port int, mask byte) {
+	switch k {
+	case Coin:
+		return 1, 0x01
+	case P2Start:
+		return 1, 0x02
+	case P1Start:
+		return 1, 0x04
+	case P1Fire:
+		return 1, 0x10
+	case P1Left:
+		return 1, 0x20
+	case P1Right:
+		return 1, 0x40
+	case Tilt:
+		return 2, 0x04
+	case P2Fire:
+		return 2, 0x10
+	case P2Left:
+		return 2, 0x20
+	case P2Right:
+		return 2, 0x40
+	}
+
+	return 0, 0
+}
+
+// Press presses the given key, which stays down until released.
+func (m *Machine) Press(k Key) {
+	switch port, mask := k.bit(); port {
+	case 1:
+		m.in1 |= mask
+	case 2:
+		m.in2 |= mask
+	}
+}
+
+// Release releases the given key.
+func (m *Machine) Release(k Key) {
+	switch port, mask := k.bit(); port {
+	case 1:
+		m.in1 &^= mask
+	case 2:
+		m.in2 &^= mask
+	}
+}
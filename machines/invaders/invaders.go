@@ -0,0 +1,280 @@
+// Package invaders emulates the Taito/Midway Space Invaders arcade board.
+//
+// The board pairs an Intel 8080 at 2MHz with 8K of ROM, 1K of work RAM and
+// 7K of video RAM, a 16 bit shift register used to draw sprites at any pixel
+// offset, and two interrupts per frame from the video hardware. The monitor
+// is mounted on its side, so the 256x224 frame buffer is displayed rotated as
+// a 224x256 picture with a coloured overlay.
+package invaders
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/machines/mw8080"
+	"github.com/danmrichards/go8080/romset"
+)
+
+const (
+	// Width and Height of the displayed picture, after rotation.
+	Width  = 224
+	Height = 256
+
+	// ROMSize is the size of the program ROM.
+	ROMSize = 0x2000
+
+	// CPU clock rate and frame rate.
+	clockRate = 2000000
+	frameRate = 60
+
+	// CPU cycles per frame, and the interrupts raised at the middle and end
+	// of each frame.
+	cyclesPerFrame = clockRate / frameRate
+	midScreenRST   = 0x08 // RST 1
+	vblankRST      = 0x10 // RST 2
+
+	// Memory map.
+	ramStart   = 0x2000
+	videoStart = 0x2400
+	memSize    = 0x4000
+)
+
+// ROMFiles are the names of the four 2K ROMs of the MAME "invaders" set, in
+// the order they appear in memory.
+var ROMFiles = []string{"invaders.h", "invaders.g", "invaders.f", "invaders.e"}
+
+type (
+	// Machine is a Space Invaders board.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		// Shift register and its result offset.
+		shift       uint16
+		shiftOffset byte
+
+		// Input port values, active high.
+		in1, in2 byte
+
+		// Values last written to the sound ports.
+		sound3, sound5 byte
+
+		// Sound event handler, and the mixer and its samples if audio is
+		// enabled.
+		onSound func(SoundEvent)
+		mixer   *mixer
+		samples map[Sound][]int16
+
+		// CPU cycles since power on, accumulated from the 32 bit counter of
+		// the CPU.
+		totalCycles uint64
+		lastCycles  uint32
+
+		// Cycle count at the start of the current frame.
+		frameStart uint32
+
+		screen  *image.RGBA
+		overlay bool
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the 16K address space of the board. The upper 48K mirrors
+	// it, and writes to ROM are ignored.
+	memory []byte
+)
+
+// Read returns the value from memory at the given address.
+func (m memory) Read(addr uint16) byte {
+	return m[addr%memSize]
+}
+
+// ReadAll returns the full memory contents.
+func (m memory) ReadAll() []byte {
+	return m
+}
+
+// Write writes the value v into memory at the given address.
+func (m memory) Write(addr uint16, v byte) {
+	if addr %= memSize; addr >= ramStart {
+		m[addr] = v
+	}
+}
+
+// WithShips sets the DIP switches for the number of ships per game, 3 to 6.
+func WithShips(n int) Option {
+	return func(m *Machine) {
+		if n >= 3 && n <= 6 {
+			m.in2 = m.in2&^0x03 | byte(n-3)
+		}
+	}
+}
+
+// WithEarlyBonus sets the DIP switch which awards the extra ship at 1000
+// points instead of 1500.
+func WithEarlyBonus() Option {
+	return func(m *Machine) {
+		m.in2 |= 0x08
+	}
+}
+
+// WithoutCoinInfo sets the DIP switch which hides the coin information on
+// the attract screen.
+func WithoutCoinInfo() Option {
+	return func(m *Machine) {
+		m.in2 |= 0x80
+	}
+}
+
+// WithoutOverlay renders the screen in black and white, without the coloured
+// overlay.
+func WithoutOverlay() Option {
+	return func(m *Machine) {
+		m.overlay = false
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// LoadROM reads the program ROM from the files named in ROMFiles in a
+// directory or zip archive, checking them against the checksums of the MAME
+// set.
+func LoadROM(path string) ([]byte, error) {
+	res, err := romset.Load(path, mw8080.Invaders.ROMs)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	rom := make([]byte, ROMSize)
+	for _, r := range mw8080.Invaders.ROMs {
+		copy(rom[r.Addr:], res.Files[r.Name])
+	}
+
+	return rom, nil
+}
+
+// New returns a board running the given program ROM, which must be 8K.
+func New(rom []byte, opts ...Option) (*Machine, error) {
+	if len(rom) != ROMSize {
+		return nil, fmt.Errorf("invaders: ROM is %d bytes, want %d", len(rom), ROMSize)
+	}
+
+	m := &Machine{
+		mem:     make(memory, memSize),
+		in1:     0x08,
+		overlay: true,
+	}
+	copy(m.mem, rom)
+
+	for _, o := range opts {
+		o(m)
+	}
+	if m.mixer != nil {
+		for s := range m.mixer.samples {
+			if smp, ok := m.samples[Sound(s)]; ok {
+				m.mixer.samples[s] = smp
+			} else {
+				m.mixer.samples[s] = synth(Sound(s), m.mixer.rate)
+			}
+		}
+	}
+
+	m.cpuOpts = append(m.cpuOpts, go8080.WithInput(m.input), go8080.WithOutput(m.output))
+	m.cpu = go8080.NewIntel8080(m.mem, m.cpuOpts...)
+	m.screen = image.NewRGBA(image.Rect(0, 0, Width, Height))
+
+	return m, nil
+}
+
+// CPU returns the CPU of the machine.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the memory of the machine.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// Frame runs the machine for one frame, a sixtieth of a second, raising the
+// mid-screen interrupt half way through and the vertical blank interrupt at
+// the end.
+func (m *Machine) Frame() error {
+	if err := m.run(cyclesPerFrame / 2); err != nil {
+		return err
+	}
+	m.cpu.Interrupt(midScreenRST)
+
+	if err := m.run(cyclesPerFrame); err != nil {
+		return err
+	}
+	m.cpu.Interrupt(vblankRST)
+
+	m.frameStart += cyclesPerFrame
+	if m.mixer != nil {
+		m.updateAudio()
+	}
+
+	return nil
+}
+
+// run runs the CPU until the given number of cycles into the frame.
+func (m *Machine) run(cycles uint32) error {
+	for m.cpu.Cycles()-m.frameStart < cycles {
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// input handles the IN instruction.
+func (m *Machine) input(port byte) byte {
+	switch port {
+	case 0:
+		// Unused by the game, but wired with these bits set.
+		return 0x0e
+	case 1:
+		return m.in1
+	case 2:
+		return m.in2
+	case 3:
+		return byte(m.shift >> (8 - m.shiftOffset))
+	}
+
+	return 0
+}
+
+// output handles the OUT instruction.
+func (m *Machine) output(port byte) {
+	v := m.cpu.Accumulator()
+
+	switch port {
+	case 2:
+		m.shiftOffset = v & 0x07
+	case 3:
+		m.soundPort(m.sound3, v, port3Sounds)
+		m.sound3 = v
+	case 4:
+		m.shift = m.shift>>8 | uint16(v)<<8
+	case 5:
+		m.soundPort(m.sound5, v, port5Sounds)
+		m.sound5 = v
+	case 6:
+		// Watchdog, not emulated.
+	}
+}
@@ -0,0 +1,160 @@
+package invaders
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/asm"
+)
+
+// testROM is a stand in for the game which counts interrupts, exercises the
+// shift register and copies input port 1 to RAM.
+const testROM = `
+	ORG	0
+	JMP	START
+
+	ORG	8
+	JMP	RST1
+
+	ORG	10H
+	JMP	RST2
+
+RST1:	PUSH	PSW
+	LDA	2001H
+	INR	A
+	STA	2001H
+	POP	PSW
+	EI
+	RET
+
+RST2:	PUSH	PSW
+	LDA	2002H
+	INR	A
+	STA	2002H
+	POP	PSW
+	EI
+	RET
+
+START:	LXI	SP,2400H
+	MVI	A,0AAH
+	OUT	4
+	MVI	A,0FFH
+	OUT	4
+	MVI	A,3
+	OUT	2
+	IN	3
+	STA	2000H
+	EI
+LOOP:	IN	1
+	STA	2003H
+	JMP	LOOP
+`
+
+func newTestMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	prog, err := asm.Assemble("rom.asm", strings.NewReader(testROM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := make([]byte, ROMSize)
+	copy(rom, prog.Binary())
+
+	m, err := New(rom, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestMachine(t *testing.T) {
+	m := newTestMachine(t)
+
+	m.Press(Coin)
+	for i := 0; i < 3; i++ {
+		if err := m.Frame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// FFAAH shifted left by 3 leaves FDH in the top byte.
+	if got := m.mem[0x2000]; got != 0xfd {
+		t.Errorf("shift register result = %02x, want fd", got)
+	}
+	if got := m.mem[0x2001]; got != 3 {
+		t.Errorf("mid-screen interrupts = %d, want 3", got)
+	}
+	// The last vertical blank interrupt is handled at the start of the next
+	// frame.
+	if got := m.mem[0x2002]; got != 2 {
+		t.Errorf("vblank interrupts = %d, want 2", got)
+	}
+	if got := m.mem[0x2003]; got != 0x09 {
+		t.Errorf("port 1 = %02x, want 09", got)
+	}
+
+	m.Release(Coin)
+	m.Press(P1Fire)
+	if err := m.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.mem[0x2003]; got != 0x18 {
+		t.Errorf("port 1 = %02x, want 18", got)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := newTestMachine(t)
+
+	m.mem.Write(0x0000, 0x55)
+	if got := m.mem.Read(0x0000); got != 0xc3 {
+		t.Errorf("ROM after write = %02x, want c3", got)
+	}
+	m.mem.Write(0x6000, 0x55)
+	if got := m.mem.Read(0x2000); got != 0x55 {
+		t.Errorf("RAM written through mirror = %02x, want 55", got)
+	}
+}
+
+func TestDIPSwitches(t *testing.T) {
+	m := newTestMachine(t, WithShips(5), WithEarlyBonus(), WithoutCoinInfo())
+	if got := m.input(2); got != 0x8a {
+		t.Errorf("port 2 = %02x, want 8a", got)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	m := newTestMachine(t)
+
+	m.mem[videoStart] = 0x01
+	m.mem[videoStart+32*100+5] = 0x01
+	m.mem[videoStart+32*50+27] = 0x01
+
+	img := m.Screen()
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Fatalf("screen is %dx%d, want %dx%d", b.Dx(), b.Dy(), Width, Height)
+	}
+
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 255, white},
+		{0, 254, black},
+		{100, 215, green},
+		{50, 39, red},
+	}
+	for _, tt := range tests {
+		if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel %d,%d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	m = newTestMachine(t, WithoutOverlay())
+	m.mem[videoStart+32*50+27] = 0x01
+	if got := m.Screen().RGBAAt(50, 39); got != white {
+		t.Errorf("pixel without overlay = %v, want %v", got, white)
+	}
+}
@@ -0,0 +1,252 @@
+package invaders
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/danmrichards/go8080/wav"
+)
+
+// Sound is one of the discrete sound circuits on the board.
+type Sound int
+
+// Sounds, in the order of the MAME sample files 0.wav to 9.wav.
+const (
+	UFO Sound = iota
+	Shot
+	PlayerDeath
+	InvaderDeath
+	Fleet1
+	Fleet2
+	Fleet3
+	Fleet4
+	UFOHit
+	ExtraShip
+	numSounds
+)
+
+var soundNames = [numSounds]string{
+	"UFO", "Shot", "PlayerDeath", "InvaderDeath",
+	"Fleet1", "Fleet2", "Fleet3", "Fleet4", "UFOHit", "ExtraShip",
+}
+
+// String returns the name of the sound.
+func (s Sound) String() string {
+	if s < 0 || s >= numSounds {
+		return "Sound(" + strconv.Itoa(int(s)) + ")"
+	}
+
+	return soundNames[s]
+}
+
+// port3Sounds and port5Sounds give the sound triggered by each bit of the
+// sound ports. Bit 5 of port 3 enables the amplifier and bit 5 of port 5
+// flips the screen of cocktail cabinets.
+var (
+	port3Sounds = []Sound{UFO, Shot, PlayerDeath, InvaderDeath, ExtraShip}
+	port5Sounds = []Sound{Fleet1, Fleet2, Fleet3, Fleet4, UFOHit}
+)
+
+// ampEnable is the bit of port 3 which turns the sound amplifier on.
+const ampEnable = 0x20
+
+type (
+	// SoundEvent reports a sound being triggered or released by the game.
+	SoundEvent struct {
+		Sound Sound
+
+		// On is true when the trigger bit is set and false when cleared.
+		On bool
+
+		// Cycle is the CPU cycle count since power on at which the event
+		// occurred.
+		Cycle uint64
+	}
+
+	// mixer plays the samples of the triggered sounds into a PCM stream.
+	mixer struct {
+		rate    int
+		samples [numSounds][]int16
+
+		// Play position of each sound, or -1 if it is not playing.
+		pos [numSounds]int
+
+		// Samples generated so far, and those not yet collected.
+		generated uint64
+		out       []int16
+	}
+)
+
+// WithSoundEvents calls fn for each change of the sound trigger bits.
+func WithSoundEvents(fn func(SoundEvent)) Option {
+	return func(m *Machine) {
+		m.onSound = fn
+	}
+}
+
+// WithAudio mixes the sounds into a 16 bit mono PCM stream at the given
+// sample rate, which is collected with Audio. Unless samples are supplied
+// with WithSamples, simple synthesized sounds are used.
+func WithAudio(rate int) Option {
+	return func(m *Machine) {
+		m.mixer = &mixer{rate: rate}
+		for s := range m.mixer.pos {
+			m.mixer.pos[s] = -1
+		}
+	}
+}
+
+// WithSamples replaces the synthesized sounds with the given samples, which
+// must be at the rate passed to WithAudio.
+func WithSamples(samples map[Sound][]int16) Option {
+	return func(m *Machine) {
+		m.samples = samples
+	}
+}
+
+// LoadSamples reads the MAME sample files 0.wav to 9.wav from dir, in the
+// order of the Sound constants, and resamples them to the given rate. Missing
+// files are skipped.
+func LoadSamples(dir string, rate int) (map[Sound][]int16, error) {
+	samples := make(map[Sound][]int16)
+	for s := Sound(0); s < numSounds; s++ {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(int(s))+".wav"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pcm, r, err := wav.Read(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		samples[s] = resample(pcm, r, rate)
+	}
+
+	return samples, nil
+}
+
+// resample converts samples from one rate to another by linear
+// interpolation.
+func resample(in []int16, from, to int) []int16 {
+	if from == to || len(in) == 0 {
+		return in
+	}
+
+	out := make([]int16, int(int64(len(in))*int64(to)/int64(from)))
+	for i := range out {
+		p := float64(i) * float64(from) / float64(to)
+		j := int(p)
+		if j+1 >= len(in) {
+			out[i] = in[len(in)-1]
+			continue
+		}
+		f := p - float64(j)
+		out[i] = int16(float64(in[j])*(1-f) + float64(in[j+1])*f)
+	}
+
+	return out
+}
+
+// Audio returns the PCM samples generated since the last call.
+func (m *Machine) Audio() []int16 {
+	if m.mixer == nil {
+		return nil
+	}
+	m.updateAudio()
+
+	out := m.mixer.out
+	m.mixer.out = nil
+
+	return out
+}
+
+// cycles returns the CPU cycle count since power on. The CPU counter is 32
+// bits, so it is accumulated here to avoid wrapping.
+func (m *Machine) cycles() uint64 {
+	c := m.cpu.Cycles()
+	m.totalCycles += uint64(c - m.lastCycles)
+	m.lastCycles = c
+
+	return m.totalCycles
+}
+
+// updateAudio generates the samples due up to the current cycle.
+func (m *Machine) updateAudio() {
+	mx := m.mixer
+	due := m.cycles() * uint64(mx.rate) / clockRate
+	for ; mx.generated < due; mx.generated++ {
+		mx.out = append(mx.out, mx.next(m.sound3&ampEnable != 0))
+	}
+}
+
+// soundPort handles a write to a sound port, reporting each changed trigger
+// bit and starting or stopping the sounds.
+func (m *Machine) soundPort(old, v byte, sounds []Sound) {
+	if m.mixer != nil {
+		m.updateAudio()
+	}
+
+	for bit, s := range sounds {
+		mask := byte(1) << uint(bit)
+		if (old^v)&mask == 0 {
+			continue
+		}
+		on := v&mask != 0
+
+		if m.onSound != nil {
+			m.onSound(SoundEvent{Sound: s, On: on, Cycle: m.cycles()})
+		}
+		if m.mixer != nil {
+			m.mixer.trigger(s, on)
+		}
+	}
+}
+
+// trigger starts a sound when its trigger bit is set. The UFO sound loops
+// until its bit is cleared; the others play to the end once triggered.
+func (mx *mixer) trigger(s Sound, on bool) {
+	switch {
+	case on:
+		mx.pos[s] = 0
+	case s == UFO:
+		mx.pos[s] = -1
+	}
+}
+
+// next returns the next sample of the mix.
+func (mx *mixer) next(amp bool) int16 {
+	var sum int
+	for s, p := range mx.pos {
+		if p < 0 {
+			continue
+		}
+
+		smp := mx.samples[s]
+		if p >= len(smp) {
+			if s != int(UFO) || len(smp) == 0 {
+				mx.pos[s] = -1
+				continue
+			}
+			p = 0
+		}
+		sum += int(smp[p])
+		mx.pos[s] = p + 1
+	}
+
+	if !amp {
+		return 0
+	}
+	switch {
+	case sum > 32767:
+		sum = 32767
+	case sum < -32768:
+		sum = -32768
+	}
+
+	return int16(sum)
+}
@@ -0,0 +1,109 @@
+package invaders
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/asm"
+)
+
+// newSoundMachine returns a machine running a program which writes the given
+// values to sound ports, then loops.
+func newSoundMachine(t *testing.T, writes [][2]byte, opts ...Option) *Machine {
+	t.Helper()
+
+	var src strings.Builder
+	for _, w := range writes {
+		fmt.Fprintf(&src, "\tMVI\tA,0%02XH\n\tOUT\t%d\n", w[1], w[0])
+	}
+	src.WriteString("LOOP:\tJMP\tLOOP\n")
+
+	prog, err := asm.Assemble("sound.asm", strings.NewReader(src.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := make([]byte, ROMSize)
+	copy(rom, prog.Binary())
+
+	m, err := New(rom, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestSoundEvents(t *testing.T) {
+	var got []SoundEvent
+	m := newSoundMachine(t, [][2]byte{
+		{3, 0x21}, // Amplifier and UFO on.
+		{3, 0x23}, // Shot.
+		{3, 0x20}, // UFO and shot released.
+		{5, 0x01}, // Fleet 1.
+	}, WithSoundEvents(func(e SoundEvent) {
+		got = append(got, e)
+	}))
+	if err := m.Frame(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sounds []Sound
+	var ons []bool
+	for i, e := range got {
+		sounds = append(sounds, e.Sound)
+		ons = append(ons, e.On)
+		if i > 0 && e.Cycle < got[i-1].Cycle {
+			t.Errorf("event %d at cycle %d is before the previous event", i, e.Cycle)
+		}
+	}
+	if want := []Sound{UFO, Shot, UFO, Shot, Fleet1}; !reflect.DeepEqual(sounds, want) {
+		t.Errorf("sounds = %v, want %v", sounds, want)
+	}
+	if want := []bool{true, true, false, false, true}; !reflect.DeepEqual(ons, want) {
+		t.Errorf("on = %v, want %v", ons, want)
+	}
+}
+
+func TestAudio(t *testing.T) {
+	samples := map[Sound][]int16{Shot: {1000, 2000, 3000}}
+
+	m := newSoundMachine(t, [][2]byte{{3, 0x22}}, WithAudio(6000), WithSamples(samples))
+	if err := m.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	pcm := m.Audio()
+	if len(pcm) != 100 {
+		t.Fatalf("got %d samples for one frame, want 100", len(pcm))
+	}
+	if want := []int16{1000, 2000, 3000, 0}; !reflect.DeepEqual(pcm[:4], want) {
+		t.Errorf("samples = %v, want %v", pcm[:4], want)
+	}
+	if len(m.Audio()) != 0 {
+		t.Error("Audio returned samples twice")
+	}
+
+	// Without the amplifier enabled the board is silent.
+	m = newSoundMachine(t, [][2]byte{{3, 0x02}}, WithAudio(6000), WithSamples(samples))
+	if err := m.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range m.Audio() {
+		if s != 0 {
+			t.Fatalf("sample %d = %d with the amplifier off, want 0", i, s)
+		}
+	}
+}
+
+func TestSynth(t *testing.T) {
+	for s := Sound(0); s < numSounds; s++ {
+		a, b := synth(s, 22050), synth(s, 22050)
+		if len(a) == 0 {
+			t.Errorf("%v: no samples", s)
+		}
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%v: synthesized sound differs between calls", s)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package invaders
+
+import (
+	"math"
+)
+
+// Synthesized stand ins for the sounds, roughly following the discrete
+// circuits: tones for the UFO, fleet and extra ship, filtered noise for the
+// explosions. They are deterministic so that audio from headless runs can be
+// compared between runs.
+
+// volume is the peak amplitude of a synthesized sound, leaving headroom for
+// several to play at once.
+const volume = 8000
+
+// synth returns the synthesized sound s at the given sample rate.
+func synth(s Sound, rate int) []int16 {
+	switch s {
+	case UFO:
+		// A siren warbling between 500 and 900Hz, one cycle of which is
+		// looped while the UFO is on screen.
+		return tone(rate, 0.1, func(t float64) float64 {
+			return 700 + 200*math.Sin(2*math.Pi*10*t)
+		}, nil)
+	case Shot:
+		return noise(rate, 0.2, 0x1234)
+	case PlayerDeath:
+		return noise(rate, 1.0, 0x2345)
+	case InvaderDeath:
+		return noise(rate, 0.25, 0x3456)
+	case Fleet1, Fleet2, Fleet3, Fleet4:
+		// Four descending notes of the march.
+		f := []float64{98, 87, 78, 73}[s-Fleet1]
+		return tone(rate, 0.1, func(float64) float64 { return f }, decay(0.1))
+	case UFOHit:
+		return tone(rate, 1.0, func(t float64) float64 {
+			return 1200 - 900*t
+		}, decay(1.0))
+	case ExtraShip:
+		return tone(rate, 0.5, func(float64) float64 { return 1000 }, nil)
+	}
+
+	return nil
+}
+
+// decay returns an envelope falling linearly to silence over d seconds.
+func decay(d float64) func(float64) float64 {
+	return func(t float64) float64 {
+		return 1 - t/d
+	}
+}
+
+// tone returns d seconds of a square wave with frequency freq(t) Hz and an
+// optional envelope.
+func tone(rate int, d float64, freq, env func(float64) float64) []int16 {
+	out := make([]int16, int(d*float64(rate)))
+
+	var phase float64
+	for i := range out {
+		t := float64(i) / float64(rate)
+		phase += freq(t) / float64(rate)
+
+		a := float64(volume)
+		if env != nil {
+			a *= env(t)
+		}
+		if phase-math.Floor(phase) >= 0.5 {
+			a = -a
+		}
+		out[i] = int16(a)
+	}
+
+	return out
+}
+
+// noise returns d seconds of decaying white noise from a 16 bit linear
+// feedback shift register with the given seed.
+func noise(rate int, d float64, seed uint16) []int16 {
+	out := make([]int16, int(d*float64(rate)))
+
+	lfsr := seed
+	env := decay(d)
+	for i := range out {
+		bit := (lfsr ^ lfsr>>2 ^ lfsr>>3 ^ lfsr>>5) & 1
+		lfsr = lfsr>>1 | bit<<15
+
+		a := float64(volume) * env(float64(i)/float64(rate))
+		if lfsr&1 == 0 {
+			a = -a
+		}
+		out[i] = int16(a)
+	}
+
+	return out
+}
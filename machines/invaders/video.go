@@ -0,0 +1,57 @@
+package invaders
+
+import (
+	"image"
+	"image/color"
+)
+
+// Colours of the cellophane overlay on the monitor.
+var (
+	black = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	red   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	green = color.RGBA{0x00, 0xff, 0x00, 0xff}
+)
+
+// overlayColour returns the colour of a lit pixel at x, y on the rotated
+// screen. The top band, where the UFO flies, is red; the band around the
+// player's ship and shields is green, as are the reserve ships but not the
+// credit count below them.
+func overlayColour(x, y int) color.RGBA {
+	switch {
+	case y >= 32 && y < 64:
+		return red
+	case y >= 184 && y < 240:
+		return green
+	case y >= 240 && x >= 16 && x < 134:
+		return green
+	}
+
+	return white
+}
+
+// Screen renders the video RAM and returns the picture as it appears on the
+// monitor. The returned image is reused by later calls.
+func (m *Machine) Screen() *image.RGBA {
+	vram := m.mem[videoStart:memSize]
+
+	// Each byte holds eight pixels of a column of the unrotated frame,
+	// starting from the bottom of the rotated picture with the least
+	// significant bit.
+	for i, b := range vram {
+		x := i / 32
+		y := Height - 1 - i%32*8
+		for bit := 0; bit < 8; bit, y = bit+1, y-1 {
+			c := black
+			if b&(1<<uint(bit)) != 0 {
+				c = white
+				if m.overlay {
+					c = overlayColour(x, y)
+				}
+			}
+			m.screen.SetRGBA(x, y, c)
+		}
+	}
+
+	return m.screen
+}
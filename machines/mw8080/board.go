@@ -0,0 +1,440 @@
+// Package mw8080 emulates the family of Taito/Midway arcade boards built
+// around the Intel 8080, such as Space Invaders, from a data-driven board
+// description.
+//
+// The boards share a design: program ROM at the bottom of memory, RAM with a
+// 1 bit per pixel frame buffer above it, a 16 bit shift register on I/O
+// ports for drawing sprites, and interrupts from the video hardware. They
+// differ in their memory map, port assignments, DIP switches, interrupt
+// timing and monitor orientation, all of which a Board describes, so that a
+// new game only needs a new description, which may be loaded from JSON.
+package mw8080
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/danmrichards/go8080/romset"
+)
+
+type (
+	// Board describes an arcade board.
+	Board struct {
+		Name string `json:"name"`
+
+		// CPU clock rate in Hz and frames per second.
+		Clock     int `json:"clock"`
+		FrameRate int `json:"frame_rate"`
+
+		// Mask applied to addresses, giving the mirroring of the memory map.
+		AddressMask uint16 `json:"address_mask"`
+
+		// Program ROMs and RAM regions.
+		ROMs []ROM    `json:"roms"`
+		RAM  []Region `json:"ram"`
+
+		// Start of the frame buffer.
+		VideoAddr uint16 `json:"video_addr"`
+
+		// Shift register ports, if the board has one.
+		ShiftRegister *ShiftRegister `json:"shift_register,omitempty"`
+
+		// Input ports and DIP switches.
+		Inputs      []InputPort `json:"inputs"`
+		DIPSwitches []DIPSwitch `json:"dip_switches,omitempty"`
+
+		// Interrupts raised during each frame.
+		Interrupts []Interrupt `json:"interrupts"`
+
+		// Screen orientation and colour overlay.
+		Screen Screen `json:"screen"`
+	}
+
+	// ROM is a program ROM, with the checksums used to verify it.
+	ROM = romset.ROM
+
+	// Region is a range of memory.
+	Region struct {
+		Addr uint16 `json:"addr"`
+		Size int    `json:"size"`
+	}
+
+	// ShiftRegister gives the ports of the shift register: data is written
+	// to DataPort, the shift amount to OffsetPort and the result read from
+	// ResultPort.
+	//
+	// Some boards can also read the result with its bits reversed. On boards
+	// such as Boot Hill the result read from ResultPort is reversed while the
+	// ReverseBit of the value last written to OffsetPort is set. On boards
+	// such as Sea Wolf the reversed result is always read from ReversedPort.
+	ShiftRegister struct {
+		DataPort   byte `json:"data_port"`
+		OffsetPort byte `json:"offset_port"`
+		ResultPort byte `json:"result_port"`
+
+		ReverseBit   byte  `json:"reverse_bit,omitempty"`
+		ReversedPort *byte `json:"reversed_port,omitempty"`
+	}
+
+	// InputPort is an input port and the controls wired to it.
+	InputPort struct {
+		Port byte `json:"port"`
+
+		// Value read with no controls active and the DIP switches off.
+		Idle byte `json:"idle"`
+
+		Bits []Input `json:"bits,omitempty"`
+	}
+
+	// Input is a control wired to a bit of an input port.
+	Input struct {
+		Name string `json:"name"`
+		Mask byte   `json:"mask"`
+
+		// Active low inputs clear their bit when pressed.
+		ActiveLow bool `json:"active_low,omitempty"`
+	}
+
+	// DIPSwitch is a bank of DIP switches read through an input port. Its
+	// value is held in the bits of Mask.
+	DIPSwitch struct {
+		Name    string `json:"name"`
+		Port    byte   `json:"port"`
+		Mask    byte   `json:"mask"`
+		Default byte   `json:"default"`
+	}
+
+	// Interrupt is raised the given number of CPU cycles into each frame,
+	// calling the given address, usually an RST vector.
+	Interrupt struct {
+		Cycle  int    `json:"cycle"`
+		Vector uint16 `json:"vector"`
+	}
+
+	// Screen describes the monitor.
+	Screen struct {
+		// Rotation of the monitor in degrees anticlockwise: 0, 90 or 270.
+		Rotate int `json:"rotate"`
+
+		// Coloured overlay, in displayed coordinates. Lit pixels outside
+		// the overlay are white.
+		Overlay []Overlay `json:"overlay,omitempty"`
+	}
+
+	// Overlay is a rectangle of coloured cellophane on the monitor.
+	Overlay struct {
+		X   int     `json:"x"`
+		Y   int     `json:"y"`
+		W   int     `json:"w"`
+		H   int     `json:"h"`
+		RGB [3]byte `json:"rgb"`
+	}
+)
+
+// Size of the raster of all boards, before rotation: 256 pixels on each of
+// 224 lines, 32 bytes per line.
+const (
+	rasterWidth  = 256
+	rasterHeight = 224
+	lineBytes    = rasterWidth / 8
+	videoSize    = lineBytes * rasterHeight
+)
+
+// Invaders is the Space Invaders board.
+var Invaders = &Board{
+	Name:        "invaders",
+	Clock:       2000000,
+	FrameRate:   60,
+	AddressMask: 0x3fff,
+	ROMs: []ROM{
+		{Name: "invaders.h", Addr: 0x0000, Size: 0x800, CRC32: "734f5ad8", SHA1: "ff6200af4c9110d8181249cbcef1a8a40fa40b7f"},
+		{Name: "invaders.g", Addr: 0x0800, Size: 0x800, CRC32: "6bfaca4a", SHA1: "16f48649b531bdef8c2d1446c429b5f414524350"},
+		{Name: "invaders.f", Addr: 0x1000, Size: 0x800, CRC32: "0ccead96", SHA1: "537aef03468f63c5b9e11dd61e253f7ae17d9743"},
+		{Name: "invaders.e", Addr: 0x1800, Size: 0x800, CRC32: "14e538b0", SHA1: "1d6ca0c99f9df71e2990b610deb9d7da0125e2d8"},
+	},
+	RAM:           []Region{{Addr: 0x2000, Size: 0x2000}},
+	VideoAddr:     0x2400,
+	ShiftRegister: &ShiftRegister{DataPort: 4, OffsetPort: 2, ResultPort: 3},
+	Inputs: []InputPort{
+		{Port: 0, Idle: 0x0e},
+		{Port: 1, Idle: 0x08, Bits: []Input{
+			{Name: "coin", Mask: 0x01},
+			{Name: "start2", Mask: 0x02},
+			{Name: "start1", Mask: 0x04},
+			{Name: "fire1", Mask: 0x10},
+			{Name: "left1", Mask: 0x20},
+			{Name: "right1", Mask: 0x40},
+		}},
+		{Port: 2, Bits: []Input{
+			{Name: "tilt", Mask: 0x04},
+			{Name: "fire2", Mask: 0x10},
+			{Name: "left2", Mask: 0x20},
+			{Name: "right2", Mask: 0x40},
+		}},
+	},
+	DIPSwitches: []DIPSwitch{
+		{Name: "ships", Port: 2, Mask: 0x03},
+		{Name: "bonus", Port: 2, Mask: 0x08},
+		{Name: "coin_info", Port: 2, Mask: 0x80},
+	},
+	Interrupts: []Interrupt{
+		{Cycle: 2000000 / 60 / 2, Vector: 0x08},
+		{Cycle: 2000000 / 60, Vector: 0x10},
+	},
+	Screen: Screen{
+		Rotate: 90,
+		Overlay: []Overlay{
+			{X: 0, Y: 32, W: 224, H: 32, RGB: [3]byte{0xff, 0x00, 0x00}},
+			{X: 0, Y: 184, W: 224, H: 56, RGB: [3]byte{0x00, 0xff, 0x00}},
+			{X: 16, Y: 240, W: 118, H: 16, RGB: [3]byte{0x00, 0xff, 0x00}},
+		},
+	},
+}
+
+// The other built in boards follow the port layouts of MAME's mw8080bw
+// driver. Checksums are only given for the Invaders ROMs, so the ROMs of the
+// other boards load as romset.NoGoodDump. The gun aim and periscope
+// controls are positional rather than buttons, so they are not mapped and
+// read as zero.
+
+// midwayClock is the CPU clock of the Midway boards: 19.968MHz divided by
+// ten.
+const midwayClock = 1996800
+
+// midwayInterrupts are the interrupts of the Midway boards: RST 1 at the
+// middle of the screen and RST 2 at the start of vertical blanking.
+var midwayInterrupts = []Interrupt{
+	{Cycle: midwayClock / 60 / 2, Vector: 0x08},
+	{Cycle: midwayClock / 60, Vector: 0x10},
+}
+
+// GunFight is the Midway Gun Fight board.
+var GunFight = &Board{
+	Name:        "gunfight",
+	Clock:       midwayClock,
+	FrameRate:   60,
+	AddressMask: 0x3fff,
+	ROMs: []ROM{
+		{Name: "7609h.bin", Addr: 0x0000, Size: 0x400},
+		{Name: "7609g.bin", Addr: 0x0400, Size: 0x400},
+		{Name: "7609f.bin", Addr: 0x0800, Size: 0x400},
+		{Name: "7609e.bin", Addr: 0x0c00, Size: 0x400},
+	},
+	RAM:           []Region{{Addr: 0x2000, Size: 0x2000}},
+	VideoAddr:     0x2400,
+	ShiftRegister: &ShiftRegister{DataPort: 4, OffsetPort: 2, ResultPort: 3},
+	Inputs: []InputPort{
+		{Port: 0, Bits: cowboy("1")},
+		{Port: 1, Bits: cowboy("2")},
+		{Port: 2, Bits: []Input{
+			{Name: "coin", Mask: 0x40},
+			{Name: "start", Mask: 0x80, ActiveLow: true},
+		}},
+	},
+	DIPSwitches: []DIPSwitch{
+		{Name: "coinage", Port: 2, Mask: 0x0f},
+		{Name: "game_time", Port: 2, Mask: 0x30, Default: 0x10},
+	},
+	Interrupts: midwayInterrupts,
+}
+
+// BootHill is the Midway Boot Hill board, whose shift register reverses its
+// result to draw the second player's cowboy facing the first.
+var BootHill = &Board{
+	Name:        "boothill",
+	Clock:       midwayClock,
+	FrameRate:   60,
+	AddressMask: 0x3fff,
+	ROMs: []ROM{
+		{Name: "romh.cpu", Addr: 0x0000, Size: 0x400},
+		{Name: "romg.cpu", Addr: 0x0400, Size: 0x400},
+		{Name: "romf.cpu", Addr: 0x0800, Size: 0x400},
+		{Name: "rome.cpu", Addr: 0x0c00, Size: 0x400},
+	},
+	RAM:           []Region{{Addr: 0x2000, Size: 0x2000}},
+	VideoAddr:     0x2400,
+	ShiftRegister: &ShiftRegister{DataPort: 2, OffsetPort: 1, ResultPort: 3, ReverseBit: 0x08},
+	Inputs: []InputPort{
+		{Port: 0, Bits: cowboy("2")},
+		{Port: 1, Bits: cowboy("1")},
+		{Port: 2, Bits: []Input{
+			{Name: "start", Mask: 0x10},
+			{Name: "coin", Mask: 0x20},
+		}},
+	},
+	DIPSwitches: []DIPSwitch{
+		{Name: "coinage", Port: 2, Mask: 0x03},
+		{Name: "game_time", Port: 2, Mask: 0x0c},
+	},
+	Interrupts: midwayInterrupts,
+}
+
+// seaWolfReversed is the port from which Sea Wolf reads the reversed shift
+// register result.
+var seaWolfReversed = byte(0)
+
+// SeaWolf is the Midway Sea Wolf board, which reads the shift register
+// result reversed from a port of its own.
+var SeaWolf = &Board{
+	Name:        "seawolf",
+	Clock:       midwayClock,
+	FrameRate:   60,
+	AddressMask: 0x3fff,
+	ROMs: []ROM{
+		{Name: "sw0041.h", Addr: 0x0000, Size: 0x400},
+		{Name: "sw0042.g", Addr: 0x0400, Size: 0x400},
+		{Name: "sw0043.f", Addr: 0x0800, Size: 0x400},
+		{Name: "sw0044.e", Addr: 0x0c00, Size: 0x400},
+	},
+	RAM:       []Region{{Addr: 0x2000, Size: 0x2000}},
+	VideoAddr: 0x2400,
+	ShiftRegister: &ShiftRegister{
+		DataPort:     3,
+		OffsetPort:   4,
+		ResultPort:   3,
+		ReversedPort: &seaWolfReversed,
+	},
+	Inputs: []InputPort{
+		{Port: 1, Bits: []Input{
+			{Name: "fire", Mask: 0x20, ActiveLow: true},
+		}},
+		{Port: 2, Bits: []Input{
+			{Name: "coin", Mask: 0x04},
+			{Name: "start", Mask: 0x08},
+		}},
+	},
+	DIPSwitches: []DIPSwitch{
+		{Name: "game_time", Port: 1, Mask: 0xc0, Default: 0x40},
+		{Name: "coinage", Port: 2, Mask: 0x03},
+		{Name: "extended_play", Port: 2, Mask: 0xe0},
+	},
+	Interrupts: midwayInterrupts,
+}
+
+// LunarRescue is the Taito Lunar Rescue board, a Space Invaders board with
+// two more ROMs above the RAM.
+var LunarRescue = &Board{
+	Name:        "lrescue",
+	Clock:       midwayClock,
+	FrameRate:   60,
+	AddressMask: 0x7fff,
+	ROMs: []ROM{
+		{Name: "lrescue.1", Addr: 0x0000, Size: 0x800},
+		{Name: "lrescue.2", Addr: 0x0800, Size: 0x800},
+		{Name: "lrescue.3", Addr: 0x1000, Size: 0x800},
+		{Name: "lrescue.4", Addr: 0x1800, Size: 0x800},
+		{Name: "lrescue.5", Addr: 0x4000, Size: 0x800},
+		{Name: "lrescue.6", Addr: 0x4800, Size: 0x800},
+	},
+	RAM:           []Region{{Addr: 0x2000, Size: 0x2000}},
+	VideoAddr:     0x2400,
+	ShiftRegister: &ShiftRegister{DataPort: 4, OffsetPort: 2, ResultPort: 3},
+	Inputs: []InputPort{
+		{Port: 1, Idle: 0x08, Bits: []Input{
+			{Name: "coin", Mask: 0x01},
+			{Name: "start2", Mask: 0x02},
+			{Name: "start1", Mask: 0x04},
+			{Name: "fire1", Mask: 0x10},
+			{Name: "left1", Mask: 0x20},
+			{Name: "right1", Mask: 0x40},
+		}},
+		{Port: 2, Bits: []Input{
+			{Name: "tilt", Mask: 0x04},
+			{Name: "fire2", Mask: 0x10},
+			{Name: "left2", Mask: 0x20},
+			{Name: "right2", Mask: 0x40},
+		}},
+	},
+	DIPSwitches: []DIPSwitch{
+		{Name: "ships", Port: 2, Mask: 0x03},
+		{Name: "bonus", Port: 2, Mask: 0x08},
+		{Name: "coin_info", Port: 2, Mask: 0x80},
+	},
+	Interrupts: midwayInterrupts,
+	Screen:     Screen{Rotate: 90},
+}
+
+// cowboy returns the active low joystick and trigger of one player of Gun
+// Fight or Boot Hill, with the given suffix on their names.
+func cowboy(player string) []Input {
+	return []Input{
+		{Name: "up" + player, Mask: 0x01, ActiveLow: true},
+		{Name: "down" + player, Mask: 0x02, ActiveLow: true},
+		{Name: "left" + player, Mask: 0x04, ActiveLow: true},
+		{Name: "right" + player, Mask: 0x08, ActiveLow: true},
+		{Name: "fire" + player, Mask: 0x80, ActiveLow: true},
+	}
+}
+
+// Boards lists the built in boards by name.
+var Boards = map[string]*Board{
+	Invaders.Name:    Invaders,
+	GunFight.Name:    GunFight,
+	BootHill.Name:    BootHill,
+	SeaWolf.Name:     SeaWolf,
+	LunarRescue.Name: LunarRescue,
+}
+
+// Load reads a board description in JSON.
+func Load(r io.Reader) (*Board, error) {
+	var b Board
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// Validate checks the description for mistakes which would stop the board
+// from running.
+func (b *Board) Validate() error {
+	if b.Clock <= 0 || b.FrameRate <= 0 {
+		return errors.New("mw8080: clock and frame rate must be positive")
+	}
+	mem := int(b.AddressMask) + 1
+	for _, r := range b.ROMs {
+		if int(r.Addr)+r.Size > mem {
+			return fmt.Errorf("mw8080: ROM %s is outside the address space", r.Name)
+		}
+	}
+	for _, r := range b.RAM {
+		if int(r.Addr)+r.Size > mem {
+			return fmt.Errorf("mw8080: RAM at %04X is outside the address space", r.Addr)
+		}
+	}
+	if int(b.VideoAddr)+videoSize > mem {
+		return errors.New("mw8080: frame buffer is outside the address space")
+	}
+	if s := b.ShiftRegister; s != nil && s.ReverseBit&0x07 != 0 {
+		return errors.New("mw8080: shift register reverse bit overlaps the shift amount")
+	}
+	for _, p := range b.Inputs {
+		for _, in := range p.Bits {
+			if in.Mask == 0 {
+				return fmt.Errorf("mw8080: input %s has no bits", in.Name)
+			}
+		}
+	}
+	for _, d := range b.DIPSwitches {
+		if d.Mask == 0 {
+			return fmt.Errorf("mw8080: DIP switch %s has no bits", d.Name)
+		}
+	}
+	for _, i := range b.Interrupts {
+		if i.Cycle > b.Clock/b.FrameRate {
+			return fmt.Errorf("mw8080: interrupt at cycle %d is after the end of the frame", i.Cycle)
+		}
+	}
+	switch b.Screen.Rotate {
+	case 0, 90, 270:
+	default:
+		return fmt.Errorf("mw8080: unsupported screen rotation of %d degrees", b.Screen.Rotate)
+	}
+
+	return nil
+}
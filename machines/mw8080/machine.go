@@ -0,0 +1,304 @@
+package mw8080
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"sort"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/romset"
+)
+
+type (
+	// Machine is a running board.
+	Machine struct {
+		board *Board
+		cpu   *go8080.Intel8080
+		mem   *memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		// Handler for writes to ports not handled by the board.
+		oh func(port, v byte)
+
+		// Current value of each input port.
+		ports [256]byte
+
+		// Shift register, its result offset and whether the result is read
+		// reversed.
+		shift        uint16
+		shiftOffset  byte
+		shiftReverse bool
+
+		// Interrupts in order of their cycle, and the cycle count at the start
+		// of the current frame.
+		interrupts []Interrupt
+		frameStart uint32
+
+		screen *image.RGBA
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the address space of a board.
+	memory struct {
+		data     []byte
+		mask     uint16
+		writable []bool
+	}
+)
+
+// Read returns the value from memory at the given address.
+func (m *memory) Read(addr uint16) byte {
+	return m.data[addr&m.mask]
+}
+
+// ReadAll returns the full memory contents.
+func (m *memory) ReadAll() []byte {
+	return m.data
+}
+
+// Write writes the value v into memory at the given address, unless it is
+// ROM.
+func (m *memory) Write(addr uint16, v byte) {
+	if addr &= m.mask; m.writable[addr] {
+		m.data[addr] = v
+	}
+}
+
+// WithOutputHandler sets a handler for writes to output ports other than
+// those of the shift register, such as sound and watchdog ports.
+func WithOutputHandler(fn func(port, v byte)) Option {
+	return func(m *Machine) {
+		m.oh = fn
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// LoadROMs reads the ROMs of the board from a directory or zip archive,
+// returning an error if any is missing or does not match its checksums. Use
+// the romset package directly for a full report.
+func LoadROMs(b *Board, path string) (map[string][]byte, error) {
+	res, err := romset.Load(path, b.ROMs)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	return res.Files, nil
+}
+
+// New returns a machine running the board with the given ROM contents, keyed
+// by ROM name.
+func New(b *Board, roms map[string][]byte, opts ...Option) (*Machine, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+
+	size := int(b.AddressMask) + 1
+	mem := &memory{
+		data:     make([]byte, size),
+		mask:     b.AddressMask,
+		writable: make([]bool, size),
+	}
+	for _, r := range b.ROMs {
+		data, ok := roms[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("mw8080: missing ROM %s", r.Name)
+		}
+		if len(data) != r.Size {
+			return nil, fmt.Errorf("mw8080: ROM %s is %d bytes, want %d", r.Name, len(data), r.Size)
+		}
+		copy(mem.data[r.Addr:], data)
+	}
+	for _, r := range b.RAM {
+		for a := int(r.Addr); a < int(r.Addr)+r.Size; a++ {
+			mem.writable[a] = true
+		}
+	}
+
+	m := &Machine{board: b, mem: mem}
+	for _, p := range b.Inputs {
+		m.ports[p.Port] = p.Idle
+		for _, in := range p.Bits {
+			if in.ActiveLow {
+				m.ports[p.Port] |= in.Mask
+			}
+		}
+	}
+	for _, d := range b.DIPSwitches {
+		m.ports[d.Port] = m.ports[d.Port]&^d.Mask | d.Default&d.Mask
+	}
+
+	m.interrupts = append([]Interrupt(nil), b.Interrupts...)
+	sort.SliceStable(m.interrupts, func(i, j int) bool {
+		return m.interrupts[i].Cycle < m.interrupts[j].Cycle
+	})
+
+	w, h := rasterWidth, rasterHeight
+	if b.Screen.Rotate != 0 {
+		w, h = h, w
+	}
+	m.screen = image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for _, o := range opts {
+		o(m)
+	}
+	m.cpuOpts = append(m.cpuOpts, go8080.WithInput(m.input), go8080.WithOutput(m.output))
+	m.cpu = go8080.NewIntel8080(m.mem, m.cpuOpts...)
+
+	return m, nil
+}
+
+// Board returns the description of the board.
+func (m *Machine) Board() *Board {
+	return m.board
+}
+
+// CPU returns the CPU of the machine.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the memory of the machine.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// control returns the port and bit of the named control.
+func (m *Machine) control(name string) (InputPort, Input, error) {
+	for _, p := range m.board.Inputs {
+		for _, in := range p.Bits {
+			if in.Name == name {
+				return p, in, nil
+			}
+		}
+	}
+
+	return InputPort{}, Input{}, fmt.Errorf("mw8080: %s has no control named %q", m.board.Name, name)
+}
+
+// Press presses the named control, which stays down until released.
+func (m *Machine) Press(name string) error {
+	return m.setControl(name, true)
+}
+
+// Release releases the named control.
+func (m *Machine) Release(name string) error {
+	return m.setControl(name, false)
+}
+
+// setControl sets the bit of the named control for the given state.
+func (m *Machine) setControl(name string, pressed bool) error {
+	p, in, err := m.control(name)
+	if err != nil {
+		return err
+	}
+
+	if pressed != in.ActiveLow {
+		m.ports[p.Port] |= in.Mask
+	} else {
+		m.ports[p.Port] &^= in.Mask
+	}
+
+	return nil
+}
+
+// SetDIPSwitch sets the named bank of DIP switches to the given value, which
+// is shifted into the bits of the bank's mask.
+func (m *Machine) SetDIPSwitch(name string, v byte) error {
+	for _, d := range m.board.DIPSwitches {
+		if d.Name != name {
+			continue
+		}
+
+		shift := uint(0)
+		for d.Mask>>shift&1 == 0 {
+			shift++
+		}
+		m.ports[d.Port] = m.ports[d.Port]&^d.Mask | v<<shift&d.Mask
+
+		return nil
+	}
+
+	return fmt.Errorf("mw8080: %s has no DIP switch named %q", m.board.Name, name)
+}
+
+// Frame runs the machine for one frame, raising the board's interrupts at
+// their cycles.
+func (m *Machine) Frame() error {
+	for _, i := range m.interrupts {
+		if err := m.run(uint32(i.Cycle)); err != nil {
+			return err
+		}
+		m.cpu.Interrupt(i.Vector)
+	}
+
+	end := uint32(m.board.Clock / m.board.FrameRate)
+	if err := m.run(end); err != nil {
+		return err
+	}
+	m.frameStart += end
+
+	return nil
+}
+
+// run runs the CPU until the given number of cycles into the frame.
+func (m *Machine) run(cycles uint32) error {
+	for m.cpu.Cycles()-m.frameStart < cycles {
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// input handles the IN instruction.
+func (m *Machine) input(port byte) byte {
+	if s := m.board.ShiftRegister; s != nil {
+		result := byte(m.shift >> (8 - m.shiftOffset))
+		switch {
+		case port == s.ResultPort && m.shiftReverse:
+			return bits.Reverse8(result)
+		case port == s.ResultPort:
+			return result
+		case s.ReversedPort != nil && port == *s.ReversedPort:
+			return bits.Reverse8(result)
+		}
+	}
+
+	return m.ports[port]
+}
+
+// output handles the OUT instruction.
+func (m *Machine) output(port byte) {
+	v := m.cpu.Accumulator()
+
+	if s := m.board.ShiftRegister; s != nil {
+		switch port {
+		case s.DataPort:
+			m.shift = m.shift>>8 | uint16(v)<<8
+			return
+		case s.OffsetPort:
+			m.shiftOffset = v & 0x07
+			m.shiftReverse = v&s.ReverseBit != 0
+			return
+		}
+	}
+
+	if m.oh != nil {
+		m.oh(port, v)
+	}
+}
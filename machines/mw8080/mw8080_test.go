@@ -0,0 +1,448 @@
+package mw8080
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/romset"
+)
+
+// assemble assembles src and splits it into the ROMs of the board.
+func assemble(t *testing.T, b *Board, src string) map[string][]byte {
+	t.Helper()
+
+	prog, err := asm.Assemble("rom.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := prog.Binary()
+
+	roms := make(map[string][]byte)
+	for _, r := range b.ROMs {
+		data := make([]byte, r.Size)
+		if int(r.Addr) < len(bin) {
+			copy(data, bin[r.Addr:])
+		}
+		roms[r.Name] = data
+	}
+
+	return roms
+}
+
+func TestInvaders(t *testing.T) {
+	const src = `
+	ORG	0
+	JMP	START
+	ORG	8
+	JMP	RST1
+	ORG	10H
+	JMP	RST2
+RST1:	LXI	H,2001H
+	INR	M
+	EI
+	RET
+RST2:	LXI	H,2002H
+	INR	M
+	EI
+	RET
+START:	LXI	SP,2400H
+	MVI	A,0AAH
+	OUT	4
+	MVI	A,0FFH
+	OUT	4
+	MVI	A,3
+	OUT	2
+	IN	3
+	STA	2000H
+	MVI	A,42H
+	OUT	6
+	EI
+LOOP:	IN	1
+	STA	2003H
+	IN	2
+	STA	2004H
+	JMP	LOOP
+`
+	var outputs [][2]byte
+	m, err := New(Invaders, assemble(t, Invaders, src), WithOutputHandler(func(port, v byte) {
+		outputs = append(outputs, [2]byte{port, v})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Press("coin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDIPSwitch("ships", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := m.Frame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem := m.mem.data
+	if got := mem[0x2000]; got != 0xfd {
+		t.Errorf("shift register result = %02x, want fd", got)
+	}
+	if got := mem[0x2001]; got != 3 {
+		t.Errorf("mid-screen interrupts = %d, want 3", got)
+	}
+	if got := mem[0x2002]; got != 2 {
+		t.Errorf("vblank interrupts = %d, want 2", got)
+	}
+	if got := mem[0x2003]; got != 0x09 {
+		t.Errorf("port 1 = %02x, want 09", got)
+	}
+	if got := mem[0x2004]; got != 0x02 {
+		t.Errorf("port 2 = %02x, want 02", got)
+	}
+	if want := [][2]byte{{6, 0x42}}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs = %v, want %v", outputs, want)
+	}
+
+	if err := m.Press("jump"); err == nil {
+		t.Error("Press of unknown control succeeded")
+	}
+
+	// Writes to ROM are ignored, and RAM is mirrored above 4000H.
+	m.mem.Write(0x0000, 0x55)
+	m.mem.Write(0x6000, 0x55)
+	if m.mem.Read(0x0000) != 0xc3 || m.mem.Read(0x2000) != 0x55 {
+		t.Error("memory map does not protect ROM or mirror RAM")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	m, err := New(Invaders, assemble(t, Invaders, "\tHLT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vram := m.mem.data[Invaders.VideoAddr:]
+	vram[0] = 0x01
+	vram[32*100+5] = 0x01
+	vram[32*50+27] = 0x01
+
+	img := m.Screen()
+	if b := img.Bounds(); b.Dx() != 224 || b.Dy() != 256 {
+		t.Fatalf("screen is %dx%d, want 224x256", b.Dx(), b.Dy())
+	}
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 255, white},
+		{0, 254, black},
+		{100, 215, color.RGBA{0x00, 0xff, 0x00, 0xff}},
+		{50, 39, color.RGBA{0xff, 0x00, 0x00, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel %d,%d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+// testBoard is a board without a shift register or rotation, described in
+// JSON as a new game would be.
+const testBoard = `{
+	"name": "test",
+	"clock": 1996800,
+	"frame_rate": 60,
+	"address_mask": 16383,
+	"roms": [{"name": "test.rom", "addr": 0, "size": 4096}],
+	"ram": [{"addr": 8192, "size": 8192}],
+	"video_addr": 9216,
+	"inputs": [
+		{"port": 0, "idle": 16, "bits": [{"name": "fire", "mask": 1, "active_low": true}]}
+	],
+	"dip_switches": [{"name": "lives", "port": 0, "mask": 96, "default": 32}],
+	"interrupts": [{"cycle": 33280, "vector": 16}],
+	"screen": {"rotate": 0}
+}`
+
+func TestLoad(t *testing.T) {
+	b, err := Load(strings.NewReader(testBoard))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(b, assemble(t, b, "\tHLT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.input(0); got != 0x31 {
+		t.Errorf("port 0 = %02x, want 31", got)
+	}
+	if err := m.Press("fire"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDIPSwitch("lives", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.input(0); got != 0x70 {
+		t.Errorf("port 0 = %02x, want 70", got)
+	}
+
+	m.mem.data[b.VideoAddr+32*10+1] = 0x80
+	img := m.Screen()
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 224 {
+		t.Fatalf("screen is %dx%d, want 256x224", b.Dx(), b.Dy())
+	}
+	if got := img.RGBAAt(15, 10); got != white {
+		t.Errorf("pixel 15,10 = %v, want white", got)
+	}
+
+	if _, err := New(b, map[string][]byte{"test.rom": make([]byte, 10)}); err == nil {
+		t.Error("New with a short ROM succeeded")
+	}
+}
+
+func TestBoardJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(Invaders); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b, Invaders) {
+		t.Errorf("board after JSON round trip = %+v, want %+v", b, Invaders)
+	}
+
+	bad := strings.Replace(testBoard, `"rotate": 0`, `"rotate": 45`, 1)
+	if _, err := Load(strings.NewReader(bad)); err == nil {
+		t.Error("Load of board with 45 degree rotation succeeded")
+	}
+}
+
+// forge returns size bytes with the given CRC32, by choosing the last four.
+// The CRC is affine in the data bits, so the bits are solved for by Gaussian
+// elimination.
+func forge(t *testing.T, size int, crc string) []byte {
+	t.Helper()
+
+	var want uint32
+	if _, err := fmt.Sscanf(crc, "%08x", &want); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	tail := data[size-4:]
+	copy(tail, []byte{0, 0, 0, 0})
+	base := crc32.ChecksumIEEE(data)
+
+	// Each row holds the CRC change made by one bit of the tail, with the
+	// bit itself above it.
+	var rows [32]uint64
+	for i := range rows {
+		tail[i/8] = 1 << uint(i%8)
+		rows[i] = uint64(crc32.ChecksumIEEE(data)^base) | 1<<uint(32+i)
+		tail[i/8] = 0
+	}
+	target := uint64(want ^ base)
+	var x uint64
+	for bit := uint(0); bit < 32; bit++ {
+		pivot := -1
+		for i := int(bit); i < 32; i++ {
+			if rows[i]&(1<<bit) != 0 {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			t.Fatal("CRC cannot be forged")
+		}
+		rows[bit], rows[pivot] = rows[pivot], rows[bit]
+		for i := range rows {
+			if i != int(bit) && rows[i]&(1<<bit) != 0 {
+				rows[i] ^= rows[bit]
+			}
+		}
+	}
+	for bit := uint(0); bit < 32; bit++ {
+		if target&(1<<bit) != 0 {
+			x ^= rows[bit] >> 32
+		}
+	}
+	for i := 0; i < 4; i++ {
+		tail[i] = byte(x >> uint(8*i))
+	}
+
+	return data
+}
+
+func TestROMChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mw8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files with the right size and CRC32 but not the real contents are
+	// caught by the SHA-1.
+	for _, r := range Invaders.ROMs {
+		if b, err := hex.DecodeString(r.SHA1); err != nil || len(b) != 20 {
+			t.Errorf("%s: SHA1 %q is not a SHA-1", r.Name, r.SHA1)
+		}
+		data := forge(t, r.Size, r.CRC32)
+		if got := fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)); got != r.CRC32 {
+			t.Fatalf("%s: forged CRC32 %s, want %s", r.Name, got, r.CRC32)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, r.Name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := romset.Load(dir, Invaders.ROMs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range res.Checks {
+		if c.Status != romset.BadChecksum {
+			t.Errorf("%s: status = %v, want %v", c.ROM.Name, c.Status, romset.BadChecksum)
+		}
+	}
+}
+
+func TestReverseShift(t *testing.T) {
+	const src = `
+	MVI	A,40H
+	OUT	4
+	MVI	A,02H
+	OUT	4
+	MVI	A,3
+	OUT	2
+	IN	3
+	STA	2000H
+	IN	1
+	STA	2001H
+	MVI	A,0BH
+	OUT	2
+	IN	3
+	STA	2002H
+	HLT
+`
+	board := strings.Replace(testBoard, `"video_addr"`,
+		`"shift_register": {"data_port": 4, "offset_port": 2, "result_port": 3, "reverse_bit": 8, "reversed_port": 1},
+	"video_addr"`, 1)
+	b, err := Load(strings.NewReader(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(b, assemble(t, b, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Frame(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0240H shifted left by three gives 12H, or 48H reversed.
+	mem := m.mem.data
+	for _, tt := range []struct {
+		addr uint16
+		want byte
+		name string
+	}{
+		{0x2000, 0x12, "result"},
+		{0x2001, 0x48, "reversed port"},
+		{0x2002, 0x48, "result with reverse bit"},
+	} {
+		if got := mem[tt.addr]; got != tt.want {
+			t.Errorf("%s = %02x, want %02x", tt.name, got, tt.want)
+		}
+	}
+
+	bad := strings.Replace(board, `"reverse_bit": 8`, `"reverse_bit": 4`, 1)
+	if _, err := Load(strings.NewReader(bad)); err == nil {
+		t.Error("Load of shift register with overlapping reverse bit succeeded")
+	}
+}
+
+func TestBoards(t *testing.T) {
+	for name, board := range Boards {
+		// Each board is loaded from JSON, as a new game would be, and runs
+		// the shift register through the ports in its description.
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(board); err != nil {
+			t.Fatal(err)
+		}
+		b, err := Load(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		s := b.ShiftRegister
+		reversed := s.ResultPort
+		if s.ReversedPort != nil {
+			reversed = *s.ReversedPort
+		}
+		src := fmt.Sprintf(`
+	ORG	0
+	JMP	START
+	ORG	8
+	EI
+	RET
+	ORG	10H
+	LXI	H,2001H
+	INR	M
+	EI
+	RET
+START:	LXI	SP,2400H
+	MVI	A,40H
+	OUT	%d
+	MVI	A,02H
+	OUT	%d
+	MVI	A,3
+	OUT	%d
+	IN	%d
+	STA	2000H
+	IN	%d
+	STA	2002H
+	EI
+LOOP:	JMP	LOOP
+`, s.DataPort, s.DataPort, s.OffsetPort, s.ResultPort, reversed)
+
+		m, err := New(b, assemble(t, b, src))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := m.Frame(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		mem := m.mem.data
+		want := byte(0x12)
+		if s.ReversedPort != nil {
+			want = 0x48
+		}
+		if mem[0x2000] != 0x12 || mem[0x2002] != want {
+			t.Errorf("%s: shift register results = %02x %02x, want 12 %02x", name, mem[0x2000], mem[0x2002], want)
+		}
+		if mem[0x2001] != 2 {
+			t.Errorf("%s: vblank interrupts = %d, want 2", name, mem[0x2001])
+		}
+		if img := m.Screen(); img.Bounds().Empty() {
+			t.Errorf("%s: empty screen", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mw8080
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	black = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Screen renders the frame buffer and returns the picture as it appears on
+// the monitor, rotated and coloured by the overlay. The returned image is
+// reused by later calls.
+func (m *Machine) Screen() *image.RGBA {
+	vram := m.mem.data[m.board.VideoAddr:]
+	rotate := m.board.Screen.Rotate
+
+	// Each line of the raster is 32 bytes, with the least significant bit
+	// of each byte leftmost.
+	for i, b := range vram[:videoSize] {
+		line, px := i/lineBytes, i%lineBytes*8
+		for bit := 0; bit < 8; bit, px = bit+1, px+1 {
+			var x, y int
+			switch rotate {
+			case 0:
+				x, y = px, line
+			case 90:
+				x, y = line, rasterWidth-1-px
+			case 270:
+				x, y = rasterHeight-1-line, px
+			}
+
+			c := black
+			if b&(1<<uint(bit)) != 0 {
+				c = m.colour(x, y)
+			}
+			m.screen.SetRGBA(x, y, c)
+		}
+	}
+
+	return m.screen
+}
+
+// colour returns the colour of a lit pixel at x, y on the displayed screen.
+func (m *Machine) colour(x, y int) color.RGBA {
+	for _, o := range m.board.Screen.Overlay {
+		if x >= o.X && x < o.X+o.W && y >= o.Y && y < o.Y+o.H {
+			return color.RGBA{o.RGB[0], o.RGB[1], o.RGB[2], 0xff}
+		}
+	}
+
+	return white
+}
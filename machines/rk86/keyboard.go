@@ -0,0 +1,184 @@
+package rk86
+
+// Key is a key on the Radio-86RK keyboard. Most keys are in an eight by eight
+// matrix, numbered column*8+row; the modifier keys have their own inputs.
+type Key int
+
+// Keys without a character of their own.
+const (
+	Home      Key = 0*8 + 0
+	Clear     Key = 0*8 + 1 // STR
+	Esc       Key = 0*8 + 2 // AR2
+	F1        Key = 0*8 + 3
+	F2        Key = 0*8 + 4
+	F3        Key = 0*8 + 5
+	F4        Key = 0*8 + 6
+	F5        Key = 0*8 + 7
+	Tab       Key = 1*8 + 0
+	LineFeed  Key = 1*8 + 1 // PS
+	Return    Key = 1*8 + 2 // VK
+	Backspace Key = 1*8 + 3 // ZB
+	Left      Key = 1*8 + 4
+	Up        Key = 1*8 + 5
+	Right     Key = 1*8 + 6
+	Down      Key = 1*8 + 7
+	Space     Key = 7*8 + 7
+
+	Shift  Key = 64 // SS
+	Ctrl   Key = 65 // US
+	RusLat Key = 66 // RUS/LAT
+
+	numKeys = 67
+)
+
+// chars holds the unshifted legends of the character keys, from column 2.
+const chars = "01234567" + "89:;,-./" + "@ABCDEFG" + "HIJKLMNO" + "PQRSTUVW" + "XYZ[\\]^ "
+
+// Number of frames each typed key is held down, and released for before the
+// next.
+const (
+	holdFrames    = 4
+	releaseFrames = 4
+)
+
+type (
+	// keyboard is the key matrix, scanned through the keyboard PPI.
+	keyboard struct {
+		down [numKeys]bool
+
+		// Columns selected by port A, active low.
+		columns byte
+
+		// Keys queued by Type, and the one being typed.
+		queue  []stroke
+		typing *stroke
+		wait   int
+	}
+
+	// stroke is a typed key, with shift if needed.
+	stroke struct {
+		key   Key
+		shift bool
+	}
+)
+
+// CharKey returns the key with the given character as its unshifted legend.
+// Lower case letters give the key of their upper case form.
+func CharKey(c byte) (Key, bool) {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	for i := 0; i < len(chars); i++ {
+		if chars[i] == c {
+			return Key(2*8 + i), true
+		}
+	}
+
+	return 0, false
+}
+
+// strokeFor returns the keystroke typing the given character. Shifted
+// symbols are those of the digit and punctuation keys with bit 4 flipped, as
+// the monitor decodes them.
+func strokeFor(c byte) (stroke, bool) {
+	switch c {
+	case '\n', '\r':
+		return stroke{key: Return}, true
+	case '\t':
+		return stroke{key: Tab}, true
+	case '\b', 0x7f:
+		return stroke{key: Backspace}, true
+	}
+	if k, ok := CharKey(c); ok {
+		return stroke{key: k}, true
+	}
+	if c > ' ' && c < '@' {
+		if k, ok := CharKey(c ^ 0x10); ok {
+			return stroke{key: k, shift: true}, true
+		}
+	}
+
+	return stroke{}, false
+}
+
+// selectColumns handles writes to port A.
+func (k *keyboard) selectColumns(v byte) {
+	k.columns = v
+}
+
+// rows returns the rows with a key held down in any selected column, active
+// low, for port B.
+func (k *keyboard) rows() byte {
+	v := byte(0xff)
+	for col := 0; col < 8; col++ {
+		if k.columns&(1<<uint(col)) != 0 {
+			continue
+		}
+		for row := 0; row < 8; row++ {
+			if k.down[col*8+row] {
+				v &^= 1 << uint(row)
+			}
+		}
+	}
+
+	return v
+}
+
+// frame moves typing on by a frame.
+func (k *keyboard) frame() {
+	if k.wait > 0 {
+		k.wait--
+		return
+	}
+	if k.typing != nil {
+		k.down[k.typing.key] = false
+		if k.typing.shift {
+			k.down[Shift] = false
+		}
+		k.typing, k.wait = nil, releaseFrames-1
+		return
+	}
+	if len(k.queue) == 0 {
+		return
+	}
+
+	s := k.queue[0]
+	k.queue = k.queue[1:]
+	k.down[s.key] = true
+	if s.shift {
+		k.down[Shift] = true
+	}
+	k.typing, k.wait = &s, holdFrames-1
+}
+
+// Press presses a key and holds it down until it is released.
+func (m *Machine) Press(k Key) {
+	m.keyboard.down[k] = true
+}
+
+// Release releases a key.
+func (m *Machine) Release(k Key) {
+	m.keyboard.down[k] = false
+}
+
+// Type queues keystrokes to be typed, one key at a time, each held down for
+// a few frames. Newlines are typed as the return key, and symbols with the
+// shift key where needed. Characters which cannot be typed are skipped.
+func (m *Machine) Type(s string) {
+	for i := 0; i < len(s); i++ {
+		if st, ok := strokeFor(s[i]); ok {
+			m.keyboard.queue = append(m.keyboard.queue, st)
+		}
+	}
+}
+
+// KeysPending returns the number of typed keys which have not yet been
+// released.
+func (m *Machine) KeysPending() int {
+	n := len(m.keyboard.queue)
+	if m.keyboard.typing != nil {
+		n++
+	}
+
+	return n
+}
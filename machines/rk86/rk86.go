@@ -0,0 +1,303 @@
+// Package rk86 emulates the Radio-86RK, a Soviet home computer published as
+// a construction project in Radio magazine.
+//
+// The machine has a KR580VM80A CPU, a clone of the 8080A, with 32K of RAM, a
+// keyboard scanned through a KR580VV55 (8255), and a display driven by a
+// KR580VG75 (8275) CRT controller fed from RAM by a KR580VT57 (8257) DMA
+// controller. The monitor ROM is not included and must be supplied.
+//
+// The machine runs a frame at a time. The display DMA takes the CPU's bus
+// for each character fetched, so the time left to the CPU in a frame depends
+// on how the display is programmed, as it does on the real machine; software
+// timing loops, such as the tape routines, rely on this.
+package rk86
+
+import (
+	"fmt"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/devices/i8255"
+	"github.com/danmrichards/go8080/devices/i8257"
+	"github.com/danmrichards/go8080/devices/i8275"
+)
+
+const (
+	// Clock is the CPU clock rate in Hz: the 16MHz master clock divided by
+	// nine.
+	Clock = 16000000 / 9
+
+	// FrameRate is the number of frames per second.
+	FrameRate = 50
+
+	// CyclesPerFrame is the number of CPU cycles in a frame, before the
+	// display DMA takes its share.
+	CyclesPerFrame = Clock / FrameRate
+)
+
+// Memory map. Each device is repeated throughout its 8K block.
+const (
+	RAMSize  = 0x8000
+	PPI1Addr = 0x8000
+	PPI2Addr = 0xa000
+	CRTAddr  = 0xc000
+	DMAAddr  = 0xe000
+	ROMAddr  = 0xf800
+	ROMSize  = 0x800
+
+	// crtChannel is the DMA channel serving the CRT controller.
+	crtChannel = 2
+)
+
+type (
+	// Machine is a Radio-86RK.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem *memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		kbd  *i8255.PPI
+		ppi2 *i8255.PPI
+		crt  *i8275.CRT
+		dma  *i8257.DMA
+
+		keyboard keyboard
+
+		// Tape input and output, called with the CPU cycle count.
+		tapeIn    func(cycles uint32) bool
+		tapeOut   func(cycles uint32, level bool)
+		tapeLevel bool
+
+		// Cycles taken by the display DMA in the last frame.
+		stolen uint32
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the Radio-86RK address space. Reads and writes above the RAM
+	// go to the devices; data holds the RAM and the ROM, at its own address
+	// and repeated below it, for ReadAll.
+	memory struct {
+		m    *Machine
+		data []byte
+	}
+)
+
+// Read returns the value from memory at the given address.
+func (mem *memory) Read(addr uint16) byte {
+	m := mem.m
+	switch {
+	case addr < RAMSize, addr >= DMAAddr:
+		return mem.data[addr]
+	case addr < PPI2Addr:
+		return m.kbd.In(byte(addr))
+	case addr < CRTAddr:
+		return m.ppi2.In(byte(addr))
+	default:
+		return m.crt.In(byte(addr))
+	}
+}
+
+// ReadAll returns the full memory contents.
+func (mem *memory) ReadAll() []byte {
+	return mem.data
+}
+
+// Write writes the value v into memory at the given address. Writes above
+// 0E000H go to the DMA controller; the ROM cannot be written.
+func (mem *memory) Write(addr uint16, v byte) {
+	m := mem.m
+	switch {
+	case addr < RAMSize:
+		mem.data[addr] = v
+	case addr < PPI2Addr:
+		m.kbd.Out(byte(addr), v)
+	case addr < CRTAddr:
+		m.ppi2.Out(byte(addr), v)
+	case addr < DMAAddr:
+		m.crt.Out(byte(addr), v)
+	default:
+		if r := byte(addr) & 0x0f; int(r) < m.dma.Ports() {
+			m.dma.Out(r, v)
+		}
+	}
+}
+
+// WithTapeInput sets the function which reads the level of the tape input,
+// given the CPU cycle count.
+func WithTapeInput(fn func(cycles uint32) bool) Option {
+	return func(m *Machine) {
+		m.tapeIn = fn
+	}
+}
+
+// WithTapeOutput sets the function called with the CPU cycle count and the
+// new level whenever the tape output changes.
+func WithTapeOutput(fn func(cycles uint32, level bool)) Option {
+	return func(m *Machine) {
+		m.tapeOut = fn
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU. The CPU is a
+// KR580VM80A unless another variant is given.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// New returns a Radio-86RK running the given monitor ROM. It is an error for
+// the ROM to be larger than 2K.
+func New(rom []byte, opts ...Option) (*Machine, error) {
+	if len(rom) > ROMSize {
+		return nil, fmt.Errorf("rk86: ROM is %d bytes, larger than %d", len(rom), ROMSize)
+	}
+
+	m := &Machine{
+		crt: i8275.New(),
+		dma: i8257.New(),
+	}
+	m.mem = &memory{m: m, data: make([]byte, 0x10000)}
+	for addr := DMAAddr; addr < len(m.mem.data); addr++ {
+		m.mem.data[addr] = 0xff
+	}
+	for addr := DMAAddr; addr < len(m.mem.data); addr += ROMSize {
+		copy(m.mem.data[addr:], rom)
+	}
+
+	for _, o := range opts {
+		o(m)
+	}
+
+	m.kbd = i8255.New(
+		i8255.WithOutput(i8255.A, m.keyboard.selectColumns),
+		i8255.WithInput(i8255.B, m.keyboard.rows),
+		i8255.WithInput(i8255.C, m.portC),
+		i8255.WithOutput(i8255.C, m.setPortC),
+	)
+	m.ppi2 = i8255.New()
+
+	m.cpu = go8080.NewIntel8080(m.mem, append([]go8080.Option{
+		go8080.WithVariant(go8080.KR580VM80A),
+	}, m.cpuOpts...)...)
+	m.Reset()
+
+	return m, nil
+}
+
+// CPU returns the machine's CPU.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the machine's memory.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// CRT returns the machine's CRT controller.
+func (m *Machine) CRT() *i8275.CRT {
+	return m.crt
+}
+
+// UserPort returns the second 8255, which drives the user port.
+func (m *Machine) UserPort() *i8255.PPI {
+	return m.ppi2
+}
+
+// Reset resets the CPU, which starts the monitor. The reset circuit, which
+// makes the first fetches come from the ROM, is emulated by starting at its
+// address. Memory is untouched.
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+	m.cpu.SetProgramCounter(ROMAddr)
+}
+
+// Frame runs the machine for one frame, then fetches the frame's characters
+// for the display.
+func (m *Machine) Frame() error {
+	m.keyboard.frame()
+
+	for end := m.cpu.Cycles() + CyclesPerFrame - m.stolen; int32(m.cpu.Cycles()-end) < 0; {
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+	}
+
+	n := m.crt.Frame(func() (byte, bool) {
+		return m.dma.Read(crtChannel, m.mem)
+	})
+	m.stolen = uint32(n) * i8257.CyclesPerByte
+
+	return nil
+}
+
+// StolenCycles returns the number of CPU cycles taken by the display DMA in
+// the last frame.
+func (m *Machine) StolenCycles() uint32 {
+	return m.stolen
+}
+
+// Text returns the characters displayed in the last frame, decoded from the
+// KOI-7 character set, with one line per character row.
+func (m *Machine) Text() string {
+	return m.crt.TextFunc(decode)
+}
+
+// Keyboard port C. The lower half is output, the upper half input.
+const (
+	tapeOutBit = 0x01
+	tapeInBit  = 0x10
+	shiftBit   = 0x20
+	ctrlBit    = 0x40
+	rusLatBit  = 0x80
+)
+
+// portC returns the inputs of the keyboard PPI's port C: the tape input and
+// the active low modifier keys.
+func (m *Machine) portC() byte {
+	var v byte
+	if !m.keyboard.down[Shift] {
+		v |= shiftBit
+	}
+	if !m.keyboard.down[Ctrl] {
+		v |= ctrlBit
+	}
+	if !m.keyboard.down[RusLat] {
+		v |= rusLatBit
+	}
+	if m.tapeIn != nil && m.tapeIn(m.cpu.Cycles()) {
+		v |= tapeInBit
+	}
+
+	return v
+}
+
+// setPortC handles writes to the keyboard PPI's port C, passing changes of
+// the tape output on.
+func (m *Machine) setPortC(v byte) {
+	level := v&tapeOutBit != 0
+	if m.tapeOut != nil && level != m.tapeLevel {
+		m.tapeOut(m.cpu.Cycles(), level)
+	}
+	m.tapeLevel = level
+}
+
+// decode decodes a KOI-7 N2 character code, as displayed by the Radio-86RK's
+// character generator: ASCII with the lower case letters replaced by upper
+// case Cyrillic.
+func decode(b byte) rune {
+	const cyrillic = "ЮАБЦДЕФГХИЙКЛМНОПЯРСТУЖВЬЫЗШЭЩЧ"
+
+	switch b &= 0x7f; {
+	case b < 0x20 || b == 0x7f:
+		return ' '
+	case b >= 0x60:
+		return []rune(cyrillic)[b-0x60]
+	}
+
+	return rune(b)
+}
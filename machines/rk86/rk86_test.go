@@ -0,0 +1,213 @@
+package rk86
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/devices/i8255"
+)
+
+// A stand in for the monitor which sets up the display as the real one does,
+// pulses the tape output, prints a banner and then echoes letter keys,
+// shifted letters as the Cyrillic in their place.
+const monitor = `
+	ORG	0F800H
+SCREEN	EQU	76D0H
+	LXI	SP,7600H
+	MVI	A,8AH
+	STA	8003H
+	MVI	A,01H
+	STA	8003H
+	DCR	A
+	STA	8003H
+	LXI	H,0C001H
+	MVI	M,00H
+	DCX	H
+	MVI	M,4DH
+	MVI	M,1DH
+	MVI	M,99H
+	MVI	M,93H
+	INX	H
+	MVI	M,27H
+	LXI	H,0E008H
+	MVI	M,80H
+	MVI	L,04H
+	MVI	M,SCREEN AND 0FFH
+	MVI	M,SCREEN SHR 8
+	INR	L
+	MVI	M,23H
+	MVI	M,49H
+	MVI	L,08H
+	MVI	M,0A4H
+	LXI	D,SCREEN
+	LXI	H,BANNER
+PRINT:	MOV	A,M
+	ORA	A
+	JZ	KEYS
+	STAX	D
+	INX	H
+	INX	D
+	JMP	PRINT
+KEYS:	XRA	A
+	STA	8000H
+	LDA	8001H
+	INR	A
+	JZ	KEYS
+	MVI	B,0EFH
+	MVI	C,40H
+COL:	MOV	A,B
+	STA	8000H
+	LDA	8001H
+	CMA
+	ORA	A
+	JNZ	FOUND
+	MOV	A,B
+	RLC
+	MOV	B,A
+	MOV	A,C
+	ADI	8
+	MOV	C,A
+	CPI	60H
+	JNZ	COL
+	JMP	KEYS
+FOUND:	RRC
+	JC	GOT
+	INR	C
+	JMP	FOUND
+GOT:	LDA	8002H
+	ANI	20H
+	MOV	A,C
+	JNZ	STORE
+	ORI	20H
+STORE:	STAX	D
+	INX	D
+UP:	XRA	A
+	STA	8000H
+	LDA	8001H
+	INR	A
+	JNZ	UP
+	JMP	KEYS
+BANNER:	DB	'RK86',0
+`
+
+func newMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	prog, err := asm.Assemble("monitor.asm", strings.NewReader(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(prog.Binary(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func frames(t *testing.T, m *Machine, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if err := m.Frame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	m := newMachine(t)
+	if v := m.CPU().Variant(); v != go8080.KR580VM80A {
+		t.Errorf("variant = %v, want KR580VM80A", v)
+	}
+
+	frames(t, m, 2)
+	want := "RK86\n" + strings.Repeat("\n", 29)
+	if got := m.Text(); got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if cols, rows := m.CRT().Size(); cols != 78 || rows != 30 {
+		t.Errorf("size = %dx%d, want 78x30", cols, rows)
+	}
+
+	// The DMA reloads at the end of each frame, taking four cycles a
+	// character.
+	frames(t, m, 1)
+	if got, want := m.StolenCycles(), uint32(78*30*4); got != want {
+		t.Errorf("stolen cycles = %d, want %d", got, want)
+	}
+	if got := m.Text(); !strings.HasPrefix(got, "RK86\n") {
+		t.Errorf("text after reload = %q", got)
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	m := newMachine(t)
+	frames(t, m, 1)
+
+	m.Type("hi!Z")
+	for i := 0; m.KeysPending() > 0; i++ {
+		if i == 100 {
+			t.Fatal("keys not typed")
+		}
+		frames(t, m, 1)
+	}
+
+	// Shifted letters display as Cyrillic.
+	m.Press(Shift)
+	k, _ := CharKey('A')
+	m.Press(k)
+	frames(t, m, 2)
+	m.Release(k)
+	m.Release(Shift)
+	frames(t, m, 2)
+
+	// The monitor ignores the exclamation mark.
+	want := "RK86HIZА\n"
+	if got := m.Text(); !strings.HasPrefix(got, want) {
+		t.Errorf("text = %q, want prefix %q", got, want)
+	}
+}
+
+func TestTape(t *testing.T) {
+	var levels []bool
+	m := newMachine(t,
+		WithTapeInput(func(uint32) bool { return true }),
+		WithTapeOutput(func(_ uint32, level bool) { levels = append(levels, level) }),
+	)
+	frames(t, m, 1)
+
+	if len(levels) != 2 || !levels[0] || levels[1] {
+		t.Errorf("tape output = %v, want [true false]", levels)
+	}
+	if v := m.kbd.In(i8255.C); v&tapeInBit == 0 {
+		t.Errorf("port C = %02x, want tape input set", v)
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	m := newMachine(t)
+	mem := m.Memory()
+
+	mem.Write(0x1234, 0x55)
+	if got := mem.Read(0x1234); got != 0x55 {
+		t.Errorf("RAM = %02x, want 55", got)
+	}
+
+	// The ROM repeats from 0E000H and cannot be written.
+	for _, addr := range []uint16{0xe000, 0xe800, ROMAddr} {
+		if got := mem.Read(addr); got != 0x31 {
+			t.Errorf("%04x = %02x, want 31", addr, got)
+		}
+	}
+	mem.Write(ROMAddr, 0)
+	if got := mem.Read(ROMAddr); got != 0x31 {
+		t.Errorf("ROM written: %02x", got)
+	}
+
+	if _, err := New(make([]byte, ROMSize+1)); err == nil {
+		t.Error("New succeeded with an oversized ROM")
+	}
+}
@@ -0,0 +1,95 @@
+package sdk85
+
+import (
+	"strings"
+)
+
+// Digits is the number of 7-segment digits: four in the address field and
+// two in the data field.
+const Digits = 6
+
+// Segments, as the bits of a display RAM byte. A set bit lights the segment.
+const (
+	SegE  = 0x01
+	SegF  = 0x02
+	SegG  = 0x04
+	SegDP = 0x08
+	SegA  = 0x10
+	SegB  = 0x20
+	SegC  = 0x40
+	SegD  = 0x80
+)
+
+// glyphs maps segment patterns, without the decimal point, to the characters
+// they show.
+var glyphs = map[byte]rune{
+	0:                                              ' ',
+	SegA | SegB | SegC | SegD | SegE | SegF:        '0',
+	SegB | SegC:                                    '1',
+	SegA | SegB | SegD | SegE | SegG:               '2',
+	SegA | SegB | SegC | SegD | SegG:               '3',
+	SegB | SegC | SegF | SegG:                      '4',
+	SegA | SegC | SegD | SegF | SegG:               '5',
+	SegA | SegC | SegD | SegE | SegF | SegG:        '6',
+	SegA | SegB | SegC:                             '7',
+	SegA | SegB | SegC | SegD | SegE | SegF | SegG: '8',
+	SegA | SegB | SegC | SegF | SegG:               '9',
+	SegA | SegB | SegC | SegE | SegF | SegG:        'A',
+	SegC | SegD | SegE | SegF | SegG:               'b',
+	SegA | SegD | SegE | SegF:                      'C',
+	SegB | SegC | SegD | SegE | SegG:               'd',
+	SegA | SegD | SegE | SegF | SegG:               'E',
+	SegA | SegE | SegF | SegG:                      'F',
+	SegB | SegC | SegE | SegF | SegG:               'H',
+	SegD | SegE | SegF:                             'L',
+	SegA | SegB | SegE | SegF | SegG:               'P',
+	SegB | SegC | SegD | SegE | SegF:               'U',
+	SegC | SegD | SegE | SegG:                      'o',
+	SegE | SegG:                                    'r',
+	SegG:                                           '-',
+}
+
+// Segments returns the segments lit on each digit, from the left.
+func (m *Machine) Segments() [Digits]byte {
+	var s [Digits]byte
+	copy(s[:], m.kdc.Display())
+
+	return s
+}
+
+// Display returns the characters shown on the six digits, with a space
+// between the address and data fields. A digit with its decimal point lit is
+// followed by ".", and a pattern which is not a character is shown as "?".
+func (m *Machine) Display() string {
+	return m.AddressField() + " " + m.DataField()
+}
+
+// AddressField returns the characters shown on the four digits of the
+// address field.
+func (m *Machine) AddressField() string {
+	s := m.Segments()
+	return decode(s[:4])
+}
+
+// DataField returns the characters shown on the two digits of the data field.
+func (m *Machine) DataField() string {
+	s := m.Segments()
+	return decode(s[4:])
+}
+
+// decode returns the characters shown by the given digits.
+func decode(segs []byte) string {
+	var sb strings.Builder
+	for _, s := range segs {
+		c, ok := glyphs[s&^SegDP]
+		if !ok {
+			c = '?'
+		}
+		sb.WriteRune(c)
+		if s&SegDP != 0 {
+			sb.WriteByte('.')
+		}
+	}
+
+	return sb.String()
+}
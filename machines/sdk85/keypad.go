@@ -0,0 +1,69 @@
+package sdk85
+
+import (
+	"fmt"
+)
+
+// Key is a key on the SDK-85 keypad, as the character the 8279 enters in its
+// FIFO: the scan row in bits 5-3 and the return line in bits 2-0. The hex
+// keys are on the first two rows, so their codes are their values.
+type Key byte
+
+// The command keys, on the third row.
+const (
+	Exec       Key = 0x10 // EXEC, or "."
+	Next       Key = 0x11 // NEXT, or ","
+	Go         Key = 0x12
+	SubstMem   Key = 0x13 // SUBST MEM
+	ExamReg    Key = 0x14 // EXAM REG
+	SingleStep Key = 0x15
+)
+
+// ParseKeys returns the keys typed by a string: hex digits, "." for EXEC, ","
+// for NEXT, and G, M, X and S for GO, SUBST MEM, EXAM REG and SINGLE STEP.
+// Spaces are ignored. For example "M0800,3E,." substitutes 3EH at 0800H.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, c := range s {
+		var k Key
+		switch {
+		case c >= '0' && c <= '9':
+			k = Key(c - '0')
+		case c >= 'A' && c <= 'F':
+			k = Key(c-'A') + 0x0a
+		case c >= 'a' && c <= 'f':
+			k = Key(c-'a') + 0x0a
+		case c == '.':
+			k = Exec
+		case c == ',':
+			k = Next
+		case c == 'G' || c == 'g':
+			k = Go
+		case c == 'M' || c == 'm':
+			k = SubstMem
+		case c == 'X' || c == 'x':
+			k = ExamReg
+		case c == 'S' || c == 's':
+			k = SingleStep
+		case c == ' ':
+			continue
+		default:
+			return nil, fmt.Errorf("sdk85: no key for %q", c)
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// Type queues key presses, entered into the 8279 one at a time as the
+// monitor reads the previous one, so a script of key presses always has the
+// same effect.
+func (m *Machine) Type(keys ...Key) {
+	m.keys = append(m.keys, keys...)
+}
+
+// KeysPending returns the number of typed keys which have not yet been read.
+func (m *Machine) KeysPending() int {
+	return len(m.keys) + m.kdc.Pending()
+}
@@ -0,0 +1,314 @@
+// Package sdk85 emulates the Intel SDK-85 System Design Kit, a single board
+// 8085 trainer.
+//
+// The board has an 8355 holding the monitor ROM, an 8155 with 256 bytes of
+// RAM, I/O ports and a timer, and an 8279 scanning the hex keypad and six
+// 7-segment digits. Sockets for a second 8755A (or 8355) and a second 8155
+// can be filled with WithExpansionROM and WithExpansionRAM. The monitor ROM is
+// not included and must be supplied.
+//
+// The 8155's timer is clocked from the CPU clock and its output drives TRAP,
+// which the monitor uses to single step. The 8279's interrupt request drives
+// RST 5.5 and the VECT INTR key RST 7.5. The teletype interface on SID and SOD
+// can be connected with WithCPUOptions, using go8080.WithSerialInput and
+// go8080.WithSerialOutput.
+package sdk85
+
+import (
+	"fmt"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/bus"
+	"github.com/danmrichards/go8080/devices/i8155"
+	"github.com/danmrichards/go8080/devices/i8279"
+	"github.com/danmrichards/go8080/devices/i8755"
+)
+
+// Clock is the CPU clock rate in Hz: the 6.144MHz crystal divided by two.
+const Clock = 3072000
+
+// Memory map. The address decoder selects a chip for each 2K block, and each
+// chip is repeated throughout its block. I/O ports are decoded in the same
+// way from bits 3-5 of the port number, so the monitor ROM's ports are at 00H,
+// the RAM's at 20H and so on.
+const (
+	ROMAddr          = 0x0000
+	ExpansionROMAddr = 0x0800
+	KeyDisplayAddr   = 0x1800
+	RAMAddr          = 0x2000
+	ExpansionRAMAddr = 0x2800
+
+	// The 8279 register is selected by address bit 8.
+	KeyDisplayCommand = KeyDisplayAddr + 0x100
+
+	blockShift = 11
+)
+
+// floating is read from unpopulated memory and ports.
+const floating = 0xff
+
+type (
+	// Machine is an SDK-85.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem *memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		rom, expROM *i8755.ROMIO
+		ram, expRAM *i8155.RAMIO
+		kdc         *i8279.KDC
+
+		// Additional I/O devices and the ports they are to be attached at.
+		ports   bus.Ports
+		devices []device
+
+		// Keys typed but not yet entered into the 8279.
+		keys []Key
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the SDK-85 address space.
+	memory struct {
+		m    *Machine
+		data []byte
+	}
+
+	// device is an I/O device and its base port.
+	device struct {
+		base byte
+		dev  bus.Device
+	}
+)
+
+// Read returns the value from memory at the given address.
+func (mem *memory) Read(addr uint16) byte {
+	m := mem.m
+	switch addr >> blockShift {
+	case ROMAddr >> blockShift:
+		return m.rom.Read(addr)
+	case ExpansionROMAddr >> blockShift:
+		if m.expROM != nil {
+			return m.expROM.Read(addr)
+		}
+	case KeyDisplayAddr >> blockShift:
+		return m.kdc.In(byte(addr >> 8))
+	case RAMAddr >> blockShift:
+		return m.ram.Read(byte(addr))
+	case ExpansionRAMAddr >> blockShift:
+		if m.expRAM != nil {
+			return m.expRAM.Read(byte(addr))
+		}
+	}
+
+	return floating
+}
+
+// ReadAll returns the full memory contents, as read at each address. The
+// 8279 is not read, so that its FIFO is undisturbed.
+func (mem *memory) ReadAll() []byte {
+	for addr := range mem.data {
+		if addr>>blockShift == KeyDisplayAddr>>blockShift {
+			mem.data[addr] = floating
+			continue
+		}
+		mem.data[addr] = mem.Read(uint16(addr))
+	}
+
+	return mem.data
+}
+
+// Write writes the value v into memory at the given address, unless it is
+// ROM or unpopulated.
+func (mem *memory) Write(addr uint16, v byte) {
+	m := mem.m
+	switch addr >> blockShift {
+	case KeyDisplayAddr >> blockShift:
+		m.kdc.Out(byte(addr>>8), v)
+	case RAMAddr >> blockShift:
+		m.ram.Write(byte(addr), v)
+	case ExpansionRAMAddr >> blockShift:
+		if m.expRAM != nil {
+			m.expRAM.Write(byte(addr), v)
+		}
+	}
+}
+
+// WithExpansionROM fits a second 8755A holding the given program, of up to
+// 2K, at 0800H.
+func WithExpansionROM(rom []byte) Option {
+	return func(m *Machine) {
+		m.expROM = i8755.New(rom)
+	}
+}
+
+// WithExpansionRAM fits a second 8155 at 2800H.
+func WithExpansionRAM() Option {
+	return func(m *Machine) {
+		m.expRAM = i8155.New()
+	}
+}
+
+// WithDevice attaches an additional I/O device decoding the ports from base.
+// The ports of the fitted chips take priority.
+func WithDevice(base byte, d bus.Device) Option {
+	return func(m *Machine) {
+		m.devices = append(m.devices, device{base, d})
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU, which is an
+// 8085.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// New returns an SDK-85 running the given monitor ROM from reset. It is an
+// error for the ROM to be larger than 2K or for the ports of two devices to
+// overlap.
+func New(rom []byte, opts ...Option) (*Machine, error) {
+	if len(rom) > i8755.ROMSize {
+		return nil, fmt.Errorf("sdk85: ROM is %d bytes, larger than %d", len(rom), i8755.ROMSize)
+	}
+
+	m := &Machine{
+		rom: i8755.New(rom),
+		kdc: i8279.New(),
+	}
+	m.mem = &memory{m: m, data: make([]byte, 0x10000)}
+	m.ram = i8155.New(i8155.WithTimerOutput(func() {
+		m.cpu.Trap()
+	}))
+
+	for _, o := range opts {
+		o(m)
+	}
+	for _, d := range m.devices {
+		if err := m.ports.Attach(d.base, d.dev); err != nil {
+			return nil, err
+		}
+	}
+
+	m.cpu = go8080.NewIntel8080(m.mem, append([]go8080.Option{
+		go8080.WithVariant(go8080.Intel8085),
+		go8080.WithInput(m.input),
+		go8080.WithOutput(m.output),
+	}, m.cpuOpts...)...)
+	m.Reset()
+
+	return m, nil
+}
+
+// CPU returns the machine's CPU.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the machine's memory.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// ROM returns the 8355 holding the monitor ROM, whose ports are free for the
+// user.
+func (m *Machine) ROM() *i8755.ROMIO {
+	return m.rom
+}
+
+// RAM returns the 8155, whose ports are free for the user.
+func (m *Machine) RAM() *i8155.RAMIO {
+	return m.ram
+}
+
+// KeyDisplay returns the 8279 keyboard/display controller.
+func (m *Machine) KeyDisplay() *i8279.KDC {
+	return m.kdc
+}
+
+// Reset emulates the RESET key, which resets the CPU and starts the monitor.
+// Memory is untouched.
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+}
+
+// VectorInterrupt emulates the VECT INTR key, which interrupts the CPU
+// through RST 7.5.
+func (m *Machine) VectorInterrupt() {
+	m.cpu.SetInterruptInput(go8080.RST75, true)
+	m.cpu.SetInterruptInput(go8080.RST75, false)
+}
+
+// Execute runs the machine for at least the given number of CPU cycles.
+func (m *Machine) Execute(cycles uint32) error {
+	for end := m.cpu.Cycles() + cycles; int32(m.cpu.Cycles()-end) < 0; {
+		if len(m.keys) > 0 && m.kdc.Pending() == 0 {
+			m.kdc.Key(byte(m.keys[0]))
+			m.keys = m.keys[1:]
+		}
+		m.cpu.SetInterruptInput(go8080.RST55, m.kdc.IRQ())
+
+		before := m.cpu.Cycles()
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+		n := m.cpu.Cycles() - before
+		m.ram.Clock(n)
+		if m.expRAM != nil {
+			m.expRAM.Clock(n)
+		}
+	}
+
+	return nil
+}
+
+// input handles the IN instruction.
+func (m *Machine) input(port byte) byte {
+	d, ok := m.chip(port)
+	switch {
+	case !ok:
+		return m.ports.In(port)
+	case int(port&0x07) < d.Ports():
+		return d.In(port & 0x07)
+	}
+
+	return floating
+}
+
+// output handles the OUT instruction.
+func (m *Machine) output(port byte) {
+	v := m.cpu.Accumulator()
+	d, ok := m.chip(port)
+	switch {
+	case !ok:
+		m.ports.Out(port, v)
+	case int(port&0x07) < d.Ports():
+		d.Out(port&0x07, v)
+	}
+}
+
+// chip returns the fitted chip decoding the given port, if any. The port
+// number appears on both halves of the address bus, so the chip is selected
+// as if it were the top byte of a memory address.
+func (m *Machine) chip(port byte) (bus.Device, bool) {
+	switch uint16(port) << 8 >> blockShift {
+	case ROMAddr >> blockShift:
+		return m.rom, true
+	case ExpansionROMAddr >> blockShift:
+		if m.expROM != nil {
+			return m.expROM, true
+		}
+	case RAMAddr >> blockShift:
+		return m.ram, true
+	case ExpansionRAMAddr >> blockShift:
+		if m.expRAM != nil {
+			return m.expRAM, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,222 @@
+package sdk85
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/devices/i8155"
+)
+
+// A stand in for the monitor which signs on with 8085, then shows the last
+// two keys pressed in the data field, hex keys as digits and others as a
+// dash. GO starts the timer, whose TRAP is counted at 2081H with the timer
+// status read in the handler at 2082H; VECT INTR sets 2083H.
+const monitor = `
+	ORG	0
+	JMP	START
+	ORG	24H
+	JMP	TRAPH
+	ORG	2CH
+	JMP	KEY
+	ORG	3CH
+	JMP	VECT
+	ORG	40H
+START:	LXI	SP,20F0H
+	XRA	A
+	STA	1900H
+	MVI	A,90H
+	STA	1900H
+	LXI	H,SIGNON
+	MVI	B,6
+SHOW:	MOV	A,M
+	STA	1800H
+	INX	H
+	DCR	B
+	JNZ	SHOW
+	MVI	A,0AH
+	SIM
+	EI
+WAIT:	HLT
+	JMP	WAIT
+KEY:	PUSH	PSW
+	PUSH	B
+	PUSH	H
+	LDA	1800H
+	CPI	10H
+	JNC	CMD
+	LXI	H,DIGITS
+	ADD	L
+	MOV	L,A
+	MOV	A,M
+SHOWK:	MOV	B,A
+	MVI	A,94H
+	STA	1900H
+	LDA	2080H
+	STA	1800H
+	MOV	A,B
+	STA	1800H
+	STA	2080H
+	POP	H
+	POP	B
+	POP	PSW
+	EI
+	RET
+CMD:	CPI	12H
+	JNZ	DASH
+	MVI	A,64H
+	OUT	24H
+	MVI	A,80H
+	OUT	25H
+	MVI	A,0C0H
+	OUT	20H
+DASH:	MVI	A,04H
+	JMP	SHOWK
+TRAPH:	PUSH	PSW
+	LDA	2081H
+	INR	A
+	STA	2081H
+	IN	20H
+	STA	2082H
+	POP	PSW
+	EI
+	RET
+VECT:	PUSH	PSW
+	MVI	A,1
+	STA	2083H
+	POP	PSW
+	EI
+	RET
+SIGNON:	DB	0F7H,0F3H,0F7H,0D6H,0,0
+	ORG	100H
+DIGITS:	DB	0F3H,60H,0B5H,0F4H,66H,0D6H,0D7H,70H
+	DB	0F7H,76H,77H,0C7H,93H,0E5H,97H,17H
+`
+
+func newMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	prog, err := asm.Assemble("monitor.asm", strings.NewReader(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(prog.Binary(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// typeKeys types the given keys and runs the machine until they are read.
+func typeKeys(t *testing.T, m *Machine, s string) {
+	t.Helper()
+
+	keys, err := ParseKeys(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Type(keys...)
+	for i := 0; m.KeysPending() > 0; i++ {
+		if i == 1000 {
+			t.Fatal("keys not read")
+		}
+		if err := m.Execute(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Execute(1000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeypadDisplay(t *testing.T) {
+	m := newMachine(t)
+	if err := m.Execute(1000); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Display(), "8085   "; got != want {
+		t.Errorf("display = %q, want %q", got, want)
+	}
+
+	typeKeys(t, m, "1a")
+	if got, want := m.DataField(), "1A"; got != want {
+		t.Errorf("data field = %q, want %q", got, want)
+	}
+	typeKeys(t, m, ",")
+	if got, want := m.Display(), "8085 A-"; got != want {
+		t.Errorf("display = %q, want %q", got, want)
+	}
+
+	if _, err := ParseKeys("Z"); err == nil {
+		t.Error("ParseKeys succeeded with no such key")
+	}
+}
+
+func TestTimerTrap(t *testing.T) {
+	m := newMachine(t)
+	typeKeys(t, m, "G")
+
+	mem := m.Memory()
+	if got := mem.Read(0x2081); got != 1 {
+		t.Errorf("traps = %d, want 1", got)
+	}
+	if got := mem.Read(0x2082); got != i8155.TimerDone {
+		t.Errorf("timer status = %02x, want %02x", got, i8155.TimerDone)
+	}
+	if m.RAM().Running() {
+		t.Error("single pulse timer still running")
+	}
+}
+
+func TestVectorInterrupt(t *testing.T) {
+	m := newMachine(t)
+	if err := m.Execute(1000); err != nil {
+		t.Fatal(err)
+	}
+	m.VectorInterrupt()
+	if err := m.Execute(1000); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Memory().Read(0x2083); got != 1 {
+		t.Errorf("vector interrupt flag = %d, want 1", got)
+	}
+
+	m.Reset()
+	if pc := m.CPU().ProgramCounter(); pc != 0 {
+		t.Errorf("PC after reset = %04x, want 0000", pc)
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	m := newMachine(t, WithExpansionRAM())
+	mem := m.Memory()
+
+	for _, tc := range []struct {
+		addr     uint16
+		writable bool
+	}{
+		{ROMAddr, false},
+		{ExpansionROMAddr, false},
+		{RAMAddr, true},
+		{RAMAddr + 0x7ff, true},
+		{ExpansionRAMAddr, true},
+		{0x3000, false},
+	} {
+		before := mem.Read(tc.addr)
+		mem.Write(tc.addr, before^0x55)
+		if got := mem.Read(tc.addr) != before; got != tc.writable {
+			t.Errorf("%04x writable = %v, want %v", tc.addr, got, tc.writable)
+		}
+	}
+
+	// The RAM repeats through its block.
+	mem.Write(RAMAddr+0x10, 0x42)
+	if got := mem.Read(RAMAddr + 0x110); got != 0x42 {
+		t.Errorf("RAM mirror = %02x, want 42", got)
+	}
+
+	if _, err := New(make([]byte, 0x801)); err == nil {
+		t.Error("New succeeded with an oversized ROM")
+	}
+}
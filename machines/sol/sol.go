@@ -0,0 +1,304 @@
+// Package sol emulates the Processor Technology Sol-20.
+//
+// The Sol-20 combines an 8080 with a keyboard, a serial port and a VDM-1
+// compatible display in one case. Its monitor, SOLOS, or CUTER on machines
+// without the Sol's hardware, runs from a 2K ROM at 0C000H, followed by 1K of
+// scratchpad RAM used by the monitor and the 1K of display memory.
+//
+// Execution only advances when Execute is called, and keys typed with Type
+// are delivered one at a time as the monitor polls for them, so a script of
+// keystrokes always produces the same screen.
+package sol
+
+import (
+	"fmt"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/bus"
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// Memory map.
+const (
+	ROMAddr     = 0xc000
+	ROMSize     = 0x800
+	ScratchAddr = 0xc800
+	ScratchSize = 0x400
+	VideoAddr   = 0xcc00
+	VideoSize   = Columns * Rows
+	MaxRAM      = ROMAddr
+)
+
+// Built in ports.
+const (
+	SerialStatusPort   = 0xf8
+	SerialDataPort     = 0xf9
+	StatusPort         = 0xfa
+	KeyboardPort       = 0xfc
+	DisplayControlPort = 0xfe
+	SenseSwitchPort    = 0xff
+)
+
+// Status port bits. The keyboard, parallel input and parallel output ready
+// bits are active low.
+const (
+	statusKeyboardReady = 0x01
+	statusParallelReady = 0x02
+	statusDeviceReady   = 0x04
+	statusIdle          = statusKeyboardReady | statusParallelReady | statusDeviceReady
+)
+
+// Serial status port bits.
+const (
+	serialDataReady = 0x40
+	serialTxEmpty   = 0x80
+)
+
+// floating is read from unpopulated memory.
+const floating = 0xff
+
+type (
+	// Machine is a Sol-20.
+	Machine struct {
+		cpu *go8080.Intel8080
+		mem *memory
+
+		// Options passed through to the CPU.
+		cpuOpts []go8080.Option
+
+		// Additional I/O devices and the ports they are to be attached at.
+		ports   bus.Ports
+		devices []device
+
+		// Keys typed but not yet read.
+		keys []byte
+
+		serial   *serial.Line
+		serialRx byte
+		serialOK bool
+
+		senseSwitches byte
+
+		// VDM-1 display control: the memory row shown at the top of the
+		// screen, and the number of rows blanked from the top.
+		startRow, blankRows int
+	}
+
+	// Option is a functional option that modifies a field on the machine.
+	Option func(*Machine)
+
+	// memory is the Sol-20 address space.
+	memory struct {
+		data    []byte
+		ramSize int
+	}
+
+	// device is an I/O device and its base port.
+	device struct {
+		base byte
+		dev  bus.Device
+	}
+)
+
+// Read returns the value from memory at the given address.
+func (m *memory) Read(addr uint16) byte {
+	if int(addr) >= m.ramSize && addr < ROMAddr || addr >= VideoAddr+VideoSize {
+		return floating
+	}
+
+	return m.data[addr]
+}
+
+// ReadAll returns the full memory contents.
+func (m *memory) ReadAll() []byte {
+	return m.data
+}
+
+// Write writes the value v into memory at the given address, unless it is
+// ROM or unpopulated.
+func (m *memory) Write(addr uint16, v byte) {
+	switch {
+	case int(addr) < m.ramSize, addr >= ScratchAddr && addr < VideoAddr+VideoSize:
+		m.data[addr] = v
+	}
+}
+
+// WithMemory sets the amount of RAM fitted from address 0, in bytes. The
+// default, and the most that fits below the ROM, is 48K.
+func WithMemory(size int) Option {
+	return func(m *Machine) {
+		if size > MaxRAM {
+			size = MaxRAM
+		}
+		m.mem.ramSize = size
+	}
+}
+
+// WithSerial connects the serial port to a line. Without it the serial port
+// never receives anything and its output is discarded.
+func WithSerial(line *serial.Line) Option {
+	return func(m *Machine) {
+		m.serial = line
+	}
+}
+
+// WithSenseSwitches sets the sense switches read from port 0FFH.
+func WithSenseSwitches(v byte) Option {
+	return func(m *Machine) {
+		m.senseSwitches = v
+	}
+}
+
+// WithDevice attaches an additional I/O device decoding the ports from base.
+// The built in ports take priority.
+func WithDevice(base byte, d bus.Device) Option {
+	return func(m *Machine) {
+		m.devices = append(m.devices, device{base, d})
+	}
+}
+
+// WithCPUOptions passes the given options through to the CPU.
+func WithCPUOptions(opts ...go8080.Option) Option {
+	return func(m *Machine) {
+		m.cpuOpts = append(m.cpuOpts, opts...)
+	}
+}
+
+// New returns a Sol-20 running the given monitor ROM, such as SOLOS, from
+// its reset address. It is an error for the ROM to be larger than 2K or for
+// the ports of two devices to overlap.
+func New(rom []byte, opts ...Option) (*Machine, error) {
+	if len(rom) > ROMSize {
+		return nil, fmt.Errorf("sol: ROM is %d bytes, larger than %d", len(rom), ROMSize)
+	}
+
+	m := &Machine{
+		mem: &memory{
+			data:    make([]byte, 0x10000),
+			ramSize: MaxRAM,
+		},
+		serial: serial.NewLine(nil, nil),
+	}
+	for i := range m.mem.data[ROMAddr : ROMAddr+ROMSize] {
+		m.mem.data[ROMAddr+i] = floating
+	}
+	copy(m.mem.data[ROMAddr:], rom)
+
+	for _, o := range opts {
+		o(m)
+	}
+	for _, d := range m.devices {
+		if err := m.ports.Attach(d.base, d.dev); err != nil {
+			return nil, err
+		}
+	}
+
+	m.cpu = go8080.NewIntel8080(m.mem, append([]go8080.Option{
+		go8080.WithInput(m.input),
+		go8080.WithOutput(m.output),
+	}, m.cpuOpts...)...)
+	m.Reset()
+
+	return m, nil
+}
+
+// CPU returns the machine's CPU.
+func (m *Machine) CPU() *go8080.Intel8080 {
+	return m.cpu
+}
+
+// Memory returns the machine's memory.
+func (m *Machine) Memory() go8080.MemReadWriter {
+	return m.mem
+}
+
+// Reset resets the CPU, which starts the monitor. Memory is untouched.
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+	m.cpu.SetProgramCounter(ROMAddr)
+}
+
+// Execute runs the machine for at least the given number of CPU cycles.
+func (m *Machine) Execute(cycles uint32) error {
+	for end := m.cpu.Cycles() + cycles; int32(m.cpu.Cycles()-end) < 0; {
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Type queues keystrokes to be read from the keyboard, one each time the
+// previous key has been read. Newlines are typed as the RETURN key.
+func (m *Machine) Type(s string) {
+	for _, b := range []byte(s) {
+		if b == '\n' {
+			b = '\r'
+		}
+		m.keys = append(m.keys, b)
+	}
+}
+
+// KeysPending returns the number of typed keys which have not yet been read.
+func (m *Machine) KeysPending() int {
+	return len(m.keys)
+}
+
+// input handles the IN instruction.
+func (m *Machine) input(port byte) byte {
+	switch port {
+	case StatusPort:
+		if len(m.keys) > 0 {
+			return statusIdle &^ statusKeyboardReady
+		}
+		return statusIdle
+
+	case KeyboardPort:
+		if len(m.keys) == 0 {
+			return 0
+		}
+		k := m.keys[0]
+		m.keys = m.keys[1:]
+		return k
+
+	case SerialStatusPort:
+		if !m.serialOK {
+			if b, ok := m.serial.Receive(); ok {
+				m.serialRx, m.serialOK = b, true
+			}
+		}
+		if m.serialOK {
+			return serialTxEmpty | serialDataReady
+		}
+		return serialTxEmpty
+
+	case SerialDataPort:
+		m.serialOK = false
+		return m.serialRx
+
+	case SenseSwitchPort:
+		return m.senseSwitches
+	}
+
+	return m.ports.In(port)
+}
+
+// output handles the OUT instruction.
+func (m *Machine) output(port byte) {
+	v := m.cpu.Accumulator()
+
+	switch port {
+	case SerialDataPort:
+		m.serial.Transmit(v)
+
+	case DisplayControlPort:
+		m.startRow, m.blankRows = int(v&0x0f), int(v>>4)
+
+	case SerialStatusPort, StatusPort, KeyboardPort, SenseSwitchPort:
+		// Input only.
+
+	default:
+		m.ports.Out(port, v)
+	}
+}
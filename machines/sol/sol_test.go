@@ -0,0 +1,166 @@
+package sol
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/danmrichards/go8080"
+	"github.com/danmrichards/go8080/asm"
+	"github.com/danmrichards/go8080/devices/serial"
+)
+
+// A stand in for the monitor which writes typed keys to the display, with an
+// inverse video cursor, and echoes the serial port.
+const monitor = `
+	ORG	0C000H
+	LXI	SP,0CBFFH
+	XRA	A
+	OUT	0FEH
+	LXI	H,0CC00H
+LOOP:	MVI	M,0A0H
+	IN	0FAH
+	CMA
+	ANI	1
+	JZ	SERIAL
+	IN	0FCH
+	CPI	0DH
+	JZ	NEWLN
+	MOV	M,A
+	INX	H
+	JMP	LOOP
+NEWLN:	MVI	M,' '
+	MOV	A,L
+	ANI	0C0H
+	ADI	40H
+	MOV	L,A
+	JNC	LOOP
+	INR	H
+	JMP	LOOP
+SERIAL:	IN	0F8H
+	ANI	40H
+	JZ	LOOP
+	IN	0F9H
+	OUT	0F9H
+	JMP	LOOP
+`
+
+func newMachine(t *testing.T, opts ...Option) *Machine {
+	t.Helper()
+
+	prog, err := asm.Assemble("monitor.asm", strings.NewReader(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(prog.Binary(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestDisplay(t *testing.T) {
+	m := newMachine(t)
+	m.Type("HI\nTHERE")
+	for i := 0; m.KeysPending() > 0; i++ {
+		if i == 1000 {
+			t.Fatal("keys not read")
+		}
+		if err := m.Execute(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Execute(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "HI\nTHERE\n" + strings.Repeat("\n", Rows-2)
+	if got := m.Text(); got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+
+	img := m.Screen()
+	white := color.Gray{Y: 0xff}
+	for _, p := range []struct {
+		x, y int
+		on   bool
+	}{
+		// The left and right strokes of the top of the H.
+		{glyphX, glyphY, true},
+		{glyphX + 1, glyphY, false},
+		{glyphX + 4, glyphY, true},
+		// The cursor cell after THERE is solid.
+		{5 * CellWidth, CellHeight, true},
+		{6*CellWidth - 1, 2*CellHeight - 1, true},
+		// Outside the characters.
+		{0, 0, false},
+	} {
+		if on := img.GrayAt(p.x, p.y) == white; on != p.on {
+			t.Errorf("dot at %d,%d = %v, want %v", p.x, p.y, on, p.on)
+		}
+	}
+
+	// Start the display at the second memory row, wrapping round to the
+	// first.
+	m.cpu.SetRegister(go8080.A, 0x01)
+	m.output(DisplayControlPort)
+	want = "THERE\n" + strings.Repeat("\n", Rows-2) + "HI\n"
+	if got := m.Text(); got != want {
+		t.Errorf("scrolled text = %q, want %q", got, want)
+	}
+
+	// Blank the top row.
+	m.cpu.SetRegister(go8080.A, 0x10)
+	m.output(DisplayControlPort)
+	want = "\nTHERE\n" + strings.Repeat("\n", Rows-2)
+	if got := m.Text(); got != want {
+		t.Errorf("blanked text = %q, want %q", got, want)
+	}
+}
+
+func TestSerial(t *testing.T) {
+	var out bytes.Buffer
+	line := serial.NewLine(strings.NewReader("HELLO"), &out)
+	m := newMachine(t, WithSerial(line))
+	for i := 0; !line.Exhausted(); i++ {
+		if i == 10000 {
+			t.Fatal("input not consumed")
+		}
+		if err := m.Execute(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := out.String(); got != "HELLO" {
+		t.Errorf("output = %q, want %q", got, "HELLO")
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	m := newMachine(t, WithMemory(0x4000))
+	mem := m.Memory()
+
+	for _, tc := range []struct {
+		addr     uint16
+		writable bool
+	}{
+		{0x0000, true},
+		{0x3fff, true},
+		{0x4000, false},
+		{ROMAddr, false},
+		{ScratchAddr, true},
+		{VideoAddr + VideoSize - 1, true},
+		{VideoAddr + VideoSize, false},
+	} {
+		before := mem.Read(tc.addr)
+		mem.Write(tc.addr, before^0x55)
+		if got := mem.Read(tc.addr) != before; got != tc.writable {
+			t.Errorf("%04x writable = %v, want %v", tc.addr, got, tc.writable)
+		}
+	}
+
+	if _, err := New(make([]byte, ROMSize+1)); err == nil {
+		t.Error("New succeeded with an oversized ROM")
+	}
+}
@@ -0,0 +1,99 @@
+package sol
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Display geometry. Each character cell is CellWidth by CellHeight dots,
+// with the 5x7 character in its upper middle.
+const (
+	Columns    = 64
+	Rows       = 16
+	CellWidth  = 9
+	CellHeight = 13
+	Width      = Columns * CellWidth
+	Height     = Rows * CellHeight
+
+	glyphX = 2
+	glyphY = 2
+
+	// inverse is the character bit which displays it in inverse video, as
+	// the monitor does for the cursor.
+	inverse = 0x80
+)
+
+// Screen renders the display as white dots on black.
+func (m *Machine) Screen() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, Width, Height))
+
+	for row := 0; row < Rows; row++ {
+		for col := 0; col < Columns; col++ {
+			c, ok := m.character(row, col)
+			if !ok {
+				continue
+			}
+			drawCell(img, col*CellWidth, row*CellHeight, c)
+		}
+	}
+
+	return img
+}
+
+// Text returns the display as sixteen lines of text, with inverse video
+// ignored, control characters shown as spaces and trailing spaces removed.
+func (m *Machine) Text() string {
+	var sb strings.Builder
+
+	for row := 0; row < Rows; row++ {
+		line := make([]byte, Columns)
+		for col := range line {
+			c, _ := m.character(row, col)
+			if c &^= inverse; c < 0x20 || c == 0x7f {
+				c = ' '
+			}
+			line[col] = c
+		}
+		sb.WriteString(strings.TrimRight(string(line), " "))
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// character returns the character displayed at the given screen position,
+// or false if the row is blanked.
+func (m *Machine) character(row, col int) (byte, bool) {
+	if row < m.blankRows {
+		return ' ', false
+	}
+	addr := VideoAddr + ((m.startRow+row)%Rows)*Columns + col
+
+	return m.mem.data[addr], true
+}
+
+// drawCell draws character c in the cell with its top left corner at x, y.
+func drawCell(img *image.Gray, x, y int, c byte) {
+	var glyph [7]byte
+	if ch := c &^ inverse; ch >= 0x20 {
+		glyph = font[ch-0x20]
+	}
+
+	for dy := 0; dy < CellHeight; dy++ {
+		var bits byte
+		if gy := dy - glyphY; gy >= 0 && gy < len(glyph) {
+			bits = glyph[gy]
+		}
+		for dx := 0; dx < CellWidth; dx++ {
+			gx := dx - glyphX
+			on := gx >= 0 && gx < 5 && bits&(0x10>>uint(gx)) != 0
+			if c&inverse != 0 {
+				on = !on
+			}
+			if on {
+				img.SetGray(x+dx, y+dy, color.Gray{Y: 0xff})
+			}
+		}
+	}
+}
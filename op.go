@@ -5,9 +5,21 @@ import "fmt"
 // handleOp dispatches the appropriate handler for the given opcode.
 func (i *Intel8080) handleOp(opc byte) error {
 	switch opc {
-	case 0x00, 0x10, 0x20, 0x30, 0x08, 0x18, 0x28, 0x38:
+	case 0x00, 0x10, 0x08, 0x18, 0x28, 0x38:
 		// NOP and ignore opcodes.
 
+	case 0x20:
+		// RIM on the 8085.
+		if i.variant == Intel8085 {
+			i.rim()
+		}
+
+	case 0x30:
+		// SIM on the 8085.
+		if i.variant == Intel8085 {
+			i.sim()
+		}
+
 	case 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c,
 		0x4d, 0x4f, 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x57, 0x58, 0x59, 0x5a,
 		0x5b, 0x5c, 0x5d, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x67, 0x68,
@@ -58,3 +58,13 @@ func (i *Intel8080) setHL(v uint16) {
 	i.r[H] = byte(v >> 8)
 	i.r[L] = byte(v)
 }
+
+// Register returns the contents of the given working register, e.g. B or A.
+func (i *Intel8080) Register(r int) byte {
+	return i.r[r]
+}
+
+// SetRegister sets the contents of the given working register, e.g. B or A.
+func (i *Intel8080) SetRegister(r int, v byte) {
+	i.r[r] = v
+}
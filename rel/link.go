@@ -0,0 +1,398 @@
+package rel
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/danmrichards/go8080/ihex"
+)
+
+type (
+	// Linker combines modules into a single absolute memory image, resolving
+	// references between them.
+	Linker struct {
+		// Address at which the image is loaded.
+		origin uint16
+
+		// Address at which the data segments are placed, only used if hasData
+		// is true. Otherwise data follows the code.
+		dataOrigin uint16
+		hasData    bool
+
+		// Modules which are always linked.
+		modules []*Module
+
+		// Modules which are only linked if they define a symbol needed by
+		// another module.
+		libraries []*Module
+	}
+
+	// Option is a functional option that modifies a field on the linker.
+	Option func(*Linker)
+
+	// Image is a linked program.
+	Image struct {
+		// Origin is the address of the first byte of Data.
+		Origin uint16
+
+		// Data is the memory image of the program.
+		Data []byte
+
+		// Start is the entry point of the program, only valid if HasStart is
+		// true.
+		Start    uint16
+		HasStart bool
+
+		// Symbols holds the address of every public symbol.
+		Symbols map[string]uint16
+	}
+
+	// placement records where each segment of a module ended up.
+	placement struct {
+		code, data uint16
+	}
+)
+
+// WithOrigin sets the address at which the image is loaded. It defaults to
+// 0100H, the start of the CP/M transient program area.
+func WithOrigin(addr uint16) Option {
+	return func(l *Linker) {
+		l.origin = addr
+	}
+}
+
+// WithDataOrigin places the data segments at the given address rather than
+// immediately after the code. The data may be below or above the code, but
+// linking fails if the two overlap.
+func WithDataOrigin(addr uint16) Option {
+	return func(l *Linker) {
+		l.dataOrigin, l.hasData = addr, true
+	}
+}
+
+// NewLinker returns an instantiated linker.
+func NewLinker(opts ...Option) *Linker {
+	l := &Linker{origin: 0x100}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	return l
+}
+
+// Add adds modules which are always linked.
+func (l *Linker) Add(mods ...*Module) {
+	l.modules = append(l.modules, mods...)
+}
+
+// AddLibrary adds modules which are only linked if they define a symbol
+// referenced by a module that is linked.
+func (l *Linker) AddLibrary(mods ...*Module) {
+	l.libraries = append(l.libraries, mods...)
+}
+
+// Link links the modules into an image.
+//
+// Like L80, if any module has a start address the image begins with a jump to
+// it and the code follows.
+//
+// Bytes in absolute segments, such as those of an M80 ASEG, are placed at
+// their own addresses. Linking fails if they overlap the code, data or common
+// blocks.
+func (l *Linker) Link() (*Image, error) {
+	mods, err := l.selectModules()
+	if err != nil {
+		return nil, err
+	}
+
+	// Lay out the code of every module, then the data, then the common blocks.
+	var (
+		start  *Value
+		startM *Module
+	)
+	for _, m := range mods {
+		if m.HasStart {
+			start, startM = &m.Start, m
+			break
+		}
+	}
+
+	pc := int(l.origin)
+	if start != nil {
+		pc += 3
+	}
+
+	places := make(map[*Module]placement, len(mods))
+	for _, m := range mods {
+		places[m] = placement{code: uint16(pc)}
+		pc += len(m.Code)
+	}
+	codeEnd := pc
+	if l.hasData {
+		pc = int(l.dataOrigin)
+	}
+	for _, m := range mods {
+		p := places[m]
+		p.data = uint16(pc)
+		places[m] = p
+		pc += len(m.Data)
+	}
+
+	var commonNames []string
+	commonSizes := make(map[string]int)
+	for _, m := range mods {
+		for name, size := range m.commons {
+			if _, ok := commonSizes[name]; !ok {
+				commonNames = append(commonNames, name)
+			}
+			if int(size) > commonSizes[name] {
+				commonSizes[name] = int(size)
+			}
+		}
+		for name, b := range m.commonData {
+			if _, ok := commonSizes[name]; !ok {
+				commonNames = append(commonNames, name)
+			}
+			if len(b) > commonSizes[name] {
+				commonSizes[name] = len(b)
+			}
+		}
+	}
+	sort.Strings(commonNames)
+
+	commons := make(map[string]uint16, len(commonNames))
+	for _, name := range commonNames {
+		commons[name] = uint16(pc)
+		pc += commonSizes[name]
+	}
+
+	if pc > 0x10000 {
+		return nil, fmt.Errorf("program too large: ends at %05XH", pc)
+	}
+	if codeEnd > 0x10000 {
+		return nil, fmt.Errorf("program too large: code ends at %05XH", codeEnd)
+	}
+
+	// The image runs from the lowest start of the code, the data or any
+	// absolute bytes to the highest end, and none of them may overlap.
+	lo, hi := 0x10000, 0
+	span := func(start, end int) {
+		if start >= end {
+			return
+		}
+		if start < lo {
+			lo = start
+		}
+		if end > hi {
+			hi = end
+		}
+	}
+	codeLo, dataLo := int(l.origin), codeEnd
+	if l.hasData {
+		dataLo = int(l.dataOrigin)
+		if dataLo < pc && codeLo < codeEnd && dataLo < codeEnd && codeLo < pc {
+			return nil, fmt.Errorf("data at %04XH-%04XH overlaps code at %04XH-%04XH", dataLo, pc-1, codeLo, codeEnd-1)
+		}
+	}
+	span(codeLo, codeEnd)
+	span(dataLo, pc)
+	for _, m := range mods {
+		for _, addr := range m.absoluteAddrs() {
+			a := int(addr)
+			if a >= codeLo && a < codeEnd || a >= dataLo && a < pc {
+				return nil, fmt.Errorf("%s: absolute byte at %04XH overlaps the relocated segments", m.Name, a)
+			}
+			span(a, a+1)
+		}
+	}
+	if lo > hi {
+		lo, hi = codeLo, codeLo
+	}
+
+	// Load every segment into memory.
+	mem := make([]byte, 0x10000)
+	for _, m := range mods {
+		copy(mem[places[m].code:], m.Code)
+		copy(mem[places[m].data:], m.Data)
+		for name, b := range m.commonData {
+			copy(mem[commons[name]:], b)
+		}
+		for addr, v := range m.absolute {
+			mem[addr] = v
+		}
+	}
+
+	// resolve returns the absolute address of loc in module m.
+	resolve := func(m *Module, loc location) uint16 {
+		switch loc.seg {
+		case ProgramRelative:
+			return places[m].code + loc.addr
+		case DataRelative:
+			return places[m].data + loc.addr
+		case CommonRelative:
+			return commons[loc.common] + loc.addr
+		}
+		return loc.addr
+	}
+
+	symbols := make(map[string]uint16)
+	for _, m := range mods {
+		for _, s := range m.Publics {
+			if s.Value.Type == CommonRelative {
+				return nil, fmt.Errorf("%s: public %s in a common block is not supported", m.Name, s.Name)
+			}
+			symbols[s.Name] = resolve(m, location{seg: s.Value.Type, addr: s.Value.Addr})
+		}
+	}
+
+	for _, m := range mods {
+		// Fill the chains first, as each link in a chain is itself a
+		// relocatable word which must not be relocated.
+		fixed := make(map[location]bool)
+		for _, c := range m.chains {
+			v := resolve(m, c.value)
+			if c.symbol != "" {
+				v = symbols[c.symbol]
+			}
+
+			for loc, n := c.head, 0; loc.seg != Absolute || loc.addr != 0; n++ {
+				if n > 0xffff {
+					return nil, fmt.Errorf("%s: chain for %q does not terminate", m.Name, c.symbol)
+				}
+
+				next := location{addr: m.load(loc)}
+				if t, ok := m.relocs[loc]; ok {
+					next.seg, next.common = t.seg, t.common
+				}
+
+				putWord(mem, resolve(m, loc), v)
+				fixed[loc] = true
+				loc = next
+			}
+		}
+
+		for loc, t := range m.relocs {
+			if fixed[loc] {
+				continue
+			}
+			base := resolve(m, location{seg: t.seg, common: t.common})
+			putWord(mem, resolve(m, loc), m.load(loc)+base)
+		}
+
+		for _, o := range m.offsets {
+			addr := resolve(m, o.at)
+			putWord(mem, addr, uint16(int(getWord(mem, addr))+o.value))
+		}
+	}
+
+	img := &Image{
+		Origin:  uint16(lo),
+		Data:    mem[lo:hi],
+		Symbols: symbols,
+	}
+	if start != nil {
+		img.Start, img.HasStart = resolve(startM, location{seg: start.Type, addr: start.Addr}), true
+		mem[l.origin] = 0xc3
+		putWord(mem, l.origin+1, img.Start)
+	}
+
+	return img, nil
+}
+
+// selectModules returns the modules to be linked, searching the libraries for
+// any symbols which are not defined by the modules added with Add.
+func (l *Linker) selectModules() ([]*Module, error) {
+	mods := append([]*Module(nil), l.modules...)
+
+	defined := make(map[string]*Module)
+	define := func(m *Module) error {
+		for _, s := range m.Publics {
+			if other, ok := defined[s.Name]; ok {
+				return fmt.Errorf("%s: multiply defined by %s and %s", s.Name, other.Name, m.Name)
+			}
+			defined[s.Name] = m
+		}
+		return nil
+	}
+	for _, m := range mods {
+		if err := define(m); err != nil {
+			return nil, err
+		}
+	}
+
+	undefined := func() map[string]bool {
+		u := make(map[string]bool)
+		for _, m := range mods {
+			for _, s := range m.Externals {
+				if defined[s.Name] == nil {
+					u[s.Name] = true
+				}
+			}
+		}
+		return u
+	}
+
+	used := make(map[*Module]bool)
+	for changed := true; changed; {
+		changed = false
+		u := undefined()
+
+		for _, m := range l.libraries {
+			if used[m] || !definesAny(m, u) {
+				continue
+			}
+			if err := define(m); err != nil {
+				return nil, err
+			}
+			mods = append(mods, m)
+			used[m], changed = true, true
+		}
+	}
+
+	if u := undefined(); len(u) > 0 {
+		names := make([]string, 0, len(u))
+		for name := range u {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("undefined symbols: %s", strings.Join(names, ", "))
+	}
+
+	return mods, nil
+}
+
+// definesAny returns true if m defines any of the given symbols.
+func definesAny(m *Module, symbols map[string]bool) bool {
+	for _, s := range m.Publics {
+		if symbols[s.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// getWord returns the word at addr, low byte first.
+func getWord(mem []byte, addr uint16) uint16 {
+	return uint16(mem[addr]) | uint16(mem[addr+1])<<8
+}
+
+// putWord stores the word v at addr, low byte first.
+func putWord(mem []byte, addr, v uint16) {
+	mem[addr] = byte(v)
+	mem[addr+1] = byte(v >> 8)
+}
+
+// WriteHex writes the image to w in Intel HEX format, including the start
+// address if there is one.
+func (img *Image) WriteHex(w io.Writer) error {
+	hw := ihex.NewWriter(w)
+	hw.WriteData(img.Origin, img.Data)
+	if img.HasStart {
+		hw.WriteStart(img.Start)
+	}
+
+	return hw.Close()
+}
@@ -0,0 +1,293 @@
+package rel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type (
+	// Symbol is a named address.
+	Symbol struct {
+		Name  string
+		Value Value
+	}
+
+	// Module is a single program from a REL file, loaded into its segments
+	// ready to be linked.
+	Module struct {
+		// Name of the program, if given.
+		Name string
+
+		// Code and Data are the contents of the program and data segments.
+		Code []byte
+		Data []byte
+
+		// Publics are the entry points defined by the module.
+		Publics []Symbol
+
+		// Externals are the symbols referenced by the module. The value of
+		// each is the head of the chain of locations which refer to it.
+		Externals []Symbol
+
+		// Libraries are the library files the module asks to be searched.
+		Libraries []string
+
+		// Start is the entry point of the module, only valid if HasStart is
+		// true.
+		Start    Value
+		HasStart bool
+
+		// Sizes of the common blocks declared by the module.
+		commons map[string]uint16
+
+		// Contents of the common blocks, as initialised by the module.
+		commonData map[string][]byte
+
+		// Bytes loaded at absolute addresses, such as those of an ASEG.
+		absolute map[uint16]byte
+
+		// Relocatable words, keyed by location.
+		relocs map[location]target
+
+		// Chains of locations to be filled with a value by the linker.
+		chains []chain
+
+		// Offsets added to external references once they are resolved.
+		offsets []offset
+	}
+
+	// location is an address within a segment of a module.
+	location struct {
+		seg    AddrType
+		common string
+		addr   uint16
+	}
+
+	// target is the segment a relocatable word is relative to.
+	target struct {
+		seg    AddrType
+		common string
+	}
+
+	// chain is a chain of locations, each holding the address of the next,
+	// which are all to be replaced by a value.
+	chain struct {
+		head location
+
+		// Name of the external symbol whose value fills the chain, or empty if
+		// the chain is filled with value.
+		symbol string
+		value  location
+	}
+
+	// offset is a value to be added to the word at a location.
+	offset struct {
+		at    location
+		value int
+	}
+)
+
+// ReadModules reads every module from the REL file r.
+func ReadModules(r io.Reader) ([]*Module, error) {
+	rr := NewReader(r)
+
+	var mods []*Module
+	for {
+		m, err := ReadModule(rr)
+		if err == io.EOF {
+			return mods, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, m)
+	}
+}
+
+// ReadModule reads the next module from r, returning io.EOF once the end
+// of file item is reached.
+func ReadModule(r *Reader) (*Module, error) {
+	m := &Module{
+		commons:    make(map[string]uint16),
+		commonData: make(map[string][]byte),
+		absolute:   make(map[uint16]byte),
+		relocs:     make(map[location]target),
+	}
+
+	var (
+		loc    = location{seg: ProgramRelative}
+		common string
+		empty  = true
+	)
+	for {
+		it, err := r.Next()
+		if err == io.ErrUnexpectedEOF && empty {
+			// Some tools end the file without an end file item.
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		empty = false
+
+		switch it.Kind {
+		case Byte:
+			if err = m.store(loc, it.Byte); err != nil {
+				return nil, err
+			}
+			loc.addr++
+			continue
+
+		case Word:
+			if err = m.storeWord(loc, it.Value.Addr); err != nil {
+				return nil, err
+			}
+			m.relocs[loc] = target{seg: it.Value.Type, common: commonName(it.Value.Type, common)}
+			loc.addr += 2
+			continue
+		}
+
+		at := location{seg: it.Value.Type, common: commonName(it.Value.Type, common), addr: it.Value.Addr}
+
+		switch it.Control {
+		case EntrySymbol, Extension:
+			// Only used to index libraries.
+
+		case ProgramName:
+			m.Name = it.Name
+
+		case LibrarySearch:
+			m.Libraries = append(m.Libraries, it.Name)
+
+		case SelectCommon:
+			common = it.Name
+
+		case CommonSize:
+			if it.Value.Addr > m.commons[it.Name] {
+				m.commons[it.Name] = it.Value.Addr
+			}
+
+		case ChainExternal:
+			m.Externals = append(m.Externals, Symbol{Name: it.Name, Value: it.Value})
+			m.chains = append(m.chains, chain{head: at, symbol: it.Name})
+
+		case EntryPoint:
+			m.Publics = append(m.Publics, Symbol{Name: it.Name, Value: it.Value})
+
+		case ExternalMinusOffset:
+			m.offsets = append(m.offsets, offset{at: loc, value: -int(it.Value.Addr)})
+
+		case ExternalPlusOffset:
+			m.offsets = append(m.offsets, offset{at: loc, value: int(it.Value.Addr)})
+
+		case DataSize:
+			m.Data = grow(m.Data, int(it.Value.Addr))
+
+		case ProgramSize:
+			m.Code = grow(m.Code, int(it.Value.Addr))
+
+		case SetLocation:
+			loc = at
+
+		case ChainAddress:
+			m.chains = append(m.chains, chain{head: at, value: loc})
+
+		case EndProgram:
+			if it.Value.Type != Absolute || it.Value.Addr != 0 {
+				m.Start, m.HasStart = it.Value, true
+			}
+			return m, nil
+
+		case EndFile:
+			return nil, io.EOF
+
+		default:
+			return nil, fmt.Errorf("unsupported link item: %s", it.Control)
+		}
+	}
+}
+
+// commonName returns the name of the common block an address of the given
+// type refers to.
+func commonName(t AddrType, common string) string {
+	if t == CommonRelative {
+		return common
+	}
+	return ""
+}
+
+// store loads the byte v at loc, growing the segment if necessary.
+func (m *Module) store(loc location, v byte) error {
+	n := int(loc.addr) + 1
+
+	switch loc.seg {
+	case ProgramRelative:
+		m.Code = grow(m.Code, n)
+		m.Code[loc.addr] = v
+	case DataRelative:
+		m.Data = grow(m.Data, n)
+		m.Data[loc.addr] = v
+	case CommonRelative:
+		if loc.common == "" {
+			return errors.New("common relative address with no common block selected")
+		}
+		b := grow(m.commonData[loc.common], n)
+		b[loc.addr] = v
+		m.commonData[loc.common] = b
+	default:
+		m.absolute[loc.addr] = v
+	}
+
+	return nil
+}
+
+// storeWord loads the word v at loc, low byte first.
+func (m *Module) storeWord(loc location, v uint16) error {
+	if err := m.store(loc, byte(v)); err != nil {
+		return err
+	}
+	loc.addr++
+	return m.store(loc, byte(v>>8))
+}
+
+// load returns the word at loc.
+func (m *Module) load(loc location) uint16 {
+	var b []byte
+	switch loc.seg {
+	case ProgramRelative:
+		b = m.Code
+	case DataRelative:
+		b = m.Data
+	case CommonRelative:
+		b = m.commonData[loc.common]
+	default:
+		return uint16(m.absolute[loc.addr]) | uint16(m.absolute[loc.addr+1])<<8
+	}
+
+	if int(loc.addr)+1 >= len(b) {
+		return 0
+	}
+	return uint16(b[loc.addr]) | uint16(b[loc.addr+1])<<8
+}
+
+// absoluteAddrs returns the addresses of the module's absolute bytes in
+// order.
+func (m *Module) absoluteAddrs() []uint16 {
+	addrs := make([]uint16, 0, len(m.absolute))
+	for addr := range m.absolute {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs
+}
+
+// grow returns b extended with zeros to at least n bytes.
+func grow(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(b, make([]byte, n-len(b))...)
+}
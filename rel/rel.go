@@ -0,0 +1,357 @@
+// Package rel reads, writes and links Microsoft REL relocatable object files,
+// as produced by the M80 assembler and consumed by the L80 linker.
+//
+// A REL file is a bitstream rather than a sequence of bytes. Each item starts
+// with a single bit: 0 means the following 8 bits are an absolute byte to be
+// loaded, 1 means the following 2 bits describe either a relocatable word or
+// a special link item such as a symbol definition.
+package rel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type (
+	// AddrType describes which segment an address is relative to.
+	AddrType byte
+
+	// Kind is the kind of an item in a REL file.
+	Kind byte
+
+	// Control identifies the type of a special link item.
+	Control byte
+
+	// Value is an address along with the segment it is relative to.
+	Value struct {
+		Type AddrType
+		Addr uint16
+	}
+
+	// Item is a single item from a REL file.
+	Item struct {
+		Kind Kind
+
+		// Byte is the value of an absolute byte.
+		Byte byte
+
+		// Value is the relocatable word of a Word item, or the address field
+		// of a Link item.
+		Value Value
+
+		// Control is the type of a Link item.
+		Control Control
+
+		// Name is the name field of a Link item.
+		Name string
+	}
+
+	// Reader reads items from a REL bitstream.
+	Reader struct {
+		r   *bufio.Reader
+		cur byte
+		n   uint
+	}
+
+	// Writer writes items to a REL bitstream.
+	Writer struct {
+		w   *bufio.Writer
+		cur byte
+		n   uint
+	}
+)
+
+const (
+	// Absolute addresses are not relocated.
+	Absolute AddrType = iota
+
+	// ProgramRelative addresses are relative to the code segment.
+	ProgramRelative
+
+	// DataRelative addresses are relative to the data segment.
+	DataRelative
+
+	// CommonRelative addresses are relative to the selected common block.
+	CommonRelative
+)
+
+const (
+	// Byte is an absolute byte, loaded at the location counter.
+	Byte Kind = iota
+
+	// Word is a relocatable word, loaded at the location counter.
+	Word
+
+	// Link is a special link item.
+	Link
+)
+
+// Special link item types. Items up to LibrarySearch carry only a name, items
+// from CommonSize to EntryPoint carry an address and a name, and items from
+// ExternalMinusOffset to EndProgram carry only an address.
+const (
+	EntrySymbol Control = iota
+	SelectCommon
+	ProgramName
+	LibrarySearch
+	Extension
+	CommonSize
+	ChainExternal
+	EntryPoint
+	ExternalMinusOffset
+	ExternalPlusOffset
+	DataSize
+	SetLocation
+	ChainAddress
+	ProgramSize
+	EndProgram
+	EndFile
+)
+
+// maxName is the longest name which can be stored in a name field.
+const maxName = 8
+
+var controlNames = [...]string{
+	"entry symbol",
+	"select common block",
+	"program name",
+	"request library search",
+	"extension link item",
+	"define common size",
+	"chain external",
+	"define entry point",
+	"external minus offset",
+	"external plus offset",
+	"define size of data area",
+	"set loading location counter",
+	"chain address",
+	"define program size",
+	"end program",
+	"end file",
+}
+
+// String returns the name of the link item type.
+func (c Control) String() string {
+	if int(c) < len(controlNames) {
+		return controlNames[c]
+	}
+	return fmt.Sprintf("control %d", byte(c))
+}
+
+// hasAddr returns true if link items of this type carry an address field.
+func (c Control) hasAddr() bool {
+	return c >= CommonSize && c <= EndProgram
+}
+
+// hasName returns true if link items of this type carry a name field.
+func (c Control) hasName() bool {
+	return c <= EntryPoint
+}
+
+// NewReader returns a reader which reads items from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// bits reads the next n bits from the stream, most significant first.
+func (r *Reader) bits(n uint) (uint16, error) {
+	var v uint16
+	for ; n > 0; n-- {
+		if r.n == 0 {
+			b, err := r.r.ReadByte()
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.n = b, 8
+		}
+		r.n--
+		v = v<<1 | uint16(r.cur>>r.n)&1
+	}
+
+	return v, nil
+}
+
+// word reads a 16-bit word, which is stored low byte first.
+func (r *Reader) word() (uint16, error) {
+	lo, err := r.bits(8)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := r.bits(8)
+	if err != nil {
+		return 0, err
+	}
+	return hi<<8 | lo, nil
+}
+
+// Next returns the next item from the stream.
+//
+// After an EndProgram item the rest of the current byte is skipped, as each
+// program in a file starts on a byte boundary.
+func (r *Reader) Next() (Item, error) {
+	var it Item
+
+	bit, err := r.bits(1)
+	if err != nil {
+		return it, err
+	}
+	if bit == 0 {
+		b, err := r.bits(8)
+		return Item{Kind: Byte, Byte: byte(b)}, err
+	}
+
+	typ, err := r.bits(2)
+	if err != nil {
+		return it, err
+	}
+	if typ != 0 {
+		addr, err := r.word()
+		return Item{Kind: Word, Value: Value{Type: AddrType(typ), Addr: addr}}, err
+	}
+
+	ctl, err := r.bits(4)
+	if err != nil {
+		return it, err
+	}
+	it = Item{Kind: Link, Control: Control(ctl)}
+
+	if it.Control.hasAddr() {
+		typ, err := r.bits(2)
+		if err != nil {
+			return it, err
+		}
+		addr, err := r.word()
+		if err != nil {
+			return it, err
+		}
+		it.Value = Value{Type: AddrType(typ), Addr: addr}
+	}
+
+	if it.Control.hasName() {
+		n, err := r.bits(3)
+		if err != nil {
+			return it, err
+		}
+		if n == 0 {
+			n = maxName
+		}
+
+		name := make([]byte, n)
+		for i := range name {
+			c, err := r.bits(8)
+			if err != nil {
+				return it, err
+			}
+			name[i] = byte(c)
+		}
+		it.Name = string(name)
+	}
+
+	if it.Control == EndProgram {
+		r.n = 0
+	}
+
+	return it, nil
+}
+
+// NewWriter returns a writer which writes items to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// bits writes the low n bits of v to the stream, most significant first.
+func (w *Writer) bits(v uint16, n uint) error {
+	for ; n > 0; n-- {
+		w.cur = w.cur<<1 | byte(v>>(n-1))&1
+		w.n++
+		if w.n == 8 {
+			if err := w.w.WriteByte(w.cur); err != nil {
+				return err
+			}
+			w.cur, w.n = 0, 0
+		}
+	}
+	return nil
+}
+
+// word writes a 16-bit word, low byte first.
+func (w *Writer) word(v uint16) error {
+	if err := w.bits(v&0xff, 8); err != nil {
+		return err
+	}
+	return w.bits(v>>8, 8)
+}
+
+// pad writes zero bits up to the next byte boundary.
+func (w *Writer) pad() error {
+	if w.n == 0 {
+		return nil
+	}
+	return w.bits(0, 8-w.n)
+}
+
+// Write writes a single item to the stream.
+func (w *Writer) Write(it Item) error {
+	switch it.Kind {
+	case Byte:
+		return w.bits(uint16(it.Byte), 9)
+
+	case Word:
+		if it.Value.Type == Absolute {
+			return errors.New("relocatable word cannot be absolute")
+		}
+		if err := w.bits(4|uint16(it.Value.Type), 3); err != nil {
+			return err
+		}
+		return w.word(it.Value.Addr)
+	}
+
+	if it.Control > EndFile {
+		return fmt.Errorf("invalid link item type %d", it.Control)
+	}
+	if err := w.bits(4<<4|uint16(it.Control), 7); err != nil {
+		return err
+	}
+
+	if it.Control.hasAddr() {
+		if err := w.bits(uint16(it.Value.Type), 2); err != nil {
+			return err
+		}
+		if err := w.word(it.Value.Addr); err != nil {
+			return err
+		}
+	}
+
+	if it.Control.hasName() {
+		if len(it.Name) == 0 || len(it.Name) > maxName {
+			return fmt.Errorf("%s: invalid name %q", it.Control, it.Name)
+		}
+		if err := w.bits(uint16(len(it.Name)&7), 3); err != nil {
+			return err
+		}
+		for i := 0; i < len(it.Name); i++ {
+			if err := w.bits(uint16(it.Name[i]), 8); err != nil {
+				return err
+			}
+		}
+	}
+
+	if it.Control == EndProgram || it.Control == EndFile {
+		return w.pad()
+	}
+
+	return nil
+}
+
+// Flush writes any buffered data, padding the final byte with zero bits.
+func (w *Writer) Flush() error {
+	if err := w.pad(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,236 @@
+package rel
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeItems returns the REL bitstream for the given items.
+func writeItems(t *testing.T, items []Item) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, it := range items {
+		if err := w.Write(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func abs(b byte) Item {
+	return Item{Kind: Byte, Byte: b}
+}
+
+func link(c Control, typ AddrType, addr uint16, name string) Item {
+	return Item{Kind: Link, Control: c, Value: Value{Type: typ, Addr: addr}, Name: name}
+}
+
+// mainItems is a main program which calls PRINT, jumps to itself and loads the
+// address of MSG+2, both of which are defined in another module.
+var mainItems = []Item{
+	link(ProgramName, Absolute, 0, "MAIN"),
+	link(DataSize, Absolute, 1, ""),
+	link(ProgramSize, Absolute, 9, ""),
+	abs(0xcd), abs(0x00), abs(0x00), // CALL PRINT
+	abs(0xc3), {Kind: Word, Value: Value{Type: ProgramRelative, Addr: 0}}, // JMP $-3
+	abs(0x21), link(ExternalPlusOffset, Absolute, 2, ""), abs(0x00), abs(0x00), // LXI H,MSG+2
+	link(SetLocation, DataRelative, 0, ""),
+	abs(0x55),
+	link(ChainExternal, ProgramRelative, 1, "PRINT"),
+	link(ChainExternal, ProgramRelative, 7, "MSG"),
+	link(EndProgram, ProgramRelative, 0, ""),
+}
+
+// libItems defines PRINT and MSG.
+var libItems = []Item{
+	link(ProgramName, Absolute, 0, "LIB"),
+	link(DataSize, Absolute, 2, ""),
+	link(ProgramSize, Absolute, 1, ""),
+	abs(0xc9),
+	link(SetLocation, DataRelative, 0, ""),
+	abs('H'), abs('I'),
+	link(EntryPoint, ProgramRelative, 0, "PRINT"),
+	link(EntryPoint, DataRelative, 0, "MSG"),
+	link(EndProgram, Absolute, 0, ""),
+	link(EndFile, Absolute, 0, ""),
+}
+
+func TestReadWrite(t *testing.T) {
+	items := append(append([]Item(nil), mainItems...), libItems...)
+	r := NewReader(bytes.NewReader(writeItems(t, items)))
+
+	for i, want := range items {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("item %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	mods, err := ReadModules(bytes.NewReader(writeItems(t, mainItems)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := ReadModules(bytes.NewReader(writeItems(t, libItems)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLinker()
+	l.Add(mods...)
+	l.AddLibrary(lib...)
+
+	img, err := l.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0xc3, 0x03, 0x01, // JMP START
+		0xcd, 0x0c, 0x01, // START: CALL PRINT
+		0xc3, 0x03, 0x01, // JMP START
+		0x21, 0x10, 0x01, // LXI H,MSG+2
+		0xc9,     // PRINT: RET
+		0x55,     // MAIN's data
+		'H', 'I', // MSG
+	}
+	if img.Origin != 0x100 {
+		t.Errorf("origin = %04x, want 0100", img.Origin)
+	}
+	if !bytes.Equal(img.Data, want) {
+		t.Errorf("image =\n% x\nwant\n% x", img.Data, want)
+	}
+	if !img.HasStart || img.Start != 0x103 {
+		t.Errorf("start = %04x (%v), want 0103", img.Start, img.HasStart)
+	}
+	if img.Symbols["MSG"] != 0x10e {
+		t.Errorf("MSG = %04x, want 010e", img.Symbols["MSG"])
+	}
+}
+
+func TestLinkUndefined(t *testing.T) {
+	mods, err := ReadModules(bytes.NewReader(writeItems(t, mainItems)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLinker()
+	l.Add(mods...)
+
+	_, err = l.Link()
+	if err == nil || !strings.Contains(err.Error(), "undefined symbols: MSG, PRINT") {
+		t.Errorf("error = %v, want undefined symbols", err)
+	}
+}
+
+// splitItems is a module with two bytes of code and one of data.
+var splitItems = []Item{
+	link(ProgramName, Absolute, 0, "SPLIT"),
+	link(DataSize, Absolute, 1, ""),
+	link(ProgramSize, Absolute, 2, ""),
+	abs(0x01), abs(0x02),
+	link(SetLocation, DataRelative, 0, ""),
+	abs(0x55),
+	link(EndProgram, Absolute, 0, ""),
+}
+
+func TestLinkDataOrigin(t *testing.T) {
+	mods, err := ReadModules(bytes.NewReader(writeItems(t, splitItems)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data   uint16
+		origin uint16
+		want   []byte
+		err    string
+	}{
+		{0x80, 0x80, append(append([]byte{0x55}, make([]byte, 0x7f)...), 0x01, 0x02), ""},
+		{0x102, 0x100, []byte{0x01, 0x02, 0x55}, ""},
+		{0x104, 0x100, []byte{0x01, 0x02, 0x00, 0x00, 0x55}, ""},
+		{0x101, 0, nil, "overlaps code"},
+		{0x100, 0, nil, "overlaps code"},
+	}
+	for _, tt := range tests {
+		l := NewLinker(WithDataOrigin(tt.data))
+		l.Add(mods...)
+
+		img, err := l.Link()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("data at %04x: error = %v, want %q", tt.data, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("data at %04x: %v", tt.data, err)
+			continue
+		}
+		if img.Origin != tt.origin || !bytes.Equal(img.Data, tt.want) {
+			t.Errorf("data at %04x: image at %04x\n% x\nwant at %04x\n% x", tt.data, img.Origin, img.Data, tt.origin, tt.want)
+		}
+	}
+}
+
+// asegItems is a module with two bytes of code and a jump to them at an
+// absolute address, as M80 produces for ASEG.
+func asegItems(addr uint16) []Item {
+	return []Item{
+		link(ProgramName, Absolute, 0, "ASEG"),
+		link(ProgramSize, Absolute, 2, ""),
+		abs(0x01), abs(0x02),
+		link(SetLocation, Absolute, addr, ""),
+		abs(0xc3), {Kind: Word, Value: Value{Type: ProgramRelative, Addr: 0}},
+		link(EndProgram, Absolute, 0, ""),
+	}
+}
+
+func TestLinkAbsolute(t *testing.T) {
+	tests := []struct {
+		addr   uint16
+		origin uint16
+		want   []byte
+		err    string
+	}{
+		{0x0000, 0x0000, append(append([]byte{0xc3, 0x00, 0x01}, make([]byte, 0xfd)...), 0x01, 0x02), ""},
+		{0x0102, 0x0100, []byte{0x01, 0x02, 0xc3, 0x00, 0x01}, ""},
+		{0x00ff, 0, nil, "overlaps"},
+		{0x0101, 0, nil, "overlaps"},
+	}
+	for _, tt := range tests {
+		mods, err := ReadModules(bytes.NewReader(writeItems(t, asegItems(tt.addr))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := NewLinker()
+		l.Add(mods...)
+
+		img, err := l.Link()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ASEG at %04x: error = %v, want %q", tt.addr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ASEG at %04x: %v", tt.addr, err)
+			continue
+		}
+		if img.Origin != tt.origin || !bytes.Equal(img.Data, tt.want) {
+			t.Errorf("ASEG at %04x: image at %04x\n% x\nwant at %04x\n% x", tt.addr, img.Origin, img.Data, tt.origin, tt.want)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Package romset loads the ROMs of a machine from a directory or a zip
+// archive, verifying each against its expected size and checksums and
+// reporting problems in the spirit of MAME's ROM validation.
+package romset
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/danmrichards/go8080"
+)
+
+type (
+	// ROM is a ROM expected by a machine.
+	ROM struct {
+		// Name of the ROM file.
+		Name string `json:"name"`
+
+		// Address the ROM is placed at and its size.
+		Addr uint16 `json:"addr"`
+		Size int    `json:"size"`
+
+		// Checksums of a good dump as hexadecimal strings. A ROM with
+		// neither has no good dump known.
+		CRC32 string `json:"crc32,omitempty"`
+		SHA1  string `json:"sha1,omitempty"`
+
+		// Checksums of other known versions of the ROM.
+		Alternates []Alternate `json:"alternates,omitempty"`
+	}
+
+	// Alternate is another known version of a ROM.
+	Alternate struct {
+		Version string `json:"version"`
+		CRC32   string `json:"crc32,omitempty"`
+		SHA1    string `json:"sha1,omitempty"`
+	}
+
+	// Source is a directory or archive of ROM files.
+	Source interface {
+		// Names returns the names of the files in the source.
+		Names() ([]string, error)
+
+		// ReadFile returns the contents of the named file.
+		ReadFile(name string) ([]byte, error)
+	}
+
+	// Status is the outcome of verifying a ROM.
+	Status int
+
+	// Check is the result of verifying a single ROM.
+	Check struct {
+		ROM    ROM
+		Status Status
+
+		// File the ROM was read from, if any.
+		File string
+
+		// Checksums of the file, as hexadecimal strings.
+		CRC32 string
+		SHA1  string
+
+		// Size of the file.
+		Size int
+
+		// Version of the ROM, if it is an alternate.
+		Version string
+	}
+
+	// Result is the result of loading a ROM set.
+	Result struct {
+		// Contents of each usable ROM, keyed by ROM name.
+		Files map[string][]byte
+
+		// Checks of each ROM, in the order they were declared.
+		Checks []Check
+
+		roms []ROM
+	}
+)
+
+// ROM statuses.
+const (
+	// OK means the ROM was found and matches a good dump.
+	OK Status = iota
+
+	// Renamed means the ROM was not found under its name, but a file with
+	// the right checksums was found under another.
+	Renamed
+
+	// NoGoodDump means the ROM was found but no checksums are known for it.
+	NoGoodDump
+
+	// AlternateVersion means the ROM matches a known alternate version.
+	AlternateVersion
+
+	// NotFound means no file for the ROM was found.
+	NotFound
+
+	// WrongSize means the file for the ROM has the wrong size.
+	WrongSize
+
+	// BadChecksum means the file for the ROM does not match any known dump.
+	BadChecksum
+)
+
+// Usable reports whether a ROM with the status can be used.
+func (s Status) Usable() bool {
+	return s <= AlternateVersion
+}
+
+// String returns the status as worded in the report.
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Renamed:
+		return "FOUND UNDER ANOTHER NAME"
+	case NoGoodDump:
+		return "NO GOOD DUMP KNOWN"
+	case AlternateVersion:
+		return "ALTERNATE VERSION"
+	case NotFound:
+		return "NOT FOUND"
+	case WrongSize:
+		return "INCORRECT LENGTH"
+	case BadChecksum:
+		return "INCORRECT CHECKSUM"
+	}
+
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Open opens path as a source of ROMs: a zip archive if it is a file, or a
+// directory.
+func Open(path string) (Source, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return dirSource(path), nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, fmt.Errorf("romset: %s: %v", path, err)
+	}
+
+	return zipSource{zr}, nil
+}
+
+// Load loads and verifies the ROMs from the directory or zip archive at
+// path.
+func Load(path string, roms []ROM) (*Result, error) {
+	src, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Verify(src, roms)
+}
+
+// Verify loads and verifies the ROMs from the source. ROMs are matched by
+// name, ignoring case, or failing that by checksum.
+func Verify(src Source, roms []ROM) (*Result, error) {
+	// Checksum every file so that renamed ROMs can be found.
+	type file struct {
+		name        string
+		data        []byte
+		crc32, sha1 string
+	}
+	var files []file
+	names, err := src.Names()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		data, err := src.ReadFile(n)
+		if err != nil {
+			return nil, err
+		}
+		c, s := checksums(data)
+		files = append(files, file{n, data, c, s})
+	}
+
+	r := &Result{Files: make(map[string][]byte), roms: roms}
+	for _, rom := range roms {
+		c := Check{ROM: rom, Status: NotFound}
+
+		var found *file
+		for i := range files {
+			if strings.EqualFold(path(files[i].name), rom.Name) {
+				found = &files[i]
+				break
+			}
+		}
+		if found == nil && (rom.CRC32 != "" || rom.SHA1 != "") {
+			for i := range files {
+				if len(files[i].data) == rom.Size && matches(rom.CRC32, rom.SHA1, files[i].crc32, files[i].sha1) {
+					found = &files[i]
+					c.Status = Renamed
+					break
+				}
+			}
+		}
+
+		if found != nil {
+			c.File, c.Size = found.name, len(found.data)
+			c.CRC32, c.SHA1 = found.crc32, found.sha1
+			if c.Status != Renamed {
+				c.Status, c.Version = status(rom, found.data, found.crc32, found.sha1)
+			}
+			if c.Status.Usable() {
+				r.Files[rom.Name] = found.data
+			}
+		}
+
+		r.Checks = append(r.Checks, c)
+	}
+
+	return r, nil
+}
+
+// path returns the last element of a file name in a source, as zip archives
+// may hold ROMs in a directory.
+func path(name string) string {
+	return filepath.Base(filepath.FromSlash(name))
+}
+
+// status returns the status of a file found under the name of a ROM.
+func status(rom ROM, data []byte, crc, sha string) (Status, string) {
+	switch {
+	case len(data) != rom.Size:
+		return WrongSize, ""
+	case rom.CRC32 == "" && rom.SHA1 == "":
+		return NoGoodDump, ""
+	case matches(rom.CRC32, rom.SHA1, crc, sha):
+		return OK, ""
+	}
+	for _, a := range rom.Alternates {
+		if matches(a.CRC32, a.SHA1, crc, sha) {
+			return AlternateVersion, a.Version
+		}
+	}
+
+	return BadChecksum, ""
+}
+
+// matches reports whether the checksums of a file match the expected ones.
+// Checksums which are not declared are not compared.
+func matches(wantCRC, wantSHA, crc, sha string) bool {
+	if wantCRC == "" && wantSHA == "" {
+		return false
+	}
+
+	return (wantCRC == "" || strings.EqualFold(wantCRC, crc)) &&
+		(wantSHA == "" || strings.EqualFold(wantSHA, sha))
+}
+
+// checksums returns the CRC32 and SHA-1 of data as hexadecimal strings.
+func checksums(data []byte) (string, string) {
+	sum := sha1.Sum(data)
+
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)), hex.EncodeToString(sum[:])
+}
+
+// OK reports whether every ROM is usable.
+func (r *Result) OK() bool {
+	for _, c := range r.Checks {
+		if !c.Status.Usable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Err returns an error naming the unusable ROMs, or nil if all are usable.
+func (r *Result) Err() error {
+	var bad []string
+	for _, c := range r.Checks {
+		if !c.Status.Usable() {
+			bad = append(bad, c.ROM.Name+": "+strings.ToLower(c.Status.String()))
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("romset: %s", strings.Join(bad, ", "))
+}
+
+// Place writes each usable ROM into memory at its address.
+func (r *Result) Place(mem go8080.MemWriter) {
+	for _, rom := range r.roms {
+		for i, b := range r.Files[rom.Name] {
+			mem.Write(rom.Addr+uint16(i), b)
+		}
+	}
+}
+
+// WriteReport writes a line for each ROM describing its status, followed by
+// a summary.
+func (r *Result) WriteReport(w io.Writer) error {
+	width := 0
+	for _, c := range r.Checks {
+		if len(c.ROM.Name) > width {
+			width = len(c.ROM.Name)
+		}
+	}
+
+	ew := &errWriter{w: w}
+	for _, c := range r.Checks {
+		fmt.Fprintf(ew, "%-*s  %s", width, c.ROM.Name, c.Status)
+		switch c.Status {
+		case Renamed:
+			fmt.Fprintf(ew, ": %s", c.File)
+		case AlternateVersion:
+			fmt.Fprintf(ew, ": %s", c.Version)
+		case WrongSize:
+			fmt.Fprintf(ew, ": %d bytes, expected %d", c.Size, c.ROM.Size)
+		case BadChecksum:
+			fmt.Fprintf(ew, ": %s", describe(c))
+		}
+		fmt.Fprintln(ew)
+	}
+
+	counts := make(map[Status]int)
+	for _, c := range r.Checks {
+		counts[c.Status]++
+	}
+	var statuses []Status
+	for s := range counts {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i] < statuses[j] })
+
+	var parts []string
+	for _, s := range statuses {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[s], strings.ToLower(s.String())))
+	}
+	verdict := "usable"
+	if !r.OK() {
+		verdict = "NOT usable"
+	}
+	fmt.Fprintf(ew, "%d ROMs: %s; set is %s\n", len(r.Checks), strings.Join(parts, ", "), verdict)
+
+	return ew.err
+}
+
+// describe returns the checksums of a bad dump and those expected.
+func describe(c Check) string {
+	var parts []string
+	if c.ROM.CRC32 != "" {
+		parts = append(parts, fmt.Sprintf("CRC32 %s, expected %s", c.CRC32, strings.ToLower(c.ROM.CRC32)))
+	}
+	if c.ROM.SHA1 != "" {
+		parts = append(parts, fmt.Sprintf("SHA1 %s, expected %s", c.SHA1, strings.ToLower(c.ROM.SHA1)))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// errWriter is a writer which remembers the first error from the underlying
+// writer and discards everything written after it.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return len(p), nil
+	}
+	_, ew.err = ew.w.Write(p)
+
+	return len(p), nil
+}
+
+// dirSource is a directory of ROM files.
+type dirSource string
+
+func (d dirSource) Names() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() {
+			names = append(names, fi.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (d dirSource) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// zipSource is a zip archive of ROM files.
+type zipSource struct {
+	r *zip.Reader
+}
+
+func (z zipSource) Names() ([]string, error) {
+	var names []string
+	for _, f := range z.r.File {
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+		}
+	}
+
+	return names, nil
+}
+
+func (z zipSource) ReadFile(name string) ([]byte, error) {
+	for _, f := range z.r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		return ioutil.ReadAll(rc)
+	}
+
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,187 @@
+package romset
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type mem []byte
+
+// Read returns the value from memory at the given address.
+func (m mem) Read(addr uint16) byte {
+	return m[addr]
+}
+
+// ReadAll returns the full memory contents.
+func (m mem) ReadAll() []byte {
+	return m
+}
+
+// Write writes the value v into memory at the given address.
+func (m mem) Write(addr uint16, v byte) {
+	m[addr] = v
+}
+
+// data returns n bytes of test data derived from seed.
+func data(seed byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i)
+	}
+
+	return b
+}
+
+// testROMs returns a set of ROM declarations covering each status, and the
+// files of a dump of it.
+func testROMs() ([]ROM, map[string][]byte) {
+	crc := func(b []byte) string { c, _ := checksums(b); return c }
+	sha := func(b []byte) string { _, s := checksums(b); return s }
+
+	roms := []ROM{
+		{Name: "good.rom", Addr: 0x0000, Size: 16, CRC32: crc(data(1, 16)), SHA1: sha(data(1, 16))},
+		{Name: "short.rom", Addr: 0x0010, Size: 16, CRC32: crc(data(2, 16))},
+		{Name: "bad.rom", Addr: 0x0020, Size: 16, CRC32: crc(data(3, 16))},
+		{Name: "alt.rom", Addr: 0x0030, Size: 16, CRC32: crc(data(4, 16)), Alternates: []Alternate{
+			{Version: "rev 2", CRC32: crc(data(5, 16))},
+		}},
+		{Name: "moved.rom", Addr: 0x0040, Size: 16, CRC32: crc(data(6, 16))},
+		{Name: "missing.rom", Addr: 0x0050, Size: 16, CRC32: crc(data(7, 16))},
+		{Name: "nodump.rom", Addr: 0x0060, Size: 16},
+	}
+	files := map[string][]byte{
+		"good.rom":   data(1, 16),
+		"short.rom":  data(2, 8),
+		"bad.rom":    data(9, 16),
+		"alt.rom":    data(5, 16),
+		"other.bin":  data(6, 16),
+		"nodump.rom": data(8, 16),
+	}
+
+	return roms, files
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	roms, files := testROMs()
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := Load(dir, roms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Status{OK, WrongSize, BadChecksum, AlternateVersion, Renamed, NotFound, NoGoodDump}
+	for i, c := range res.Checks {
+		if c.Status != want[i] {
+			t.Errorf("%s: status = %v, want %v", c.ROM.Name, c.Status, want[i])
+		}
+	}
+	if res.OK() {
+		t.Error("OK = true for a set with bad ROMs")
+	}
+	if got, want := res.Err().Error(), "romset: short.rom: incorrect length, bad.rom: incorrect checksum, missing.rom: not found"; got != want {
+		t.Errorf("Err = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := res.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	report := `good.rom     OK
+short.rom    INCORRECT LENGTH: 8 bytes, expected 16
+bad.rom      INCORRECT CHECKSUM: CRC32 ` + crcOf(data(9, 16)) + `, expected ` + roms[2].CRC32 + `
+alt.rom      ALTERNATE VERSION: rev 2
+moved.rom    FOUND UNDER ANOTHER NAME: other.bin
+missing.rom  NOT FOUND
+nodump.rom   NO GOOD DUMP KNOWN
+7 ROMs: 1 ok, 1 found under another name, 1 no good dump known, 1 alternate version, 1 not found, 1 incorrect length, 1 incorrect checksum; set is NOT usable
+`
+	if got := buf.String(); got != report {
+		t.Errorf("report =\n%s\nwant\n%s", got, report)
+	}
+
+	m := make(mem, 0x100)
+	res.Place(m)
+	if !bytes.Equal(m[0x00:0x10], data(1, 16)) || !bytes.Equal(m[0x40:0x50], data(6, 16)) {
+		t.Error("Place did not place the usable ROMs")
+	}
+	if !bytes.Equal(m[0x10:0x30], make([]byte, 0x20)) {
+		t.Error("Place placed unusable ROMs")
+	}
+}
+
+func crcOf(b []byte) string {
+	c, _ := checksums(b)
+	return c
+}
+
+func TestZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("set/GOOD.ROM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data(1, 16))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "set.zip")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	roms, _ := testROMs()
+	res, err := Load(path, roms[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if c := res.Checks[0]; c.File != "set/GOOD.ROM" {
+		t.Errorf("file = %q, want set/GOOD.ROM", c.File)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.zip"), roms); err == nil {
+		t.Error("Load of missing archive succeeded")
+	}
+}
+
+func TestUnreadableDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The directory goes away after it was opened, so it cannot be listed.
+	os.RemoveAll(dir)
+	roms, _ := testROMs()
+	if _, err := Verify(src, roms); !os.IsNotExist(err) {
+		t.Errorf("Verify error = %v, want the directory's not exist error", err)
+	}
+}
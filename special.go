@@ -1,8 +1,10 @@
 package go8080
 
-// ei is the "enable interrupt" handler.
+// ei is the "enable interrupt" handler. Interrupts are accepted once the
+// following instruction has run, so that an interrupt routine ending with EI
+// and RET returns before the next interrupt.
 func (i *Intel8080) ei() {
-	i.ie = true
+	i.ie, i.eiPending = true, true
 }
 
 // di is the "disable interrupt" handler.
@@ -0,0 +1,106 @@
+// Package wav reads and writes mono 16 bit PCM WAV files.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// formatPCM is the WAVE format tag for uncompressed PCM.
+const formatPCM = 1
+
+// ErrFormat is returned by Read for files which are not PCM WAV files.
+var ErrFormat = errors.New("wav: not a PCM WAV file")
+
+// Write writes samples as a mono 16 bit WAV file with the given sample rate.
+func Write(w io.Writer, rate int, samples []int16) error {
+	data := 2 * len(samples)
+
+	hdr := make([]byte, 44)
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(36+data))
+	copy(hdr[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], formatPCM)
+	binary.LittleEndian.PutUint16(hdr[22:], 1)
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(rate*2))
+	binary.LittleEndian.PutUint16(hdr[32:], 2)
+	binary.LittleEndian.PutUint16(hdr[34:], 16)
+	copy(hdr[36:], "data")
+	binary.LittleEndian.PutUint32(hdr[40:], uint32(data))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// Read reads a PCM WAV file with 8 or 16 bit samples and returns its samples
+// as 16 bit mono, averaging the channels of multi-channel files, along with
+// the sample rate.
+func Read(r io.Reader) ([]int16, int, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, 0, ErrFormat
+	}
+
+	var (
+		channels, bits int
+		rate           int
+		data           []byte
+		haveFmt        bool
+	)
+	for p := 12; p+8 <= len(b); {
+		id, size := string(b[p:p+4]), int(binary.LittleEndian.Uint32(b[p+4:]))
+		p += 8
+		if size > len(b)-p {
+			size = len(b) - p
+		}
+		chunk := b[p : p+size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(chunk) != formatPCM {
+				return nil, 0, ErrFormat
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			rate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+			haveFmt = true
+		case "data":
+			data = chunk
+		}
+
+		// Chunks are padded to an even length.
+		p += size + size&1
+	}
+	if !haveFmt || data == nil || channels == 0 {
+		return nil, 0, ErrFormat
+	}
+	if bits != 8 && bits != 16 {
+		return nil, 0, fmt.Errorf("wav: unsupported sample size of %d bits", bits)
+	}
+
+	frame := channels * bits / 8
+	samples := make([]int16, len(data)/frame)
+	for i := range samples {
+		var sum int
+		for c := 0; c < channels; c++ {
+			if bits == 8 {
+				sum += (int(data[i*frame+c]) - 0x80) << 8
+			} else {
+				sum += int(int16(binary.LittleEndian.Uint16(data[i*frame+c*2:])))
+			}
+		}
+		samples[i] = int16(sum / channels)
+	}
+
+	return samples, rate, nil
+}
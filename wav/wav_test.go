@@ -0,0 +1,51 @@
+package wav
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, 32767, -32768, 1234}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, 22050, samples); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 44+2*len(samples) {
+		t.Errorf("file is %d bytes, want %d", buf.Len(), 44+2*len(samples))
+	}
+
+	got, rate, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 22050 {
+		t.Errorf("rate = %d, want 22050", rate)
+	}
+	if !reflect.DeepEqual(got, samples) {
+		t.Errorf("samples = %v, want %v", got, samples)
+	}
+}
+
+func TestRead8BitStereo(t *testing.T) {
+	b := []byte("RIFF\x00\x00\x00\x00WAVE" +
+		"fmt \x10\x00\x00\x00\x01\x00\x02\x00\x11\x2b\x00\x00\x22\x56\x00\x00\x02\x00\x08\x00" +
+		"data\x04\x00\x00\x00\x80\x80\xff\x81")
+
+	got, rate, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 11025 {
+		t.Errorf("rate = %d, want 11025", rate)
+	}
+	if want := []int16{0, 0x4000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+
+	if _, _, err := Read(bytes.NewReader([]byte("RIFX"))); err != ErrFormat {
+		t.Errorf("Read of bad header error = %v, want %v", err, ErrFormat)
+	}
+}